@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/Humpheh/goboy/gameboy"
+	"github.com/hajimehoshi/oto"
+)
+
+var (
+	// audioQueue holds chunks of samples that wait to be played. It is nil if
+	// there is no sound output.
+	audioQueue chan []byte
+	// audioDone is closed when the goroutine playing audioQueue has released
+	// the sound device.
+	audioDone chan struct{}
+	// audioOutputRate is the sample rate that the sound device was opened
+	// with. Our samples are resampled from sampleRate to it.
+	audioOutputRate int
+)
+
+// startAudio opens the sound device and starts a goroutine that plays the
+// samples passed to queueAudio.
+func startAudio(settings audioSettings) error {
+	rate := int(settings.SampleRate)
+	bufferSize := rate * int(settings.BufferMillis) / 1000
+	context, err := oto.NewContext(rate, 1, 1, bufferSize)
+	if err != nil {
+		return err
+	}
+	player := context.NewPlayer()
+
+	queue := make(chan []byte, 16)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer context.Close()
+		defer player.Close()
+
+		for samples := range queue {
+			if _, err := player.Write(samples); err != nil {
+				fmt.Println("playing sound failed:", err)
+				// Keep receiving so stopAudio does not block.
+				for range queue {
+				}
+				return
+			}
+		}
+	}()
+
+	audioQueue = queue
+	audioDone = done
+	audioOutputRate = rate
+	return nil
+}
+
+// stopAudio closes the sound device. It is safe to call if no sound device is
+// open.
+func stopAudio() {
+	if audioQueue == nil {
+		return
+	}
+	close(audioQueue)
+	<-audioDone
+	audioQueue = nil
+	audioDone = nil
+}
+
+// restartAudio re-opens the sound device with the given settings, e.g. after
+// the sample rate or buffer size changed.
+func restartAudio(settings audioSettings) error {
+	stopAudio()
+	return startAudio(settings)
+}
+
+// queueAudio plays the given samples after the ones that were queued before.
+// If the sound device cannot keep up, samples are dropped.
+func queueAudio(samples []byte) {
+	settings := &globalAudioSettings
+	if audioQueue == nil || settings.Muted || len(samples) == 0 {
+		return
+	}
+
+	var out []byte
+	if audioOutputRate == gameboy.SampleRate {
+		out = append([]byte(nil), samples...)
+	} else {
+		out = resampleAudio(samples, len(samples)*audioOutputRate/gameboy.SampleRate)
+	}
+	if settings.Volume < 100 {
+		for i := range out {
+			out[i] = byte(int32(out[i]) * settings.Volume / 100)
+		}
+	}
+
+	select {
+	case audioQueue <- out:
+	default:
+	}
+}
+
+// resampleAudio stretches or squeezes the samples to the given length.
+func resampleAudio(samples []byte, length int) []byte {
+	if len(samples) == 0 || length <= 0 {
+		return nil
+	}
+
+	out := make([]byte, length)
+	for i := range out {
+		out[i] = samples[i*len(samples)/length]
+	}
+	return out
+}
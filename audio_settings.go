@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/Humpheh/goboy/gameboy"
+	"github.com/gonutz/prototype/draw"
+)
+
+const audioSettingsFileVersion = 1
+
+// audioSettings are stored independently of the speedrun files because they
+// belong to the machine, not the session. The fields are fixed-size so the
+// struct can be read and written with encoding/binary.
+type audioSettings struct {
+	// Volume is the master volume in percent.
+	Volume int32
+	Muted  bool
+	// SampleRate is the sample rate that the sound device is opened with.
+	SampleRate int32
+	// BufferMillis is the size of the sound device's buffer. Smaller buffers
+	// mean less delay but might crackle on slow machines.
+	BufferMillis int32
+}
+
+var defaultAudioSettings = audioSettings{
+	Volume:       100,
+	SampleRate:   gameboy.SampleRate,
+	BufferMillis: 66,
+}
+
+var (
+	audioSampleRates    = []int32{22050, 44100, 48000}
+	audioBufferMillis   = []int32{20, 33, 66, 100, 200}
+	globalAudioSettings = defaultAudioSettings
+)
+
+func audioSettingsPath() string {
+	return filepath.Join(os.Getenv("APPDATA"), "gameboy.audio")
+}
+
+// loadAudioSettings returns the default settings if there are no valid
+// settings stored yet.
+func loadAudioSettings() audioSettings {
+	data, err := os.ReadFile(audioSettingsPath())
+	if err != nil {
+		return defaultAudioSettings
+	}
+
+	r := bytes.NewReader(data)
+	var version uint32
+	var settings audioSettings
+	if binary.Read(r, binary.LittleEndian, &version) != nil ||
+		version != audioSettingsFileVersion ||
+		binary.Read(r, binary.LittleEndian, &settings) != nil {
+		return defaultAudioSettings
+	}
+
+	settings.Volume = min(100, max(0, settings.Volume))
+	if !slices.Contains(audioSampleRates, settings.SampleRate) {
+		settings.SampleRate = defaultAudioSettings.SampleRate
+	}
+	if !slices.Contains(audioBufferMillis, settings.BufferMillis) {
+		settings.BufferMillis = defaultAudioSettings.BufferMillis
+	}
+	return settings
+}
+
+func saveAudioSettings(settings audioSettings) {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint32(audioSettingsFileVersion))
+	binary.Write(&buf, binary.LittleEndian, settings)
+	if err := os.WriteFile(audioSettingsPath(), buf.Bytes(), 0666); err != nil {
+		fmt.Println("saving audio settings failed:", err)
+	}
+}
+
+// nextOption returns the option after (delta = 1) or before (delta = -1) the
+// current one, clamped to the first and last options.
+func nextOption(options []int32, current, delta int32) int32 {
+	i := 0
+	for i < len(options) && options[i] != current {
+		i++
+	}
+	i = min(len(options)-1, max(0, i+int(delta)))
+	return options[i]
+}
+
+func (s *editorState) toggleMute() {
+	globalAudioSettings.Muted = !globalAudioSettings.Muted
+	saveAudioSettings(globalAudioSettings)
+	if globalAudioSettings.Muted {
+		s.setInfo("Sound muted (Ctrl+M to unmute).")
+	} else {
+		s.setInfo("Sound unmuted.")
+	}
+	s.render()
+}
+
+// changeAudioSettings applies and stores new settings. The sound device is
+// re-opened if the device parameters changed.
+func (s *editorState) changeAudioSettings(settings audioSettings) {
+	old := globalAudioSettings
+	globalAudioSettings = settings
+	saveAudioSettings(settings)
+
+	if settings.SampleRate != old.SampleRate || settings.BufferMillis != old.BufferMillis {
+		if err := restartAudio(settings); err != nil {
+			s.setWarning("Opening the sound device failed: " + err.Error())
+		}
+	}
+	s.render()
+}
+
+// executeAudioSettingsFrame shows the audio settings panel on top of the
+// editor or replay, which keep running in the background so changes can be
+// heard right away.
+func (state *editorState) executeAudioSettingsFrame(window draw.Window) {
+	if state.replayingGame {
+		state.executeReplayFrame(newReadOnlyWindow(window))
+	} else {
+		state.executeEditorFrame(newReadOnlyWindow(window))
+	}
+
+	if closeDialogKeys.wasPressed(window) {
+		state.audioSettingsOpen = false
+		state.render()
+		return
+	}
+
+	windowW, windowH := window.Size()
+	mouseX, mouseY := window.MousePosition()
+	leftClick := wasLeftClicked(window)
+
+	const textScale = 2
+	_, textH := window.GetScaledTextSize("|", textScale)
+	rowH := textH + 16
+
+	panel := rect(0, 0, 560, 7*rowH+40)
+	panel.x = (windowW - panel.w) / 2
+	panel.y = (windowH - panel.h) / 2
+	panel.fill(window, draw.Black)
+	panel.inset(5).fill(window, draw.White)
+
+	title := "Audio Settings"
+	titleW, _ := window.GetScaledTextSize(title, textScale)
+	y := panel.y + 20
+	window.DrawScaledText(title, panel.x+(panel.w-titleW)/2, y, textScale, draw.Black)
+	y += rowH
+
+	clicked := func(r rectangle) bool {
+		hover := r.contains(mouseX, mouseY)
+		color := draw.LightPurple
+		if hover {
+			color = draw.Purple
+		}
+		r.fill(window, color)
+		return leftClick && hover
+	}
+
+	// row draws a setting with buttons to decrease and increase it. It
+	// returns -1, 0 or 1 for the button that was clicked.
+	row := func(name, value string, adjustable bool) int32 {
+		window.DrawScaledText(name, panel.x+30, y+8, textScale, draw.Black)
+
+		valueX := panel.x + 280
+		valueW := panel.w - 280 - 30
+		delta := int32(0)
+		if adjustable {
+			less := rect(valueX, y+4, rowH-8, rowH-8)
+			more := rect(valueX+valueW-less.w, y+4, less.w, less.h)
+			if clicked(less) {
+				delta = -1
+			}
+			if clicked(more) {
+				delta = 1
+			}
+			window.DrawScaledText("<", less.x+8, y+8, textScale, draw.Black)
+			window.DrawScaledText(">", more.x+8, y+8, textScale, draw.Black)
+		}
+		w, _ := window.GetScaledTextSize(value, textScale)
+		window.DrawScaledText(value, valueX+(valueW-w)/2, y+8, textScale, draw.Black)
+
+		y += rowH
+		return delta
+	}
+
+	settings := globalAudioSettings
+
+	if d := row("Volume", fmt.Sprintf("%d%%", settings.Volume), true); d != 0 {
+		settings.Volume = min(100, max(0, settings.Volume+10*d))
+	}
+
+	muted := "no"
+	if settings.Muted {
+		muted = "yes"
+	}
+	if d := row("Muted (Ctrl+M)", muted, true); d != 0 {
+		settings.Muted = !settings.Muted
+	}
+
+	rate := fmt.Sprintf("%d Hz", settings.SampleRate)
+	if d := row("Sample Rate", rate, true); d != 0 {
+		settings.SampleRate = nextOption(audioSampleRates, settings.SampleRate, d)
+	}
+
+	buffer := fmt.Sprintf("%d ms", settings.BufferMillis)
+	if d := row("Buffer Size", buffer, true); d != 0 {
+		settings.BufferMillis = nextOption(audioBufferMillis, settings.BufferMillis, d)
+	}
+
+	// Our sound library always plays on the system's default device.
+	row("Output Device", "System Default", false)
+
+	if settings != globalAudioSettings {
+		state.changeAudioSettings(settings)
+	}
+
+	closeText := "Close"
+	closeW, _ := window.GetScaledTextSize(closeText, textScale)
+	closeButton := rect(panel.x+(panel.w-closeW-40)/2, y+4, closeW+40, rowH-8)
+	if clicked(closeButton) {
+		state.audioSettingsOpen = false
+		state.render()
+	}
+	window.DrawScaledText(closeText, closeButton.x+20, y+8, textScale, draw.Black)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/Humpheh/goboy/gameboy"
+)
+
+// The editor assumes that emulating a frame only depends on the state before
+// it and the frame's inputs. It caches frames, keeps key frames and emulates
+// on several goroutines at once, so generateFrame(N) must give the same state
+// no matter how and when it is called. Reading the wall clock or math/rand,
+// iterating a map or a goroutine that touches the Gameboy in the background
+// all break that.
+//
+// The audit mode checks this for a ROM. It emulates the same inputs in several
+// ways at the same time and compares the states after every frame. Map
+// iteration order and the global math/rand source change with every run, so a
+// dependence on them shows up as two runs that diverge. Building the editor
+// with -race in addition reports goroutines that access the Gameboy while it is
+// emulated.
+
+// auditVariant emulates the inputs in its own way on its own goroutine. Its
+// states must equal those of emulating the inputs straight.
+type auditVariant struct {
+	name string
+	// step emulates the inputs of frame i.
+	step   func(gb *gameboy.Gameboy, i int, inputs inputState) error
+	states chan *gameboy.Gameboy
+	err    error
+	// diverged is set after the first difference, we only report that one.
+	diverged bool
+}
+
+func auditVariants() []*auditVariant {
+	straight := func(gb *gameboy.Gameboy, i int, inputs inputState) error {
+		auditStep(gb, inputs)
+		return nil
+	}
+	return []*auditVariant{
+		{name: "a second run at the same time", step: straight},
+		{name: "a third run at the same time", step: straight},
+		{
+			name: "saving and loading the state at every key frame",
+			step: func(gb *gameboy.Gameboy, i int, inputs inputState) error {
+				if i%keyFrameInterval == 0 {
+					loaded, err := gameboy.LoadState(gb.Memory.Cart.ROM(), gb.SaveState())
+					if err != nil {
+						return err
+					}
+					*gb = loaded
+				}
+				auditStep(gb, inputs)
+				return nil
+			},
+		},
+		{
+			name: "pausing and collecting garbage every second of game time",
+			step: func(gb *gameboy.Gameboy, i int, inputs inputState) error {
+				if i%gameboy.FramesSecond == 0 {
+					runtime.GC()
+					time.Sleep(time.Millisecond)
+				}
+				auditStep(gb, inputs)
+				return nil
+			},
+		},
+	}
+}
+
+func auditStep(gb *gameboy.Gameboy, inputs inputState) {
+	applyInputs(gb, inputs)
+	gb.Step()
+}
+
+// auditInputs returns inputs that hold random buttons for a few frames each,
+// so the game does more than wait on its title screen. The seed is fixed, every
+// audit of a ROM uses the same inputs.
+func auditInputs(frames int) []inputState {
+	rng := rand.New(rand.NewPCG(1, 2))
+	inputs := make([]inputState, frames)
+	var held inputState
+	for i := range inputs {
+		if rng.IntN(8) == 0 {
+			held = inputState(rng.IntN(1 << gameboy.ButtonCount))
+		}
+		inputs[i] = held
+	}
+	return inputs
+}
+
+// runAudit emulates the given number of frames of the ROM file in the ways of
+// auditVariants and reports where the states differ. It returns the process
+// exit code, 0 if the emulation only depends on the inputs.
+func runAudit(frames int, path string) int {
+	rom, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Println(err)
+		return 2
+	}
+	options := defaultGameboyOptions
+	options.Model = gameboy.DefaultConsoleModel(rom)
+	inputs := auditInputs(frames)
+
+	findings := 0
+	goroutines := runtime.NumGoroutine()
+	start := gameboy.NewGameboy(rom, options)
+	if n := runtime.NumGoroutine() - goroutines; n > 0 {
+		findings++
+		fmt.Printf("creating the Gameboy started %d goroutine(s) that run while it is emulated\n", n)
+	}
+	goroutines = runtime.NumGoroutine()
+
+	variants := auditVariants()
+	var done sync.WaitGroup
+	for _, v := range variants {
+		v.states = make(chan *gameboy.Gameboy, 8)
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			defer close(v.states)
+			gb := start
+			for i, in := range inputs {
+				if v.err = v.step(&gb, i, in); v.err != nil {
+					return
+				}
+				state := new(gameboy.Gameboy)
+				*state = gb
+				v.states <- state
+			}
+		}()
+	}
+
+	gb := start
+	for i, in := range inputs {
+		auditStep(&gb, in)
+		for _, v := range variants {
+			have, ok := <-v.states
+			if !ok || v.diverged {
+				continue
+			}
+			if diffs := gameboyDifferences(&gb, have, maxReportedDifferences+1); len(diffs) > 0 {
+				v.diverged = true
+				findings++
+				fmt.Printf("frame %d: %s diverges in %s\n", i, v.name, formatDifferences(diffs))
+			}
+		}
+	}
+	done.Wait()
+
+	for _, v := range variants {
+		if v.err != nil {
+			findings++
+			fmt.Printf("%s failed: %v\n", v.name, v.err)
+		}
+	}
+	// The variants' goroutines end right after they are done, any goroutines
+	// left after that were started by the emulation.
+	for range 100 {
+		if runtime.NumGoroutine() <= goroutines {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if n := runtime.NumGoroutine() - goroutines; n > 0 {
+		findings++
+		fmt.Printf("emulating started %d goroutine(s) that are still running\n", n)
+	}
+
+	if findings > 0 {
+		fmt.Printf("FAIL %s: %d finding(s) in %d frames\n", path, findings, frames)
+		return 1
+	}
+	fmt.Printf("PASS %s: %d frames only depend on the inputs\n", path, frames)
+	return 0
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Humpheh/goboy/gameboy"
+)
+
+// runBenchmark emulates the given number of frames of the ROM file without
+// pressing any buttons and prints how fast that was. It returns the process
+// exit code.
+func runBenchmark(frames int, path string) int {
+	if path == "" {
+		fmt.Println("the benchmark needs a ROM file argument")
+		return 2
+	}
+	rom, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Println(err)
+		return 2
+	}
+	options := defaultGameboyOptions
+	options.Model = gameboy.DefaultConsoleModel(rom)
+	gb := gameboy.NewGameboy(rom, options)
+
+	start := time.Now()
+	for range frames {
+		gb.Step()
+	}
+	elapsed := time.Since(start)
+
+	fps := float64(frames) / elapsed.Seconds()
+	fmt.Printf("emulated %d frames in %v\n", frames, elapsed.Round(time.Millisecond))
+	fmt.Printf("%.0f frames/s, %.1f times real time\n", fps, fps/gameboy.FramesSecond)
+	fmt.Printf(
+		"one key frame takes %d KB, %d key frames per minute of gameplay with the interval of %d frames\n",
+		gameboySize/1024, 60*gameboy.FramesSecond/keyFrameInterval, keyFrameInterval,
+	)
+	return 0
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sync/atomic"
+
+	"github.com/Humpheh/goboy/gameboy"
+	"github.com/gonutz/prototype/draw"
+)
+
+// branchStats are shown in a table to compare the branches. Counting the lag
+// frames means emulating every branch, this is done on a background goroutine
+// while the table is open.
+type branchStats struct {
+	// lagFrames has one entry per branch, -1 until it is counted.
+	lagFrames []int
+	// countingBranch is the branch whose lag frames are counted right now and
+	// emulatedFrames the progress in it. They are written by the background
+	// goroutine.
+	countingBranch atomic.Int64
+	emulatedFrames atomic.Int64
+	result         chan lagFrameCount
+	cancel         chan struct{}
+}
+
+type lagFrameCount struct {
+	branch, lagFrames int
+}
+
+// openBranchStats shows the table and starts counting the lag frames.
+func (s *editorState) openBranchStats() {
+	inputs := make([][]inputState, len(s.branches))
+	subframes := make([]map[int][]subframeInput, len(s.branches))
+	for i := range s.branches {
+		inputs[i] = slices.Clone(s.branches[i].frameInputs)
+		subframes[i] = cloneSubframeInputs(s.branches[i].subframeInputs)
+	}
+
+	stats := &branchStats{
+		lagFrames: make([]int, len(s.branches)),
+		result:    make(chan lagFrameCount, len(s.branches)),
+		cancel:    make(chan struct{}),
+	}
+	for i := range stats.lagFrames {
+		stats.lagFrames[i] = -1
+	}
+	s.branchStats = stats
+
+	// We do not need any sound to count lag frames.
+	options := s.gameboyOptions
+	options.Sound = false
+	go stats.countLagFrames(s.startGameboy(options), inputs, subframes)
+}
+
+func (s *editorState) closeBranchStats() {
+	close(s.branchStats.cancel)
+	for range s.branchStats.result {
+	}
+	s.branchStats = nil
+	s.render()
+}
+
+// countLagFrames emulates all branches from the start of the session and
+// counts the frames in which the game did not read the joypad. subframes[b]
+// are the subframe inputs of branch b.
+func (stats *branchStats) countLagFrames(start gameboy.Gameboy, inputs [][]inputState, subframes []map[int][]subframeInput) {
+	defer close(stats.result)
+
+	for b, branchInputs := range inputs {
+		stats.emulatedFrames.Store(0)
+		stats.countingBranch.Store(int64(b))
+		gb := start
+		lag := 0
+		for i, in := range branchInputs {
+			select {
+			case <-stats.cancel:
+				return
+			default:
+			}
+
+			applyInputs(&gb, in)
+			setPollInputs(&gb, subframes[b][i])
+			gb.Step()
+			if !gb.JoypadPolled {
+				lag++
+			}
+			stats.emulatedFrames.Store(int64(i + 1))
+		}
+		stats.result <- lagFrameCount{branch: b, lagFrames: lag}
+	}
+}
+
+// buttonPresses counts how often a button goes down in the inputs, all buttons
+// start out released.
+func buttonPresses(inputs []inputState) int {
+	presses := 0
+	last := inputState(0)
+	for _, in := range inputs {
+		for b := range gameboy.ButtonCount {
+			if isButtonDown(in, b) && !isButtonDown(last, b) {
+				presses++
+			}
+		}
+		last = in
+	}
+	return presses
+}
+
+// firstDivergence returns the first frame in which the inputs or subframe
+// inputs of a and b differ, or -1 if they are the same. Frames after the end of a branch have
+// its default inputs.
+func firstDivergence(a, b *branch) int {
+	first := -1
+	for i := range max(len(a.frameInputs), len(b.frameInputs)) {
+		inA, inB := a.defaultInputs, b.defaultInputs
+		if i < len(a.frameInputs) {
+			inA = a.frameInputs[i]
+		}
+		if i < len(b.frameInputs) {
+			inB = b.frameInputs[i]
+		}
+		if inA != inB {
+			first = i
+			break
+		}
+	}
+	for _, frameIndex := range subframeDifferences(a.subframeInputs, b.subframeInputs) {
+		if first == -1 || frameIndex < first {
+			first = frameIndex
+		}
+	}
+	return first
+}
+
+// executeBranchStatsFrame shows the statistics of all branches on top of the
+// editor or replay.
+func (state *editorState) executeBranchStatsFrame(window draw.Window) {
+	if state.replayingGame {
+		state.executeReplayFrame(newReadOnlyWindow(window))
+	} else {
+		state.executeEditorFrame(newReadOnlyWindow(window))
+	}
+
+	if closeDialogKeys.wasPressed(window) {
+		state.closeBranchStats()
+		return
+	}
+
+	stats := state.branchStats
+	for done := false; !done; {
+		select {
+		case c, ok := <-stats.result:
+			if ok {
+				stats.lagFrames[c.branch] = c.lagFrames
+			} else {
+				done = true
+			}
+		default:
+			done = true
+		}
+	}
+
+	windowW, windowH := window.Size()
+	mouseX, mouseY := window.MousePosition()
+	leftClick := wasLeftClicked(window)
+
+	const textScale = 1.5
+	_, textH := window.GetScaledTextSize("|", textScale)
+	rowH := textH + 8
+
+	panel := rect(0, 0, min(windowW-40, 960), windowH-80)
+	panel.x = (windowW - panel.w) / 2
+	panel.y = (windowH - panel.h) / 2
+	panel.fill(window, draw.Black)
+	panel.inset(5).fill(window, draw.White)
+
+	title := "Branch Statistics"
+	titleW, _ := window.GetScaledTextSize(title, textScale)
+	y := panel.y + 20
+	window.DrawScaledText(title, panel.x+(panel.w-titleW)/2, y, textScale, draw.Black)
+	y += rowH
+
+	first := &state.branches[0]
+	note := "Divergence is compared to the first branch, " + first.name
+	noteW, _ := window.GetScaledTextSize(note, textScale)
+	window.DrawScaledText(note, panel.x+(panel.w-noteW)/2, y, textScale, draw.DarkGray)
+	y += 2 * rowH
+
+	columnX := []int{30, 330, 440, 560, 700, 810}
+	row := func(color draw.Color, columns ...string) {
+		for i, text := range columns {
+			window.DrawScaledText(text, panel.x+columnX[i], y, textScale, color)
+		}
+		y += rowH
+	}
+
+	row(draw.DarkGray, "Name", "Frames", "Time", "Diverges", "Presses", "Lag")
+
+	// Leave room for the button at the bottom.
+	maxRows := (panel.y + panel.h - 3*rowH - 20 - y) / rowH
+	for i := range state.branches {
+		if i == maxRows-1 && len(state.branches) > maxRows {
+			row(draw.DarkGray, fmt.Sprintf("... %d more", len(state.branches)-i))
+			break
+		}
+
+		b := &state.branches[i]
+		diverges := "main"
+		if i > 0 {
+			diverges = "same"
+			if d := firstDivergence(first, b); d != -1 {
+				diverges = fmt.Sprint(d)
+			}
+		}
+
+		lag := fmt.Sprint(stats.lagFrames[i])
+		if stats.lagFrames[i] == -1 {
+			lag = "..."
+			if int(stats.countingBranch.Load()) == i && len(b.frameInputs) > 0 {
+				done := stats.emulatedFrames.Load()
+				lag = fmt.Sprintf("%d%%", done*100/int64(len(b.frameInputs)))
+			}
+		}
+
+		color := draw.Black
+		if i == state.branchIndex {
+			color = draw.DarkRed
+		}
+		row(
+			color,
+			b.name,
+			fmt.Sprint(len(b.frameInputs)),
+			formatRunTime(framesToDuration(len(b.frameInputs))),
+			diverges,
+			fmt.Sprint(buttonPresses(b.frameInputs)),
+			lag,
+		)
+	}
+
+	closeText := "Close"
+	closeW, _ := window.GetScaledTextSize(closeText, textScale)
+	closeButton := rect(panel.x+(panel.w-closeW-20)/2, panel.y+panel.h-2*rowH-10, closeW+20, rowH+6)
+	color := draw.LightPurple
+	if closeButton.contains(mouseX, mouseY) {
+		color = draw.Purple
+	}
+	closeButton.fill(window, color)
+	window.DrawScaledText(closeText, closeButton.x+10, closeButton.y+7, textScale, draw.Black)
+	if leftClick && closeButton.contains(mouseX, mouseY) {
+		state.closeBranchStats()
+	}
+}
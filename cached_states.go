@@ -0,0 +1,57 @@
+package main
+
+import (
+	"slices"
+
+	"github.com/Humpheh/goboy/gameboy"
+)
+
+// The frame cache is not saved with the session, so after opening a session
+// every screen of the working area is emulated from the key frame before it,
+// up to keyFrameInterval frames per screen. With the SavedCacheStates setting
+// the session file keeps a few cached states around the grid that was visible
+// and they go back into the frame cache when the session is opened.
+
+type cachedState struct {
+	frameIndex int
+	gameboy    gameboy.Gameboy
+}
+
+// collectWorkingAreaStates picks up to globalSettings.SavedCacheStates frames
+// from the frame cache, starting before the visible grid or the selection,
+// whichever comes first. They are at least maxSyncThumbnailFrames apart so
+// they cover as many screens as possible. Nothing is emulated for this, only
+// frames that are already cached are saved.
+func (s *editorState) collectWorkingAreaStates() []cachedState {
+	count := int(globalSettings.SavedCacheStates)
+	if count == 0 {
+		return nil
+	}
+
+	c := s.frameCache
+	order := make([]int, len(c.frameIndices))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortFunc(order, func(a, b int) int {
+		return c.frameIndices[a] - c.frameIndices[b]
+	})
+
+	var states []cachedState
+	next := min(s.leftMostFrame, s.activeSelection.start()) - 1
+	for _, i := range order {
+		if len(states) == count {
+			break
+		}
+		frameIndex := c.frameIndices[i]
+		if frameIndex < next {
+			continue
+		}
+		states = append(states, cachedState{
+			frameIndex: frameIndex,
+			gameboy:    c.gameboys[i],
+		})
+		next = frameIndex + maxSyncThumbnailFrames
+	}
+	return states
+}
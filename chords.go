@@ -0,0 +1,48 @@
+package main
+
+import "github.com/gonutz/prototype/draw"
+
+// In chord mode the button keys do not toggle their buttons right away.
+// Instead the user holds all the buttons of a chord, e.g. Right+A+B, and
+// presses Enter to press them together in the selection. That is a single
+// edit, so the frames are emulated again only once, and the +/- keys adjust
+// the whole chord afterwards.
+
+func (s *editorState) toggleChordMode() {
+	s.chordMode = !s.chordMode
+	if s.chordMode {
+		s.setInfo("Chord mode: hold buttons and press Enter to press them together")
+	} else {
+		s.setInfo("Chord mode off")
+	}
+	s.render()
+}
+
+// heldChord returns the buttons whose keys are held in chord mode.
+func (s *editorState) heldChord(window draw.Window) inputState {
+	if !s.chordMode {
+		return 0
+	}
+	var chord inputState
+	for key, b := range keyMap {
+		if window.IsKeyDown(key) {
+			setButtonDown(&chord, b, true)
+		}
+	}
+	return chord
+}
+
+// renderChordMode shows the held chord at y in the side menu while in chord
+// mode and returns the height it took.
+func (s *editorState) renderChordMode(window draw.Window, menuX, y int) int {
+	if !s.chordMode {
+		return 0
+	}
+	text := "Chord: hold buttons, Enter"
+	if chord := s.heldChord(window); chord != 0 {
+		text = "Chord:" + formatFrameInputs(chord) + ", Enter"
+	}
+	w, h := window.GetTextSize(text)
+	window.DrawText(text, menuX+(inputMenuW-w)/2, y, currentTheme().menuText)
+	return h + 6
+}
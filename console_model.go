@@ -0,0 +1,25 @@
+package main
+
+import "github.com/Humpheh/goboy/gameboy"
+
+// cycleConsoleModel switches to the next console model. All frames have to
+// be emulated again since the game boots differently. A savestate belongs to
+// the model it was made on, so sessions that start from one keep their model.
+// Switching the model is no input edit, so it does not count as a rerecord.
+func (s *editorState) cycleConsoleModel() {
+	if err := s.checkStart("switch the console model", true); err != nil {
+		s.setWarning(err.Error())
+		s.render()
+		return
+	}
+	next := (s.gameboyOptions.Model + 1) % gameboy.ConsoleModelCount
+	if next == gameboy.ModelLegacy {
+		// The legacy model is only for older sessions, it is no console that
+		// new runs should be made for.
+		next = gameboy.ModelDMG
+	}
+	s.gameboyOptions.Model = next
+	s.invalidateFramesFrom(0)
+	s.setInfo("Emulating the " + s.gameboyOptions.Model.String())
+	s.render()
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Humpheh/goboy/gameboy"
+)
+
+const (
+	contactSheetColumns = 10
+	// contactSheetTextScale scales the 3x5 pixel font.
+	contactSheetTextScale = 2
+	contactSheetLineH     = 6 * contactSheetTextScale
+	contactSheetCellW     = 1 + gameboy.ScreenWidth + 1
+	contactSheetCellH     = 2*contactSheetLineH + gameboy.ScreenHeight + 1
+)
+
+// exportContactSheet saves the selected frames as a PNG image, laid out like
+// the editor grid with the frame number and inputs above each screen.
+func (s *editorState) exportContactSheet() error {
+	s.startSaveDialog("Export Contact Sheet", "PNG Image", "png", func(path string) error {
+		img := s.renderContactSheet(s.activeSelection.start(), s.activeSelection.end())
+		if err := writePNG(path, img); err != nil {
+			return fmt.Errorf("failed to export '%s': %w", path, err)
+		}
+
+		s.setInfo(fmt.Sprintf("Exported %d frames to %s", s.activeSelection.count(), path))
+		return nil
+	})
+	return nil
+}
+
+func writePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// renderContactSheet draws the frames [start..end) into an image.
+func (s *editorState) renderContactSheet(start, end int) *image.RGBA {
+	count := end - start
+	columns := min(count, contactSheetColumns)
+	rows := (count + columns - 1) / columns
+
+	img := image.NewRGBA(image.Rect(0, 0, columns*contactSheetCellW, rows*contactSheetCellH))
+	fillImage(img, img.Bounds(), color.RGBA{A: 255})
+
+	border := color.RGBA{R: 128, G: 128, B: 128, A: 255}
+	white := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+
+	for i := range count {
+		frameIndex := start + i
+		cellX := (i % columns) * contactSheetCellW
+		cellY := (i / columns) * contactSheetCellH
+
+		fillImage(img, image.Rect(cellX, cellY, cellX+contactSheetCellW, cellY+contactSheetCellH), border)
+		fillImage(img, image.Rect(cellX+1, cellY, cellX+contactSheetCellW-1, cellY+contactSheetCellH-1), color.RGBA{A: 255})
+
+		drawPixelText(img, strconv.Itoa(frameIndex), cellX+2, cellY+1, white)
+		drawPixelText(img, inputCaption(s.inputsAt(frameIndex)), cellX+2, cellY+1+contactSheetLineH, white)
+
+		screen := s.displayScreen(frameIndex)
+		screenX := cellX + 1
+		screenY := cellY + 2*contactSheetLineH
+		for y := range gameboy.ScreenHeight {
+			for x := range gameboy.ScreenWidth {
+				c := screen.Pixel(x, y)
+				img.SetRGBA(screenX+x, screenY+y, color.RGBA{R: c[0], G: c[1], B: c[2], A: 255})
+			}
+		}
+	}
+
+	return img
+}
+
+// inputCaption is a compact text of the pressed buttons, short enough to fit
+// above a screen in the contact sheet.
+func inputCaption(inputs inputState) string {
+	var caption strings.Builder
+	add := func(b gameboy.Button, pressed string) {
+		if isButtonDown(inputs, b) {
+			caption.WriteString(pressed)
+		}
+	}
+	add(gameboy.ButtonLeft, "<")
+	add(gameboy.ButtonUp, "^")
+	add(gameboy.ButtonRight, ">")
+	add(gameboy.ButtonDown, "v")
+	caption.WriteString(" ")
+	add(gameboy.ButtonA, "A")
+	add(gameboy.ButtonB, "B")
+	add(gameboy.ButtonSelect, " SEL")
+	add(gameboy.ButtonStart, " START")
+	if inputs&powerCycleEvent != 0 {
+		caption.WriteString(" POWER")
+	} else if inputs&resetEvent != 0 {
+		caption.WriteString(" RESET")
+	}
+	return strings.TrimSpace(caption.String())
+}
+
+func fillImage(img *image.RGBA, r image.Rectangle, c color.RGBA) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+}
+
+// drawPixelText draws text with our tiny 3x5 pixel font, scaled by
+// contactSheetTextScale. Characters that are not in the font are skipped.
+func drawPixelText(img *image.RGBA, text string, x, y int, c color.RGBA) {
+	const scale = contactSheetTextScale
+	for _, r := range text {
+		glyph, ok := pixelFont[r]
+		if ok {
+			for row, bits := range glyph {
+				for col := range 3 {
+					if bits&(4>>col) != 0 {
+						fillImage(img, image.Rect(
+							x+col*scale, y+row*scale,
+							x+(col+1)*scale, y+(row+1)*scale,
+						), c)
+					}
+				}
+			}
+		}
+		x += 4 * scale
+	}
+}
+
+// pixelFont has a 3x5 pixel glyph for each character we need in captions. Each
+// row is 3 bits, the highest bit being the left-most pixel.
+var pixelFont = map[rune][5]byte{
+	'0': {7, 5, 5, 5, 7},
+	'1': {2, 6, 2, 2, 7},
+	'2': {7, 1, 7, 4, 7},
+	'3': {7, 1, 7, 1, 7},
+	'4': {5, 5, 7, 1, 1},
+	'5': {7, 4, 7, 1, 7},
+	'6': {7, 4, 7, 5, 7},
+	'7': {7, 1, 1, 1, 1},
+	'8': {7, 5, 7, 5, 7},
+	'9': {7, 5, 7, 1, 7},
+	'A': {2, 5, 7, 5, 5},
+	'B': {6, 5, 6, 5, 6},
+	'E': {7, 4, 6, 4, 7},
+	'L': {4, 4, 4, 4, 7},
+	'O': {7, 5, 5, 5, 7},
+	'P': {6, 5, 6, 4, 4},
+	'R': {6, 5, 6, 5, 5},
+	'S': {7, 4, 7, 1, 7},
+	'T': {7, 2, 2, 2, 2},
+	'W': {5, 5, 5, 7, 5},
+	'<': {1, 2, 4, 2, 1},
+	'>': {4, 2, 1, 2, 4},
+	'^': {2, 5, 0, 0, 0},
+	'v': {0, 0, 5, 5, 2},
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+)
+
+// A panic in a window frame, e.g. from an emulator bug or a cartridge type we
+// do not support, would close the editor and take all unsaved work with it.
+// Instead we save the session to an emergency file, tell the user what went
+// wrong and keep running.
+
+func emergencySessionPath() string {
+	return filepath.Join(os.Getenv("APPDATA"), "gameboy.emergency.speedrun")
+}
+
+// recoverFromPanic is deferred in every window frame. A panic while its own
+// message is still open means the editor cannot continue, it panics again and
+// the editor exits.
+func (s *editorState) recoverFromPanic() {
+	err := recover()
+	if err == nil {
+		return
+	}
+	frameIndex := s.emulatingFrame
+	s.emulatingFrame = -1
+	fmt.Printf("panic: %v\n%s", err, debug.Stack())
+
+	// An edit transaction that the panic interrupted would keep the editor
+	// from rendering, we apply what it changed so far.
+	if s.edit.depth > 0 {
+		s.edit.depth = 1
+		s.endEdit()
+	}
+
+	path := emergencySessionPath()
+	saveErr := s.saveEmergencySession(path)
+	if s.panicDialog != nil && s.modal == s.panicDialog {
+		panic(err)
+	}
+
+	where := "The editor ran into an error"
+	if frameIndex != -1 {
+		where = fmt.Sprintf("The editor ran into an error while emulating frame %d", frameIndex)
+	}
+	saved := fmt.Sprintf(
+		"The session was saved to %s without key frames, open it to continue.",
+		path,
+	)
+	if saveErr != nil {
+		saved = "Saving the session failed: " + saveErr.Error()
+	}
+	s.startModalMessageDialog("Error", fmt.Sprintf(
+		"%s:\n%v\n\n%s\n\nThe editor might not work correctly anymore, "+
+			"save the session under a new name and restart the editor.",
+		where, err, saved,
+	))
+	s.panicDialog = s.modal
+	s.render()
+}
+
+// saveEmergencySession writes the session with the ROM but without key frames,
+// which might be broken after a panic. They are regenerated when the session
+// is opened.
+func (s *editorState) saveEmergencySession(path string) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	session := s.sessionSnapshot(saveROM)
+	return writeSessionFile(path, func(w io.Writer) error {
+		return session.writeSession(w, s.rom, true, s.gameboyOptions.Model, nil)
+	})
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/Humpheh/goboy/gameboy"
+	"github.com/gonutz/prototype/draw"
+)
+
+// The default inputs of a branch are the buttons that are held in all frames
+// after its end. The side menu shows them as a row of small buttons.
+
+// setFutureButton presses or releases the button in the default inputs and
+// in the frames of the branch from the given frame on. If the button is used
+// later in the branch, the user decides whether to set it only up to that use
+// or to overwrite it.
+func (s *editorState) setFutureButton(from int, button gameboy.Button, down bool) {
+	if s.branchLocked() {
+		return
+	}
+	s.createInputsUpTo(from)
+
+	// The frame right after from may differ, it is what the user sees change
+	// when toggling a single frame.
+	inputs := s.branch().frameInputs
+	nextUse := -1
+	for i := from + 2; i < len(inputs); i++ {
+		if isButtonDown(inputs[i], button) != isButtonDown(inputs[i-1], button) {
+			nextUse = i
+			break
+		}
+	}
+	if nextUse == -1 {
+		s.applyFutureButton(from, len(inputs), button, down, true)
+		return
+	}
+
+	name := helpButtonNames[button]
+	action := "Release"
+	if down {
+		action = "Hold"
+	}
+	options := []string{
+		fmt.Sprintf("%s %s until frame %d, where it is used next", action, name, nextUse-1),
+		fmt.Sprintf("%s %s to the end, overwriting its later uses", action, name),
+		"Cancel",
+	}
+	title := fmt.Sprintf("%s Is Used Again at Frame %d", name, nextUse)
+	s.startModalListDialog(title, options, 0, func(i int) {
+		switch i {
+		case 0:
+			s.applyFutureButton(from, nextUse, button, down, false)
+		case 1:
+			s.applyFutureButton(from, len(s.branch().frameInputs), button, down, true)
+		}
+	})
+}
+
+// applyFutureButton sets the button in the frames from first to before end
+// and, if setDefault is set, in the default inputs.
+func (s *editorState) applyFutureButton(first, end int, button gameboy.Button, down, setDefault bool) {
+	b := s.branch()
+	if setDefault {
+		setButtonDown(&b.defaultInputs, button, down)
+		s.unsavedChanges = true
+	}
+	changed := false
+	for i := first; i < end; i++ {
+		if isButtonDown(b.frameInputs[i], button) != down {
+			setButtonDown(&b.frameInputs[i], button, down)
+			changed = true
+		}
+	}
+	if changed {
+		if down {
+			s.applyComboPolicy(first, end-first, button)
+		}
+		s.setDirtyFrame(first)
+	}
+
+	action := "released"
+	if down {
+		action = "held"
+	}
+	if setDefault {
+		s.setInfo(fmt.Sprintf("%s is %s from frame %d on.", helpButtonNames[button], action, first))
+	} else {
+		s.setInfo(fmt.Sprintf("%s is %s in frames %d to %d.", helpButtonNames[button], action, first, end-1))
+	}
+	s.render()
+}
+
+// renderDefaultInputs draws the default inputs of the active branch at y in
+// the side menu and returns the height it took. Clicking a button holds or
+// releases it from the first selected frame on.
+func (s *editorState) renderDefaultInputs(window draw.Window, menuX, y int) int {
+	const label = "Held for all future frames:"
+	theme := currentTheme()
+	labelW, labelH := window.GetTextSize(label)
+	window.DrawText(label, menuX+(inputMenuW-labelW)/2, y, theme.menuText)
+	y += labelH + 2
+
+	buttons := [gameboy.ButtonCount]struct {
+		button gameboy.Button
+		text   string
+	}{
+		{gameboy.ButtonLeft, "<"},
+		{gameboy.ButtonUp, "^"},
+		{gameboy.ButtonRight, ">"},
+		{gameboy.ButtonDown, "v"},
+		{gameboy.ButtonA, "A"},
+		{gameboy.ButtonB, "B"},
+		{gameboy.ButtonSelect, "Se"},
+		{gameboy.ButtonStart, "St"},
+	}
+	const gap = 2
+	w := (inputMenuW - 20 - (len(buttons)-1)*gap) / len(buttons)
+	h := labelH + 6
+	mouseX, mouseY := window.MousePosition()
+	leftClick := wasLeftClicked(window)
+	defaults := s.branch().defaultInputs
+	for i, b := range buttons {
+		r := rect(menuX+10+i*(w+gap), y, w, h)
+		color := theme.button
+		textColor := theme.menuText
+		if isButtonDown(defaults, b.button) {
+			color = draw.Red
+			textColor = draw.White
+		} else if r.contains(mouseX, mouseY) {
+			color = theme.buttonHover
+		}
+		r.fill(window, color)
+		textW, _ := window.GetTextSize(b.text)
+		window.DrawText(b.text, r.x+(r.w-textW)/2, r.y+3, textColor)
+		if leftClick && r.contains(mouseX, mouseY) {
+			s.setFutureButton(s.activeSelection.start(), b.button, !isButtonDown(defaults, b.button))
+		}
+	}
+	return labelH + 2 + h
+}
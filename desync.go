@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/Humpheh/goboy/gameboy"
+	"github.com/gonutz/prototype/draw"
+)
+
+// desyncCheck runs the active branch on our ROM and on another ROM, e.g. a
+// different revision or region of the game, on a background goroutine.
+// It reports the first frame at which the screens or the work RAM diverge.
+type desyncCheck struct {
+	lastFrame int
+	// emulatedFrames is the number of frames that both ROMs have run. The
+	// check's goroutine stores it, the progress box loads it.
+	emulatedFrames atomic.Int64
+	// result receives a single message and is closed afterwards.
+	result chan desyncResult
+	cancel chan struct{}
+}
+
+type desyncResult struct {
+	message  string
+	diverged bool
+}
+
+func (s *editorState) startDesyncCheck() {
+	if s.desyncCheck != nil {
+		s.setWarning("A desync check is already running.")
+		s.render()
+		return
+	}
+
+	inputs := s.branch().frameInputs
+	if len(inputs) == 0 {
+		s.setWarning("Nothing to check, the branch has no inputs.")
+		s.render()
+		return
+	}
+	// A savestate is a state of our ROM, the other revision cannot start from
+	// it.
+	if err := s.checkStart("check another ROM revision", true); err != nil {
+		s.setWarning(err.Error())
+		s.render()
+		return
+	}
+
+	s.startLoadDialog("Load Other ROM Revision", "GameBoy ROM", []string{"gb", "gbc", "bin"}, func(path string) error {
+		rom, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if len(rom) < 0x150 {
+			s.setWarning("The ROM is too small to be a Gameboy game.")
+			return nil
+		}
+
+		// We do not need any sound to compare the games.
+		options := s.gameboyOptions
+		options.Sound = false
+		// Both games start with the battery save, if there is one.
+		other, err := s.start.gameboy(rom, options)
+		if err != nil {
+			return fmt.Errorf("cannot start the other revision from the %s %s: %w", s.start.kind, s.metadata.startFile, err)
+		}
+
+		d := &desyncCheck{
+			lastFrame: len(inputs) - 1,
+			result:    make(chan desyncResult, 1),
+			cancel:    make(chan struct{}),
+		}
+		s.desyncCheck = d
+		go d.run(s.startGameboy(options), other, slices.Clone(inputs), cloneSubframeInputs(s.branch().subframeInputs))
+		return nil
+	})
+}
+
+// run emulates a, which runs our ROM, and b, which runs the other one.
+func (d *desyncCheck) run(a, b gameboy.Gameboy, inputs []inputState, subframes map[int][]subframeInput) {
+	defer close(d.result)
+
+	for i, in := range inputs {
+		select {
+		case <-d.cancel:
+			return
+		default:
+		}
+
+		applyInputs(&a, in)
+		setPollInputs(&a, subframes[i])
+		a.Step()
+		applyInputs(&b, in)
+		setPollInputs(&b, subframes[i])
+		b.Step()
+		d.emulatedFrames.Store(int64(i + 1))
+
+		if diffs := desyncDifferences(&a, &b); len(diffs) > 0 {
+			d.result <- desyncResult{
+				message: fmt.Sprintf(
+					"The revisions diverge at frame %d: %s.",
+					i, strings.Join(diffs, ", "),
+				),
+				diverged: true,
+			}
+			return
+		}
+	}
+
+	d.result <- desyncResult{
+		message: fmt.Sprintf("The revisions do not diverge in frames 0 to %d.", d.lastFrame),
+	}
+}
+
+// desyncDifferences describes how the screens and work RAM of a and b differ.
+// Other state, like the CPU registers, is expected to differ between
+// revisions and is not compared.
+func desyncDifferences(a, b *gameboy.Gameboy) []string {
+	var diffs []string
+	if a.PreparedData != b.PreparedData {
+		diffs = append(diffs, "the screens differ")
+	}
+	for i := range a.Memory.WRAM {
+		if a.Memory.WRAM[i] != b.Memory.WRAM[i] {
+			diffs = append(diffs, fmt.Sprintf("work RAM differs at offset 0x%04X", i))
+			break
+		}
+	}
+	return diffs
+}
+
+// cancelDesyncCheck stops a running desync check and waits for the background
+// goroutine to finish. It is safe to call if no check is running.
+func (s *editorState) cancelDesyncCheck() {
+	if s.desyncCheck != nil {
+		close(s.desyncCheck.cancel)
+		for range s.desyncCheck.result {
+		}
+		s.desyncCheck = nil
+	}
+}
+
+// updateDesyncCheck is called once per UI frame to display the result when the
+// check is done.
+func (s *editorState) updateDesyncCheck() {
+	d := s.desyncCheck
+	if d == nil {
+		return
+	}
+
+	select {
+	case result, ok := <-d.result:
+		if !ok {
+			return
+		}
+		s.desyncCheck = nil
+		if result.diverged {
+			s.setWarning(result.message)
+		} else {
+			s.setInfo(result.message)
+		}
+		s.render()
+	default:
+	}
+}
+
+func (s *editorState) renderDesyncProgress(window draw.Window) {
+	d := s.desyncCheck
+	if d == nil {
+		return
+	}
+
+	done := d.emulatedFrames.Load()
+	total := int64(d.lastFrame + 1)
+	text := fmt.Sprintf(
+		"Checking revisions, frame %d of %d (Escape to cancel)",
+		done, total,
+	)
+	renderProgressBox(window, text, done, total, 1)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"github.com/gonutz/prototype/draw"
+
+	"github.com/Humpheh/goboy/gameboy"
+)
+
+// displayFilters post-process the Gameboy screen in the replay view so it looks
+// more like on the real hardware.
+type displayFilters struct {
+	// grid draws dark lines between the LCD pixels.
+	grid bool
+	// scanlines darkens every other screen row.
+	scanlines bool
+	// ghosting blends every frame with the previously displayed one, like the
+	// slow DMG LCD does. Games use this for transparency by flickering
+	// sprites.
+	ghosting bool
+}
+
+// handleDisplayFilterKeys toggles the filters with F5 (grid), F6 (scanlines)
+// and F7 (ghosting).
+func (s *editorState) handleDisplayFilterKeys(window draw.Window) {
+	if gridFilterKeys.wasPressed(window) {
+		s.displayFilters.grid = !s.displayFilters.grid
+	}
+	if scanlinesKeys.wasPressed(window) {
+		s.displayFilters.scanlines = !s.displayFilters.scanlines
+	}
+	if ghostingKeys.wasPressed(window) {
+		s.displayFilters.ghosting = !s.displayFilters.ghosting
+	}
+}
+
+// ghostPixel returns the color that the LCD shows at a pixel that was last
+// showing the old color and is now set to the new color.
+func ghostPixel(old, new byte) byte {
+	return byte((int(old) + int(new)) / 2)
+}
+
+// render draws the grid and scanlines over the Gameboy screen
+// that was drawn at the given screen rectangle.
+func (f displayFilters) render(window draw.Window, screen rectangle) {
+	scaleX := float64(screen.w) / gameboy.ScreenWidth
+	scaleY := float64(screen.h) / gameboy.ScreenHeight
+
+	if f.scanlines {
+		// Scanlines are half a pixel high.
+		lineH := max(1, round(scaleY/2))
+		for y := range gameboy.ScreenHeight {
+			lineY := screen.y + round(float64(y)*scaleY+scaleY/2)
+			window.FillRect(screen.x, lineY, screen.w, lineH, draw.RGBA(0, 0, 0, 0.25))
+		}
+	}
+
+	// The grid is only visible if the pixels are big enough.
+	if f.grid && scaleX >= 3 {
+		gridColor := draw.RGBA(0, 0, 0, 0.2)
+		for x := 1; x < gameboy.ScreenWidth; x++ {
+			lineX := screen.x + round(float64(x)*scaleX)
+			window.FillRect(lineX, screen.y, 1, screen.h, gridColor)
+		}
+		for y := 1; y < gameboy.ScreenHeight; y++ {
+			lineY := screen.y + round(float64(y)*scaleY)
+			window.FillRect(screen.x, lineY, screen.w, 1, gridColor)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Humpheh/goboy/gameboy"
+)
+
+// dmgPalette holds the four colors, from lightest to darkest, that we display
+// DMG games in. The emulator always renders with ColorPalette, so changing the
+// palette does not change any emulated state. We only replace the colors when
+// displaying a screen.
+type dmgPalette [4][3]byte
+
+type namedPalette struct {
+	name   string
+	colors dmgPalette
+}
+
+var dmgPalettes = []namedPalette{
+	{name: "Classic Green", colors: gameboy.ColorPalette},
+	{name: "Grayscale", colors: dmgPalette{
+		{0xFF, 0xFF, 0xFF},
+		{0xAA, 0xAA, 0xAA},
+		{0x55, 0x55, 0x55},
+		{0x00, 0x00, 0x00},
+	}},
+	{name: "Pocket", colors: dmgPalette{
+		{0xE3, 0xE6, 0xC9},
+		{0xC3, 0xC4, 0xA5},
+		{0x8E, 0x8B, 0x61},
+		{0x6C, 0x6C, 0x4E},
+	}},
+}
+
+// customPaletteIndex is the palette index after the predefined dmgPalettes. It
+// selects the user-defined editorState.customPalette.
+var customPaletteIndex = len(dmgPalettes)
+
+func (s *editorState) displayPalette() dmgPalette {
+	if s.paletteIndex == customPaletteIndex {
+		return s.customPalette
+	}
+	return dmgPalettes[s.paletteIndex].colors
+}
+
+func (s *editorState) paletteName() string {
+	if s.paletteIndex == customPaletteIndex {
+		return "Custom"
+	}
+	return dmgPalettes[s.paletteIndex].name
+}
+
+// applyPalette replaces the colors of the emulator's ColorPalette in a DMG
+// screen by our display palette. Gameboy Color screens are left alone.
+func (s *editorState) applyPalette(screen *gameboy.Screen, gb *gameboy.Gameboy) {
+	if gb.CGBMode || s.paletteIndex == 0 {
+		return
+	}
+
+	palette := s.displayPalette()
+	for y := range screen {
+		row := screen[y][:]
+		for ; len(row) > 0; row = row[3:] {
+			for i, c := range gameboy.ColorPalette {
+				if [3]uint8(row) == c {
+					copy(row, palette[i][:])
+					break
+				}
+			}
+		}
+	}
+}
+
+// cyclePalette selects the next palette. When we reach the custom palette, the
+// user can edit its colors.
+func (s *editorState) cyclePalette() {
+	s.paletteIndex = (s.paletteIndex + 1) % (customPaletteIndex + 1)
+	if s.paletteIndex == customPaletteIndex {
+		s.startModalCustomPaletteDialog()
+	}
+	s.render()
+}
+
+func (s *editorState) startModalCustomPaletteDialog() {
+	s.startModalTextDialog(
+		"Enter 4 Colors, e.g. E0F8D0 88C070 346856 081820",
+		formatPalette(s.customPalette),
+		func(text string) {
+			palette, err := parsePalette(text)
+			if err != nil {
+				s.setWarning(err.Error())
+				return
+			}
+			s.customPalette = palette
+		},
+	)
+}
+
+func formatPalette(p dmgPalette) string {
+	colors := make([]string, len(p))
+	for i, c := range p {
+		colors[i] = fmt.Sprintf("%02X%02X%02X", c[0], c[1], c[2])
+	}
+	return strings.Join(colors, " ")
+}
+
+func parsePalette(text string) (dmgPalette, error) {
+	var p dmgPalette
+	colors := strings.Fields(strings.ReplaceAll(text, "#", ""))
+	if len(colors) != len(p) {
+		return p, fmt.Errorf("a palette needs 4 colors but %d were given", len(colors))
+	}
+	for i, c := range colors {
+		var r, g, b byte
+		if len(c) != 6 {
+			return p, fmt.Errorf("invalid color \"%s\", use 6 hex digits like E0F8D0", c)
+		}
+		if _, err := fmt.Sscanf(c, "%02x%02x%02x", &r, &g, &b); err != nil {
+			return p, fmt.Errorf("invalid color \"%s\", use 6 hex digits like E0F8D0", c)
+		}
+		p[i] = [3]byte{r, g, b}
+	}
+	return p, nil
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+// Dropping files onto the window is only supported on Windows.
+
+func acceptDroppedFiles() {}
+
+func takeDroppedFile() string {
+	return ""
+}
@@ -0,0 +1,54 @@
+//go:build windows
+
+package main
+
+import (
+	"syscall"
+
+	"github.com/gonutz/w32/v2"
+)
+
+var (
+	// dropWindow is the editor window once it accepts dropped files.
+	dropWindow w32.HWND
+	// droppedFile is the last file that was dropped onto the window and was
+	// not yet taken by takeDroppedFile.
+	droppedFile string
+	dropHandler = syscall.NewCallback(handleDropMessage)
+)
+
+// acceptDroppedFiles makes the editor window accept files dropped onto it. The
+// draw package does not give us its window handle, so we use the active window
+// of the UI thread. This is called once per UI frame until that works.
+func acceptDroppedFiles() {
+	if dropWindow != 0 {
+		return
+	}
+	window := w32.GetActiveWindow()
+	if window == 0 || !w32.SetWindowSubclass(window, dropHandler, 1, 0) {
+		return
+	}
+	w32.DragAcceptFiles(window, true)
+	dropWindow = window
+}
+
+// handleDropMessage runs on the UI thread while the window messages are
+// dispatched, so it does not need to synchronize with the editor.
+func handleDropMessage(window w32.HWND, msg uint32, w, l, id, data uintptr) uintptr {
+	if msg == w32.WM_DROPFILES {
+		drop := w32.HDROP(w)
+		// We can only open one file, if there are more, we take the first.
+		droppedFile = w32.DragQueryFile(drop, 0)
+		w32.DragFinish(drop)
+		return 0
+	}
+	return w32.DefSubclassProc(window, msg, w, l)
+}
+
+// takeDroppedFile returns the file dropped onto the window since the last
+// call or "" if there is none.
+func takeDroppedFile() string {
+	path := droppedFile
+	droppedFile = ""
+	return path
+}
@@ -0,0 +1,47 @@
+package main
+
+// An edit transaction groups several changes of the inputs into one edit.
+// Between beginEdit and endEdit, setDirtyFrame and render only remember what
+// they have to do. endEdit then throws away the emulated frames once, from
+// the earliest changed frame on, counts a single rerecord and renders once.
+// Transactions can be nested, only the outermost endEdit applies the edit.
+// A setDirtyFrame outside of a transaction is an edit of its own.
+type editTransaction struct {
+	depth int
+	// dirty is set if a frame changed, dirtyFrom is the earliest one.
+	dirty     bool
+	dirtyFrom int
+	render    bool
+}
+
+func (s *editorState) beginEdit() {
+	s.edit.depth++
+}
+
+func (s *editorState) endEdit() {
+	e := &s.edit
+	e.depth--
+	if e.depth > 0 {
+		return
+	}
+	done := *e
+	*e = editTransaction{}
+	if done.dirty {
+		// This is where an edit is complete, it is one rerecord no matter
+		// how many changes it made.
+		s.metadata.rerecordCount++
+		s.invalidateFramesFrom(done.dirtyFrom)
+	}
+	if done.render {
+		s.render()
+	}
+}
+
+// deferDirtyFrame remembers the changed frame during a transaction.
+func (s *editorState) deferDirtyFrame(frameIndex int) {
+	e := &s.edit
+	if !e.dirty || frameIndex < e.dirtyFrom {
+		e.dirtyFrom = frameIndex
+	}
+	e.dirty = true
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/Humpheh/goboy/gameboy"
+)
+
+// The emulation workers run emulation jobs for the background features, like
+// the optimizer, the fuzzer and the verification, on all CPU cores. Every
+// worker emulates its own copy of the Gameboy, they only share the ROM, which
+// is never written. All features share the same workers so running several of
+// them at once does not start more emulations than there are cores.
+
+// emulationWorkerCount is the number of workers, a feature that wants to keep
+// all cores busy submits this many jobs at a time.
+var emulationWorkerCount = runtime.NumCPU()
+
+// emulationJob emulates the inputs, starting at the state start.
+type emulationJob struct {
+	// start is only read, many jobs can start from the same state.
+	start  *gameboy.Gameboy
+	inputs []inputState
+	// subframes are the subframe inputs of inputs[i], keyed by i. It may be
+	// nil.
+	subframes map[int][]subframeInput
+	// afterFrame is called on the worker after emulating inputs[i]. If it
+	// returns false the job stops early. It may be nil.
+	afterFrame func(i int, gb *gameboy.Gameboy) bool
+}
+
+type queuedEmulationJob struct {
+	job  *emulationJob
+	done *sync.WaitGroup
+}
+
+var (
+	emulationQueue       chan queuedEmulationJob
+	startEmulationWorker sync.Once
+)
+
+// emulateJobs runs the jobs in parallel and returns once all of them are done.
+func emulateJobs(jobs []emulationJob) {
+	startEmulationWorker.Do(func() {
+		emulationQueue = make(chan queuedEmulationJob)
+		for range emulationWorkerCount {
+			go runEmulationWorker()
+		}
+	})
+
+	var done sync.WaitGroup
+	done.Add(len(jobs))
+	for i := range jobs {
+		emulationQueue <- queuedEmulationJob{job: &jobs[i], done: &done}
+	}
+	done.Wait()
+}
+
+func runEmulationWorker() {
+	for q := range emulationQueue {
+		job := q.job
+		gb := *job.start
+		for i, in := range job.inputs {
+			applyInputs(&gb, in)
+			setPollInputs(&gb, job.subframes[i])
+			gb.Step()
+			if job.afterFrame != nil && !job.afterFrame(i, &gb) {
+				break
+			}
+		}
+		q.done.Done()
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/Humpheh/goboy/gameboy"
+	"github.com/gonutz/prototype/draw"
+)
+
+// executeExportFrame shows the export formats on top of the editor or replay.
+func (state *editorState) executeExportFrame(window draw.Window) {
+	if state.replayingGame {
+		state.executeReplayFrame(newReadOnlyWindow(window))
+	} else {
+		state.executeEditorFrame(newReadOnlyWindow(window))
+	}
+
+	if cancelDialogKeys.wasPressed(window) {
+		state.exportOpen = false
+		state.render()
+		return
+	}
+
+	windowW, windowH := window.Size()
+	mouseX, mouseY := window.MousePosition()
+	leftClick := wasLeftClicked(window)
+
+	const textScale = 1.5
+	_, textH := window.GetScaledTextSize("|", textScale)
+	rowH := textH + 16
+
+	panel := rect(0, 0, 720, 16*rowH+40)
+	panel.x = (windowW - panel.w) / 2
+	panel.y = (windowH - panel.h) / 2
+	panel.fill(window, draw.Black)
+	panel.inset(5).fill(window, draw.White)
+
+	title := "Export"
+	titleW, _ := window.GetScaledTextSize(title, textScale)
+	y := panel.y + 20
+	window.DrawScaledText(title, panel.x+(panel.w-titleW)/2, y, textScale, draw.Black)
+	y += rowH
+
+	// button draws an export button with a description of what is exported.
+	button := func(text, description string) bool {
+		r := rect(panel.x+30, y, 260, rowH-6)
+		hover := r.contains(mouseX, mouseY)
+		color := draw.LightPurple
+		if hover {
+			color = draw.Purple
+		}
+		r.fill(window, color)
+		window.DrawScaledText(text, r.x+10, r.y+(r.h-textH)/2, textScale, draw.Black)
+		window.DrawScaledText(description, r.x+r.w+20, r.y+(r.h-textH)/2, textScale, draw.DarkGray)
+		y += rowH
+		return leftClick && hover
+	}
+
+	export := func(f func() error) {
+		if err := f(); err != nil {
+			state.setWarning(err.Error())
+		}
+		state.exportOpen = false
+		state.render()
+	}
+
+	selected := fmt.Sprintf("%d selected frames", state.activeSelection.count())
+
+	if button("Contact Sheet (PNG)", selected) {
+		export(state.exportContactSheet)
+	}
+
+	if button("Animation (GIF)", selected) {
+		export(state.exportGIF)
+	}
+
+	if button("Game Boy Interface", "whole branch, for consoles") {
+		export(state.exportGBI)
+	}
+
+	if button("lsnes Movie", "whole branch, for lsnes and Gambatte") {
+		export(state.exportLSMV)
+	}
+
+	if button("Verification Bundle", "zip of the branch, ROM hashes and settings") {
+		export(state.exportVerificationBundle)
+	}
+
+	if button("Battery Save (SAV)", "cartridge RAM at the first selected frame") {
+		export(state.exportBatterySave)
+	}
+
+	if button("Start From Save", "new session from a battery save or savestate") {
+		export(func() error { return state.startNewSessionFrom(window) })
+	}
+
+	if button("Inputs Only", "small session, key frames are rebuilt on load") {
+		export(state.saveInputsOnly)
+	}
+
+	if button("For Sharing", "inputs only, without the ROM") {
+		export(state.saveForSharing)
+	}
+
+	if button("Session Patch", "changes since a baseline speedrun file") {
+		export(state.exportPatch)
+	}
+
+	if button("Apply Patch", "load changes from a session patch") {
+		export(state.applyPatch)
+	}
+
+	if button("Merge Session", "import the branches of another speedrun") {
+		export(state.mergeSession)
+	}
+
+	if button("RAM Map", "import address names, CSV or JSON") {
+		export(state.importRAMMap)
+	}
+
+	y += rowH / 2
+	if button("Close", "") {
+		state.exportOpen = false
+		state.render()
+	}
+}
+
+// exportGBI saves the inputs of the current branch for the Game Boy Interface
+// which plays them back on a real console for verification. Each line holds
+// the time at which the buttons change and the new buttons, both in hex:
+//
+//	0059af35 0008
+//
+// The time is counted in the Gameboy's machine cycles (1 MiHz) since power-on
+// and the buttons use the Gameboy's bit order A, B, Select, Start, Right,
+// Left, Up, Down, starting at the lowest bit.
+func (s *editorState) exportGBI() error {
+	// The console can start with the battery save on the cartridge.
+	if err := s.checkStart("export to GBI", true); err != nil {
+		return err
+	}
+	// The emulator starts after the boot ROM, GBI starts counting at power-on.
+	model := s.gameboyOptions.Model
+	bootCycles, ok := model.BootCycles()
+	if !ok {
+		return fmt.Errorf("cannot export to GBI, the boot ROM duration of the %s is not known", model)
+	}
+	b := s.branch()
+	for _, inputs := range b.frameInputs {
+		if inputs&frameEvents != 0 {
+			return fmt.Errorf("cannot export resets and power cycles to GBI")
+		}
+	}
+
+	s.startSaveDialog("Export Game Boy Interface Inputs", "GBI Inputs", "txt", func(path string) error {
+		var buf bytes.Buffer
+		last := inputState(0)
+		for frame, inputs := range b.frameInputs {
+			if frame == 0 || inputs != last {
+				// A machine cycle is 4 of the CPU's clock cycles.
+				machineCycles := (bootCycles + frame*gameboy.CyclesPerFrame) / 4
+				fmt.Fprintf(&buf, "%08x %04x\n", machineCycles, uint16(inputs))
+				last = inputs
+			}
+		}
+
+		if err := os.WriteFile(path, buf.Bytes(), 0666); err != nil {
+			return fmt.Errorf("failed to export '%s': %w", path, err)
+		}
+
+		s.setInfo(fmt.Sprintf("Exported %d frames to %s", len(b.frameInputs), path))
+		return nil
+	})
+	return nil
+}
+
+// exportBatterySave writes the cartridge RAM at the first selected frame as a
+// .sav file, like other emulators and flash carts use for battery saves. The
+// RAM is part of the emulator state, so the session already keeps it, this
+// only makes it usable outside the editor.
+func (s *editorState) exportBatterySave() error {
+	h, ok := parseROMHeader(s.rom)
+	if !ok || !h.hasBattery() {
+		return fmt.Errorf("the cartridge has no battery, the game cannot save")
+	}
+	frameIndex := s.activeSelection.start()
+	gb := s.generateFrame(frameIndex)
+	data := gb.Memory.Cart.GetSaveData()
+	size := h.batteryRAMSize()
+	if size == 0 || len(data) < size {
+		return fmt.Errorf("the RAM of %s cartridges is not emulated", h.cartTypeName())
+	}
+	data = data[:size]
+
+	s.startSaveDialog("Export Battery Save", "Battery Save", "sav", func(path string) error {
+		if err := os.WriteFile(path, data, 0666); err != nil {
+			return fmt.Errorf("failed to export '%s': %w", path, err)
+		}
+		s.setInfo(fmt.Sprintf("Exported the cartridge RAM of frame %d to %s", frameIndex, path))
+		return nil
+	})
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/gonutz/prototype/draw"
+	"github.com/sqweek/dialog"
+)
+
+// fileDialog is a native file dialog that runs on its own goroutine, so the
+// editor keeps rendering while it is open. The editor does not take any input
+// until it is closed.
+type fileDialog struct {
+	// done receives the chosen path, or "" if the dialog was cancelled.
+	done chan string
+	// path is the result once done delivered it.
+	path     string
+	finished bool
+	chosen   func(path string) error
+}
+
+// startLoadDialog asks the user for an existing file with one of the
+// extensions. chosen is called on the UI goroutine with the file's path,
+// unless the user cancels the dialog. If it returns an error, it is shown as
+// a warning.
+func (s *editorState) startLoadDialog(title, filter string, extensions []string, chosen func(path string) error) {
+	b := dialog.File().Title(title).Filter(filter, extensions...)
+	s.startFileDialog(b.Load, chosen)
+}
+
+// startSaveDialog is like startLoadDialog but asks for a file to save to. The
+// extension is appended to the path if it is missing.
+func (s *editorState) startSaveDialog(title, filter, extension string, chosen func(path string) error) {
+	b := dialog.File().Title(title).Filter(filter, extension)
+	s.startFileDialog(b.Save, func(path string) error {
+		if !strings.HasSuffix(strings.ToLower(path), "."+extension) {
+			path += "." + extension
+		}
+		return chosen(path)
+	})
+}
+
+func (s *editorState) startFileDialog(run func() (string, error), chosen func(path string) error) {
+	if s.fileDialog != nil {
+		return
+	}
+
+	d := &fileDialog{
+		done:   make(chan string, 1),
+		chosen: chosen,
+	}
+	s.fileDialog = d
+
+	go func() {
+		// The dialog's error state is stored per thread.
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+
+		path, err := run()
+		if err != nil && err != dialog.ErrCancelled {
+			fmt.Println("file dialog failed:", err)
+		}
+		if err != nil {
+			path = ""
+		}
+		d.done <- path
+	}()
+}
+
+// executeFileDialogFrame shows the editor or replay without letting them
+// take input while a file dialog is open.
+func (state *editorState) executeFileDialogFrame(window draw.Window) {
+	if state.replayingGame {
+		state.executeReplayFrame(newReadOnlyWindow(window))
+	} else {
+		state.executeEditorFrame(newReadOnlyWindow(window))
+	}
+
+	d := state.fileDialog
+	if !d.finished {
+		select {
+		case d.path = <-d.done:
+			d.finished = true
+		default:
+		}
+	}
+
+	// Double clicking a file in the dialog closes it when the mouse button
+	// goes down the second time. We wait for it to be released so the editor
+	// does not take it for the start of a selection.
+	if !d.finished || window.IsMouseDown(draw.LeftButton) {
+		renderFileDialogNote(window)
+		return
+	}
+
+	state.fileDialog = nil
+	if d.path != "" {
+		if err := d.chosen(d.path); err != nil {
+			state.setWarning(err.Error())
+		}
+	}
+	state.render()
+}
+
+func renderFileDialogNote(window draw.Window) {
+	windowW, windowH := window.Size()
+	const text = "Waiting for the file dialog..."
+	const textScale = 2
+	textW, textH := window.GetScaledTextSize(text, textScale)
+	box := rect((windowW-textW-40)/2, (windowH-textH-20)/2, textW+40, textH+20)
+	box.fill(window, draw.Black)
+	box.inset(2).fill(window, draw.DarkGray)
+	window.DrawScaledText(text, box.x+20, box.y+10, textScale, draw.White)
+}
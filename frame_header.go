@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/Humpheh/goboy/gameboy"
+	"github.com/gonutz/prototype/draw"
+)
+
+// The frame header is pinned above the grid. It shows the frame under the
+// mouse, or the selected frame, in large text, because the captions above the
+// frames become unreadable when zoomed out.
+const frameHeaderTextScale = 2
+
+// frameHeaderHeight is the space above the grid that the header takes, 0 if it
+// is hidden.
+func (s *editorState) frameHeaderHeight(window draw.Window) int {
+	if s.hideFrameHeader {
+		return 0
+	}
+	_, textH := window.GetScaledTextSize("|", frameHeaderTextScale)
+	return textH + 8
+}
+
+// formatFrameInputs lists the pressed buttons and frame events the way the
+// captions above the frames show them, each with a leading space, e.g. " < A".
+func formatFrameInputs(inputs inputState) string {
+	text := ""
+	add := func(b gameboy.Button, pressed string) {
+		if isButtonDown(inputs, b) {
+			text += " " + pressed
+		}
+	}
+	add(gameboy.ButtonLeft, "<")
+	add(gameboy.ButtonUp, "^")
+	add(gameboy.ButtonRight, ">")
+	add(gameboy.ButtonDown, "v")
+	add(gameboy.ButtonA, "A")
+	add(gameboy.ButtonB, "B")
+	add(gameboy.ButtonSelect, "Sel")
+	add(gameboy.ButtonStart, "Start")
+	if inputs&powerCycleEvent != 0 {
+		text += " POWER"
+	} else if inputs&resetEvent != 0 {
+		text += " RESET"
+	}
+	return text
+}
+
+// frameHeaderText describes the frame, e.g.
+// "Frame 1234   0:20.56   < A   2 polls from 40%   Split: Menu".
+func (s *editorState) frameHeaderText(frameIndex int) string {
+	text := "Frame " + strconv.Itoa(frameIndex)
+	text += "   " + formatRunTime(framesToDuration(frameIndex))
+
+	inputs := formatFrameInputs(s.inputsAt(frameIndex))
+	if inputs == "" {
+		inputs = " no buttons"
+	}
+	text += "  " + inputs
+
+	gb := s.generateFrame(frameIndex)
+	text += "   " + formatPolls(&gb)
+
+	b := s.branch()
+	if changes, ok := b.subframeInputs[frameIndex]; ok {
+		text += "   Subframe: " + formatSubframeInputs(changes)
+	}
+	if frameIndex == b.highlightFrameIndex {
+		text += "   Highlighted"
+	}
+	if i := b.splitIndex(frameIndex); i != -1 {
+		text += fmt.Sprintf("   Split: %s", b.splits[i].name)
+	}
+	if anchor := s.syncAnchorState(frameIndex); anchor != "" {
+		text += "   Sync " + anchor
+	}
+	return text
+}
+
+// renderFrameHeader draws the header over the width w of the grid.
+func (s *editorState) renderFrameHeader(window draw.Window, frameIndex, w int) {
+	h := s.frameHeaderHeight(window)
+	if h == 0 {
+		return
+	}
+	theme := currentTheme()
+	window.FillRect(0, 0, w, h, theme.menuBackground)
+	window.DrawScaledText(s.frameHeaderText(frameIndex), 8, 4, frameHeaderTextScale, theme.menuText)
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/Humpheh/goboy/gameboy"
+	"github.com/gonutz/prototype/draw"
+)
+
+// The fuzzer hunts for glitches. It replaces the inputs of a frame range with
+// random ones, many trials in parallel on the emulation workers, and checks a
+// condition over the memory after every frame, e.g. that an out-of-bounds flag
+// is set. The inputs of every trial that meets the condition are saved as a
+// new branch. It runs until the user stops it or until it found
+// fuzzerMaxHits hits.
+const fuzzerMaxHits = 10
+
+// parseCondition parses a comparison of two expressions like the ones of the
+// optimizer's objective, e.g. "[D35E] >= 0x40" or "[Map] != 3".
+func parseCondition(text string, names ramMap) (func(gb *gameboy.Gameboy) bool, error) {
+	p := objectiveParser{text: strings.TrimSpace(text), names: names}
+	left, err := p.sum()
+	if err != nil {
+		return nil, fmt.Errorf("invalid condition: %w", err)
+	}
+
+	p.skipSpace()
+	var op string
+	for _, o := range []string{"==", "!=", "<=", ">=", "=", "<", ">"} {
+		if strings.HasPrefix(p.text[p.pos:], o) {
+			op = o
+			p.pos += len(o)
+			break
+		}
+	}
+	if op == "" {
+		return nil, errors.New("invalid condition: expected a comparison like =, != , < or >=")
+	}
+
+	right, err := p.sum()
+	if err == nil && p.skipSpace() < len(p.text) {
+		err = fmt.Errorf("unexpected '%s'", p.text[p.pos:])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid condition: %w", err)
+	}
+
+	compare := map[string]func(a, b int) bool{
+		"=":  func(a, b int) bool { return a == b },
+		"==": func(a, b int) bool { return a == b },
+		"!=": func(a, b int) bool { return a != b },
+		"<":  func(a, b int) bool { return a < b },
+		"<=": func(a, b int) bool { return a <= b },
+		">":  func(a, b int) bool { return a > b },
+		">=": func(a, b int) bool { return a >= b },
+	}[op]
+	return func(gb *gameboy.Gameboy) bool { return compare(left(gb), right(gb)) }, nil
+}
+
+type fuzzer struct {
+	condition     string
+	first         int
+	allowCombos   bool
+	branchName    string
+	branchInputs  []inputState
+	branchDefault inputState
+	// subframes are the branch's subframe inputs in the range, keyed by the
+	// index in the range. Every trial keeps them.
+	subframes map[int][]subframeInput
+	// trials and hits are written by the background goroutine and read by the
+	// UI to display the progress.
+	trials atomic.Int64
+	hits   atomic.Int64
+	// found receives the inputs of every hit, it is closed when the fuzzer
+	// stops.
+	found  chan fuzzHit
+	cancel chan struct{}
+}
+
+type fuzzHit struct {
+	// inputs are the range's inputs up to and including the frame that met
+	// the condition.
+	inputs []inputState
+	frame  int
+}
+
+// startFuzzer asks for the condition and the buttons that may be pressed and
+// fuzzes the selected frames.
+func (s *editorState) startFuzzer() {
+	if s.fuzzer != nil {
+		s.setWarning("The fuzzer is already running.")
+		return
+	}
+
+	first, end := s.activeSelection.start(), s.activeSelection.end()
+	s.startModalTextDialog(
+		fmt.Sprintf("Fuzz Frames %d to %d Until, e.g. [D35E] >= 40 or [C0A2] != 0", first, end-1),
+		"",
+		func(text string) {
+			condition, err := parseCondition(text, s.ramMap)
+			if err != nil {
+				s.setWarning(err.Error())
+				return
+			}
+			s.startModalTextDialog("Buttons the Fuzzer May Press, e.g. leave out start", "a,b,select,left,right,up,down", func(buttons string) {
+				allowed, err := parseButtonList(buttons)
+				if err != nil {
+					s.setWarning(err.Error())
+					return
+				}
+				s.runFuzzer(strings.TrimSpace(text), condition, first, end, allowed)
+			})
+		},
+	)
+}
+
+func (s *editorState) runFuzzer(text string, condition func(gb *gameboy.Gameboy) bool, first, end int, allowed inputState) {
+	// Frame events, like resets, are kept in every trial.
+	events := make([]inputState, end-first)
+	for i := range events {
+		events[i] = s.inputsAt(first+i) & frameEvents
+	}
+
+	var start gameboy.Gameboy
+	if first == 0 {
+		start = s.startGameboy(s.gameboyOptions)
+	} else {
+		start = s.generateFrame(first - 1)
+	}
+	// We do not need any sound to fuzz.
+	start.Options.Sound = false
+
+	f := &fuzzer{
+		condition:     text,
+		first:         first,
+		allowCombos:   s.comboPolicy == allowCombos,
+		branchName:    s.branch().name,
+		branchInputs:  slices.Clone(s.branch().frameInputs),
+		branchDefault: s.branch().defaultInputs,
+		subframes:     shiftedSubframes(maps.Clone(s.branch().subframeInputs), first),
+		found:         make(chan fuzzHit, fuzzerMaxHits),
+		cancel:        make(chan struct{}),
+	}
+	s.fuzzer = f
+
+	go f.run(start, events, allowed, condition)
+}
+
+// randomInputs holds random buttons for random runs of frames, like a player
+// would, instead of changing the buttons every frame.
+func (f *fuzzer) randomInputs(events []inputState, allowed inputState, rng *rand.Rand) []inputState {
+	inputs := make([]inputState, len(events))
+	for i := 0; i < len(inputs); {
+		var in inputState
+		for {
+			in = inputState(rng.Uint32()) & allowed
+			if f.allowCombos || !hasIllegalCombo(in) {
+				break
+			}
+		}
+		for n := 1 + rng.IntN(16); n > 0 && i < len(inputs); n-- {
+			inputs[i] = in | events[i]
+			i++
+		}
+	}
+	return inputs
+}
+
+// run emulates rounds of trials on the emulation workers until the fuzzer is
+// stopped or found enough hits.
+func (f *fuzzer) run(start gameboy.Gameboy, events []inputState, allowed inputState, condition func(gb *gameboy.Gameboy) bool) {
+	defer close(f.found)
+
+	rng := rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+	jobs := make([]emulationJob, emulationWorkerCount)
+	for f.hits.Load() < fuzzerMaxHits {
+		select {
+		case <-f.cancel:
+			return
+		default:
+		}
+
+		for i := range jobs {
+			inputs := f.randomInputs(events, allowed, rng)
+			jobs[i] = emulationJob{
+				start:     &start,
+				inputs:    inputs,
+				subframes: f.subframes,
+				afterFrame: func(frame int, gb *gameboy.Gameboy) bool {
+					select {
+					case <-f.cancel:
+						return false
+					default:
+					}
+					if !condition(gb) {
+						return true
+					}
+					if f.hits.Add(1) <= fuzzerMaxHits {
+						f.found <- fuzzHit{inputs: inputs[:frame+1], frame: f.first + frame}
+					}
+					return false
+				},
+			}
+		}
+		emulateJobs(jobs)
+		f.trials.Add(int64(len(jobs)))
+	}
+}
+
+// stopFuzzer stops the fuzzer, hits that are already found are kept. It is
+// safe to call if the fuzzer is not running.
+func (s *editorState) stopFuzzer() {
+	if s.fuzzer == nil {
+		return
+	}
+	close(s.fuzzer.cancel)
+	for hit := range s.fuzzer.found {
+		s.addFuzzHit(hit)
+	}
+	s.finishFuzzer()
+}
+
+// cancelFuzzer stops the fuzzer and drops its hits. It is safe to call if the
+// fuzzer is not running.
+func (s *editorState) cancelFuzzer() {
+	if s.fuzzer != nil {
+		close(s.fuzzer.cancel)
+		for range s.fuzzer.found {
+		}
+		s.fuzzer = nil
+	}
+}
+
+// updateFuzzer is called once per UI frame to turn new hits into branches.
+func (s *editorState) updateFuzzer() {
+	if s.fuzzer == nil {
+		return
+	}
+	for {
+		select {
+		case hit, ok := <-s.fuzzer.found:
+			if !ok {
+				s.finishFuzzer()
+				return
+			}
+			s.addFuzzHit(hit)
+		default:
+			return
+		}
+	}
+}
+
+// addFuzzHit adds a branch with the inputs before the fuzzed range followed by
+// the inputs of the hit. It ends at the frame that met the condition.
+func (s *editorState) addFuzzHit(hit fuzzHit) {
+	f := s.fuzzer
+	inputs := slices.Clone(f.branchInputs[:min(f.first, len(f.branchInputs))])
+	for len(inputs) < f.first {
+		inputs = append(inputs, f.branchDefault)
+	}
+	inputs = append(inputs, hit.inputs...)
+
+	s.branches = append(s.branches, branch{
+		name:                fmt.Sprintf("%s (fuzz hit at %d)", f.branchName, hit.frame),
+		frameInputs:         inputs,
+		defaultInputs:       f.branchDefault,
+		highlightFrameIndex: hit.frame,
+	})
+	s.unsavedChanges = true
+	s.render()
+}
+
+func (s *editorState) finishFuzzer() {
+	f := s.fuzzer
+	s.fuzzer = nil
+	hits := min(f.hits.Load(), fuzzerMaxHits)
+	if hits == 0 {
+		s.setInfo(fmt.Sprintf("No trial met %s in %d trials.", f.condition, f.trials.Load()))
+	} else {
+		s.setInfo(fmt.Sprintf(
+			"%d of %d trials met %s, they are saved as new branches.",
+			hits, f.trials.Load(), f.condition,
+		))
+	}
+	s.render()
+}
+
+func (s *editorState) renderFuzzerProgress(window draw.Window) {
+	f := s.fuzzer
+	if f == nil {
+		return
+	}
+
+	hits := min(f.hits.Load(), fuzzerMaxHits)
+	text := fmt.Sprintf(
+		"Fuzzing until %s, %d hits in %d trials (Escape to stop)",
+		f.condition, hits, f.trials.Load(),
+	)
+	renderProgressBox(window, text, hits, fuzzerMaxHits, 5)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// A gameProfile keeps the preferences that belong to a game rather than to a
+// session. It is remembered whenever a session is saved and applied when a
+// new session is started for the same ROM, e.g. so the RAM map of a game does
+// not have to be imported again for every new session.
+//
+// The profiles of all games are stored in one JSON file, keyed by the SHA-256
+// of the ROM.
+type gameProfile struct {
+	Palette       int
+	CustomPalette dmgPalette
+	// PollCadence is as parsePollCadence reads it, "" for every frame.
+	PollCadence string `json:",omitempty"`
+	RAMMap      ramMap `json:",omitempty"`
+	// Watchpoint is the address the last session watched, as
+	// parseWatchpoint reads it, "" if there was none.
+	Watchpoint string `json:",omitempty"`
+	// SplitNames are the names of the splits of the last session. New
+	// splits are named after them in order, see splitNameTemplate.
+	SplitNames []string `json:",omitempty"`
+}
+
+func gameProfilesPath() string {
+	return filepath.Join(os.Getenv("APPDATA"), "gameboy.profiles")
+}
+
+func romKey(rom []byte) string {
+	hash := sha256.Sum256(rom)
+	return hex.EncodeToString(hash[:])
+}
+
+// loadGameProfiles returns no profiles if the file does not exist or is
+// invalid.
+func loadGameProfiles() map[string]gameProfile {
+	profiles := make(map[string]gameProfile)
+	data, err := os.ReadFile(gameProfilesPath())
+	if err != nil {
+		return profiles
+	}
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		fmt.Println("reading game profiles failed:", err)
+		return make(map[string]gameProfile)
+	}
+	return profiles
+}
+
+// rememberGameProfile stores the game's preferences of the current session.
+func (s *editorState) rememberGameProfile() {
+	if len(s.rom) == 0 {
+		return
+	}
+
+	p := gameProfile{
+		Palette:       s.paletteIndex,
+		CustomPalette: s.customPalette,
+		RAMMap:        s.ramMap,
+	}
+	if s.pollCadence.frames() > 1 {
+		p.PollCadence = s.pollCadence.String()
+	}
+	if w := s.watchpoint; w != nil {
+		p.Watchpoint = fmt.Sprintf("%04X", w.address)
+		if w.hasValue {
+			p.Watchpoint += fmt.Sprintf("=%02X", w.value)
+		}
+	}
+	for _, sp := range s.branch().splits {
+		p.SplitNames = append(p.SplitNames, sp.name)
+	}
+	if len(p.SplitNames) == 0 {
+		// A branch without splits should not forget the names.
+		p.SplitNames = s.splitNameTemplate
+	}
+
+	profiles := loadGameProfiles()
+	profiles[romKey(s.rom)] = p
+	data, err := json.MarshalIndent(profiles, "", "\t")
+	if err == nil {
+		err = os.WriteFile(gameProfilesPath(), data, 0666)
+	}
+	if err != nil {
+		fmt.Println("saving game profiles failed:", err)
+	}
+}
+
+// applyGameProfile sets up a new session with the preferences of the last
+// session of the same game, if there was one.
+func (s *editorState) applyGameProfile() {
+	p, ok := loadGameProfiles()[romKey(s.rom)]
+	if !ok {
+		return
+	}
+
+	if 0 <= p.Palette && p.Palette <= customPaletteIndex {
+		s.paletteIndex = p.Palette
+	}
+	s.customPalette = p.CustomPalette
+	if cadence, err := parsePollCadence(p.PollCadence); err == nil {
+		s.pollCadence = cadence
+	}
+	s.ramMap = p.RAMMap
+	if p.Watchpoint != "" {
+		if w, err := parseWatchpoint(p.Watchpoint, s.ramMap); err == nil {
+			s.watchpoint = w
+		}
+	}
+	s.splitNameTemplate = p.SplitNames
+}
@@ -0,0 +1,605 @@
+package gameboy
+
+import "math"
+
+const (
+	SampleRate = 44100
+	twoPi      = 2 * math.Pi
+
+	// samplesPerFrame is the number of sound samples in a single frame.
+	samplesPerFrame = SampleRate / FramesSecond
+	// maxSamplesPerFrame leaves room for frames that run a few cycles longer
+	// than CyclesPerFrame.
+	maxSamplesPerFrame = samplesPerFrame + 8
+
+	// frameSequencerCycles is the number of CPU cycles per step of the 512 Hz
+	// frame sequencer.
+	frameSequencerCycles = ClockSpeed / 512
+)
+
+// APU is the GameBoy's audio processing unit. Audio is comprised of four
+// channels, each one controlled by a set of registers.
+//
+// Channels 1 and 2 are both Square channels, channel 3 is a arbitrary
+// waveform channel which can be set in RAM, and channel 4 outputs noise.
+type APU struct {
+	Memory      [52]byte
+	Channel1    Channel
+	Channel2    Channel
+	Channel3    Channel
+	Channel4    Channel
+	LeftVolume  float64
+	RightVolume float64
+	// WaveformRam holds the 32 4-bit samples of channel 3, two per byte with
+	// the first sample in the upper nibble.
+	WaveformRam [0x10]byte
+
+	// SampleCycles accumulates CPU cycles, scaled by the sample rate, until
+	// the next sample is due.
+	SampleCycles int32
+	// Samples holds the SampleCount sound samples of the current frame.
+	Samples     [maxSamplesPerFrame]byte
+	SampleCount int32
+	// ChannelSamples holds the output of each of the four channels for the
+	// SampleCount samples of the current frame.
+	ChannelSamples [4][maxSamplesPerFrame]byte
+
+	// FrameSequencerCycles accumulates CPU cycles until the next step of the
+	// frame sequencer, FrameSequencerStep is the next step, from 0 to 7.
+	FrameSequencerCycles int32
+	FrameSequencerStep   byte
+}
+
+// Init the sound emulation for a Gameboy.
+func (a *APU) Init() {
+	for i := range a.WaveformRam {
+		a.WaveformRam[i] = 0
+	}
+
+	a.Channel1 = NewChannel()
+	a.Channel2 = NewChannel()
+	a.Channel3 = NewChannel()
+	a.Channel4 = NewChannel()
+}
+
+// update advances the sound by the given number of CPU cycles. The frame
+// sequencer and channel 3's wave position always run, what they do shows in
+// what the game reads, e.g. NR52 and the waveform RAM. With generate set,
+// update also creates the samples for this time and appends them to Samples.
+func (a *APU) update(cycles int, generate bool) {
+	a.FrameSequencerCycles += int32(cycles)
+	for a.FrameSequencerCycles >= frameSequencerCycles {
+		a.FrameSequencerCycles -= frameSequencerCycles
+		a.clockFrameSequencer()
+	}
+	a.clockWave(cycles)
+	if !generate {
+		return
+	}
+
+	a.SampleCycles += int32(cycles) * SampleRate
+	for a.SampleCycles >= ClockSpeed {
+		a.SampleCycles -= ClockSpeed
+		if int(a.SampleCount) < len(a.Samples) {
+			a.Samples[a.SampleCount] = a.sample()
+			a.SampleCount++
+		}
+	}
+}
+
+// sample mixes the four channels into a single output sample.
+func (a *APU) sample() byte {
+	// TODO: output stereo channels instead of combining
+	vol := (a.LeftVolume + a.RightVolume) / 10
+	channels := [4]uint16{
+		a.Channel1.Sample(a),
+		a.Channel2.Sample(a),
+		a.Channel3.Sample(a),
+		a.Channel4.Sample(a),
+	}
+	for i, c := range channels {
+		a.ChannelSamples[i][a.SampleCount] = byte(c)
+	}
+	val := (channels[0] + channels[1] + channels[2] + channels[3]) / 4
+	return byte(float64(val) * vol)
+}
+
+// FrameSamples returns the sound samples that were generated during the last
+// call to Gameboy.Step.
+func (a *APU) FrameSamples() []byte {
+	return a.Samples[:a.SampleCount]
+}
+
+// FrameChannelSamples returns the output of the given channel (0 to 3) that
+// was generated during the last call to Gameboy.Step.
+func (a *APU) FrameChannelSamples(channel int) []byte {
+	return a.ChannelSamples[channel][:a.SampleCount]
+}
+
+var soundMask = []byte{
+	/* 0xFF10 */ 0xFF, 0xC0, 0xFF, 0x00, 0x40,
+	/* 0xFF15 */ 0x00, 0xC0, 0xFF, 0x00, 0x40,
+	/* 0xFF1A */ 0x80, 0x00, 0x60, 0x00, 0x40,
+	/* 0xFF20 */ 0x00, 0x3F, 0xFF, 0xFF, 0x40,
+	/* 0xFF24 */ 0xFF, 0xFF, 0x80,
+}
+
+// sound3VolumeShift maps channel 3's output level code in NR32 to the number
+// of bits that each 4-bit sample is shifted right. A shift of 4 mutes it.
+var sound3VolumeShift = [4]byte{4, 0, 1, 2}
+
+// Read returns a value from the APU.
+func (a *APU) Read(address uint16) byte {
+	if address >= 0xFF30 {
+		return a.WaveformRam[a.waveRamIndex(address)]
+	}
+	if address == 0xFF26 {
+		// The lower bits tell whether each channel is enabled.
+		status := a.Memory[0x26]&0x80 | 0x70
+		for i, chn := range []*Channel{&a.Channel1, &a.Channel2, &a.Channel3, &a.Channel4} {
+			if chn.Enabled {
+				status |= 1 << i
+			}
+		}
+		return status
+	}
+	return a.Memory[address-0xFF00] & soundMask[address-0xFF10]
+}
+
+// Write a value to the APU registers.
+func (a *APU) Write(address uint16, value byte) {
+	a.Memory[address-0xFF00] = value
+
+	switch address {
+	// Channel 1
+	case 0xFF14:
+		if address == 0xFF14 && value&0x80 == 0x80 {
+			a.start1()
+		}
+		frequencyValue := uint16(a.Memory[0x14]&0x7)<<8 | uint16(a.Memory[0x13])
+		a.Channel1.Frequency = 131072 / (2048 - float64(frequencyValue))
+	case 0xFF11:
+		a.Channel1.LengthCounter = 64 - int32(value&0x3F)
+		pattern := (a.Memory[0x11] & 0xC0) >> 6
+		a.Channel1.Generator = Square(squareLimits[pattern])
+
+	case 0xFF12:
+		a.Channel1.setDAC(value&0xF8 != 0)
+
+	// Channel 2
+	case 0xFF19:
+		if address == 0xFF19 && value&0x80 == 0x80 {
+			a.start2()
+		}
+		frequencyValue := uint16(a.Memory[0x19]&0x7)<<8 | uint16(a.Memory[0x18])
+		a.Channel2.Frequency = 131072 / (2048 - float64(frequencyValue))
+	case 0xFF16:
+		a.Channel2.LengthCounter = 64 - int32(value&0x3F)
+		pattern := (a.Memory[0x16] & 0xC0) >> 6
+		a.Channel2.Generator = Square(squareLimits[pattern])
+
+	case 0xFF17:
+		a.Channel2.setDAC(value&0xF8 != 0)
+
+	// Channel 3
+	case 0xFF1A:
+		a.Channel3.setDAC(value&0x80 != 0)
+	case 0xFF1B:
+		a.Channel3.LengthCounter = 256 - int32(value)
+	case 0xFF1E, 0xFF1F:
+		if address == 0xFF1E && value&0x80 == 0x80 {
+			a.start3()
+		}
+		frequencyValue := uint16(a.Memory[0x1E]&0x7)<<8 | uint16(a.Memory[0x1D])
+		a.Channel3.Frequency = 65536 / (2048 - float64(frequencyValue))
+
+	// Channel 4
+	case 0xFF20:
+		a.Channel4.LengthCounter = 64 - int32(value&0x3F)
+	case 0xFF21:
+		a.Channel4.setDAC(value&0xF8 != 0)
+	case 0xFF22:
+		shiftClock := float64((value & 0xF0) >> 4)
+		divRatio := float64(value & 0x7)
+		if divRatio == 0 {
+			divRatio = 0.5
+		}
+		a.Channel4.Frequency = 524288 / divRatio / math.Pow(2, shiftClock+1)
+		if shiftClock >= 14 {
+			// Shift clocks 14 and 15 do not clock the LFSR at all.
+			a.Channel4.Frequency = 0
+		}
+	case 0xFF23:
+		if value&0x80 == 0x80 {
+			a.Channel4.Generator = Noise()
+			a.start4()
+		}
+
+	case 0xFF24:
+		// Volume control
+		a.LeftVolume = float64((a.Memory[0x24]&0x70)>>4) / 7
+		a.RightVolume = float64(a.Memory[0x24]&0x7) / 7
+
+	case 0xFF25:
+		// Channel control
+		// Right output for each channel
+		output1r := a.Memory[0x25]&0x1 == 0x1
+		output2r := a.Memory[0x25]&0x2 == 0x2
+		output3r := a.Memory[0x25]&0x4 == 0x4
+		output4r := a.Memory[0x25]&0x8 == 0x8
+
+		// Left output for each channel
+		output1l := a.Memory[0x25]&0x10 == 0x10
+		output2l := a.Memory[0x25]&0x20 == 0x20
+		output3l := a.Memory[0x25]&0x40 == 0x40
+		output4l := a.Memory[0x25]&0x80 == 0x80
+
+		a.Channel1.On = output1r || output1l
+		a.Channel2.On = output2r || output2l
+		a.Channel3.On = output3r || output3l
+		a.Channel4.On = output4r || output4l
+	}
+	// TODO: if writing to FF26 bit 7 destroy all contents (also cannot access)
+}
+
+// WriteWaveform writes a value to the waveform ram.
+func (a *APU) WriteWaveform(address uint16, value byte) {
+	a.WaveformRam[a.waveRamIndex(address)] = value
+}
+
+// waveRamIndex returns the index into WaveformRam that the CPU accesses at the
+// given address. While channel 3 is playing, the CPU can only access the byte
+// that is currently being played, regardless of the address.
+func (a *APU) waveRamIndex(address uint16) byte {
+	if a.Channel3.Enabled {
+		return a.Channel3.Generator.Position / 2
+	}
+	return byte(address - 0xFF30)
+}
+
+// Start the 1st sound channel.
+func (a *APU) start1() {
+	a.Channel1.trigger(64)
+	a.Channel1.startEnvelope(a.Memory[0x12])
+
+	// The sweep works on a copy of the frequency register.
+	a.Channel1.ShadowFrequency = uint16(a.Memory[0x14]&0x7)<<8 | uint16(a.Memory[0x13])
+	a.Channel1.SweepTimer = a.sweepPeriod()
+	shift := a.Memory[0x10] & 0x7
+	a.Channel1.SweepEnabled = a.Memory[0x10]&0x70 != 0 || shift != 0
+	if shift != 0 {
+		// The overflow check happens right away, which can disable the
+		// channel immediately.
+		a.nextSweepFrequency()
+	}
+}
+
+// Start the 2nd sound channel.
+func (a *APU) start2() {
+	a.Channel2.trigger(64)
+	a.Channel2.startEnvelope(a.Memory[0x17])
+}
+
+// Start the 3rd sound channel.
+func (a *APU) start3() {
+	a.Channel3.Generator = Waveform()
+	a.Channel3.trigger(256)
+	// Playback always starts at the first sample.
+	a.Channel3.Generator.PositionCycles = a.wavePeriod()
+}
+
+// wavePeriod returns the number of CPU cycles that channel 3 plays each of
+// its 32 samples at the frequency in NR33 and NR34.
+func (a *APU) wavePeriod() int32 {
+	frequency := int32(a.Memory[0x1E]&0x7)<<8 | int32(a.Memory[0x1D])
+	return (2048 - frequency) * 2
+}
+
+// clockWave advances channel 3's position in the waveform RAM while it plays.
+func (a *APU) clockWave(cycles int) {
+	chn := &a.Channel3
+	if !chn.Enabled {
+		return
+	}
+	g := &chn.Generator
+	g.PositionCycles -= int32(cycles)
+	for g.PositionCycles <= 0 {
+		g.PositionCycles += a.wavePeriod()
+		g.Position = (g.Position + 1) % 32
+	}
+}
+
+// Start the 4th sound channel.
+func (a *APU) start4() {
+	a.Channel4.trigger(64)
+	a.Channel4.startEnvelope(a.Memory[0x21])
+}
+
+// Extract some envelope variables from a byte.
+func extractEnvelope(val byte) (volume, direction, sweep byte) {
+	volume = (val & 0xF0) >> 4
+	direction = (val & 0x8) >> 3 // 1 or 0
+	sweep = val & 0x7
+	return
+}
+
+// clockFrameSequencer advances the 512 Hz frame sequencer by one step. Its
+// 8 steps clock the length counters at 256 Hz, the sweep at 128 Hz and the
+// volume envelopes at 64 Hz.
+func (a *APU) clockFrameSequencer() {
+	switch a.FrameSequencerStep {
+	case 0, 4:
+		a.clockLengths()
+	case 2, 6:
+		a.clockLengths()
+		a.clockSweep()
+	case 7:
+		a.Channel1.clockEnvelope()
+		a.Channel2.clockEnvelope()
+		a.Channel4.clockEnvelope()
+	}
+	a.FrameSequencerStep = (a.FrameSequencerStep + 1) % 8
+}
+
+// clockLengths counts down the length of each channel that has its length
+// enabled in bit 6 of NRx4.
+func (a *APU) clockLengths() {
+	a.Channel1.clockLength(a.Memory[0x14]&0x40 != 0)
+	a.Channel2.clockLength(a.Memory[0x19]&0x40 != 0)
+	a.Channel3.clockLength(a.Memory[0x1E]&0x40 != 0)
+	a.Channel4.clockLength(a.Memory[0x23]&0x40 != 0)
+}
+
+// sweepPeriod returns the number of sweep clocks between two frequency
+// changes of channel 1. A period of 0 in NR10 is treated as 8.
+func (a *APU) sweepPeriod() byte {
+	if period := (a.Memory[0x10] & 0x70) >> 4; period != 0 {
+		return period
+	}
+	return 8
+}
+
+// nextSweepFrequency calculates channel 1's next frequency from its shadow
+// frequency. If the result overflows 11 bits, the channel is disabled.
+func (a *APU) nextSweepFrequency() uint16 {
+	chn := &a.Channel1
+	delta := chn.ShadowFrequency >> (a.Memory[0x10] & 0x7)
+	freq := chn.ShadowFrequency + delta
+	if a.Memory[0x10]&0x8 != 0 {
+		freq = chn.ShadowFrequency - delta
+	}
+	if freq > 2047 {
+		chn.Enabled = false
+	}
+	return freq
+}
+
+// clockSweep updates channel 1's frequency sweep. New frequencies are written
+// back to NR13 and NR14.
+func (a *APU) clockSweep() {
+	chn := &a.Channel1
+	if chn.SweepTimer > 0 {
+		chn.SweepTimer--
+	}
+	if chn.SweepTimer != 0 {
+		return
+	}
+	chn.SweepTimer = a.sweepPeriod()
+	if !chn.SweepEnabled || a.Memory[0x10]&0x70 == 0 {
+		return
+	}
+
+	freq := a.nextSweepFrequency()
+	if freq <= 2047 && a.Memory[0x10]&0x7 != 0 {
+		chn.ShadowFrequency = freq
+		a.Memory[0x13] = byte(freq)
+		a.Memory[0x14] = a.Memory[0x14]&^0x7 | byte(freq>>8)
+		chn.Frequency = 131072 / (2048 - float64(freq))
+		// The new frequency is checked for overflow once more.
+		a.nextSweepFrequency()
+	}
+}
+
+var squareLimits = map[byte]float64{
+	0: -0.25, // 12.5% ( _-------_-------_------- )
+	1: -0.5,  // 25%   ( __------__------__------ )
+	2: 0,     // 50%   ( ____----____----____---- ) (normal)
+	3: 0.5,   // 75%   ( ______--______--______-- )
+}
+
+type WaveGeneratorType byte
+
+const (
+	squareWave WaveGeneratorType = iota
+	noiseWave
+	ramWave
+)
+
+type WaveGenerator struct {
+	Type WaveGeneratorType
+	Mod  float64
+	Last float64
+	Val  byte
+	// LFSR is the linear feedback shift register of the noise channel.
+	LFSR uint16
+	// Position is the index of the wave channel's sample being played.
+	// PositionCycles counts down the CPU cycles until it moves on to the next
+	// sample, see APU.clockWave.
+	Position       byte
+	PositionCycles int32
+}
+
+// clockLFSR shifts the noise channel's linear feedback shift register once.
+// The register is 15 bits wide. In 7 bit mode the feedback is also written to
+// bit 6, which makes the noise more regular.
+func (g *WaveGenerator) clockLFSR(width7 bool) {
+	feedback := (g.LFSR ^ g.LFSR>>1) & 1
+	g.LFSR = g.LFSR>>1 | feedback<<14
+	if width7 {
+		g.LFSR = g.LFSR&^(1<<6) | feedback<<6
+	}
+}
+
+func (g *WaveGenerator) At(apu *APU, t float64) byte {
+	switch g.Type {
+	case squareWave:
+		if math.Sin(t) <= g.Mod {
+			return 0xFF
+		}
+		return 0
+	case noiseWave:
+		// The LFSR is clocked once per period, which might be multiple times
+		// per sample for high frequencies.
+		if t-g.Last > twoPi {
+			clocks := math.Floor((t - g.Last) / twoPi)
+			g.Last += clocks * twoPi
+			width7 := apu.Memory[0x22]&0x8 != 0
+			for range min(int(clocks), 0x8000) {
+				g.clockLFSR(width7)
+			}
+			// The output is the inverted bit 0 of the LFSR.
+			g.Val = byte(^g.LFSR&1) * 0xFF
+		}
+		return g.Val
+	case ramWave:
+		// The position follows the emulated cycles, not the sample rate.
+		sample := apu.WaveformRam[g.Position/2]
+		if g.Position%2 == 0 {
+			sample >>= 4
+		}
+		sample &= 0xF
+		sample >>= sound3VolumeShift[(apu.Memory[0x1C]>>5)&0x3]
+		return sample * 0x11
+	default:
+		panic("unknown wave generator type")
+	}
+}
+
+// Square returns a square wave generator with a given mod. This is used
+// for channels 1 and 2.
+func Square(mod float64) WaveGenerator {
+	return WaveGenerator{
+		Type: squareWave,
+		Mod:  mod,
+	}
+}
+
+// Noise returns a wave generator for a noise channel. This is used by
+// channel 4.
+func Noise() WaveGenerator {
+	return WaveGenerator{Type: noiseWave, LFSR: 0x7FFF}
+}
+
+// Waveform returns a wave generator playing the waveform ram. This is used
+// by channel 3.
+func Waveform() WaveGenerator {
+	return WaveGenerator{Type: ramWave}
+}
+
+// NewChannel returns a new sound channel using a sampling function.
+func NewChannel() Channel {
+	return Channel{}
+}
+
+// Channel represents one of four Gameboy sound channels.
+type Channel struct {
+	Frequency float64
+	Generator WaveGenerator
+	Time      float64
+	Amplitude float64
+
+	// Enabled is set when the channel is triggered and cleared when its length
+	// expires, its sweep overflows or its DAC is turned off.
+	Enabled bool
+	// DACOn is false if the channel's DAC is turned off in NRx2 (NR30 for
+	// channel 3). Triggering the channel does not enable it in that case.
+	DACOn bool
+	// LengthCounter is decremented by the frame sequencer if the length is
+	// enabled. The channel is disabled when it reaches 0.
+	LengthCounter int32
+
+	Volume             byte
+	EnvelopePeriod     byte
+	EnvelopeTimer      byte
+	EnvelopeIncreasing bool
+
+	// The sweep is only used by channel 1.
+	ShadowFrequency uint16
+	SweepTimer      byte
+	SweepEnabled    bool
+
+	On bool
+}
+
+// Sample returns a single sample for streaming the sound output. Each sample
+// will increase the internal timer based on the global sample rate.
+func (chn *Channel) Sample(apu *APU) (output uint16) {
+	step := chn.Frequency * twoPi / float64(SampleRate)
+	chn.Time += step
+	if chn.Enabled && chn.On {
+		// Take the sample value from the generator
+		output = uint16(float64(chn.Generator.At(apu, chn.Time)) * chn.Amplitude)
+	}
+	return output
+}
+
+// trigger restarts the channel. The length counter is reloaded with maxLength
+// if it has run out.
+func (chn *Channel) trigger(maxLength int32) {
+	chn.Enabled = chn.DACOn
+	if chn.LengthCounter == 0 {
+		chn.LengthCounter = maxLength
+	}
+	chn.Amplitude = 1
+}
+
+// startEnvelope reloads the volume envelope from the channel's NRx2 register.
+func (chn *Channel) startEnvelope(nrx2 byte) {
+	volume, direction, period := extractEnvelope(nrx2)
+	chn.Volume = volume
+	chn.EnvelopeIncreasing = direction == 1
+	chn.EnvelopePeriod = period
+	chn.EnvelopeTimer = period
+	chn.Amplitude = float64(chn.Volume) / 15
+}
+
+// setDAC turns the channel's DAC on or off. Turning it off disables the
+// channel.
+func (chn *Channel) setDAC(on bool) {
+	chn.DACOn = on
+	if !on {
+		chn.Enabled = false
+	}
+}
+
+// clockLength is called by the frame sequencer at 256 Hz.
+func (chn *Channel) clockLength(enabled bool) {
+	if enabled && chn.LengthCounter > 0 {
+		chn.LengthCounter--
+		if chn.LengthCounter == 0 {
+			chn.Enabled = false
+		}
+	}
+}
+
+// clockEnvelope is called by the frame sequencer at 64 Hz. Every
+// EnvelopePeriod clocks the volume is changed by one, until it reaches 0 or
+// 15. A period of 0 stops the envelope.
+func (chn *Channel) clockEnvelope() {
+	if chn.EnvelopePeriod == 0 {
+		return
+	}
+	if chn.EnvelopeTimer > 0 {
+		chn.EnvelopeTimer--
+	}
+	if chn.EnvelopeTimer != 0 {
+		return
+	}
+	chn.EnvelopeTimer = chn.EnvelopePeriod
+	if chn.EnvelopeIncreasing && chn.Volume < 15 {
+		chn.Volume++
+	} else if !chn.EnvelopeIncreasing && chn.Volume > 0 {
+		chn.Volume--
+	}
+	chn.Amplitude = float64(chn.Volume) / 15
+}
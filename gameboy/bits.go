@@ -1,4 +1,4 @@
-package main
+package gameboy
 
 func BitIsSet(value, bit byte) bool {
 	return value&(1<<bit) != 0
@@ -0,0 +1,78 @@
+package gameboy
+
+// The Gameboy Camera (Pocket Camera) uses its own mapper, the MAC-GBD. It has
+// 64 ROM banks and 16 RAM banks. Bit 4 of the RAM bank register maps the
+// camera sensor registers to 0xA000 instead of RAM.
+//
+// We do not have a real sensor. Taking a picture immediately produces a fixed
+// test image so runs stay deterministic.
+
+const (
+	cameraRegisterCount = 0x36
+	// cameraImageOffset is where a captured image is stored in RAM bank 0. It
+	// is 16x14 tiles in the usual 2 bits per pixel tile format.
+	cameraImageOffset = 0x100
+	cameraImageW      = 128
+	cameraImageH      = 112
+)
+
+func (c *Cart) writeCameraControl(address uint16, value byte) {
+	switch {
+	case address < 0x2000:
+		c.RAMEnabled = value&0xF == 0xA
+	case address < 0x4000:
+		c.ROMBank = uint32(value & 0x3F)
+	case address < 0x6000:
+		c.CameraSelected = value&0x10 != 0
+		c.RAMBank = uint32(value & 0xF)
+	}
+}
+
+func (c *Cart) readCamera(address uint16) byte {
+	if !c.CameraSelected {
+		return c.RAM[(0x2000*c.RAMBank)+uint32(address-0xA000)]
+	}
+	// Only the status register can be read back, the registers are mirrored
+	// every 0x80 bytes.
+	if address&0x7F == 0 {
+		return c.CameraRegisters[0]
+	}
+	return 0
+}
+
+func (c *Cart) writeCamera(address uint16, value byte) {
+	if !c.CameraSelected {
+		if c.RAMEnabled {
+			c.RAM[(0x2000*c.RAMBank)+uint32(address-0xA000)] = value
+		}
+		return
+	}
+
+	reg := address & 0x7F
+	if reg >= cameraRegisterCount {
+		return
+	}
+	c.CameraRegisters[reg] = value
+	if reg == 0 && value&0x1 != 0 {
+		c.captureCameraImage()
+		// The capture is done right away, clear the busy flag.
+		c.CameraRegisters[0] &^= 0x1
+	}
+}
+
+// captureCameraImage writes our simulated sensor image, diagonal stripes of
+// the four shades, to RAM.
+func (c *Cart) captureCameraImage() {
+	image := c.RAM[cameraImageOffset : cameraImageOffset+cameraImageW*cameraImageH/4]
+	clear(image)
+	for y := range cameraImageH {
+		for x := range cameraImageW {
+			shade := byte((x+y)/16) % 4
+			tile := (y/8)*(cameraImageW/8) + x/8
+			i := tile*16 + (y%8)*2
+			bit := byte(7 - x%8)
+			image[i] |= (shade & 1) << bit
+			image[i+1] |= (shade >> 1) << bit
+		}
+	}
+}
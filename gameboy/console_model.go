@@ -0,0 +1,114 @@
+package gameboy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ConsoleModel is the Gameboy model that we emulate. The models' boot ROMs
+// leave different values in the CPU registers and some IO registers, which
+// games use to detect the model and which often seed their RNG.
+type ConsoleModel byte
+
+const (
+	ModelDMG ConsoleModel = iota
+	ModelMGB
+	ModelCGB
+	// ModelAGB is a Gameboy Advance running Gameboy and Gameboy Color games.
+	ModelAGB
+	// ModelLegacy is no real console. It boots like the editor did before
+	// it had console models, a DMG with some of the Color's register values,
+	// so that older sessions play back like they were made.
+	ModelLegacy
+
+	ConsoleModelCount // NOTE This has to come last.
+)
+
+var consoleModelNames = [ConsoleModelCount]string{
+	ModelDMG:    "DMG",
+	ModelMGB:    "MGB",
+	ModelCGB:    "CGB",
+	ModelAGB:    "AGB",
+	ModelLegacy: "Legacy",
+}
+
+func (m ConsoleModel) String() string {
+	if m < ConsoleModelCount {
+		return consoleModelNames[m]
+	}
+	return fmt.Sprintf("ConsoleModel(%d)", m)
+}
+
+func ParseConsoleModel(name string) (ConsoleModel, error) {
+	for m, n := range consoleModelNames {
+		if strings.EqualFold(n, name) {
+			return ConsoleModel(m), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown console model '%s'", name)
+}
+
+// IsColor reports whether the model supports Gameboy Color features like
+// color palettes, VRAM and WRAM banks and HDMA.
+func (m ConsoleModel) IsColor() bool {
+	return m == ModelCGB || m == ModelAGB
+}
+
+// DefaultConsoleModel is the model that the game was made for.
+func DefaultConsoleModel(rom []byte) ConsoleModel {
+	if len(rom) > 0x143 && rom[0x143] == 0xC0 {
+		// The game only runs on the Gameboy Color.
+		return ModelCGB
+	}
+	return ModelDMG
+}
+
+// bootRegisters returns the CPU registers that the model's boot ROM leaves
+// behind when it starts the game. Color models boot Gameboy games in a
+// compatibility mode which leaves different values than for Color games.
+func (m ConsoleModel) bootRegisters(cgbGame bool) (af, bc, de, hl uint16) {
+	switch m {
+	case ModelMGB:
+		return 0xFFB0, 0x0013, 0x00D8, 0x014D
+	case ModelCGB:
+		if cgbGame {
+			return 0x1180, 0x0000, 0xFF56, 0x000D
+		}
+		return 0x1180, 0x0000, 0x0008, 0x007C
+	case ModelAGB:
+		// The AGB's boot ROM increments B, which is how games detect it.
+		if cgbGame {
+			return 0x1100, 0x0100, 0xFF56, 0x000D
+		}
+		return 0x1100, 0x0100, 0x0008, 0x007C
+	case ModelLegacy:
+		return 0x01B0, 0x0000, 0xFF56, 0x000D
+	default:
+		return 0x01B0, 0x0013, 0x00D8, 0x014D
+	}
+}
+
+// bootDivider is the value of the DIV register when the game starts. It
+// depends on how long the model's boot ROM runs.
+func (m ConsoleModel) bootDivider() byte {
+	if m.IsColor() || m == ModelLegacy {
+		return 0x1E
+	}
+	return 0xAB
+}
+
+// BootCycles returns the number of cycles that the model's boot ROM runs from
+// power-on until it starts the game. The emulator skips the boot ROM, so the
+// first emulated cycle happens this long after power-on on a real console.
+// The Color models' boot ROMs animate their logo for a time that we do not
+// know exactly and the legacy model has no boot ROM, ok is false for them.
+func (m ConsoleModel) BootCycles() (cycles int, ok bool) {
+	switch m {
+	case ModelDMG:
+		return 23440324, true
+	case ModelMGB:
+		return 23440276, true
+	default:
+		return 0, false
+	}
+}
@@ -1,10 +1,6 @@
-package main
+package gameboy
 
-import (
-	"log"
-	"os"
-	"time"
-)
+import "log"
 
 // Mode represents the types of mode the GameBoy can run in.
 type Mode byte
@@ -24,13 +20,9 @@ const (
 	mbc2
 	mbc3
 	mbc5
+	camera
 )
 
-// globalROM is the cartridge data. It is read-only and never changes throughout
-// the run of the Gameboy game. Thus we do not make it part of the Gameboy
-// state. Instead we use this global variable throughout the program.
-var globalROM []byte
-
 // Cart represents a GameBoy cartridge.
 //
 // The cartridge is an extension of a banking controller which determines how the cart
@@ -48,60 +40,96 @@ type Cart struct {
 	RTC        [0x10]byte
 	LatchedRtc [0x10]byte
 	Latched    bool
+	// HasRumble is set for MBC5 carts with a rumble motor. Rumble is true while
+	// the game turns the motor on.
+	HasRumble bool
+	Rumble    bool
+	// CameraSelected maps the Gameboy Camera's sensor registers, instead of
+	// RAM, to 0xA000.
+	CameraSelected  bool
+	CameraRegisters [cameraRegisterCount]byte
+	// rom is the cartridge data. It never changes throughout the run of the
+	// game, thus it is not part of the saved state. All copies of a Gameboy
+	// share it. It is a string, not a slice, so it cannot be modified and
+	// Gameboys stay comparable with ==.
+	rom string
+}
+
+// ROM returns the cartridge data. Pass it to LoadState so the loaded Gameboy
+// shares it instead of copying the data again.
+func (c *Cart) ROM() string {
+	return c.rom
 }
 
 // Read returns a value at a memory address in the ROM.
 func (c *Cart) Read(address uint16) byte {
+	rom := c.rom
 	switch c.MemoryBank {
 	case romOnly:
-		return globalROM[address]
+		return romByte(rom, uint32(address))
 	case mbc1:
 		switch {
 		case address < 0x4000:
-			return globalROM[address] // Bank 0 is fixed
+			return romByte(rom, uint32(address)) // Bank 0 is fixed
 		case address < 0x8000:
-			return globalROM[uint32(address-0x4000)+(c.ROMBank*0x4000)] // Use selected rom bank
+			return romByte(rom, uint32(address-0x4000)+(c.ROMBank*0x4000)) // Use selected rom bank
 		default:
 			return c.RAM[(0x2000*c.RAMBank)+uint32(address-0xA000)] // Use selected ram bank
 		}
 	case mbc2:
 		switch {
 		case address < 0x4000:
-			return globalROM[address] // Bank 0 is fixed
+			return romByte(rom, uint32(address)) // Bank 0 is fixed
 		case address < 0x8000:
-			return globalROM[uint32(address-0x4000)+(c.ROMBank*0x4000)] // Use selected rom bank
+			return romByte(rom, uint32(address-0x4000)+(c.ROMBank*0x4000)) // Use selected rom bank
 		default:
 			return c.RAM[address-0xA000] // Use ram
 		}
 	case mbc3:
 		switch {
 		case address < 0x4000:
-			return globalROM[address] // Bank 0 is fixed
+			return romByte(rom, uint32(address)) // Bank 0 is fixed
 		case address < 0x8000:
-			return globalROM[uint32(address-0x4000)+(c.ROMBank*0x4000)] // Use selected rom bank
+			return romByte(rom, uint32(address-0x4000)+(c.ROMBank*0x4000)) // Use selected rom bank
 		default:
 			if c.RAMBank >= 0x4 {
 				if c.Latched {
-					return c.LatchedRtc[c.RAMBank]
+					return c.LatchedRtc[c.RAMBank&0xF]
 				}
-				return c.RTC[c.RAMBank]
+				return c.RTC[c.RAMBank&0xF]
 			}
 			return c.RAM[(0x2000*c.RAMBank)+uint32(address-0xA000)] // Use selected ram bank
 		}
 	case mbc5:
 		switch {
 		case address < 0x4000:
-			return globalROM[address] // Bank 0 is fixed
+			return romByte(rom, uint32(address)) // Bank 0 is fixed
 		case address < 0x8000:
-			return globalROM[uint32(address-0x4000)+(c.ROMBank*0x4000)] // Use selected rom bank
+			return romByte(rom, uint32(address-0x4000)+(c.ROMBank*0x4000)) // Use selected rom bank
 		default:
 			return c.RAM[(0x2000*c.RAMBank)+uint32(address-0xA000)] // Use selected ram bank
 		}
+	case camera:
+		switch {
+		case address < 0x4000:
+			return romByte(rom, uint32(address)) // Bank 0 is fixed
+		case address < 0x8000:
+			return romByte(rom, uint32(address-0x4000)+(c.ROMBank*0x4000)) // Use selected rom bank
+		default:
+			return c.readCamera(address)
+		}
 	default:
 		panic("unknown memory bank type")
 	}
 }
 
+// romByte returns the ROM byte at offset. Offsets past the end of the ROM wrap
+// around, like on hardware where the upper bank bits of a big mapper are not
+// connected on carts with smaller ROMs.
+func romByte(rom string, offset uint32) byte {
+	return rom[offset%uint32(len(rom))]
+}
+
 func (c *Cart) updateRomBankIfZero() {
 	if c.ROMBank == 0x00 || c.ROMBank == 0x20 || c.ROMBank == 0x40 || c.ROMBank == 0x60 {
 		c.ROMBank++
@@ -194,14 +222,22 @@ func (c *Cart) WriteROM(address uint16, value byte) {
 				c.RAMEnabled = false
 			}
 		case address < 0x3000:
-			// ROM bank number
+			// Lower 8 bits of the ROM bank number
 			c.ROMBank = (c.ROMBank & 0x100) | uint32(value)
 		case address < 0x4000:
-			// ROM/RAM banking
+			// 9th bit of the ROM bank number, for ROMs of up to 8 MB
 			c.ROMBank = (c.ROMBank & 0xFF) | uint32(value&0x01)<<8
 		case address < 0x6000:
-			c.RAMBank = uint32(value & 0xF)
+			if c.HasRumble {
+				// Bit 3 drives the rumble motor instead of selecting RAM.
+				c.Rumble = value&0x8 != 0
+				c.RAMBank = uint32(value & 0x7)
+			} else {
+				c.RAMBank = uint32(value & 0xF)
+			}
 		}
+	case camera:
+		c.writeCameraControl(address, value)
 	default:
 		panic("unknown memory bank type")
 	}
@@ -221,7 +257,7 @@ func (c *Cart) WriteRAM(address uint16, value byte) {
 	case mbc3:
 		if c.RAMEnabled {
 			if c.RAMBank >= 0x4 {
-				c.RTC[c.RAMBank] = value
+				c.RTC[c.RAMBank&0xF] = value
 			} else {
 				c.RAM[(0x2000*c.RAMBank)+uint32(address-0xA000)] = value
 			}
@@ -230,11 +266,15 @@ func (c *Cart) WriteRAM(address uint16, value byte) {
 		if c.RAMEnabled {
 			c.RAM[(0x2000*c.RAMBank)+uint32(address-0xA000)] = value
 		}
+	case camera:
+		c.writeCamera(address, value)
 	default:
 		panic("unknown memory bank type")
 	}
 }
 
+// GetSaveData returns a copy of the whole cartridge RAM, which is more than
+// most carts have. It is empty for carts that we emulate without RAM.
 func (c *Cart) GetSaveData() []byte {
 	switch c.MemoryBank {
 	case romOnly:
@@ -246,6 +286,7 @@ func (c *Cart) GetSaveData() []byte {
 	}
 }
 
+// LoadSaveData copies data to the start of the cartridge RAM.
 func (c *Cart) LoadSaveData(data []byte) {
 	switch c.MemoryBank {
 	case romOnly:
@@ -254,62 +295,18 @@ func (c *Cart) LoadSaveData(data []byte) {
 	}
 }
 
-// GetSaveFilename returns the name of the file that the game should be saved to. This is
-// used for saving and loading save data to the cartridge.
-// TODO: do something better here
-func (c *Cart) GetSaveFilename() string {
-	return "" // TODO Remove this.
-}
-
 // GetMode returns the modes that this cart can run in.
 func (c *Cart) GetMode() Mode {
 	return c.Mode
 }
 
-// Attempt to load a save game from the expected location.
-func (c *Cart) initGameSaves() {
-	saveData, err := os.ReadFile(c.GetSaveFilename())
-	if err == nil {
-		c.LoadSaveData(saveData)
-	}
-	// Write the RAM to file every second
-	// TODO: improve this behaviour
-	ticker := time.NewTicker(time.Second)
-	go func() {
-		for range ticker.C {
-			c.Save()
-		}
-	}()
-}
-
-// Save dumps the carts RAM to the save location.
-func (c *Cart) Save() {
-	data := c.GetSaveData()
-	if len(data) > 0 {
-		err := os.WriteFile(c.GetSaveFilename(), data, 0644)
-		if err != nil {
-			log.Printf("Error saving cartridge RAM: %v", err)
-		}
-	}
-}
-
-// NewCartFromFile loads a cartridge ROM from a file.
-func NewCartFromFile(filename string) (Cart, error) {
-	rom, err := os.ReadFile(filename)
-	if err != nil {
-		return Cart{}, err
-	}
-	return NewCart(rom, filename), nil
-}
-
 // NewCart loads a cartridge ROM from a byte array and returns a new cartridge with
-// the correct memory banking controller. If the game supports saves, then the
-// save file for the cartridge will also be loaded, and the saving loop will be
-// started to write the save data back to file.
+// the correct memory banking controller. The cartridge RAM starts out cleared,
+// battery or not. It is part of the Gameboy state, GetSaveData and
+// LoadSaveData copy it from and to battery save files.
 //
 // The function will use the following list to determine which MBC to use. Not
-// all of the controllers are supported, and the function will only start the
-// save loop for controllers which support RAM+BATTERY.
+// all of the controllers are supported.
 //
 //	0x00  ROM ONLY
 //	0x01  MBC1
@@ -340,8 +337,15 @@ func NewCartFromFile(filename string) (Cart, error) {
 //	0xFD  BANDAI TAMA5
 //	0xFE  HuC3
 //	0xFF  HuC1+RAM+BATTERY
-func NewCart(rom []byte, filename string) Cart {
-	cartridge := Cart{}
+//
+// The cart keeps a copy of rom.
+func NewCart(rom []byte) Cart {
+	return newCart(string(rom))
+}
+
+// newCart returns a cartridge that plays rom without copying it.
+func newCart(rom string) Cart {
+	cartridge := Cart{rom: rom}
 
 	// Check for GB mode
 	switch rom[0x0143] {
@@ -353,7 +357,6 @@ func NewCart(rom []byte, filename string) Cart {
 		cartridge.Mode = DMG
 	}
 
-	globalROM = rom
 	cartridge.ROMBank = 1
 
 	// Determine cartridge type
@@ -374,15 +377,14 @@ func NewCart(rom []byte, filename string) Cart {
 			cartridge.MemoryBank = mbc1
 		case mbcFlag < 0x1F:
 			cartridge.MemoryBank = mbc5
+		case mbcFlag == 0xFC:
+			cartridge.MemoryBank = camera
 		default:
 			log.Printf("Warning: This cart may not be supported: %02x", mbcFlag)
 			cartridge.MemoryBank = mbc1
 		}
 	}
 
-	switch mbcFlag {
-	case 0x3, 0x6, 0x9, 0xD, 0xF, 0x10, 0x13, 0x17, 0x1B, 0x1E, 0xFF:
-		cartridge.initGameSaves()
-	}
+	cartridge.HasRumble = 0x1C <= mbcFlag && mbcFlag <= 0x1E
 	return cartridge
 }
@@ -1,4 +1,4 @@
-package main
+package gameboy
 
 // Register represents a GB CPU 16bit Register which provides functions
 // for setting and getting the higher and lower bytes.
@@ -55,26 +55,23 @@ func (reg *Register) updateMask() {
 // CPU contains the registers used for program execution and
 // provides methods for setting flags.
 type CPU struct {
-	AF      Register
-	BC      Register
-	DE      Register
-	HL      Register
-	PC      uint16
-	SP      Register
-	Divider int32
-}
-
-// Init CPU and its registers to the initial values.
-func (cpu *CPU) Init(cgb bool) {
+	AF Register
+	BC Register
+	DE Register
+	HL Register
+	PC uint16
+	SP Register
+}
+
+// Init CPU and its registers to the values that the model's boot ROM leaves
+// behind.
+func (cpu *CPU) Init(model ConsoleModel, cgbGame bool) {
+	af, bc, de, hl := model.bootRegisters(cgbGame)
 	cpu.PC = 0x100
-	if cgb {
-		cpu.AF.Set(0x1180)
-	} else {
-		cpu.AF.Set(0x01B0)
-	}
-	cpu.BC.Set(0x0000)
-	cpu.DE.Set(0xFF56)
-	cpu.HL.Set(0x000D)
+	cpu.AF.Set(af)
+	cpu.BC.Set(bc)
+	cpu.DE.Set(de)
+	cpu.HL.Set(hl)
 	cpu.SP.Set(0xFFFE)
 
 	cpu.AF.Mask = 0xFFF0
@@ -0,0 +1,149 @@
+package gameboy
+
+import "testing"
+
+// jrLoop jumps to itself forever, 12 cycles per iteration.
+var jrLoop = []byte{0x18, 0xFE}
+
+// lcdFrameDots is the length of an LCD frame in normal speed cycles, 154 lines
+// of 456 dots.
+const lcdFrameDots = 154 * 456
+
+// ppuDot is the position of the PPU in the LCD frame.
+func ppuDot(gb *Gameboy) int {
+	return int(gb.Memory.HighRAM[0x44])*456 + 456 - int(gb.ScanlineCounter)
+}
+
+func TestDoubleSpeedFrameTiming(t *testing.T) {
+	for _, speed := range []byte{0, 1} {
+		gb := newTestGameboy(ModelCGB, jrLoop...)
+		gb.CurrentSpeed = speed
+		// Run one frame first so the PPU is in a steady state.
+		gb.Step()
+
+		// Step counts normal speed cycles, starting with those that the
+		// last frame ran over.
+		extra := int(gb.ExtraCycles)
+		counter := gb.SystemCounter
+		dot := ppuDot(&gb)
+		cycles := gb.Step() - extra
+
+		// The PPU runs at normal speed, it moves as far as the frame took.
+		wantDot := (dot + cycles) % lcdFrameDots
+		if got := ppuDot(&gb); got != wantDot {
+			t.Errorf("speed %d: PPU at cycle %d, want %d", speed, got, wantDot)
+		}
+
+		// The timer runs at CPU speed, twice as fast in double speed.
+		wantCounter := counter + uint16(cycles*gb.getSpeed())
+		if gb.SystemCounter != wantCounter {
+			t.Errorf("speed %d: system counter is %d, want %d", speed, gb.SystemCounter, wantCounter)
+		}
+	}
+}
+
+func TestSpeedSwitch(t *testing.T) {
+	gb := newTestGameboy(ModelCGB,
+		0x3E, 0x01, // LD A,1
+		0xE0, 0x4D, // LDH (KEY1),A
+		0x10, 0x00, // STOP
+		0x18, 0xFE, // JR -2
+	)
+	gb.SystemCounter = 0x1234
+	// Request a STAT interrupt when H-Blank starts.
+	gb.Memory.Write(&gb, 0xFF41, 0x08)
+	gb.Memory.Write(&gb, 0xFF0F, 0)
+	for range 3 {
+		gb.ExecuteNextOpcode()
+	}
+
+	if gb.CurrentSpeed != 1 {
+		t.Fatal("STOP did not switch to double speed")
+	}
+	if gb.PrepareSpeed {
+		t.Error("KEY1 is still armed after the switch")
+	}
+	if got := gb.Memory.Read(&gb, 0xFF4D); got != 0xFE {
+		t.Errorf("KEY1 reads %02X, want FE", got)
+	}
+	if gb.Halted {
+		t.Error("the CPU is halted after the switch")
+	}
+	if gb.SystemCounter != 0 {
+		t.Errorf("STOP did not reset DIV, system counter is %d", gb.SystemCounter)
+	}
+	if gb.StallCycles != 2050*4 {
+		t.Errorf("the CPU is paused for %d cycles, want %d", gb.StallCycles, 2050*4)
+	}
+
+	// The PPU keeps running at normal speed during the pause, about 9 lines.
+	gb.ScreenData = Screen{}
+	dot := ppuDot(&gb)
+	lines, hblanks, statInterrupts := runStall(&gb)
+	if got, want := ppuDot(&gb), (dot+speedSwitchCycles/2)%lcdFrameDots; got != want {
+		t.Errorf("PPU at cycle %d after the pause, want %d", got, want)
+	}
+	checkStallLines(t, &gb, lines, hblanks, statInterrupts)
+	// The timer runs at CPU speed during the pause.
+	if gb.SystemCounter != speedSwitchCycles {
+		t.Errorf("system counter is %d after the pause, want %d", gb.SystemCounter, speedSwitchCycles)
+	}
+}
+
+func TestDoubleSpeedOAMDMA(t *testing.T) {
+	for _, speed := range []byte{0, 1} {
+		gb := newTestGameboy(ModelCGB, jrLoop...)
+		gb.CurrentSpeed = speed
+		for i := range uint16(0xA0) {
+			gb.Memory.Write(&gb, 0xC000+i, byte(i))
+		}
+		gb.Memory.Write(&gb, 0xFF46, 0xC0)
+
+		// OAM DMA copies one byte per machine cycle in either speed, so
+		// it takes half as long in double speed.
+		gb.Memory.updateDMA(&gb, 159*4)
+		if !gb.Memory.DMAActive {
+			t.Fatalf("speed %d: OAM DMA finished before 160 machine cycles", speed)
+		}
+		gb.Memory.updateDMA(&gb, 4)
+		if gb.Memory.DMAActive {
+			t.Fatalf("speed %d: OAM DMA did not finish after 160 machine cycles", speed)
+		}
+		for i := range 0xA0 {
+			if gb.Memory.OAM[i] != byte(i) {
+				t.Fatalf("speed %d: OAM[%02X] is %02X", speed, i, gb.Memory.OAM[i])
+			}
+		}
+	}
+}
+
+func TestDoubleSpeedHDMA(t *testing.T) {
+	for _, speed := range []byte{0, 1} {
+		gb := newTestGameboy(ModelCGB, jrLoop...)
+		gb.CurrentSpeed = speed
+		gb.Memory.Write(&gb, 0xFF51, 0xC0)
+		gb.Memory.Write(&gb, 0xFF52, 0x00)
+		gb.Memory.Write(&gb, 0xFF53, 0x80)
+		gb.Memory.Write(&gb, 0xFF54, 0x00)
+
+		// A general purpose DMA of 2 blocks takes 16 machine cycles of
+		// normal speed, plus one machine cycle to start.
+		gb.Memory.Write(&gb, 0xFF55, 0x01)
+		want := [2]int32{4 + 64, 4 + 128}[speed]
+		if gb.StallCycles != want {
+			t.Errorf("speed %d: general DMA pauses the CPU for %d cycles, want %d", speed, gb.StallCycles, want)
+		}
+
+		// A H-Blank DMA copies one block per H-Blank.
+		gb.StallCycles = 0
+		gb.Memory.Write(&gb, 0xFF55, 0x80)
+		gb.Memory.doHDMATransfer(&gb)
+		want = [2]int32{32, 64}[speed]
+		if gb.StallCycles != want {
+			t.Errorf("speed %d: H-Blank DMA pauses the CPU for %d cycles, want %d", speed, gb.StallCycles, want)
+		}
+		if gb.Memory.HdmaActive {
+			t.Errorf("speed %d: H-Blank DMA of one block is still active", speed)
+		}
+	}
+}
@@ -1,4 +1,4 @@
-package main
+package gameboy
 
 // Perform a ADD instruction on the values and store the value using the set
 // function. Will also update the CPU flags using the result of the operation.
@@ -0,0 +1,181 @@
+package gameboy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// Gambatte saves its state as a version, a small screenshot and a list of
+// records:
+//
+//	label, 0, data length (3 bytes, big endian), data
+//
+// Numbers in the data are big endian. We skip labels that we do not know, like
+// Gambatte does.
+//
+// We only convert what both emulators have in common: the CPU registers, the
+// memories, the IO registers and the cartridge banks. Gambatte also saves its
+// internal timing, e.g. when DIV or the PPU last updated, which has no
+// counterpart in our state. Loading a Gambatte state therefore starts the PPU
+// at the beginning of the current scanline and the sound channels without
+// their progress, a game usually continues normally. We cannot write those
+// timing records, so we only import Gambatte states and do not export them.
+
+// LoadGambatteState creates a Gameboy from a savestate of Gambatte for the
+// cartridge rom. A state of a Gameboy Color makes the model a color one.
+func LoadGambatteState(rom, data []byte, options GameboyOptions) (Gameboy, error) {
+	if len(data) < 5 || data[0] != 0 {
+		return Gameboy{}, errors.New("this is not a Gambatte savestate")
+	}
+	records := make(map[string][]byte)
+	rest := data[2:]
+	screenshot := int(rest[0])<<16 | int(rest[1])<<8 | int(rest[2])
+	if screenshot > len(rest)-3 {
+		return Gameboy{}, errors.New("short read: the screenshot of the savestate is incomplete")
+	}
+	rest = rest[3+screenshot:]
+	for len(rest) > 0 {
+		end := bytes.IndexByte(rest, 0)
+		if end == -1 || len(rest) < end+4 {
+			return Gameboy{}, errors.New("short read: a savestate record is incomplete")
+		}
+		label := string(rest[:end])
+		size := int(rest[end+1])<<16 | int(rest[end+2])<<8 | int(rest[end+3])
+		rest = rest[end+4:]
+		if size > len(rest) {
+			return Gameboy{}, fmt.Errorf("short read: the savestate record '%s' is incomplete", label)
+		}
+		records[label] = rest[:size]
+		rest = rest[size:]
+	}
+
+	required := []struct {
+		label string
+		size  int
+	}{
+		{"pc", 2}, {"sp", 2},
+		{"a", 1}, {"f", 1}, {"b", 1}, {"c", 1}, {"d", 1}, {"e", 1}, {"h", 1}, {"l", 1},
+		{"hram", 0x200}, {"vram", 0x2000}, {"wram", 0x2000},
+	}
+	for _, r := range required {
+		if len(records[r.label]) < r.size {
+			return Gameboy{}, fmt.Errorf("the savestate has no '%s' record", r.label)
+		}
+	}
+	number := func(label string) uint32 {
+		var n uint32
+		for _, b := range records[label] {
+			n = n<<8 | uint32(b)
+		}
+		return n
+	}
+	flag := func(label string) bool {
+		return number(label) != 0
+	}
+
+	wram := records["wram"]
+	if len(wram) >= 0x8000 && !options.Model.IsColor() {
+		options.Model = ModelCGB
+	}
+	gb := NewGameboy(rom, options)
+	mem := &gb.Memory
+
+	cpu := &gb.CPU
+	cpu.PC = uint16(number("pc"))
+	cpu.SP.Set(uint16(number("sp")))
+	cpu.AF.Set(uint16(number("a"))<<8 | uint16(number("f")))
+	cpu.BC.Set(uint16(number("b"))<<8 | uint16(number("c")))
+	cpu.DE.Set(uint16(number("d"))<<8 | uint16(number("e")))
+	cpu.HL.Set(uint16(number("h"))<<8 | uint16(number("l")))
+	gb.Halted = flag("halt")
+	gb.InterruptsOn = flag("ime")
+
+	copy(mem.VRAM[:], records["vram"])
+	for bank := range min(len(wram)/0x1000, 8) {
+		copy(mem.WRAM[wramOffset(bank):][:0x1000], wram[bank*0x1000:])
+	}
+	ioram := records["hram"]
+	copy(mem.OAM[:], ioram[:0x100])
+	gb.loadGambatteIO(ioram[0x100:0x200])
+	copy(gb.BGPalette.Palette[:], records["bgp"])
+	copy(gb.SpritePalette.Palette[:], records["objp"])
+
+	cart := &mem.Cart
+	copy(cart.RAM[:], records["sram"])
+	if _, ok := records["rombank"]; ok {
+		cart.ROMBank = number("rombank")
+	}
+	cart.RAMBank = number("rambank")
+	if !cart.validRAMBank(cart.RAMBank) {
+		return Gameboy{}, fmt.Errorf("the savestate selects RAM bank %d, which the cartridge does not have", cart.RAMBank)
+	}
+	cart.RAMEnabled = flag("sramon")
+	cart.ROMBanking = !flag("rambmod")
+
+	// The PPU starts over at the beginning of the current scanline.
+	gb.ScanlineCounter = 456
+	gb.FIFO = PixelFIFO{}
+	return gb, nil
+}
+
+// loadGambatteIO sets the IO registers and HRAM from their values at 0xFF00 to
+// 0xFFFF. Registers that start something, like OAM DMA or a sound channel, do
+// not start it again.
+func (gb *Gameboy) loadGambatteIO(io []byte) {
+	mem := &gb.Memory
+	copy(mem.HighRAM[:], io)
+	gb.SystemCounter = uint16(io[DIV-0xFF00]) << 8
+	mem.HighRAM[0x41] |= 0x80
+
+	// Turn the sound on before setting the channels.
+	gb.Sound.Write(0xFF26, io[0x26])
+	for address := uint16(0xFF10); address < 0xFF26; address++ {
+		value := io[address-0xFF00]
+		switch address {
+		case 0xFF14, 0xFF19, 0xFF1E, 0xFF23:
+			value &^= 0x80
+		}
+		gb.Sound.Write(address, value)
+	}
+	copy(gb.Sound.WaveformRam[:], io[0x30:0x40])
+
+	if gb.IsCGB() {
+		gb.CurrentSpeed = io[0x4D] >> 7
+		gb.PrepareSpeed = BitIsSet(io[0x4D], 0)
+		mem.VRAMBank = io[0x4F] & 0x1
+		gb.BGPalette.updateIndex(io[0x68])
+		gb.SpritePalette.updateIndex(io[0x6A])
+		mem.WRAMBank = max(1, io[0x70]&0x7)
+	}
+}
+
+// validRAMBank reports whether the cartridge's banking controller can select
+// RAM bank, which must also lie in Cart.RAM.
+func (c *Cart) validRAMBank(bank uint32) bool {
+	var last uint32
+	switch c.MemoryBank {
+	case mbc1:
+		last = 0x3
+	case mbc3:
+		// Banks 0x8 to 0xC select the clock registers.
+		last = 0xC
+	case mbc5:
+		last = 0xF
+		if c.HasRumble {
+			last = 0x7
+		}
+	case camera:
+		last = 0xF
+	}
+	return bank <= last && (bank+1)*0x2000 <= uint32(len(c.RAM))
+}
+
+// wramOffset returns where WRAM bank starts in Memory.WRAM. The switchable
+// banks start at 0x2000, there is a gap after bank 0.
+func wramOffset(bank int) int {
+	if bank == 0 {
+		return 0
+	}
+	return (bank + 1) * 0x1000
+}
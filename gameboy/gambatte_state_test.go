@@ -0,0 +1,77 @@
+package gameboy
+
+import (
+	"bytes"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// gambatteState returns a Gambatte savestate with the records that
+// LoadGambatteState requires, zeroed, and the given records.
+func gambatteState(records map[string][]byte) []byte {
+	all := map[string][]byte{
+		"pc": {0x01, 0x00}, "sp": {0xFF, 0xFE},
+		"a": {0}, "f": {0}, "b": {0}, "c": {0}, "d": {0}, "e": {0}, "h": {0}, "l": {0},
+		"hram": make([]byte, 0x200),
+		"vram": make([]byte, 0x2000),
+		"wram": make([]byte, 0x2000),
+	}
+	maps.Copy(all, records)
+
+	var buf bytes.Buffer
+	buf.Write([]byte{0, 1, 0, 0, 0})
+	for _, label := range slices.Sorted(maps.Keys(all)) {
+		data := all[label]
+		buf.WriteString(label)
+		buf.WriteByte(0)
+		buf.Write([]byte{byte(len(data) >> 16), byte(len(data) >> 8), byte(len(data))})
+		buf.Write(data)
+	}
+	return buf.Bytes()
+}
+
+func TestGambatteRAMBank(t *testing.T) {
+	tests := []struct {
+		name     string
+		cartType byte
+		bank     byte
+		valid    bool
+	}{
+		{"MBC1", 0x03, 3, true},
+		{"MBC1", 0x03, 4, false},
+		{"MBC2", 0x06, 0, true},
+		{"MBC2", 0x06, 1, false},
+		{"MBC3 clock", 0x10, 0xC, true},
+		{"MBC3", 0x10, 0xD, false},
+		{"MBC5", 0x1B, 0xF, true},
+		{"MBC5", 0x1B, 0x10, false},
+		{"MBC5 rumble", 0x1E, 0x8, false},
+		{"ROM only", 0x00, 1, false},
+	}
+	for _, tt := range tests {
+		rom := make([]byte, 0x8000)
+		rom[0x147] = tt.cartType
+		rom[0x149] = 4 // 128 KB RAM
+		data := gambatteState(map[string][]byte{"rambank": {tt.bank}})
+
+		gb, err := LoadGambatteState(rom, data, GameboyOptions{})
+		if !tt.valid {
+			if err == nil || !strings.Contains(err.Error(), "RAM bank") {
+				t.Errorf("%s: RAM bank %d loaded with error %v", tt.name, tt.bank, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: RAM bank %d: %v", tt.name, tt.bank, err)
+			continue
+		}
+		if gb.Memory.Cart.RAMBank != uint32(tt.bank) {
+			t.Errorf("%s: RAM bank is %d, want %d", tt.name, gb.Memory.Cart.RAMBank, tt.bank)
+		}
+		// Reading the selected bank must not go out of range.
+		gb.Memory.Cart.RAMEnabled = true
+		gb.ReadMemory(0xBFFF)
+	}
+}
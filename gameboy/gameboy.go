@@ -0,0 +1,555 @@
+// Package gameboy emulates the Gameboy and Gameboy Color. Create a Gameboy
+// for the cartridge data with NewGameboy and call Step for every frame:
+//
+//	gb := gameboy.NewGameboy(rom, gameboy.GameboyOptions{})
+//	gb.PressButton(gameboy.ButtonStart)
+//	gb.Step()
+//	screen := gb.Frame()
+//
+// Copying a Gameboy copies the whole emulator state, except for two things
+// that the copies share: the cartridge's ROM, which never changes, and the
+// Watch callback, see Watcher. SaveState and LoadState convert the state to
+// and from bytes, the ROM is not part of it.
+package gameboy
+
+import "fmt"
+
+const (
+	// ClockSpeed is the number of cycles the GameBoy CPU performs each second.
+	ClockSpeed = 4194304
+	// FramesSecond is the target number of frames for each frame of GameBoy output.
+	FramesSecond = 60
+	// CyclesPerFrame is the number of CPU cycles in each frame.
+	CyclesPerFrame = ClockSpeed / FramesSecond
+)
+
+// NewGameboy returns a new Gameboy instance.
+func NewGameboy(rom []byte, opts GameboyOptions) Gameboy {
+	gameboy := Gameboy{Options: opts}
+	gameboy.init(string(rom))
+	return gameboy
+}
+
+type GameboyOptions struct {
+	// Sound enables generating sound samples while emulating, see
+	// APU.FrameSamples. The sound registers that the game reads behave the
+	// same without it.
+	Sound bool
+	// Model is the emulated console. Gameboy Color games only use color
+	// features on color models.
+	Model ConsoleModel
+}
+
+// StateVersion needs to be incremented whenever changes make the
+// emulator behave differently. Keyframes that were saved by an older emulator
+// are then re-generated the next time we load a file. For this reason the
+// file versions are compared. Adding a field to the Gameboy struct does not
+// need a new version, see gameboy_state.go, unless its zero value in older
+// keyframes makes the emulation go differently.
+const StateVersion = 26
+
+// Gameboy is the master struct which contains all of the sub components
+// for running the Gameboy emulator.
+type Gameboy struct {
+	Options GameboyOptions
+
+	Memory Memory
+	CPU    CPU
+	Sound  APU
+	Serial Serial
+
+	// SystemCounter is the internal 16 bit counter which increments every
+	// cycle, DIV is its high byte. TIMA increments when the counter bit that
+	// TAC selects goes from 1 to 0.
+	SystemCounter uint16
+	// TIMAOverflow is set for one machine cycle after TIMA overflowed. TIMA
+	// reads 0 until it is reloaded from TMA and the interrupt is requested.
+	TIMAOverflow bool
+	// TIMAReloaded is set in the machine cycle in which TIMA was reloaded.
+	TIMAReloaded bool
+
+	// ScreenData holds the pixels while the screen is rendering. When a frame
+	// has been completed, this data is copied into PreparedData.
+	ScreenData Screen
+	// FIFO draws the current scanline.
+	FIFO PixelFIFO
+	// WindowLine is the window's internal line counter, the line of the
+	// window that is drawn next. It only counts lines on which the window
+	// was visible.
+	WindowLine byte
+	// WindowYTriggered is set once LY equals WY in the current frame.
+	WindowYTriggered bool
+	// WindowWrapped is set if the window starts at the beginning of the next
+	// line, see endScanline.
+	WindowWrapped bool
+
+	// ScanlineCounter counts down the dots until the next scanline. Dots run
+	// at the normal speed clock, also in double speed mode.
+	ScanlineCounter int32
+	ScreenCleared   bool
+	// StatLine is the STAT interrupt line, the OR of all enabled STAT
+	// conditions. The interrupt is only requested when it goes from low to
+	// high, so conditions that overlap block each other's interrupts.
+	StatLine bool
+
+	// PreparedData holds the pixels of the last frame that has been fully
+	// rendered.
+	PreparedData Screen
+
+	InterruptsEnabling bool
+	InterruptsOn       bool
+	Halted             bool
+
+	// Mask of the currenly pressed buttons.
+	InputMask byte
+	// JoypadPolled is set if the game read the joypad register during the
+	// last frame. The inputs of frames without it, lag frames, have no
+	// effect.
+	JoypadPolled bool
+	// JoypadPolls counts the reads of the joypad register in the last frame
+	// and FirstPollCycle is the cycle of the frame at which the first one
+	// happened. FrameCycle is the cycle of the current frame during Step.
+	JoypadPolls    int32
+	FirstPollCycle int32
+	FrameCycle     int32
+	// PollInputs change the buttons between the joypad reads of the next
+	// frame, see PollInput. Step clears them after the frame. Like Watch, they
+	// are not saved. It is an array so Gameboys stay comparable.
+	PollInputs [MaxPollInputs]PollInput
+
+	// Watch, if set, is told about every instruction and every write of the
+	// CPU. It is not saved and copies of the Gameboy call the same Watcher.
+	Watch Watcher
+
+	// Flag if the game is running in cgb mode. For this to be true the game
+	// rom must support cgb mode and the model must be a color model.
+	CGBMode       bool
+	BGPalette     CGBPalette
+	SpritePalette CGBPalette
+
+	CurrentSpeed byte
+	PrepareSpeed bool
+
+	ThisCpuTicks int32
+	// StallCycles is the time that the CPU is paused while a CGB DMA copies
+	// data to VRAM or after a speed switch.
+	StallCycles int32
+
+	ExtraCycles int32
+}
+
+// Step emulates a single frame. A frame is always CyclesPerFrame cycles of the
+// normal speed clock, in double speed mode the CPU runs twice as many cycles in
+// that time.
+func (gb *Gameboy) Step() int {
+	gb.Sound.SampleCount = 0
+	gb.Serial.SentCount = 0
+	gb.JoypadPolled = false
+	gb.JoypadPolls = 0
+	gb.FirstPollCycle = 0
+	cycles := int(gb.ExtraCycles)
+	for cycles < CyclesPerFrame {
+		gb.FrameCycle = int32(cycles)
+		cycles += gb.step()
+	}
+	gb.ExtraCycles = int32(cycles - CyclesPerFrame)
+	gb.PollInputs = [MaxPollInputs]PollInput{}
+	return cycles
+}
+
+// step runs one instruction, or one machine cycle while the CPU is paused, and
+// the rest of the hardware for the same time. It returns the time in normal
+// speed cycles.
+func (gb *Gameboy) step() int {
+	speed := gb.getSpeed()
+	cyclesOp := 4
+	if gb.StallCycles > 0 {
+		// Only the CPU is paused. The PPU, the timers and the DMA keep
+		// running one machine cycle at a time, so the PPU goes through the
+		// modes of every line and requests its STAT interrupts.
+		cyclesOp = min(int(gb.StallCycles), 4)
+		gb.StallCycles -= int32(cyclesOp)
+	} else if !gb.Halted {
+		cyclesOp = gb.ExecuteNextOpcode()
+	} else {
+		// TODO: This is incorrect
+	}
+	gb.updateGraphics(cyclesOp)
+	gb.updateTimers(cyclesOp)
+	gb.updateSerial(cyclesOp)
+	interruptCycles := 0
+	if gb.StallCycles == 0 {
+		interruptCycles = gb.doInterrupts()
+	}
+	if interruptCycles > 0 {
+		// The hardware keeps running while the CPU dispatches the
+		// interrupt.
+		gb.updateGraphics(interruptCycles)
+		gb.updateTimers(interruptCycles)
+		gb.updateSerial(interruptCycles)
+	}
+	gb.Memory.updateDMA(gb, cyclesOp+interruptCycles)
+	// The APU runs at normal speed like the PPU.
+	normalCycles := (cyclesOp + interruptCycles) / speed
+	gb.Sound.update(normalCycles, gb.Options.Sound)
+	return normalCycles
+}
+
+// Frame returns the screen of the last frame that was completed.
+func (gb *Gameboy) Frame() *Screen {
+	return &gb.PreparedData
+}
+
+// BGMapString returns a string of the values in the background map.
+func (gb *Gameboy) BGMapString() string {
+	out := ""
+	for y := uint16(0); y < 0x20; y++ {
+		out += fmt.Sprintf("%2x: ", y)
+		for x := uint16(0); x < 0x20; x++ {
+			out += fmt.Sprintf("%2x ", gb.ReadMemory(0x9800+(y*0x20)+x))
+		}
+		out += "\n"
+	}
+	return out
+}
+
+// Get the current CPU speed multiplier (either 1 or 2).
+func (gb *Gameboy) getSpeed() int {
+	return int(gb.CurrentSpeed + 1)
+}
+
+// speedSwitchCycles is how long the CPU is paused after STOP switched the
+// speed, 2050 machine cycles.
+const speedSwitchCycles = 2050 * 4
+
+// Check if the speed needs to be switched for CGB mode.
+func (gb *Gameboy) checkSpeedSwitch() {
+	if gb.PrepareSpeed {
+		// Switch speed
+		gb.PrepareSpeed = false
+		if gb.CurrentSpeed == 0 {
+			gb.CurrentSpeed = 1
+		} else {
+			gb.CurrentSpeed = 0
+		}
+		gb.Halted = false
+		// STOP resets DIV and the CPU waits for the clock to settle.
+		gb.setSystemCounter(0)
+		gb.StallCycles += speedSwitchCycles
+	}
+}
+
+func (gb *Gameboy) updateTimers(cycles int) {
+	// All timer bits change on machine cycle boundaries, so we step one
+	// machine cycle at a time.
+	for ; cycles > 0; cycles -= 4 {
+		gb.TIMAReloaded = false
+		if gb.TIMAOverflow {
+			gb.TIMAOverflow = false
+			gb.TIMAReloaded = true
+			gb.Memory.HighRAM[TIMA-0xFF00] = gb.Memory.HighRAM[TMA-0xFF00]
+			gb.requestInterrupt(2)
+		}
+
+		step := uint16(min(cycles, 4))
+		gb.setSystemCounter(gb.SystemCounter + step)
+	}
+}
+
+// timerBits are the bits of the system counter that TAC selects to increment
+// TIMA, for 4096, 262144, 65536 and 16384 Hz.
+var timerBits = [4]byte{9, 3, 5, 7}
+
+// timerSignal is the input of TIMA's falling edge detector.
+func (gb *Gameboy) timerSignal() bool {
+	tac := gb.Memory.HighRAM[TAC-0xFF00]
+	bit := timerBits[tac&3]
+	return BitIsSet(tac, 2) && gb.SystemCounter&(1<<bit) != 0
+}
+
+// setSystemCounter changes the system counter and increments TIMA on a falling
+// edge of the timer signal. Writing DIV resets the counter, which increments
+// TIMA if the selected bit was set.
+func (gb *Gameboy) setSystemCounter(value uint16) {
+	before := gb.timerSignal()
+	gb.SystemCounter = value
+	gb.Memory.HighRAM[DIV-0xFF00] = byte(value >> 8)
+	if before && !gb.timerSignal() {
+		gb.incrementTIMA()
+	}
+}
+
+// setTAC writes the TAC register. If this turns the timer signal off, e.g.
+// by disabling the timer or selecting a different bit, TIMA increments.
+func (gb *Gameboy) setTAC(value byte) {
+	before := gb.timerSignal()
+	gb.Memory.HighRAM[TAC-0xFF00] = value | 0xF8
+	if before && !gb.timerSignal() {
+		gb.incrementTIMA()
+	}
+}
+
+// setTIMA writes the TIMA register. Writing in the machine cycle after an
+// overflow cancels the reload and the interrupt. Writing in the cycle of the
+// reload has no effect.
+func (gb *Gameboy) setTIMA(value byte) {
+	if gb.TIMAReloaded {
+		return
+	}
+	gb.TIMAOverflow = false
+	gb.Memory.HighRAM[TIMA-0xFF00] = value
+}
+
+// setTMA writes the TMA register. Writing in the cycle in which TIMA is
+// reloaded also changes TIMA.
+func (gb *Gameboy) setTMA(value byte) {
+	gb.Memory.HighRAM[TMA-0xFF00] = value
+	if gb.TIMAReloaded {
+		gb.Memory.HighRAM[TIMA-0xFF00] = value
+	}
+}
+
+func (gb *Gameboy) incrementTIMA() {
+	tima := gb.Memory.HighRAM[TIMA-0xFF00] + 1
+	gb.Memory.HighRAM[TIMA-0xFF00] = tima
+	if tima == 0 {
+		// TIMA is reloaded one machine cycle later.
+		gb.TIMAOverflow = true
+	}
+}
+
+// Request the Gameboy to perform an interrupt.
+func (gb *Gameboy) requestInterrupt(interrupt byte) {
+	// The hardware sets the flag, the CPU does not write it. It does not go
+	// through Memory.Write, which would report it to the Watch.
+	req := gb.Memory.ReadHighRam(gb, 0xFF0F)
+	req = SetBit(req, interrupt)
+	gb.Memory.HighRAM[0x0F] = req
+}
+
+// doInterrupts is called after each instruction. It wakes the CPU from HALT
+// and dispatches pending interrupts. It returns the number of cycles that the
+// dispatch took.
+func (gb *Gameboy) doInterrupts() (cycles int) {
+	if gb.InterruptsEnabling {
+		// EI takes effect after the instruction following it, so there is
+		// no dispatch between EI and the next instruction. If that is DI,
+		// no interrupt is dispatched at all.
+		gb.InterruptsOn = true
+		gb.InterruptsEnabling = false
+		return 0
+	}
+	if !gb.InterruptsOn && !gb.Halted {
+		return 0
+	}
+
+	if _, ok := gb.pendingInterrupt(); !ok {
+		return 0
+	}
+
+	if !gb.InterruptsOn {
+		// HALT ends when an interrupt is pending, even if it is not
+		// dispatched.
+		gb.Halted = false
+		return 0
+	}
+
+	if gb.Halted {
+		// Waking up from HALT takes one more machine cycle.
+		cycles += 4
+	}
+	gb.serviceInterrupt()
+	return cycles + 20
+}
+
+// pendingInterrupt returns the requested and enabled interrupt with the
+// highest priority.
+func (gb *Gameboy) pendingInterrupt() (byte, bool) {
+	req := gb.Memory.ReadHighRam(gb, 0xFF0F)
+	enabled := gb.Memory.ReadHighRam(gb, 0xFFFF)
+	for i := byte(0); i < 5; i++ {
+		if BitIsSet(req, i) && BitIsSet(enabled, i) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// Address that should be jumped to by interrupt.
+var interruptAddresses = map[byte]uint16{
+	0: 0x40, // V-Blank
+	1: 0x48, // LCDC Status
+	2: 0x50, // Timer Overflow
+	3: 0x58, // Serial Transfer
+	4: 0x60, // Hi-Lo P10-P13
+}
+
+// serviceInterrupt pushes PC and jumps to the pending interrupt's handler.
+func (gb *Gameboy) serviceInterrupt() {
+	gb.InterruptsOn = false
+	gb.Halted = false
+
+	// The interrupt is only chosen after the high byte of PC was pushed. If
+	// the push overwrites IE at 0xFFFF and the interrupt is no longer
+	// enabled, the next pending one is dispatched or, if there is none, the
+	// CPU jumps to 0x0000 without acknowledging any interrupt.
+	pc := gb.CPU.PC
+	sp := gb.CPU.SP.HiLo()
+	gb.Memory.Write(gb, sp-1, byte(pc>>8))
+	interrupt, ok := gb.pendingInterrupt()
+	gb.Memory.Write(gb, sp-2, byte(pc))
+	gb.CPU.SP.Set(sp - 2)
+
+	if !ok {
+		gb.CPU.PC = 0x0000
+		return
+	}
+
+	// Acknowledging the interrupt is not a write of the game either.
+	req := gb.Memory.ReadHighRam(gb, 0xFF0F)
+	req = ResetBit(req, interrupt)
+	gb.Memory.HighRAM[0x0F] = req
+	gb.CPU.PC = interruptAddresses[interrupt]
+}
+
+// Push a 16 bit value onto the stack and decrement SP.
+func (gb *Gameboy) pushStack(address uint16) {
+	sp := gb.CPU.SP.HiLo()
+	gb.Memory.Write(gb, sp-1, byte(uint16(address&0xFF00)>>8))
+	gb.Memory.Write(gb, sp-2, byte(address&0xFF))
+	gb.CPU.SP.Set(gb.CPU.SP.HiLo() - 2)
+}
+
+// Pop the next 16 bit value off the stack and increment SP.
+func (gb *Gameboy) popStack() uint16 {
+	sp := gb.CPU.SP.HiLo()
+	byte1 := uint16(gb.Memory.Read(gb, sp))
+	byte2 := uint16(gb.Memory.Read(gb, sp+1)) << 8
+	gb.CPU.SP.Set(gb.CPU.SP.HiLo() + 2)
+	return byte1 | byte2
+}
+
+func (gb *Gameboy) joypadValue(current byte) byte {
+	var in byte = 0xF
+	if BitIsSet(current, 4) {
+		in = gb.InputMask & 0xF
+	} else if BitIsSet(current, 5) {
+		in = (gb.InputMask >> 4) & 0xF
+	}
+	return current | 0xc0 | in
+}
+
+// IsCGB returns if we are using CGB features.
+func (gb *Gameboy) IsCGB() bool {
+	return gb.CGBMode
+}
+
+// Initialise the Gameboy using a path to a rom.
+func (gb *Gameboy) init(rom string) {
+	gb.setup()
+	hasCGB := gb.Memory.loadCart(rom)
+	gb.CGBMode = gb.Options.Model.IsColor() && hasCGB
+	gb.CPU.Init(gb.Options.Model, gb.CGBMode)
+}
+
+// Setup and instantitate the gameboys components.
+func (gb *Gameboy) setup() {
+	// The CPU is initialised once we know whether the cart supports CGB
+	// mode.
+	gb.CPU = CPU{}
+
+	// Initialise the memory
+	gb.Memory = Memory{}
+	gb.Memory.Init(gb)
+
+	gb.Sound = APU{}
+	gb.Sound.Init()
+
+	gb.ScanlineCounter = 456
+	gb.InputMask = 0xFF
+
+	gb.SpritePalette = NewPalette()
+	gb.BGPalette = NewPalette()
+
+	gb.PreparedData.fill(ColorPalette[3])
+	gb.ScreenData = gb.PreparedData
+}
+
+// IsRumbling reports whether the game has turned on the rumble motor of an
+// MBC5 rumble cart.
+func (gb *Gameboy) IsRumbling() bool {
+	return gb.Memory.Cart.Rumble
+}
+
+// PowerCycle turns the Gameboy off and on again. Only the cartridge's battery
+// backed RAM and its real time clock keep their contents.
+func (gb *Gameboy) PowerCycle() {
+	cart := gb.Memory.Cart
+	*gb = Gameboy{Options: gb.Options}
+	gb.init(cart.rom)
+	gb.Memory.Cart.RAM = cart.RAM
+	gb.Memory.Cart.RTC = cart.RTC
+}
+
+// Reset restarts the game like a reset button would. Unlike PowerCycle, the
+// work RAM and high RAM keep their contents, which reset-based tricks rely on.
+func (gb *Gameboy) Reset() {
+	wram := gb.Memory.WRAM
+	hram := gb.Memory.HighRAM
+	gb.PowerCycle()
+	gb.Memory.WRAM = wram
+	// Only HRAM at 0xFF80-0xFFFE survives, the hardware registers are reset.
+	copy(gb.Memory.HighRAM[0x80:0xFF], hram[0x80:0xFF])
+}
+
+// PressButton notifies the GameBoy that a button has just been pressed
+// and requests a joypad interrupt.
+func (gb *Gameboy) PressButton(button Button) {
+	// We only request the interrupt if the button bit changes from high to low.
+	oldMask := gb.InputMask
+	gb.InputMask = ResetBit(gb.InputMask, byte(button))
+	if gb.InputMask != oldMask {
+		gb.requestInterrupt(4)
+	}
+}
+
+// ReleaseButton notifies the GameBoy that a button has just been released.
+func (gb *Gameboy) ReleaseButton(button Button) {
+	gb.InputMask = SetBit(gb.InputMask, byte(button))
+}
+
+// SetButtons presses the buttons whose bit 1<<Button is set in buttons and
+// releases all others.
+func (gb *Gameboy) SetButtons(buttons byte) {
+	for b := range ButtonCount {
+		if BitIsSet(buttons, byte(b)) {
+			gb.PressButton(b)
+		} else {
+			gb.ReleaseButton(b)
+		}
+	}
+}
+
+// Watcher observes the CPU, see Gameboy.Watch.
+type Watcher interface {
+	// Execute is called before the instruction at pc executes.
+	Execute(pc uint16)
+	// Write is called for every write of the CPU to memory.
+	Write(address uint16, value byte)
+}
+
+// Button represents the button on a GameBoy.
+type Button byte
+
+const (
+	ButtonA Button = iota
+	ButtonB
+	ButtonSelect
+	ButtonStart
+	ButtonRight
+	ButtonLeft
+	ButtonUp
+	ButtonDown
+
+	ButtonCount // NOTE This has to come last.
+)
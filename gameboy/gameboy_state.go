@@ -0,0 +1,375 @@
+package gameboy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// The Gameboy state is saved field by field, each field as a record of its
+// name and its data:
+//
+//	name length (1 byte), name, data length (4 bytes), data
+//
+// Loading a state only sets the fields that are in the data and ignores the
+// records that it does not know. This way, adding a field to the Gameboy does
+// not make old keyframes unreadable. A new field keeps its zero value when
+// loading old states, if that is wrong, derive it from other fields in
+// decodeGameboyState. If a field changes its meaning, give it a new name.
+//
+// All fields are listed once in serializeState which is used for both saving
+// and loading.
+
+// stateCodec saves or loads the fields of the Gameboy state.
+type stateCodec interface {
+	u8(name string, x *byte)
+	u16(name string, x *uint16)
+	u32(name string, x *uint32)
+	i16(name string, x *int16)
+	i32(name string, x *int32)
+	boolean(name string, x *bool)
+	f64(name string, x *float64)
+	bytes(name string, x []byte)
+	screen(name string, x *Screen)
+}
+
+// SaveState saves all fields of the Gameboy.
+func (gb *Gameboy) SaveState() []byte {
+	var e stateEncoder
+	gb.serializeState(&e)
+	return e.buf.Bytes()
+}
+
+// LoadState loads a state saved with SaveState, possibly from an older version
+// of the Gameboy struct. The ROM is not part of the state, the loaded Gameboy
+// plays the cartridge rom, usually the Cart.ROM of another Gameboy. Unlike in
+// NewGameboy, rom is a string because the Gameboy keeps it as is. Key frames
+// load states all the time and this way they all share one ROM instead of
+// copying it each time.
+func LoadState(rom string, data []byte) (Gameboy, error) {
+	gb, err := decodeGameboyState(data)
+	if err != nil {
+		return Gameboy{}, err
+	}
+	gb.Memory.Cart.rom = rom
+	return gb, nil
+}
+
+func decodeGameboyState(data []byte) (Gameboy, error) {
+	var gb Gameboy
+	d := stateDecoder{fields: make(map[string][]byte)}
+	for len(data) > 0 {
+		nameLength := int(data[0])
+		if len(data) < 1+nameLength+4 {
+			return gb, fmt.Errorf("short read: Gameboy state field header is incomplete")
+		}
+		name := string(data[1 : 1+nameLength])
+		data = data[1+nameLength:]
+		length := binary.LittleEndian.Uint32(data)
+		data = data[4:]
+		if uint64(length) > uint64(len(data)) {
+			return gb, fmt.Errorf("short read: Gameboy state field '%s' is incomplete", name)
+		}
+		d.fields[name] = data[:length]
+		data = data[length:]
+	}
+
+	gb.serializeState(&d)
+	if d.err != nil {
+		return gb, d.err
+	}
+
+	// The register masks never change, they are not saved, see CPU.Init.
+	gb.CPU.AF.Mask = 0xFFF0
+	return gb, nil
+}
+
+func (gb *Gameboy) serializeState(c stateCodec) {
+	c.boolean("options.sound", &gb.Options.Sound)
+	c.u8("options.model", (*byte)(&gb.Options.Model))
+
+	cpu := &gb.CPU
+	c.u16("cpu.af", &cpu.AF.Value)
+	c.u16("cpu.bc", &cpu.BC.Value)
+	c.u16("cpu.de", &cpu.DE.Value)
+	c.u16("cpu.hl", &cpu.HL.Value)
+	c.u16("cpu.pc", &cpu.PC)
+	c.u16("cpu.sp", &cpu.SP.Value)
+	c.boolean("cpu.interrupts_enabling", &gb.InterruptsEnabling)
+	c.boolean("cpu.interrupts_on", &gb.InterruptsOn)
+	c.boolean("cpu.halted", &gb.Halted)
+	c.boolean("cpu.cgb_mode", &gb.CGBMode)
+	c.u8("cpu.current_speed", &gb.CurrentSpeed)
+	c.boolean("cpu.prepare_speed", &gb.PrepareSpeed)
+	c.i32("cpu.stall_cycles", &gb.StallCycles)
+	c.i32("cpu.extra_cycles", &gb.ExtraCycles)
+
+	mem := &gb.Memory
+	c.bytes("memory.high_ram", mem.HighRAM[:])
+	c.bytes("memory.vram", mem.VRAM[:])
+	c.u8("memory.vram_bank", &mem.VRAMBank)
+	c.bytes("memory.wram", mem.WRAM[:])
+	c.u8("memory.wram_bank", &mem.WRAMBank)
+	c.bytes("memory.oam", mem.OAM[:])
+	c.u8("memory.hdma_length", &mem.HdmaLength)
+	c.boolean("memory.hdma_active", &mem.HdmaActive)
+	c.boolean("memory.dma_active", &mem.DMAActive)
+	c.u16("memory.dma_source", &mem.DMASource)
+	c.u8("memory.dma_index", &mem.DMAIndex)
+	c.i32("memory.dma_cycles", &mem.DMACycles)
+
+	cart := &mem.Cart
+	c.u8("cart.mode", (*byte)(&cart.Mode))
+	c.u8("cart.memory_bank", (*byte)(&cart.MemoryBank))
+	c.u32("cart.rom_bank", &cart.ROMBank)
+	c.boolean("cart.rom_banking", &cart.ROMBanking)
+	c.bytes("cart.ram", cart.RAM[:])
+	c.u32("cart.ram_bank", &cart.RAMBank)
+	c.boolean("cart.ram_enabled", &cart.RAMEnabled)
+	c.bytes("cart.rtc", cart.RTC[:])
+	c.bytes("cart.latched_rtc", cart.LatchedRtc[:])
+	c.boolean("cart.latched", &cart.Latched)
+	c.boolean("cart.has_rumble", &cart.HasRumble)
+	c.boolean("cart.rumble", &cart.Rumble)
+	c.boolean("cart.camera_selected", &cart.CameraSelected)
+	c.bytes("cart.camera_registers", cart.CameraRegisters[:])
+
+	c.u16("timer.system_counter", &gb.SystemCounter)
+	c.boolean("timer.tima_overflow", &gb.TIMAOverflow)
+	c.boolean("timer.tima_reloaded", &gb.TIMAReloaded)
+
+	c.i32("serial.cycles", &gb.Serial.Cycles)
+
+	c.screen("ppu.screen", &gb.ScreenData)
+	c.screen("ppu.prepared", &gb.PreparedData)
+	c.u8("ppu.window_line", &gb.WindowLine)
+	c.boolean("ppu.window_y_triggered", &gb.WindowYTriggered)
+	c.boolean("ppu.window_wrapped", &gb.WindowWrapped)
+	c.i32("ppu.scanline_counter", &gb.ScanlineCounter)
+	c.boolean("ppu.screen_cleared", &gb.ScreenCleared)
+	c.boolean("ppu.stat_line", &gb.StatLine)
+	c.bytes("ppu.bg_palette", gb.BGPalette.Palette[:])
+	c.u8("ppu.bg_palette_index", &gb.BGPalette.Index)
+	c.boolean("ppu.bg_palette_inc", &gb.BGPalette.Inc)
+	c.bytes("ppu.sprite_palette", gb.SpritePalette.Palette[:])
+	c.u8("ppu.sprite_palette_index", &gb.SpritePalette.Index)
+	c.boolean("ppu.sprite_palette_inc", &gb.SpritePalette.Inc)
+
+	f := &gb.FIFO
+	pixel := func(name string, p *fifoPixel) {
+		c.u8(name+".color", &p.Color)
+		c.u8(name+".palette", &p.Palette)
+		c.boolean(name+".bg_priority", &p.BGPriority)
+		c.u8(name+".oam_index", &p.OAMIndex)
+	}
+	for i := range f.BG {
+		pixel(fmt.Sprintf("fifo.bg%d", i), &f.BG[i])
+	}
+	c.u8("fifo.bg_count", &f.BGCount)
+	for i := range f.Sprites {
+		pixel(fmt.Sprintf("fifo.sprite%d", i), &f.Sprites[i])
+	}
+	c.u8("fifo.x", &f.X)
+	c.u8("fifo.discard", &f.Discard)
+	c.i32("fifo.stall", &f.Stall)
+	c.u8("fifo.fetch_step", &f.FetchStep)
+	c.u8("fifo.fetch_dot", &f.FetchDot)
+	c.u8("fifo.fetch_x", &f.FetchX)
+	c.u8("fifo.tile_number", &f.TileNumber)
+	c.u8("fifo.tile_attr", &f.TileAttr)
+	c.u8("fifo.tile_row", &f.TileRow)
+	c.u8("fifo.data_low", &f.DataLow)
+	c.u8("fifo.data_high", &f.DataHigh)
+	c.boolean("fifo.window", &f.Window)
+	c.u8("fifo.line", &f.Line)
+	for i := range f.LineSprites {
+		s := &f.LineSprites[i]
+		name := fmt.Sprintf("fifo.line_sprite%d", i)
+		c.u8(name+".x", &s.X)
+		c.u8(name+".y", &s.Y)
+		c.u8(name+".tile", &s.Tile)
+		c.u8(name+".attributes", &s.Attributes)
+		c.u8(name+".oam_index", &s.OAMIndex)
+		c.boolean(name+".fetched", &s.Fetched)
+	}
+	c.u8("fifo.sprite_count", &f.SpriteCount)
+	c.i16("fifo.penalty_tile", &f.PenaltyTile)
+	c.boolean("fifo.done", &f.Done)
+
+	c.u8("input.mask", &gb.InputMask)
+	c.boolean("input.joypad_polled", &gb.JoypadPolled)
+	c.i32("input.joypad_polls", &gb.JoypadPolls)
+	c.i32("input.first_poll_cycle", &gb.FirstPollCycle)
+
+	apu := &gb.Sound
+	c.bytes("apu.memory", apu.Memory[:])
+	for i, ch := range []*Channel{&apu.Channel1, &apu.Channel2, &apu.Channel3, &apu.Channel4} {
+		name := fmt.Sprintf("apu.channel%d", i+1)
+		c.f64(name+".frequency", &ch.Frequency)
+		c.u8(name+".generator.type", (*byte)(&ch.Generator.Type))
+		c.f64(name+".generator.mod", &ch.Generator.Mod)
+		c.f64(name+".generator.last", &ch.Generator.Last)
+		c.u8(name+".generator.val", &ch.Generator.Val)
+		c.u16(name+".generator.lfsr", &ch.Generator.LFSR)
+		c.u8(name+".generator.position", &ch.Generator.Position)
+		c.i32(name+".generator.position_cycles", &ch.Generator.PositionCycles)
+		c.f64(name+".time", &ch.Time)
+		c.f64(name+".amplitude", &ch.Amplitude)
+		c.boolean(name+".enabled", &ch.Enabled)
+		c.boolean(name+".dac_on", &ch.DACOn)
+		c.i32(name+".length_counter", &ch.LengthCounter)
+		c.u8(name+".volume", &ch.Volume)
+		c.u8(name+".envelope_period", &ch.EnvelopePeriod)
+		c.u8(name+".envelope_timer", &ch.EnvelopeTimer)
+		c.boolean(name+".envelope_increasing", &ch.EnvelopeIncreasing)
+		c.u16(name+".shadow_frequency", &ch.ShadowFrequency)
+		c.u8(name+".sweep_timer", &ch.SweepTimer)
+		c.boolean(name+".sweep_enabled", &ch.SweepEnabled)
+		c.boolean(name+".on", &ch.On)
+	}
+	c.f64("apu.left_volume", &apu.LeftVolume)
+	c.f64("apu.right_volume", &apu.RightVolume)
+	c.bytes("apu.waveform_ram", apu.WaveformRam[:])
+	c.i32("apu.sample_cycles", &apu.SampleCycles)
+	c.i32("apu.frame_sequencer_cycles", &apu.FrameSequencerCycles)
+	c.u8("apu.frame_sequencer_step", &apu.FrameSequencerStep)
+}
+
+type stateEncoder struct {
+	buf bytes.Buffer
+}
+
+func (e *stateEncoder) field(name string, data []byte) {
+	e.buf.WriteByte(byte(len(name)))
+	e.buf.WriteString(name)
+	e.buf.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(data))))
+	e.buf.Write(data)
+}
+
+func (e *stateEncoder) u8(name string, x *byte) {
+	e.field(name, []byte{*x})
+}
+
+func (e *stateEncoder) u16(name string, x *uint16) {
+	e.field(name, binary.LittleEndian.AppendUint16(nil, *x))
+}
+
+func (e *stateEncoder) u32(name string, x *uint32) {
+	e.field(name, binary.LittleEndian.AppendUint32(nil, *x))
+}
+
+func (e *stateEncoder) i16(name string, x *int16) {
+	e.field(name, binary.LittleEndian.AppendUint16(nil, uint16(*x)))
+}
+
+func (e *stateEncoder) i32(name string, x *int32) {
+	e.field(name, binary.LittleEndian.AppendUint32(nil, uint32(*x)))
+}
+
+func (e *stateEncoder) boolean(name string, x *bool) {
+	e.field(name, []byte{BoolToBit(*x)})
+}
+
+func (e *stateEncoder) f64(name string, x *float64) {
+	e.field(name, binary.LittleEndian.AppendUint64(nil, math.Float64bits(*x)))
+}
+
+func (e *stateEncoder) bytes(name string, x []byte) {
+	e.field(name, x)
+}
+
+func (e *stateEncoder) screen(name string, x *Screen) {
+	data := make([]byte, 0, len(x)*len(x[0]))
+	for _, row := range x {
+		data = append(data, row[:]...)
+	}
+	e.field(name, data)
+}
+
+type stateDecoder struct {
+	fields map[string][]byte
+	err    error
+}
+
+// field returns the data of the named field if it exists and has the given
+// size. Fields that are missing keep their zero value.
+func (d *stateDecoder) field(name string, size int) ([]byte, bool) {
+	data, ok := d.fields[name]
+	if !ok {
+		return nil, false
+	}
+	if len(data) != size {
+		if d.err == nil {
+			d.err = fmt.Errorf("Gameboy state field '%s' has %d bytes instead of %d", name, len(data), size)
+		}
+		return nil, false
+	}
+	return data, true
+}
+
+func (d *stateDecoder) u8(name string, x *byte) {
+	if data, ok := d.field(name, 1); ok {
+		*x = data[0]
+	}
+}
+
+func (d *stateDecoder) u16(name string, x *uint16) {
+	if data, ok := d.field(name, 2); ok {
+		*x = binary.LittleEndian.Uint16(data)
+	}
+}
+
+func (d *stateDecoder) u32(name string, x *uint32) {
+	if data, ok := d.field(name, 4); ok {
+		*x = binary.LittleEndian.Uint32(data)
+	}
+}
+
+func (d *stateDecoder) i16(name string, x *int16) {
+	if data, ok := d.field(name, 2); ok {
+		*x = int16(binary.LittleEndian.Uint16(data))
+	}
+}
+
+func (d *stateDecoder) i32(name string, x *int32) {
+	if data, ok := d.field(name, 4); ok {
+		*x = int32(binary.LittleEndian.Uint32(data))
+	}
+}
+
+func (d *stateDecoder) boolean(name string, x *bool) {
+	if data, ok := d.field(name, 1); ok {
+		*x = data[0] != 0
+	}
+}
+
+func (d *stateDecoder) f64(name string, x *float64) {
+	if data, ok := d.field(name, 8); ok {
+		*x = math.Float64frombits(binary.LittleEndian.Uint64(data))
+	}
+}
+
+func (d *stateDecoder) bytes(name string, x []byte) {
+	// Arrays may grow, e.g. when supporting bigger cartridge RAM, so smaller
+	// old data is fine.
+	data, ok := d.fields[name]
+	if !ok {
+		return
+	}
+	if len(data) > len(x) {
+		if d.err == nil {
+			d.err = fmt.Errorf("Gameboy state field '%s' has %d bytes, more than %d", name, len(data), len(x))
+		}
+		return
+	}
+	copy(x, data)
+}
+
+func (d *stateDecoder) screen(name string, x *Screen) {
+	if data, ok := d.field(name, len(x)*len(x[0])); ok {
+		for y := range x {
+			data = data[copy(x[y][:], data):]
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package gameboy
+
+import "testing"
+
+func TestCartKeepsROMCopy(t *testing.T) {
+	rom := make([]byte, 0x8000)
+	rom[0x100] = 0x00 // NOP
+	gb := NewGameboy(rom, GameboyOptions{})
+	rom[0x100] = 0x76 // HALT
+	if got := gb.ReadMemory(0x100); got != 0x00 {
+		t.Errorf("the cart reads %02X after the ROM was modified, want 00", got)
+	}
+}
+
+func TestLoadStateSharesROM(t *testing.T) {
+	gb := newTestGameboy(ModelCGB, jrLoop...)
+	gb.Step()
+	loaded, err := LoadState(gb.Memory.Cart.ROM(), gb.SaveState())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Some fields only matter within a frame, they are not saved. They are
+	// equal again after the next frame.
+	loaded.Step()
+	gb.Step()
+	if loaded != gb {
+		t.Error("the loaded Gameboy emulates differently")
+	}
+}
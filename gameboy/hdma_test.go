@@ -0,0 +1,107 @@
+package gameboy
+
+import "testing"
+
+// stallLine is a line that the PPU went through while the CPU was paused.
+type stallLine struct {
+	ly    byte
+	modes []byte
+}
+
+// runStall steps gb until the CPU runs again. It returns the lines in the order
+// that the PPU went through them, with the STAT modes that each line changed
+// to, how many times H-Blank started and how many STAT interrupts were
+// requested.
+func runStall(gb *Gameboy) (lines []stallLine, hblanks, statInterrupts int) {
+	last := gb.Memory.HighRAM[0x41] & 3
+	for gb.StallCycles > 0 {
+		gb.step()
+		ly := gb.Memory.HighRAM[0x44]
+		mode := gb.Memory.HighRAM[0x41] & 3
+		if len(lines) == 0 || lines[len(lines)-1].ly != ly {
+			lines = append(lines, stallLine{ly: ly})
+			// STAT shows the new line's mode one step after LY changed.
+			last = mode
+		}
+		line := &lines[len(lines)-1]
+		if mode != last {
+			line.modes = append(line.modes, mode)
+			last = mode
+			if mode == 0 {
+				hblanks++
+			}
+		}
+		if gb.Memory.HighRAM[0x0F]&0x02 != 0 {
+			statInterrupts++
+			gb.Memory.HighRAM[0x0F] &^= 0x02
+		}
+	}
+	return
+}
+
+// checkStallLines checks that the PPU went through the lines one after the
+// other and through modes 2, 3 and 0 on every visible line that started and
+// ended during the pause. Those lines must have been drawn and every H-Blank
+// must have requested a STAT interrupt.
+func checkStallLines(t *testing.T, gb *Gameboy, lines []stallLine, hblanks, statInterrupts int) {
+	t.Helper()
+	for i := 1; i < len(lines); i++ {
+		if want := byte((int(lines[i-1].ly) + 1) % 154); lines[i].ly != want {
+			t.Fatalf("LY went from %d to %d", lines[i-1].ly, lines[i].ly)
+		}
+	}
+
+	if len(lines) < 3 {
+		t.Fatalf("the PPU went through %d lines during the pause", len(lines))
+	}
+	visible := 0
+	for _, line := range lines[1 : len(lines)-1] {
+		if line.ly >= ScreenHeight {
+			continue
+		}
+		visible++
+		if string(line.modes) != "\x02\x03\x00" {
+			t.Errorf("line %d went through modes %v, want [2 3 0]", line.ly, line.modes)
+		}
+		// A new frame starts out black, the white palette shows that the
+		// line was drawn.
+		if p := gb.ScreenData.Pixel(0, int(line.ly)); p == [3]uint8{} {
+			t.Errorf("line %d was not drawn", line.ly)
+		}
+	}
+	if visible == 0 {
+		t.Fatal("the pause did not cover a whole visible line")
+	}
+	if statInterrupts != hblanks {
+		t.Errorf("%d STAT interrupts for %d H-Blanks", statInterrupts, hblanks)
+	}
+}
+
+func TestGeneralDMAInVBlank(t *testing.T) {
+	gb := newTestGameboy(ModelCGB, jrLoop...)
+	for gb.Memory.HighRAM[0x44] != 150 {
+		gb.step()
+	}
+	// Request a STAT interrupt when H-Blank starts.
+	gb.Memory.Write(&gb, 0xFF41, 0x08)
+	gb.Memory.Write(&gb, 0xFF0F, 0)
+
+	gb.Memory.Write(&gb, 0xFF51, 0xC0)
+	gb.Memory.Write(&gb, 0xFF52, 0x00)
+	gb.Memory.Write(&gb, 0xFF53, 0x80)
+	gb.Memory.Write(&gb, 0xFF54, 0x00)
+	// 128 blocks pause the CPU for 4100 cycles, about 9 lines. The transfer
+	// starts in V-Blank and ends in the first lines of the next frame.
+	gb.Memory.Write(&gb, 0xFF55, 0x7F)
+	dot := ppuDot(&gb)
+	stall := int(gb.StallCycles)
+
+	lines, hblanks, statInterrupts := runStall(&gb)
+	if got, want := ppuDot(&gb), (dot+stall)%lcdFrameDots; got != want {
+		t.Errorf("PPU at cycle %d after the transfer, want %d", got, want)
+	}
+	if lines[len(lines)-1].ly >= ScreenHeight {
+		t.Fatalf("the transfer ended on line %d, not in the next frame", lines[len(lines)-1].ly)
+	}
+	checkStallLines(t, &gb, lines, hblanks, statInterrupts)
+}
@@ -1,4 +1,4 @@
-package main
+package gameboy
 
 import "log"
 
@@ -50,6 +50,9 @@ var (
 // ExecuteNextOpcode gets the value at the current PC address, increments the PC,
 // updates the CPU ticks and executes the opcode.
 func (gb *Gameboy) ExecuteNextOpcode() int {
+	if gb.Watch != nil {
+		gb.Watch.Execute(gb.CPU.PC)
+	}
 	opcode := gb.popPC()
 	gb.ThisCpuTicks = int32(OpcodeCycles[opcode] * 4)
 	mainInst[opcode](gb)
@@ -1239,7 +1242,8 @@ func mainInstructions() [0x100]func(gb *Gameboy) {
 		0xD9: func(gb *Gameboy) {
 			// RETI
 			gb.instRet()
-			gb.InterruptsEnabling = true
+			// Unlike EI, RETI enables interrupts immediately.
+			gb.InterruptsOn = true
 		},
 		0xCB: func(gb *Gameboy) {
 			// CB
@@ -1,4 +1,4 @@
-package main
+package gameboy
 
 func instRlc(gb *Gameboy, setter func(gb *Gameboy, value byte), val byte) {
 	carry := val >> 7
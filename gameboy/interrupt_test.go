@@ -0,0 +1,131 @@
+package gameboy
+
+import (
+	"slices"
+	"testing"
+)
+
+// newTestGameboy returns a Gameboy that runs code from the entry point at 0x100
+// with interrupts disabled. The ROM is for the CGB, color models run it in
+// color mode.
+func newTestGameboy(model ConsoleModel, code ...byte) Gameboy {
+	rom := make([]byte, 0x8000)
+	copy(rom[0x100:], code)
+	rom[0x143] = 0xC0 // CGB only
+	gb := NewGameboy(rom, GameboyOptions{Model: model})
+	gb.Memory.Write(&gb, 0xFFFF, 0)
+	return gb
+}
+
+// stepInstruction executes one instruction and the interrupt check after it,
+// like Step does. It returns the cycles of the dispatch.
+func stepInstruction(gb *Gameboy) int {
+	gb.ExecuteNextOpcode()
+	return gb.doInterrupts()
+}
+
+// newInterruptTestGameboy returns a DMG that runs code with the interrupts in
+// mask requested and enabled.
+func newInterruptTestGameboy(mask byte, code ...byte) Gameboy {
+	gb := newTestGameboy(ModelDMG, code...)
+	gb.Memory.Write(&gb, 0xFF0F, mask)
+	gb.Memory.Write(&gb, 0xFFFF, mask)
+	return gb
+}
+
+func TestEIDelay(t *testing.T) {
+	gb := newInterruptTestGameboy(0x01,
+		0xFB, // EI
+		0x00, // NOP
+		0x00, // NOP
+	)
+	gb.CPU.SP.Set(0xD000)
+
+	if cycles := stepInstruction(&gb); cycles != 0 || gb.CPU.PC != 0x101 {
+		t.Fatalf("interrupt dispatched right after EI, PC is %04X", gb.CPU.PC)
+	}
+	if cycles := stepInstruction(&gb); cycles != 20 {
+		t.Errorf("dispatch took %d cycles, want 20", cycles)
+	}
+	if gb.CPU.PC != 0x40 {
+		t.Fatalf("PC is %04X after the dispatch, want 0040", gb.CPU.PC)
+	}
+	if ret := uint16(gb.Memory.Read(&gb, 0xCFFF))<<8 | uint16(gb.Memory.Read(&gb, 0xCFFE)); ret != 0x102 {
+		t.Errorf("pushed return address %04X, want 0102", ret)
+	}
+	if got := gb.Memory.Read(&gb, 0xFF0F) & 0x1F; got != 0 {
+		t.Errorf("IF is %02X after the dispatch, want 00", got)
+	}
+	if gb.InterruptsOn {
+		t.Error("interrupts are still enabled in the handler")
+	}
+}
+
+func TestEIDI(t *testing.T) {
+	gb := newInterruptTestGameboy(0x01,
+		0xFB, // EI
+		0xF3, // DI
+		0x00, // NOP
+	)
+
+	for range 3 {
+		if stepInstruction(&gb) != 0 {
+			t.Fatalf("interrupt dispatched at %04X", gb.CPU.PC)
+		}
+	}
+	if gb.InterruptsOn {
+		t.Error("interrupts are enabled after EI, DI")
+	}
+}
+
+func TestIEPush(t *testing.T) {
+	// The high byte of PC, 01, is pushed to IE at FFFF and disables the
+	// timer interrupt before it is chosen.
+	gb := newInterruptTestGameboy(0x04,
+		0xFB, // EI
+		0x00, // NOP
+	)
+	gb.CPU.SP.Set(0x0000)
+
+	stepInstruction(&gb)
+	stepInstruction(&gb)
+	if gb.CPU.PC != 0x0000 {
+		t.Errorf("PC is %04X after the canceled dispatch, want 0000", gb.CPU.PC)
+	}
+	if got := gb.Memory.Read(&gb, 0xFF0F) & 0x1F; got != 0x04 {
+		t.Errorf("IF is %02X after the canceled dispatch, want 04", got)
+	}
+}
+
+// writeLog is a Watcher that records the addresses that the CPU writes.
+type writeLog []uint16
+
+func (w *writeLog) Execute(pc uint16) {}
+
+func (w *writeLog) Write(address uint16, value byte) {
+	*w = append(*w, address)
+}
+
+func TestInterruptFlagsAreNotWatched(t *testing.T) {
+	gb := newInterruptTestGameboy(0x00,
+		0xFB, // EI
+		0x00, // NOP
+		0x00, // NOP
+	)
+	gb.Memory.Write(&gb, 0xFFFF, 0x01)
+	gb.CPU.SP.Set(0xD000)
+	var writes writeLog
+	gb.Watch = &writes
+
+	// Requesting and acknowledging the interrupt is done by the hardware,
+	// only pushing the return address is a write of the CPU.
+	gb.requestInterrupt(0)
+	stepInstruction(&gb)
+	stepInstruction(&gb)
+	if gb.CPU.PC != 0x40 {
+		t.Fatalf("PC is %04X, the interrupt was not dispatched", gb.CPU.PC)
+	}
+	if want := (writeLog{0xCFFF, 0xCFFE}); !slices.Equal(writes, want) {
+		t.Errorf("watched writes to %04X, want %04X", writes, want)
+	}
+}
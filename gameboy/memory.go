@@ -1,4 +1,4 @@
-package main
+package gameboy
 
 const (
 	// DIV is the divider register which is incremented periodically by
@@ -34,12 +34,23 @@ type Memory struct {
 	// CGB HDMA transfer variables
 	HdmaLength byte
 	HdmaActive bool
+	// OAM DMA transfer variables. While the transfer is active, the CPU
+	// cannot access the bus that the DMA reads from, see dmaConflict.
+	DMAActive bool
+	DMASource uint16
+	// DMAIndex is the next byte to copy to OAM.
+	DMAIndex byte
+	// DMACycles are the cycles left over from the last update, DMA copies one
+	// byte every 4 cycles.
+	DMACycles int32
 }
 
 // Init the gb memory to the post-boot values.
 func (mem *Memory) Init(gameboy *Gameboy) {
 	// Set the default values
-	mem.HighRAM[0x04] = 0x1E
+	gameboy.SystemCounter = uint16(gameboy.Options.Model.bootDivider()) << 8
+	mem.HighRAM[0x04] = gameboy.Options.Model.bootDivider()
+	mem.HighRAM[0x02] = 0x7E
 	mem.HighRAM[0x05] = 0x00
 	mem.HighRAM[0x06] = 0x00
 	mem.HighRAM[0x07] = 0xF8
@@ -79,7 +90,11 @@ func (mem *Memory) Init(gameboy *Gameboy) {
 
 // LoadCart load a cart rom into memory.
 func (mem *Memory) LoadCart(rom []byte) bool {
-	mem.Cart = NewCart(rom, "")
+	return mem.loadCart(string(rom))
+}
+
+func (mem *Memory) loadCart(rom string) bool {
+	mem.Cart = newCart(rom)
 	return mem.Cart.GetMode()&CGB != 0
 }
 
@@ -99,31 +114,24 @@ func (mem *Memory) WriteHighRam(gb *Gameboy, address uint16, value byte) {
 		gb.Sound.WriteWaveform(address, value)
 
 	case address == 0xFF02:
+		gb.writeSerialControl(value)
 
 	case address == DIV:
-		// Trap divider register
-		gb.setClockFreq()
-		gb.CPU.Divider = 0
-		mem.HighRAM[DIV-0xFF00] = 0
+		// Writing any value resets the whole system counter.
+		gb.setSystemCounter(0)
 
 	case address == TIMA:
-		mem.HighRAM[TIMA-0xFF00] = value
+		gb.setTIMA(value)
 
 	case address == TMA:
-		mem.HighRAM[TMA-0xFF00] = value
+		gb.setTMA(value)
 
 	case address == TAC:
-		// Timer control
-		currentFreq := gb.getClockFreq()
-		mem.HighRAM[TAC-0xFF00] = value | 0xF8
-		newFreq := gb.getClockFreq()
-
-		if currentFreq != newFreq {
-			gb.setClockFreq()
-		}
+		gb.setTAC(value)
 
 	case address == 0xFF41:
-		mem.HighRAM[0x41] = value | 0x80
+		// The mode and coincidence bits are read-only, only the PPU sets them.
+		mem.HighRAM[0x41] = value&0xF8 | mem.HighRAM[0x41]&0x07 | 0x80
 
 	case address == 0xFF44:
 		// Trap scanline register
@@ -131,7 +139,8 @@ func (mem *Memory) WriteHighRam(gb *Gameboy, address uint16, value byte) {
 
 	case address == 0xFF46:
 		// DMA transfer
-		mem.doDMATransfer(gb, value)
+		mem.HighRAM[0x46] = value
+		mem.startDMATransfer(value)
 
 	case address == 0xFF4D:
 		// CGB speed change
@@ -193,8 +202,22 @@ func (mem *Memory) WriteHighRam(gb *Gameboy, address uint16, value byte) {
 
 // Write a value at an address to the relevant location based on the
 // current state of the gameboy. This handles banking and side effects
-// of writing to certain addresses.
+// of writing to certain addresses. It is the CPU's access to memory, for its
+// instructions, stack pushes and interrupt dispatch, see dmaConflict.
 func (mem *Memory) Write(gb *Gameboy, address uint16, value byte) {
+	if mem.dmaConflict(address) {
+		// The write does not reach the memory.
+		return
+	}
+	if gb.Watch != nil {
+		gb.Watch.Write(address, value)
+	}
+	mem.write(gb, address, value)
+}
+
+// write is like Write but it ignores OAM DMA bus restrictions, it is used by
+// the DMA units themselves.
+func (mem *Memory) write(gb *Gameboy, address uint16, value byte) {
 	switch {
 	case address < 0x8000:
 		// Write to the cartridge ROM (banking)
@@ -238,8 +261,47 @@ func (mem *Memory) Write(gb *Gameboy, address uint16, value byte) {
 }
 
 // Read from memory. Will go and read from cartridge memory if the
-// requested address is mapped to that space.
+// requested address is mapped to that space. Like Write, it is the CPU's
+// access to memory.
 func (mem *Memory) Read(gb *Gameboy, address uint16) byte {
+	if mem.dmaConflict(address) {
+		if address >= 0xFE00 {
+			return 0xFF
+		}
+		// The CPU reads what the DMA puts on the bus.
+		return mem.read(gb, mem.DMASource+uint16(mem.DMAIndex))
+	}
+	return mem.read(gb, address)
+}
+
+// dmaConflict reports whether a CPU access to the address collides with a
+// running OAM DMA. The DMA occupies the bus of its source, the video bus for
+// VRAM and the external bus for everything else. The CPU can still use the
+// other bus, e.g. a stack in work RAM while the DMA copies from VRAM. OAM is
+// not accessible during the DMA, IO and HRAM always are.
+func (mem *Memory) dmaConflict(address uint16) bool {
+	if !mem.DMAActive || address >= 0xFF00 {
+		return false
+	}
+	if address >= 0xFE00 {
+		return true
+	}
+	return onVideoBus(address) == onVideoBus(mem.DMASource)
+}
+
+func onVideoBus(address uint16) bool {
+	return 0x8000 <= address && address < 0xA000
+}
+
+// ReadMemory returns the value at the address like the CPU would see it,
+// ignoring OAM DMA bus restrictions.
+func (gb *Gameboy) ReadMemory(address uint16) byte {
+	return gb.Memory.read(gb, address)
+}
+
+// read is like Read but it ignores OAM DMA bus restrictions, it is used by
+// the DMA units themselves and by debugging tools.
+func (mem *Memory) read(gb *Gameboy, address uint16) byte {
 	switch {
 	case address < 0x8000:
 		// Cartridge ROM
@@ -293,6 +355,7 @@ func (mem *Memory) ReadHighRam(gb *Gameboy, address uint16) byte {
 	switch {
 	// Joypad address
 	case address == 0xFF00:
+		gb.pollJoypad()
 		return gb.joypadValue(mem.HighRAM[0x00])
 
 	case address >= 0xFF10 && address <= 0xFF26:
@@ -338,7 +401,7 @@ func (mem *Memory) ReadHighRam(gb *Gameboy, address uint16) byte {
 
 	case address == 0xFF4D:
 		// Speed switch data
-		return gb.CurrentSpeed<<7 | BoolToBit(gb.PrepareSpeed)
+		return gb.CurrentSpeed<<7 | 0x7E | BoolToBit(gb.PrepareSpeed)
 
 	case address == 0xFF4F:
 		return mem.VRAMBank
@@ -351,48 +414,77 @@ func (mem *Memory) ReadHighRam(gb *Gameboy, address uint16) byte {
 	}
 }
 
-// Perform a DMA transfer.
-func (mem *Memory) doDMATransfer(gb *Gameboy, value byte) {
-	// TODO: This may need to be done instead of CPU ticks
-	address := uint16(value) << 8 // (data * 100)
+// Start an OAM DMA transfer of 0xA0 bytes from value*0x100 to OAM. The
+// transfer runs in the background and takes 160 machine cycles, see
+// updateDMA. Restarting a running transfer starts over.
+func (mem *Memory) startDMATransfer(value byte) {
+	mem.DMAActive = true
+	mem.DMASource = uint16(value) << 8
+	mem.DMAIndex = 0
+	mem.DMACycles = 0
+}
 
-	var i uint16
-	for i = 0; i < 0xA0; i++ {
-		// TODO: Check this doesn't prevent
-		mem.Write(gb, 0xFE00+i, mem.Read(gb, address+i))
+// updateDMA copies the OAM DMA bytes that are due after the given number of
+// cycles.
+func (mem *Memory) updateDMA(gb *Gameboy, cycles int) {
+	if !mem.DMAActive {
+		return
+	}
+
+	mem.DMACycles += int32(cycles)
+	for mem.DMAActive && mem.DMACycles >= 4 {
+		mem.DMACycles -= 4
+		i := uint16(mem.DMAIndex)
+		mem.OAM[i] = mem.read(gb, mem.DMASource+i)
+		mem.DMAIndex++
+		if mem.DMAIndex == 0xA0 {
+			mem.DMAActive = false
+		}
 	}
 }
 
+// dmaBlockCycles is how long the CPU is paused for a CGB DMA to copy one block
+// of 0x10 bytes in normal speed, 8 machine cycles. In double speed, the
+// copy takes as long in real time, which is twice the cycles.
+const dmaBlockCycles = 32
+
 // Start a CGB DMA transfer.
 func (mem *Memory) doNewDMATransfer(gb *Gameboy, value byte) {
 	if mem.HdmaActive && BitValue(value, 7) == 0 {
-		// Abort a HDMA transfer
+		// Abort a HDMA transfer, FF55 now reads the remaining length with
+		// bit 7 set.
 		mem.HdmaActive = false
 		mem.HighRAM[0x55] |= 0x80 // Set bit 7
 		return
 	}
 
-	length := ((uint16(value) & 0x7F) + 1) * 0x10
+	blocks := uint16(value&0x7F) + 1
 
 	// The 7th bit is DMA mode
 	if value>>7 == 0 {
-		// Mode 0, general purpose DMA
-		mem.performNewDMATransfer(gb, length)
+		// Mode 0, general purpose DMA. The CPU is paused until all blocks are
+		// copied, plus one machine cycle to start the transfer.
+		mem.performNewDMATransfer(gb, blocks*0x10)
 		mem.HighRAM[0x55] = 0xFF
+		gb.StallCycles += 4 + int32(blocks)*dmaBlockCycles*int32(gb.getSpeed())
 	} else {
-		// Mode 1, H-Blank DMA
-		mem.HdmaLength = byte(value)
+		// Mode 1, H-Blank DMA. FF55 reads the remaining length minus one with
+		// bit 7 cleared while the transfer is active.
+		mem.HdmaLength = value & 0x7F
 		mem.HdmaActive = true
+		mem.HighRAM[0x55] = mem.HdmaLength
 	}
 }
 
-// Perform a HDMA transfer during a HBlank period.
+// Perform a HDMA transfer during a HBlank period. It copies one block of 0x10
+// bytes and pauses the CPU while doing so.
 func (mem *Memory) doHDMATransfer(gb *Gameboy) {
 	if !mem.HdmaActive {
 		return
 	}
 
 	mem.performNewDMATransfer(gb, 0x10)
+	gb.StallCycles += dmaBlockCycles * int32(gb.getSpeed())
 	if mem.HdmaLength > 0 {
 		mem.HdmaLength--
 		mem.HighRAM[0x55] = mem.HdmaLength
@@ -408,11 +500,11 @@ func (mem *Memory) performNewDMATransfer(gb *Gameboy, length uint16) {
 	// Load the source and destination from RAM
 	source := (uint16(mem.HighRAM[0x51])<<8 | uint16(mem.HighRAM[0x52])) & 0xFFF0
 	destination := (uint16(mem.HighRAM[0x53])<<8 | uint16(mem.HighRAM[0x54])) & 0x1FF0
-	destination += 0x8000
 
-	// Transfer the data from the source to the destination
+	// Transfer the data from the source to the destination, which wraps
+	// around at the end of VRAM.
 	for i := uint16(0); i < length; i++ {
-		mem.Write(gb, destination, mem.Read(gb, source))
+		mem.write(gb, 0x8000+destination&0x1FFF, mem.read(gb, source))
 		destination++
 		source++
 	}
@@ -420,6 +512,6 @@ func (mem *Memory) performNewDMATransfer(gb *Gameboy, length uint16) {
 	// Update the source and destination in RAM
 	mem.HighRAM[0x51] = byte(source >> 8)
 	mem.HighRAM[0x52] = byte(source & 0xFF)
-	mem.HighRAM[0x53] = byte(destination >> 8)
+	mem.HighRAM[0x53] = byte(destination >> 8 & 0x1F)
 	mem.HighRAM[0x54] = byte(destination & 0xF0)
 }
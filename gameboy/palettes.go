@@ -1,4 +1,4 @@
-package main
+package gameboy
 
 var ColorPalette = [4][3]byte{
 	{0xE0, 0xF8, 0xD0},
@@ -0,0 +1,31 @@
+package gameboy
+
+// A game can read the joypad several times in one frame. Normally the buttons
+// stay the same for the whole frame. Poll inputs change them from a given read
+// of the joypad register on.
+
+// MaxPollInputs is the number of changes that a frame can have.
+const MaxPollInputs = 8
+
+// PollInput presses the Buttons, a bit mask with bit 1<<Button set for each
+// pressed button, and releases all others when the game reads the joypad
+// register for the FromPoll-th time in a frame, counting from 1. 0 marks
+// unused changes.
+type PollInput struct {
+	FromPoll int32
+	Buttons  byte
+}
+
+// pollJoypad is called when the game reads the joypad register.
+func (gb *Gameboy) pollJoypad() {
+	if gb.JoypadPolls == 0 {
+		gb.FirstPollCycle = gb.FrameCycle
+	}
+	gb.JoypadPolled = true
+	gb.JoypadPolls++
+	for _, p := range gb.PollInputs {
+		if p.FromPoll == gb.JoypadPolls {
+			gb.SetButtons(p.Buttons)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package gameboy
+
+const (
+	// ScreenWidth x ScreenHeight is the Gameboy screen size.
+	ScreenWidth  = 160
+	ScreenHeight = 144
+
+	// LCDC is the main LCD Control register.
+	LCDC = 0xFF40
+)
+
+// Screen holds the RGB pixels of one frame, row by row from the top.
+// Keeping the rows contiguous lets us copy whole rows at once.
+type Screen [ScreenHeight][3 * ScreenWidth]uint8
+
+func (s *Screen) Pixel(x, y int) [3]uint8 {
+	return [3]uint8(s[y][3*x:])
+}
+
+func (s *Screen) setPixel(x, y int, c [3]uint8) {
+	copy(s[y][3*x:], c[:])
+}
+
+// fill sets every pixel to the color c.
+func (s *Screen) fill(c [3]uint8) {
+	for x := range ScreenWidth {
+		s.setPixel(x, 0, c)
+	}
+	for y := 1; y < ScreenHeight; y++ {
+		s[y] = s[0]
+	}
+}
+
+// Update the state of the graphics.
+func (gb *Gameboy) updateGraphics(cycles int) {
+	gb.setLCDStatus()
+
+	if !gb.isLCDEnabled() {
+		return
+	}
+
+	// In double speed mode, the PPU still runs at normal speed.
+	dots := cycles / gb.getSpeed()
+	gb.ScanlineCounter -= int32(dots)
+
+	if gb.Memory.HighRAM[0x41]&3 == 3 {
+		gb.stepScanline(dots)
+	}
+
+	if gb.ScanlineCounter <= 0 {
+		gb.Memory.HighRAM[0x44]++
+		if gb.Memory.HighRAM[0x44] > 153 {
+			gb.PreparedData = gb.ScreenData
+			gb.ScreenData = Screen{}
+			gb.Memory.HighRAM[0x44] = 0
+			gb.resetWindow()
+		}
+
+		currentLine := gb.Memory.ReadHighRam(gb, 0xFF44)
+		gb.ScanlineCounter += 456
+
+		if currentLine == ScreenHeight {
+			gb.requestInterrupt(0)
+		}
+	}
+}
+
+// lcdMode2Bounds is where mode 2 ends. Mode 3 then takes as long as it takes
+// to draw the line, see stepScanline.
+const lcdMode2Bounds = 456 - 80
+
+// Set the status of the LCD based on the current state of memory.
+func (gb *Gameboy) setLCDStatus() {
+	status := gb.Memory.ReadHighRam(gb, 0xFF41)
+
+	if !gb.isLCDEnabled() {
+		// set the screen to white
+		gb.clearScreen()
+
+		gb.ScanlineCounter = 456
+		gb.Memory.HighRAM[0x44] = 0
+		gb.StatLine = false
+		gb.resetWindow()
+		status &= 252
+		// TODO: Check this is correct
+		// We aren't in a mode so reset the values
+		status = ResetBit(status, 0)
+		status = ResetBit(status, 1)
+		gb.Memory.HighRAM[0x41] = status | 0x80
+		return
+	}
+	gb.ScreenCleared = false
+
+	currentLine := gb.Memory.ReadHighRam(gb, 0xFF44)
+	currentMode := status & 0x3
+
+	var mode byte
+	statLine := false
+
+	switch {
+	case currentLine >= 144:
+		mode = 1
+		status = SetBit(status, 0)
+		status = ResetBit(status, 1)
+		statLine = BitIsSet(status, 4)
+	case gb.ScanlineCounter >= lcdMode2Bounds:
+		mode = 2
+		status = ResetBit(status, 0)
+		status = SetBit(status, 1)
+		statLine = BitIsSet(status, 5)
+	case currentMode == 2 || currentMode == 3 && !gb.FIFO.Done:
+		mode = 3
+		status = SetBit(status, 0)
+		status = SetBit(status, 1)
+		if mode != currentMode {
+			gb.startScanline(currentLine)
+		}
+	default:
+		mode = 0
+		status = ResetBit(status, 0)
+		status = ResetBit(status, 1)
+		statLine = BitIsSet(status, 3)
+		if mode != currentMode {
+			gb.endScanline()
+			gb.Memory.doHDMATransfer(gb)
+		}
+	}
+
+	// Check if LYC == LY (coincidence flag)
+	if currentLine == gb.Memory.ReadHighRam(gb, 0xFF45) {
+		status = SetBit(status, 2)
+		statLine = statLine || BitIsSet(status, 6)
+	} else {
+		status = ResetBit(status, 2)
+	}
+
+	// The STAT interrupt is only requested when the line goes high. If e.g.
+	// the LYC condition is still active when mode 0 starts, there is no mode
+	// 0 interrupt. Some games depend on this "STAT IRQ blocking".
+	if statLine && !gb.StatLine {
+		gb.requestInterrupt(1)
+	}
+	gb.StatLine = statLine
+
+	gb.Memory.HighRAM[0x41] = status | 0x80
+}
+
+// Checks if the LCD is enabled by examining 0xFF40.
+func (gb *Gameboy) isLCDEnabled() bool {
+	return BitIsSet(gb.Memory.ReadHighRam(gb, LCDC), 7)
+}
+
+// Get the RGB colour value for a colour num at an address using the current palette.
+func (gb *Gameboy) getColour(colourNum byte, palette byte) (uint8, uint8, uint8) {
+	hi := colourNum<<1 | 1
+	lo := colourNum << 1
+	col := (BitValue(palette, hi) << 1) | BitValue(palette, lo)
+	c := ColorPalette[col]
+	return c[0], c[1], c[2]
+}
+
+// Clear the screen by setting every pixel to white.
+func (gb *Gameboy) clearScreen() {
+	if gb.ScreenCleared {
+		return
+	}
+
+	gb.ScreenData.fill(ColorPalette[3])
+
+	// Push the cleared data right now
+	gb.PreparedData = gb.ScreenData
+	gb.ScreenCleared = true
+}
@@ -0,0 +1,451 @@
+package gameboy
+
+// The PPU draws each scanline during mode 3, one pixel per dot, like the
+// real hardware. A fetcher reads 8 background or window pixels at a time into
+// the background FIFO, from which pixels are shifted out to the screen. When
+// a sprite starts at the current pixel, the fetcher pauses to fetch the
+// sprite's pixels into the sprite FIFO, which delays the rest of the line.
+// Because registers are read while the line is drawn, mid-scanline changes
+// show up where they happen and mode 3 takes as long as on the hardware.
+
+// fifoPixel is a pixel in the background or sprite FIFO.
+type fifoPixel struct {
+	// Color is the 2 bit color number, 0 is transparent for sprites.
+	Color byte
+	// Palette is the CGB palette number, or for DMG sprites 0 for OBP0 and 1
+	// for OBP1.
+	Palette byte
+	// BGPriority is set if the background is drawn over the sprites, except
+	// where the background has color 0.
+	BGPriority bool
+	// OAMIndex decides which sprite is drawn on top in CGB mode.
+	OAMIndex byte
+}
+
+// lineSprite is a sprite that was found on the current scanline.
+type lineSprite struct {
+	X, Y, Tile, Attributes, OAMIndex byte
+	Fetched                          bool
+}
+
+// PixelFIFO holds the PPU state while drawing a scanline. It has only fixed
+// size fields so it can be part of the Gameboy state.
+type PixelFIFO struct {
+	// BG holds the background pixels, the next one to be shifted out is
+	// BG[8-BGCount].
+	BG      [8]fifoPixel
+	BGCount byte
+	// Sprites[i] is the sprite pixel for screen pixel X+i.
+	Sprites [8]fifoPixel
+
+	// X is the screen pixel that is shifted out next.
+	X byte
+	// Discard is the number of pixels to throw away instead of shifting them
+	// out, for fine scrolling.
+	Discard byte
+	// Stall is the number of dots that the fetcher is paused, while fetching
+	// sprites.
+	Stall int32
+
+	// The fetcher reads one tile in 4 steps: tile number, low data, high data
+	// and pushing the pixels into the FIFO. The first 3 steps take 2 dots.
+	FetchStep byte
+	FetchDot  byte
+	// FetchX is the tile column that is fetched, relative to the line start
+	// or window start.
+	FetchX     byte
+	TileNumber byte
+	TileAttr   byte
+	TileRow    byte
+	DataLow    byte
+	DataHigh   byte
+
+	// Window is set once the window replaced the background on this line.
+	Window bool
+
+	Line        byte
+	LineSprites [10]lineSprite
+	SpriteCount byte
+	// PenaltyTile is the last background tile that a sprite fetch had to wait
+	// for, only the first sprite on a tile waits.
+	PenaltyTile int16
+
+	Done bool
+}
+
+// startScanline prepares the FIFO at the start of mode 3 of the given line.
+func (gb *Gameboy) startScanline(line byte) {
+	f := &gb.FIFO
+	*f = PixelFIFO{
+		Line:        line,
+		Discard:     gb.Memory.ReadHighRam(gb, 0xFF43) % 8,
+		PenaltyTile: -1,
+		// The first tile of a line is fetched twice.
+		Stall: 6,
+	}
+	gb.scanOAM(line)
+
+	// The window only shows up after LY was equal to WY at the start of a
+	// line in this frame. Moving WY above LY later does not show it and moving
+	// WY below LY after that line does not hide it.
+	if line == gb.Memory.ReadHighRam(gb, 0xFF4A) {
+		gb.WindowYTriggered = true
+	}
+
+	if gb.WindowWrapped {
+		// The window started at WX=166 at the end of the last line, so it
+		// covers this whole line.
+		gb.WindowWrapped = false
+		if BitIsSet(gb.Memory.ReadHighRam(gb, LCDC), 5) {
+			f.Window = true
+			f.Discard = 0
+		}
+	}
+}
+
+// endScanline updates the window state after the line is drawn.
+func (gb *Gameboy) endScanline() {
+	lcdc := gb.Memory.ReadHighRam(gb, LCDC)
+	wx := gb.Memory.ReadHighRam(gb, 0xFF4B)
+	if !gb.FIFO.Window && BitIsSet(lcdc, 5) && gb.WindowYTriggered && wx == 166 {
+		// At WX=166 the window starts one pixel after the end of the line, it
+		// is shown on the next line instead.
+		gb.WindowWrapped = true
+	}
+	if gb.FIFO.Window {
+		// The window line only advances on lines where the window was drawn,
+		// hiding it for a few lines, e.g. with LCDC bit 5 or WX, continues
+		// the window where it left off.
+		gb.WindowLine++
+	}
+}
+
+// resetWindow is called at the start of each frame.
+func (gb *Gameboy) resetWindow() {
+	gb.WindowLine = 0
+	gb.WindowYTriggered = false
+	gb.WindowWrapped = false
+}
+
+// scanOAM finds the first 10 sprites that are on the line. This happens during
+// mode 2 on the hardware.
+func (gb *Gameboy) scanOAM(line byte) {
+	f := &gb.FIFO
+	height := 8
+	if BitIsSet(gb.Memory.ReadHighRam(gb, LCDC), 2) {
+		height = 16
+	}
+
+	for i := range 40 {
+		if f.SpriteCount == 10 {
+			break
+		}
+		// The PPU reads OAM directly, the CPU's bus restrictions during
+		// OAM DMA do not apply to it.
+		oam := gb.Memory.OAM[4*i:]
+		y := oam[0]
+		top := int(y) - 16
+		if int(line) < top || int(line) >= top+height {
+			continue
+		}
+		f.LineSprites[f.SpriteCount] = lineSprite{
+			Y:          y,
+			X:          oam[1],
+			Tile:       oam[2],
+			Attributes: oam[3],
+			OAMIndex:   byte(i),
+		}
+		f.SpriteCount++
+	}
+}
+
+// stepScanline runs the FIFO for the given number of dots. f.Done is set once
+// all 160 pixels are drawn.
+func (gb *Gameboy) stepScanline(dots int) {
+	f := &gb.FIFO
+	for ; dots > 0 && !f.Done; dots-- {
+		if f.Stall > 0 {
+			f.Stall--
+			continue
+		}
+
+		lcdc := gb.Memory.ReadHighRam(gb, LCDC)
+
+		if !f.Window && gb.windowStartsAt(lcdc, f.X) {
+			// The window replaces the background from here on, the fetcher
+			// starts over with the window's first tile.
+			f.Window = true
+			f.BGCount = 0
+			f.FetchStep = 0
+			f.FetchDot = 0
+			f.FetchX = 0
+			f.Discard = 0
+			if wx := gb.Memory.ReadHighRam(gb, 0xFF4B); wx < 7 {
+				f.Discard = 7 - wx
+			}
+		}
+
+		if f.BGCount > 0 && BitIsSet(lcdc, 1) && gb.fetchSpriteAt(lcdc) {
+			continue
+		}
+
+		gb.stepFetcher(lcdc)
+
+		if f.BGCount > 0 {
+			gb.shiftOutPixel(lcdc)
+		}
+	}
+}
+
+func (gb *Gameboy) windowStartsAt(lcdc byte, x byte) bool {
+	if !BitIsSet(lcdc, 5) {
+		return false
+	}
+	wx := gb.Memory.ReadHighRam(gb, 0xFF4B)
+	return gb.WindowYTriggered && wx < 166 && int(x)+7 >= int(wx)
+}
+
+// fetchSpriteAt fetches the next sprite that starts at the current pixel into
+// the sprite FIFO. It returns false if there is no such sprite.
+func (gb *Gameboy) fetchSpriteAt(lcdc byte) bool {
+	f := &gb.FIFO
+	for i := range f.SpriteCount {
+		s := &f.LineSprites[i]
+		if s.Fetched || int(s.X)-8 > int(f.X) {
+			continue
+		}
+		s.Fetched = true
+
+		// The sprite fetch waits for the background fetcher to finish the
+		// current tile, which takes up to 5 dots.
+		scx := gb.Memory.ReadHighRam(gb, 0xFF43)
+		penalty := int32(6)
+		tile := (int16(s.X) + int16(scx)) / 8
+		if tile != f.PenaltyTile {
+			f.PenaltyTile = tile
+			penalty += max(0, 5-int32((int(s.X)+int(scx))%8))
+		}
+		// This dot counts towards the penalty.
+		f.Stall = penalty - 1
+
+		gb.mergeSprite(lcdc, s)
+		return true
+	}
+	return false
+}
+
+// mergeSprite puts the sprite's pixels into the sprite FIFO. Pixels of sprites
+// that were fetched before take priority, except in CGB mode where the sprite
+// that comes first in OAM is drawn on top.
+func (gb *Gameboy) mergeSprite(lcdc byte, s *lineSprite) {
+	f := &gb.FIFO
+	height := byte(8)
+	tile := s.Tile
+	if BitIsSet(lcdc, 2) {
+		height = 16
+		tile &= 0xFE
+	}
+
+	row := f.Line + 16 - s.Y
+	if BitIsSet(s.Attributes, 6) {
+		row = height - 1 - row
+	}
+
+	address := uint16(tile)*16 + uint16(row)*2
+	if gb.IsCGB() && BitIsSet(s.Attributes, 3) {
+		address += 0x2000
+	}
+	low := gb.Memory.VRAM[address]
+	high := gb.Memory.VRAM[address+1]
+
+	palette := (s.Attributes >> 4) & 1
+	if gb.IsCGB() {
+		palette = s.Attributes & 7
+	}
+
+	for col := range 8 {
+		slot := int(s.X) - 8 + col - int(f.X)
+		if slot < 0 || slot >= len(f.Sprites) {
+			continue
+		}
+		bit := byte(7 - col)
+		if BitIsSet(s.Attributes, 5) {
+			bit = byte(col)
+		}
+		p := fifoPixel{
+			Color:      BitValue(high, bit)<<1 | BitValue(low, bit),
+			Palette:    palette,
+			BGPriority: BitIsSet(s.Attributes, 7),
+			OAMIndex:   s.OAMIndex,
+		}
+		if p.Color == 0 {
+			continue
+		}
+		old := f.Sprites[slot]
+		if old.Color == 0 || gb.IsCGB() && p.OAMIndex < old.OAMIndex {
+			f.Sprites[slot] = p
+		}
+	}
+}
+
+func (gb *Gameboy) stepFetcher(lcdc byte) {
+	f := &gb.FIFO
+
+	if f.FetchStep == 3 {
+		// The pixels are only pushed once the FIFO is empty.
+		if f.BGCount == 0 {
+			gb.pushTile()
+			f.FetchStep = 0
+			f.FetchX++
+		}
+		return
+	}
+
+	// The first 3 steps do their work on their second dot.
+	f.FetchDot++
+	if f.FetchDot < 2 {
+		return
+	}
+	f.FetchDot = 0
+
+	switch f.FetchStep {
+	case 0:
+		gb.fetchTileNumber(lcdc)
+	case 1:
+		f.DataLow = gb.fetchTileData(lcdc, 0)
+	case 2:
+		f.DataHigh = gb.fetchTileData(lcdc, 1)
+	}
+	f.FetchStep++
+}
+
+func (gb *Gameboy) fetchTileNumber(lcdc byte) {
+	f := &gb.FIFO
+
+	var mapBit byte = 3
+	var x, y byte
+	if f.Window {
+		mapBit = 6
+		x = f.FetchX
+		y = gb.WindowLine
+	} else {
+		x = f.FetchX + gb.Memory.ReadHighRam(gb, 0xFF43)/8
+		y = f.Line + gb.Memory.ReadHighRam(gb, 0xFF42)
+	}
+
+	tileMap := uint16(0x1800)
+	if BitIsSet(lcdc, mapBit) {
+		tileMap = 0x1C00
+	}
+	address := tileMap + uint16(y/8)*32 + uint16(x%32)
+
+	f.TileNumber = gb.Memory.VRAM[address]
+	f.TileAttr = 0
+	if gb.IsCGB() {
+		// Attributes used in CGB mode
+		//
+		//    Bit 0-2  Background Palette number  (BGP0-7)
+		//    Bit 3    Tile VRAM Bank number      (0=Bank 0, 1=Bank 1)
+		//    Bit 5    Horizontal Flip            (0=Normal, 1=Mirror horizontally)
+		//    Bit 6    Vertical Flip              (0=Normal, 1=Mirror vertically)
+		//    Bit 7    BG-to-OAM Priority         (0=Use OAM priority bit, 1=BG Priority)
+		//
+		f.TileAttr = gb.Memory.VRAM[address+0x2000]
+	}
+	f.TileRow = y % 8
+}
+
+// fetchTileData reads the low (offset 0) or high (offset 1) byte of the
+// current tile's row.
+func (gb *Gameboy) fetchTileData(lcdc byte, offset uint16) byte {
+	f := &gb.FIFO
+
+	var address uint16
+	if BitIsSet(lcdc, 4) {
+		address = uint16(f.TileNumber) * 16
+	} else {
+		address = uint16(0x1000 + int(int8(f.TileNumber))*16)
+	}
+
+	row := f.TileRow
+	if BitIsSet(f.TileAttr, 6) {
+		row = 7 - row
+	}
+	address += uint16(row)*2 + offset
+	if BitIsSet(f.TileAttr, 3) {
+		address += 0x2000
+	}
+	return gb.Memory.VRAM[address]
+}
+
+func (gb *Gameboy) pushTile() {
+	f := &gb.FIFO
+	for i := range f.BG {
+		bit := byte(7 - i)
+		if BitIsSet(f.TileAttr, 5) {
+			bit = byte(i)
+		}
+		f.BG[i] = fifoPixel{
+			Color:      BitValue(f.DataHigh, bit)<<1 | BitValue(f.DataLow, bit),
+			Palette:    f.TileAttr & 7,
+			BGPriority: BitIsSet(f.TileAttr, 7),
+		}
+	}
+	f.BGCount = 8
+}
+
+// shiftOutPixel mixes the next background and sprite pixels and draws the
+// result to the screen.
+func (gb *Gameboy) shiftOutPixel(lcdc byte) {
+	f := &gb.FIFO
+
+	bg := f.BG[8-f.BGCount]
+	f.BGCount--
+
+	if f.Discard > 0 {
+		f.Discard--
+		return
+	}
+
+	sprite := f.Sprites[0]
+	copy(f.Sprites[:], f.Sprites[1:])
+	f.Sprites[len(f.Sprites)-1] = fifoPixel{}
+
+	// LCDC bit 0 turns off the background on DMG but only takes away its
+	// priority over sprites on CGB.
+	bgEnabled := BitIsSet(lcdc, 0)
+	if !gb.IsCGB() && !bgEnabled {
+		bg.Color = 0
+	}
+
+	drawSprite := sprite.Color != 0 && BitIsSet(lcdc, 1)
+	if drawSprite && bg.Color != 0 {
+		if gb.IsCGB() {
+			drawSprite = !bgEnabled || !bg.BGPriority && !sprite.BGPriority
+		} else {
+			drawSprite = !sprite.BGPriority
+		}
+	}
+
+	var r, g, b uint8
+	switch {
+	case drawSprite && gb.IsCGB():
+		r, g, b = gb.SpritePalette.get(sprite.Palette, sprite.Color)
+	case drawSprite:
+		palette := gb.Memory.ReadHighRam(gb, 0xFF48+uint16(sprite.Palette))
+		r, g, b = gb.getColour(sprite.Color, palette)
+	case gb.IsCGB():
+		r, g, b = gb.BGPalette.get(bg.Palette, bg.Color)
+	case !bgEnabled:
+		r, g, b = ColorPalette[0][0], ColorPalette[0][1], ColorPalette[0][2]
+	default:
+		r, g, b = gb.getColour(bg.Color, gb.Memory.ReadHighRam(gb, 0xFF47))
+	}
+
+	gb.ScreenData.setPixel(int(f.X), int(f.Line), [3]uint8{r, g, b})
+
+	f.X++
+	if f.X == ScreenWidth {
+		f.Done = true
+	}
+}
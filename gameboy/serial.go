@@ -0,0 +1,60 @@
+package gameboy
+
+const (
+	// serialBitCycles is the number of cycles per bit when the Gameboy clocks
+	// the transfer itself, which is 8192 Hz.
+	serialBitCycles = 512
+	// maxSerialBytesPerFrame is more than the bytes that can be sent in one
+	// frame.
+	maxSerialBytesPerFrame = CyclesPerFrame/(8*serialBitCycles) + 2
+)
+
+// Serial is the link cable port. There is never a second Gameboy connected,
+// so every transfer receives 0xFF. Transfers with an external clock never
+// finish, like on the hardware without a link partner.
+type Serial struct {
+	// Cycles is the time left in the current transfer.
+	Cycles int32
+	// Sent holds the SentCount bytes that were sent in the current frame. Test
+	// ROMs print their results this way.
+	Sent      [maxSerialBytesPerFrame]byte
+	SentCount int32
+}
+
+// writeSerialControl handles writes to SC (0xFF02). Setting bit 7 starts a
+// transfer of SB (0xFF01), bit 0 selects the Gameboy's own clock.
+func (gb *Gameboy) writeSerialControl(value byte) {
+	gb.Memory.HighRAM[0x02] = value | 0x7E
+	if value&0x81 != 0x81 {
+		gb.Serial.Cycles = 0
+		return
+	}
+
+	s := &gb.Serial
+	if int(s.SentCount) < len(s.Sent) {
+		s.Sent[s.SentCount] = gb.Memory.HighRAM[0x01]
+		s.SentCount++
+	}
+	s.Cycles = 8 * serialBitCycles
+}
+
+// updateSerial finishes the current transfer after the given number of
+// cycles.
+func (gb *Gameboy) updateSerial(cycles int) {
+	if gb.Serial.Cycles <= 0 {
+		return
+	}
+
+	gb.Serial.Cycles -= int32(cycles)
+	if gb.Serial.Cycles <= 0 {
+		gb.Memory.HighRAM[0x01] = 0xFF
+		gb.Memory.HighRAM[0x02] &^= 0x80
+		gb.requestInterrupt(3)
+	}
+}
+
+// FrameSerialOutput returns the bytes that were sent over the link cable
+// during the last call to Gameboy.Step.
+func (gb *Gameboy) FrameSerialOutput() []byte {
+	return gb.Serial.Sent[:gb.Serial.SentCount]
+}
@@ -0,0 +1,60 @@
+package gameboy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// TestROMTimeoutFrames is how long a test ROM may run before it counts as
+// failed, 3 minutes. Blargg's cpu_instrs takes almost a minute.
+const TestROMTimeoutFrames = 3 * 60 * FramesSecond
+
+var (
+	ErrTestROMFailed  = errors.New("the test failed")
+	ErrTestROMTimeout = fmt.Errorf("the test did not finish in %d frames", TestROMTimeoutFrames)
+)
+
+// RunTestROM runs one of blargg's or mooneye's test ROMs on the model until it
+// reports its result. It returns what the test printed over the link cable and
+// nil if the test passed, ErrTestROMFailed if it failed or ErrTestROMTimeout.
+//
+// Blargg's tests print their results over the link cable, ending in "Passed"
+// or "Failed". Mooneye's tests execute LD B,B when they are done and then
+// loop forever, B, C, D, E, H and L hold the Fibonacci numbers 3, 5, 8, 13,
+// 21, 34 if the test passed and 0x42 if it failed.
+func RunTestROM(rom []byte, model ConsoleModel) (serial []byte, err error) {
+	gb := NewGameboy(rom, GameboyOptions{Model: model})
+	for range TestROMTimeoutFrames {
+		gb.Step()
+		serial = append(serial, gb.FrameSerialOutput()...)
+		if done, err := gb.testROMResult(serial); done {
+			return serial, err
+		}
+	}
+	return serial, ErrTestROMTimeout
+}
+
+// testROMResult reports whether the test is done and if so, its error.
+func (gb *Gameboy) testROMResult(serial []byte) (done bool, err error) {
+	if bytes.Contains(serial, []byte("Passed")) {
+		return true, nil
+	}
+	if bytes.Contains(serial, []byte("Failed")) {
+		return true, ErrTestROMFailed
+	}
+
+	cpu := &gb.CPU
+	regs := []byte{
+		cpu.BC.Hi(), cpu.BC.Lo(),
+		cpu.DE.Hi(), cpu.DE.Lo(),
+		cpu.HL.Hi(), cpu.HL.Lo(),
+	}
+	if bytes.Equal(regs, []byte{3, 5, 8, 13, 21, 34}) {
+		return true, nil
+	}
+	if bytes.Equal(regs, bytes.Repeat([]byte{0x42}, 6)) {
+		return true, ErrTestROMFailed
+	}
+	return false, nil
+}
@@ -0,0 +1,118 @@
+package gameboy
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The test ROMs of blargg and mooneye are not part of the repository. Copy
+// them into testdata, in any sub directories, or set GAMEBOY_TEST_ROMS to the
+// directory that holds them. Tests of missing ROMs are skipped.
+
+// loadTestROM returns the test ROM whose path ends in name, e.g. "tim00.gb" or
+// "timer/tim00.gb". The test is skipped if it cannot be found.
+func loadTestROM(t *testing.T, name string) []byte {
+	t.Helper()
+	dirs := []string{"testdata"}
+	if dir := os.Getenv("GAMEBOY_TEST_ROMS"); dir != "" {
+		dirs = append([]string{dir}, dirs...)
+	}
+	for _, dir := range dirs {
+		var path string
+		filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() && hasPathSuffix(p, name) {
+				path = p
+				return fs.SkipAll
+			}
+			return nil
+		})
+		if path != "" {
+			rom, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return rom
+		}
+	}
+	t.Skipf("test ROM %s not found in testdata or GAMEBOY_TEST_ROMS", name)
+	return nil
+}
+
+func hasPathSuffix(path, suffix string) bool {
+	path = filepath.ToSlash(path)
+	return path == suffix || strings.HasSuffix(path, "/"+suffix)
+}
+
+// runTestROM runs the test ROM on the model until it reports its result.
+func runTestROM(t *testing.T, name string, model ConsoleModel) {
+	t.Helper()
+	rom := loadTestROM(t, name)
+	serial, err := RunTestROM(rom, model)
+	if err != nil {
+		t.Errorf("%s: %v\n%s", name, err, bytes.TrimSpace(serial))
+	}
+}
+
+func TestBlarggROMs(t *testing.T) {
+	for _, name := range []string{
+		"cpu_instrs.gb",
+		"instr_timing.gb",
+		"mem_timing.gb",
+		"halt_bug.gb",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			runTestROM(t, name, ModelDMG)
+		})
+	}
+}
+
+func TestMooneyeROMs(t *testing.T) {
+	for _, name := range []string{
+		"add_sp_e_timing.gb",
+		"call_timing.gb",
+		"di_timing-GS.gb",
+		"div_timing.gb",
+		"ei_sequence.gb",
+		"ei_timing.gb",
+		"halt_ime0_ei.gb",
+		"halt_ime0_nointr_timing.gb",
+		"halt_ime1_timing.gb",
+		"halt_ime1_timing2-GS.gb",
+		"if_ie_registers.gb",
+		"intr_timing.gb",
+		"jp_timing.gb",
+		"ld_hl_sp_e_timing.gb",
+		"oam_dma_restart.gb",
+		"oam_dma_start.gb",
+		"oam_dma_timing.gb",
+		"pop_timing.gb",
+		"push_timing.gb",
+		"rapid_di_ei.gb",
+		"ret_timing.gb",
+		"reti_intr_timing.gb",
+		"reti_timing.gb",
+		"rst_timing.gb",
+		"bits/mem_oam.gb",
+		"bits/reg_f.gb",
+		"interrupts/ie_push.gb",
+		"oam_dma/basic.gb",
+		"oam_dma/reg_read.gb",
+		"timer/div_write.gb",
+		"timer/tim00.gb",
+		"timer/tim01.gb",
+		"timer/tim10.gb",
+		"timer/tim11.gb",
+		"timer/tima_reload.gb",
+		"timer/tima_write_reloading.gb",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			runTestROM(t, name, ModelDMG)
+		})
+	}
+}
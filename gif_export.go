@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	imagedraw "image/draw"
+	"image/gif"
+	"os"
+
+	"github.com/Humpheh/goboy/gameboy"
+)
+
+const (
+	// gifScale makes the exported screens big enough for the input display
+	// to be readable without covering too much of the game.
+	gifScale = 2
+
+	inputDisplayW      = 70
+	inputDisplayH      = 36
+	inputDisplayMargin = 4
+	inputDisplayDPad   = 8
+)
+
+// exportGIF saves the selected frames as an animated GIF. The user can choose
+// to have the pressed buttons drawn into a corner of each frame, like
+// verification and showcase videos usually show them.
+func (s *editorState) exportGIF() error {
+	s.startSaveDialog("Export GIF", "GIF Animation", "gif", func(path string) error {
+		s.startModalListDialog(
+			"Export GIF",
+			[]string{"Draw the input display into the frames", "Only the Gameboy screen"},
+			0,
+			func(option int) {
+				if err := s.writeGIF(path, option == 0); err != nil {
+					s.setWarning(err.Error())
+				}
+			},
+		)
+		return nil
+	})
+	return nil
+}
+
+// writeGIF writes the selected frames to the GIF file at path.
+func (s *editorState) writeGIF(path string, withInputs bool) error {
+	anim := s.renderGIF(s.activeSelection.start(), s.activeSelection.end(), withInputs)
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to export '%s': %w", path, err)
+	}
+	if err := gif.EncodeAll(f, anim); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to export '%s': %w", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to export '%s': %w", path, err)
+	}
+
+	s.setInfo(fmt.Sprintf("Exported %d frames to %s", s.activeSelection.count(), path))
+	return nil
+}
+
+// renderGIF renders the frames [start..end) into a GIF that plays in real
+// time.
+func (s *editorState) renderGIF(start, end int, withInputs bool) *gif.GIF {
+	anim := &gif.GIF{}
+	bounds := image.Rect(0, 0, gifScale*gameboy.ScreenWidth, gifScale*gameboy.ScreenHeight)
+
+	for frameIndex := start; frameIndex < end; frameIndex++ {
+		img := image.NewRGBA(bounds)
+		screen := s.displayScreen(frameIndex)
+		for y := range gameboy.ScreenHeight {
+			for x := range gameboy.ScreenWidth {
+				c := screen.Pixel(x, y)
+				fillImage(
+					img,
+					image.Rect(x*gifScale, y*gifScale, (x+1)*gifScale, (y+1)*gifScale),
+					color.RGBA{R: c[0], G: c[1], B: c[2], A: 255},
+				)
+			}
+		}
+
+		if withInputs {
+			drawInputDisplay(
+				img,
+				bounds.Max.X-inputDisplayW-inputDisplayMargin,
+				bounds.Max.Y-inputDisplayH-inputDisplayMargin,
+				s.inputsAt(frameIndex),
+			)
+		}
+
+		// GIF delays are in 1/100 seconds, we round each frame's end time so
+		// the delays add up to real time.
+		i := frameIndex - start
+		delay := gifCentis(i+1) - gifCentis(i)
+
+		anim.Image = append(anim.Image, toPaletted(img))
+		anim.Delay = append(anim.Delay, delay)
+	}
+
+	return anim
+}
+
+// gifCentis is the time in 1/100 seconds at which the given number of frames
+// have been shown, rounded to the nearest 1/100 second.
+func gifCentis(frames int) int {
+	return (frames*100*gameboy.CyclesPerFrame + gameboy.ClockSpeed/2) / gameboy.ClockSpeed
+}
+
+// toPaletted converts the image using its exact colors if there are few
+// enough of them, which is true for all DMG games.
+func toPaletted(img *image.RGBA) *image.Paletted {
+	var colors color.Palette
+	index := make(map[color.RGBA]uint8)
+	for i := 0; i < len(img.Pix); i += 4 {
+		c := color.RGBA{R: img.Pix[i], G: img.Pix[i+1], B: img.Pix[i+2], A: 255}
+		if _, ok := index[c]; !ok {
+			if len(colors) == 256 {
+				colors = nil
+				break
+			}
+			index[c] = uint8(len(colors))
+			colors = append(colors, c)
+		}
+	}
+
+	if colors == nil {
+		p := image.NewPaletted(img.Bounds(), palette.Plan9)
+		imagedraw.Draw(p, p.Bounds(), img, image.Point{}, imagedraw.Src)
+		return p
+	}
+
+	p := image.NewPaletted(img.Bounds(), colors)
+	for i := range p.Pix {
+		j := 4 * i
+		p.Pix[i] = index[color.RGBA{R: img.Pix[j], G: img.Pix[j+1], B: img.Pix[j+2], A: 255}]
+	}
+	return p
+}
+
+// drawInputDisplay draws a small controller, looking like the one in the
+// editor menu, with the given inputs pressed.
+func drawInputDisplay(img *image.RGBA, x, y int, inputs inputState) {
+	// Darken the background so the controller is visible on any screen.
+	for py := y; py < y+inputDisplayH; py++ {
+		for px := x; px < x+inputDisplayW; px++ {
+			c := img.RGBAAt(px, py)
+			img.SetRGBA(px, py, color.RGBA{R: c.R / 3, G: c.G / 3, B: c.B / 3, A: 255})
+		}
+	}
+
+	black := color.RGBA{A: 255}
+	gray := color.RGBA{R: 192, G: 192, B: 192, A: 255}
+	red := color.RGBA{R: 255, A: 255}
+	darkRed := color.RGBA{R: 96, A: 255}
+
+	// The D-Pad is a cross of 3x3 cells.
+	const cell = inputDisplayDPad
+	dpadX, dpadY := x+6, y+6
+	fillImage(img, image.Rect(dpadX+cell, dpadY, dpadX+2*cell, dpadY+3*cell), black)
+	fillImage(img, image.Rect(dpadX, dpadY+cell, dpadX+3*cell, dpadY+2*cell), black)
+	dpad := func(b gameboy.Button, col, row int) {
+		if isButtonDown(inputs, b) {
+			cx, cy := dpadX+col*cell, dpadY+row*cell
+			fillImage(img, image.Rect(cx+1, cy+1, cx+cell-1, cy+cell-1), gray)
+		}
+	}
+	dpad(gameboy.ButtonUp, 1, 0)
+	dpad(gameboy.ButtonLeft, 0, 1)
+	dpad(gameboy.ButtonRight, 2, 1)
+	dpad(gameboy.ButtonDown, 1, 2)
+
+	ab := func(b gameboy.Button, cx, cy int) {
+		c := darkRed
+		if isButtonDown(inputs, b) {
+			c = red
+		}
+		fillCircle(img, cx, cy, 6, c)
+	}
+	ab(gameboy.ButtonB, x+42, y+20)
+	ab(gameboy.ButtonA, x+58, y+14)
+
+	startSelect := func(b gameboy.Button, sx int) {
+		c := black
+		if isButtonDown(inputs, b) {
+			c = gray
+		}
+		fillImage(img, image.Rect(sx, y+29, sx+9, y+32), c)
+	}
+	startSelect(gameboy.ButtonSelect, x+36)
+	startSelect(gameboy.ButtonStart, x+50)
+}
+
+func fillCircle(img *image.RGBA, centerX, centerY, radius int, c color.RGBA) {
+	for y := -radius; y <= radius; y++ {
+		for x := -radius; x <= radius; x++ {
+			if x*x+y*y <= radius*radius {
+				img.SetRGBA(centerX+x, centerY+y, c)
+			}
+		}
+	}
+}
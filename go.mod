@@ -4,6 +4,7 @@ go 1.24.5
 
 require (
 	github.com/gonutz/prototype v1.9.2
+	github.com/gonutz/w32/v2 v2.2.0
 	github.com/hajimehoshi/oto v0.5.4
 	github.com/sqweek/dialog v0.0.0-20190728103509-6254ed5b0d3c
 )
@@ -15,7 +16,6 @@ require (
 	github.com/gonutz/gl v1.0.0 // indirect
 	github.com/gonutz/glfw v1.0.2 // indirect
 	github.com/gonutz/mixer v1.0.0 // indirect
-	github.com/gonutz/w32/v2 v2.2.0 // indirect
 	github.com/gotk3/gotk3 v0.0.0-20200103101635-d3629b451bb5 // indirect
 	golang.org/x/exp v0.0.0-20191227195350-da58074b4299 // indirect
 	golang.org/x/image v0.0.0-20191214001246-9130b4cfad52 // indirect
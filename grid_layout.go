@@ -0,0 +1,29 @@
+package main
+
+// The editor grid shows frames either in rows, left to right and then top to
+// bottom, or in columns, top to bottom and then left to right, see the
+// ColumnLayout setting. The columns read like a piano roll and use the space of
+// wide monitors better.
+//
+// Either way the grid indexes count the frames from the left-most one, the
+// layout only changes which grid cell shows which of them.
+
+// gridIndex returns the index, counted from the left-most frame, of the frame
+// in the grid cell at column x and row y of a grid that is countX by countY
+// cells large.
+func gridIndex(x, y, countX, countY int) int {
+	if globalSettings.ColumnLayout {
+		return x*countY + y
+	}
+	return y*countX + x
+}
+
+// gridLineFrames returns the number of frames in one line of the grid, which
+// is a row or a column, depending on the layout. Scrolling by a line keeps the
+// frames in their cells.
+func gridLineFrames(countX, countY int) int {
+	if globalSettings.ColumnLayout {
+		return countY
+	}
+	return countX
+}
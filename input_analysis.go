@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"slices"
+	"sync/atomic"
+
+	"github.com/Humpheh/goboy/gameboy"
+	"github.com/gonutz/prototype/draw"
+)
+
+// The input analysis finds dead inputs in the selected frames: buttons that
+// can be released without changing anything. For every pressed button it
+// emulates the frames after it again without the press and compares the
+// screen and RAM to the original run for deadInputHorizon frames. The tests
+// run on the emulation workers.
+//
+// Every press is tested on its own. Two presses that are each dead can still
+// matter together, e.g. when holding a button for two frames is only needed
+// for one of them, so the dead inputs are only marked and not removed.
+const deadInputHorizon = 60
+
+type inputAnalysis struct {
+	branchName   string
+	first, end   int
+	totalPresses int64
+	// testedPresses is written by the background goroutine and read by the UI
+	// to display the progress.
+	testedPresses atomic.Int64
+	// result receives the dead buttons per frame once and is closed
+	// afterwards.
+	result chan map[int]inputState
+	cancel chan struct{}
+}
+
+// deadInputs are the result of the last input analysis.
+type deadInputs struct {
+	branchName string
+	buttons    map[int]inputState
+}
+
+// startInputAnalysis analyzes the selected frames of the active branch.
+func (s *editorState) startInputAnalysis() {
+	if s.inputAnalysis != nil {
+		s.setWarning("The inputs are already being analyzed.")
+		return
+	}
+
+	b := s.branch()
+	first, end := s.activeSelection.start(), s.activeSelection.end()
+	// The frames after the selection are emulated as well, to see if the
+	// presses change anything later on.
+	inputs := make([]inputState, end-first+deadInputHorizon)
+	presses := int64(0)
+	for i := range inputs {
+		inputs[i] = b.defaultInputs
+		if first+i < len(b.frameInputs) {
+			inputs[i] = b.frameInputs[first+i]
+		}
+		if first+i < end {
+			for button := range gameboy.ButtonCount {
+				if isButtonDown(inputs[i], button) {
+					presses++
+				}
+			}
+		}
+	}
+	if presses == 0 {
+		s.setInfo("There are no pressed buttons in the selection.")
+		s.render()
+		return
+	}
+
+	var start gameboy.Gameboy
+	if first == 0 {
+		start = s.startGameboy(s.gameboyOptions)
+	} else {
+		start = s.generateFrame(first - 1)
+	}
+	// We do not need any sound to compare the frames.
+	start.Options.Sound = false
+
+	a := &inputAnalysis{
+		branchName:   b.name,
+		first:        first,
+		end:          end,
+		totalPresses: presses,
+		result:       make(chan map[int]inputState, 1),
+		cancel:       make(chan struct{}),
+	}
+	s.inputAnalysis = a
+	s.deadInputs = deadInputs{}
+	go a.run(start, inputs, maps.Clone(b.subframeInputs))
+}
+
+// hashFrame hashes what the analysis compares: the screen, the work RAM and
+// the high RAM.
+func hashFrame(gb *gameboy.Gameboy) uint64 {
+	h := fnv.New64a()
+	for y := range gb.PreparedData {
+		h.Write(gb.PreparedData[y][:])
+	}
+	h.Write(gb.Memory.WRAM[:])
+	h.Write(gb.Memory.HighRAM[:])
+	return h.Sum64()
+}
+
+// shiftedSubframes returns the subframe inputs of the frames from first on,
+// keyed by their index after first.
+func shiftedSubframes(subframes map[int][]subframeInput, first int) map[int][]subframeInput {
+	if len(subframes) == 0 {
+		return nil
+	}
+	shifted := make(map[int][]subframeInput)
+	for frame, changes := range subframes {
+		if frame >= first {
+			shifted[frame-first] = changes
+		}
+	}
+	return shifted
+}
+
+func (a *inputAnalysis) run(start gameboy.Gameboy, inputs []inputState, subframes map[int][]subframeInput) {
+	defer close(a.result)
+
+	canceled := func() bool {
+		select {
+		case <-a.cancel:
+			return true
+		default:
+			return false
+		}
+	}
+
+	// First we emulate the inputs as they are, to compare against.
+	original := make([]uint64, len(inputs))
+	emulateJobs([]emulationJob{{
+		start:     &start,
+		inputs:    inputs,
+		subframes: shiftedSubframes(subframes, a.first),
+		afterFrame: func(i int, gb *gameboy.Gameboy) bool {
+			original[i] = hashFrame(gb)
+			return !canceled()
+		},
+	}})
+
+	dead := make(map[int]inputState)
+	type trial struct {
+		frame   int
+		button  gameboy.Button
+		changed bool
+	}
+	var jobs []emulationJob
+	var trials []*trial
+	runTrials := func() {
+		emulateJobs(jobs)
+		for _, t := range trials {
+			if !t.changed {
+				in := dead[t.frame]
+				setButtonDown(&in, t.button, true)
+				dead[t.frame] = in
+			}
+		}
+		a.testedPresses.Add(int64(len(jobs)))
+		jobs, trials = jobs[:0], trials[:0]
+	}
+
+	current := start
+	for i := range a.end - a.first {
+		if canceled() {
+			return
+		}
+
+		for button := range gameboy.ButtonCount {
+			if !isButtonDown(inputs[i], button) {
+				continue
+			}
+			before := new(gameboy.Gameboy)
+			*before = current
+			changed := slices.Clone(inputs[i : i+deadInputHorizon])
+			setButtonDown(&changed[0], button, false)
+			t := &trial{frame: a.first + i, button: button}
+			trials = append(trials, t)
+			jobs = append(jobs, emulationJob{
+				start:     before,
+				inputs:    changed,
+				subframes: shiftedSubframes(subframes, a.first+i),
+				afterFrame: func(j int, gb *gameboy.Gameboy) bool {
+					if hashFrame(gb) != original[i+j] {
+						t.changed = true
+						return false
+					}
+					return !canceled()
+				},
+			})
+			if len(jobs) == emulationWorkerCount {
+				runTrials()
+			}
+		}
+
+		applyInputs(&current, inputs[i])
+		setPollInputs(&current, subframes[a.first+i])
+		current.Step()
+	}
+	runTrials()
+
+	if !canceled() {
+		a.result <- dead
+	}
+}
+
+// cancelInputAnalysis stops the analysis. It is safe to call if none is
+// running.
+func (s *editorState) cancelInputAnalysis() {
+	if s.inputAnalysis != nil {
+		close(s.inputAnalysis.cancel)
+		for range s.inputAnalysis.result {
+		}
+		s.inputAnalysis = nil
+	}
+}
+
+// updateInputAnalysis is called once per UI frame to show the result when the
+// analysis is done.
+func (s *editorState) updateInputAnalysis() {
+	a := s.inputAnalysis
+	if a == nil {
+		return
+	}
+
+	select {
+	case dead, ok := <-a.result:
+		if !ok {
+			return
+		}
+		s.inputAnalysis = nil
+		s.deadInputs = deadInputs{branchName: a.branchName, buttons: dead}
+		count := 0
+		for _, in := range dead {
+			for b := range gameboy.ButtonCount {
+				if isButtonDown(in, b) {
+					count++
+				}
+			}
+		}
+		s.setInfo(fmt.Sprintf(
+			"%d of %d presses in frames %d to %d are dead, they are marked in the grid.",
+			count, a.totalPresses, a.first, a.end-1,
+		))
+		s.render()
+	default:
+	}
+}
+
+// deadButtonsAt returns the dead buttons of the frame in the active branch.
+func (s *editorState) deadButtonsAt(frameIndex int) inputState {
+	if s.deadInputs.branchName != s.branch().name {
+		return 0
+	}
+	return s.deadInputs.buttons[frameIndex]
+}
+
+func (s *editorState) renderInputAnalysisProgress(window draw.Window) {
+	a := s.inputAnalysis
+	if a == nil {
+		return
+	}
+
+	done := a.testedPresses.Load()
+	text := fmt.Sprintf(
+		"Testing presses for dead inputs, %d of %d (Escape to cancel)",
+		done, a.totalPresses,
+	)
+	renderProgressBox(window, text, done, a.totalPresses, 7)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Humpheh/goboy/gameboy"
+)
+
+// comboPolicy decides what happens when an edit presses two opposing
+// directions, Left+Right or Up+Down, in the same frame. These cannot be
+// pressed on a real Gameboy.
+type comboPolicy byte
+
+const (
+	// allowCombos lets the user press opposing directions.
+	allowCombos comboPolicy = iota
+	// warnAboutCombos lets the user press opposing directions but shows a
+	// warning.
+	warnAboutCombos
+	// cleanCombos releases the opposing direction when pressing a direction.
+	cleanCombos
+
+	comboPolicyCount // NOTE This has to come last.
+)
+
+func (p comboPolicy) String() string {
+	switch p {
+	case allowCombos:
+		return "Allow"
+	case warnAboutCombos:
+		return "Warn"
+	case cleanCombos:
+		return "Clean"
+	default:
+		return "Unknown"
+	}
+}
+
+// opposingButton returns Right for Left, Up for Down and so on. For non-
+// directional buttons it returns false.
+func opposingButton(b gameboy.Button) (gameboy.Button, bool) {
+	switch b {
+	case gameboy.ButtonLeft:
+		return gameboy.ButtonRight, true
+	case gameboy.ButtonRight:
+		return gameboy.ButtonLeft, true
+	case gameboy.ButtonUp:
+		return gameboy.ButtonDown, true
+	case gameboy.ButtonDown:
+		return gameboy.ButtonUp, true
+	default:
+		return 0, false
+	}
+}
+
+func hasIllegalCombo(inputs inputState) bool {
+	return isButtonDown(inputs, gameboy.ButtonLeft) && isButtonDown(inputs, gameboy.ButtonRight) ||
+		isButtonDown(inputs, gameboy.ButtonUp) && isButtonDown(inputs, gameboy.ButtonDown)
+}
+
+// applyComboPolicy is called after button was pressed in the given frames. It
+// warns about or cleans up opposing directions according to the policy.
+func (s *editorState) applyComboPolicy(firstFrameIndex, count int, button gameboy.Button) {
+	opposite, ok := opposingButton(button)
+	if !ok || s.comboPolicy == allowCombos {
+		return
+	}
+
+	b := s.branch()
+	illegal := -1
+	for i := firstFrameIndex; i < firstFrameIndex+count; i++ {
+		if isButtonDown(b.frameInputs[i], opposite) {
+			if s.comboPolicy == cleanCombos {
+				setButtonDown(&b.frameInputs[i], opposite, false)
+			} else if illegal == -1 {
+				illegal = i
+			}
+		}
+	}
+
+	if illegal != -1 {
+		s.setWarning(fmt.Sprintf("Frame %d has opposing directions pressed.", illegal))
+	}
+}
+
+// applyComboPolicyToButtons is applyComboPolicy for edits that press several
+// buttons at once. If they include opposing directions, the one that comes
+// first in the button order stays pressed when cleaning.
+func (s *editorState) applyComboPolicyToButtons(firstFrameIndex, count int, buttons inputState) {
+	for button := range gameboy.ButtonCount {
+		if !isButtonDown(buttons, button) {
+			continue
+		}
+		s.applyComboPolicy(firstFrameIndex, count, button)
+		if opposite, ok := opposingButton(button); ok && s.comboPolicy == cleanCombos {
+			setButtonDown(&buttons, opposite, false)
+		}
+	}
+}
+
+func (s *editorState) cycleComboPolicy() {
+	s.comboPolicy = (s.comboPolicy + 1) % comboPolicyCount
+	s.setInfo("Opposing directions: " + s.comboPolicy.String())
+	s.render()
+}
+
+// sanitizeBranch lists all frames in the active branch with opposing
+// directions and, after asking the user, releases both directions in them.
+func (s *editorState) sanitizeBranch() {
+	if s.branchLocked() {
+		return
+	}
+	b := s.branch()
+
+	var frames []int
+	for i, inputs := range b.frameInputs {
+		if hasIllegalCombo(inputs) {
+			frames = append(frames, i)
+		}
+	}
+
+	if len(frames) == 0 {
+		s.setInfo("No frames have opposing directions pressed.")
+		s.render()
+		return
+	}
+
+	msg := fmt.Sprintf(
+		"These frames have opposing directions pressed:\n\n%s\n\n"+
+			"Do you want to release both opposing directions in them?",
+		formatFrameRanges(frames),
+	)
+	s.startModalConfirmDialog("Sanitize Branch", msg, func() {
+		s.releaseOpposingDirections(frames)
+	})
+}
+
+// releaseOpposingDirections releases both opposing directions in the given
+// frames of the active branch, which are sorted.
+func (s *editorState) releaseOpposingDirections(frames []int) {
+	b := s.branch()
+	for _, i := range frames {
+		inputs := &b.frameInputs[i]
+		if isButtonDown(*inputs, gameboy.ButtonLeft) && isButtonDown(*inputs, gameboy.ButtonRight) {
+			setButtonDown(inputs, gameboy.ButtonLeft, false)
+			setButtonDown(inputs, gameboy.ButtonRight, false)
+		}
+		if isButtonDown(*inputs, gameboy.ButtonUp) && isButtonDown(*inputs, gameboy.ButtonDown) {
+			setButtonDown(inputs, gameboy.ButtonUp, false)
+			setButtonDown(inputs, gameboy.ButtonDown, false)
+		}
+	}
+
+	s.setDirtyFrame(frames[0])
+	s.setInfo(fmt.Sprintf("Sanitized %d frames.", len(frames)))
+	s.render()
+}
+
+// formatFrameRanges turns sorted frame indices like 1, 2, 3, 7 into the
+// string "1-3, 7".
+func formatFrameRanges(frames []int) string {
+	var parts []string
+	for i := 0; i < len(frames); {
+		j := i
+		for j+1 < len(frames) && frames[j+1] == frames[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, strconv.Itoa(frames[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", frames[i], frames[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The input search finds a sequence of buttons in all branches of the session,
+// e.g. to check that a menu is always navigated the same way. Only the
+// buttons count, frame events like resets are ignored.
+
+// maxSearchHits limits the hits that are listed.
+const maxSearchHits = 1000
+
+type searchHit struct {
+	branchIndex int
+	frameIndex  int
+}
+
+// parseInputPattern parses steps separated by spaces. A step is a list of
+// buttons like for the subframe inputs, or - for no buttons, optionally
+// followed by * and the number of frames, e.g. "a -*2 start" is A for a
+// frame, no buttons for two frames and then Start for a frame.
+func parseInputPattern(text string) ([]inputState, error) {
+	var pattern []inputState
+	for step := range strings.FieldsSeq(text) {
+		buttons, times, hasTimes := strings.Cut(step, "*")
+		frames := 1
+		if hasTimes {
+			n, err := strconv.Atoi(times)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("'%s' is not a number of frames of 1 or more", times)
+			}
+			frames = n
+		}
+		var inputs inputState
+		if buttons != "-" {
+			var err error
+			inputs, err = parseButtonList(buttons)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for range frames {
+			pattern = append(pattern, inputs)
+		}
+	}
+	if len(pattern) == 0 {
+		return nil, errors.New("the pattern is empty")
+	}
+	return pattern, nil
+}
+
+// findInputPattern returns the frames at which the pattern starts in the
+// inputs. Hits do not overlap.
+func findInputPattern(inputs, pattern []inputState) []int {
+	var hits []int
+	for i := 0; i+len(pattern) <= len(inputs); i++ {
+		match := true
+		for j, want := range pattern {
+			if inputs[i+j]&^frameEvents != want {
+				match = false
+				break
+			}
+		}
+		if match {
+			hits = append(hits, i)
+			i += len(pattern) - 1
+		}
+	}
+	return hits
+}
+
+// startModalInputSearchDialog asks for a pattern and lists where it occurs.
+func (s *editorState) startModalInputSearchDialog() {
+	title := "Find Buttons in All Branches, e.g. a -*2 start for A, Nothing for 2 Frames, Start"
+	s.startModalTextDialog(title, s.lastInputSearch, func(text string) {
+		s.lastInputSearch = text
+		pattern, err := parseInputPattern(text)
+		if err != nil {
+			s.setWarning(err.Error())
+			return
+		}
+
+		var hits []searchHit
+		for i := range s.branches {
+			for _, frame := range findInputPattern(s.branches[i].frameInputs, pattern) {
+				hits = append(hits, searchHit{branchIndex: i, frameIndex: frame})
+			}
+		}
+		if len(hits) == 0 {
+			s.setInfo(fmt.Sprintf("\"%s\" was not found in any branch.", text))
+			s.render()
+			return
+		}
+
+		total := len(hits)
+		hits = hits[:min(total, maxSearchHits)]
+		// Start at the first hit after the selection in the active branch.
+		selected := 0
+		for i, hit := range hits {
+			if hit.branchIndex == s.branchIndex && hit.frameIndex > s.activeSelection.start() {
+				selected = i
+				break
+			}
+		}
+		options := make([]string, len(hits))
+		for i, hit := range hits {
+			options[i] = fmt.Sprintf(
+				"%s: frames %d to %d",
+				s.branches[hit.branchIndex].name, hit.frameIndex, hit.frameIndex+len(pattern)-1,
+			)
+		}
+		listTitle := fmt.Sprintf("%d Hits of \"%s\"", total, text)
+		if total > len(hits) {
+			listTitle += fmt.Sprintf(", the First %d", len(hits))
+		}
+		s.startModalListDialog(listTitle, options, selected, func(i int) {
+			s.goToSearchHit(hits[i], len(pattern))
+		})
+	})
+}
+
+// goToSearchHit selects the frames of the hit, in its branch.
+func (s *editorState) goToSearchHit(hit searchHit, frames int) {
+	if hit.branchIndex != s.branchIndex {
+		s.switchToBranch(hit.branchIndex)
+	}
+	s.recordSeek()
+	s.leftMostFrame = hit.frameIndex
+	s.activeSelection = frameSelection{
+		first: hit.frameIndex,
+		last:  hit.frameIndex + frames - 1,
+	}
+	s.render()
+}
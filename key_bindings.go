@@ -0,0 +1,335 @@
+package main
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/Humpheh/goboy/gameboy"
+	"github.com/gonutz/prototype/draw"
+)
+
+// bindingMode is where a keyBinding applies. The help overlay groups the
+// bindings by it.
+type bindingMode int
+
+const (
+	generalMode bindingMode = iota
+	editorMode
+	replayMode
+	recordingMode
+	dialogMode
+	bindingModeCount // NOTE This has to come last.
+)
+
+var bindingModeNames = [bindingModeCount]string{
+	generalMode:   "General",
+	editorMode:    "Editor",
+	replayMode:    "Replay",
+	recordingMode: "Recording",
+	dialogMode:    "Dialogs",
+}
+
+type keyModifier int
+
+const (
+	noModifier keyModifier = iota
+	controlModifier
+	shiftModifier
+	altModifier
+	// severalModifiers is held down if more than one modifier is down, no
+	// binding uses it.
+	severalModifiers
+)
+
+var modifierPrefixes = [...]string{
+	noModifier:      "",
+	controlModifier: "Ctrl+",
+	shiftModifier:   "Shift+",
+	altModifier:     "Alt+",
+}
+
+// keyBinding is a keyboard shortcut. It is triggered by any of its keys while
+// exactly its modifier is held down. All bindings are listed in the help
+// overlay, which is why the code should check keys through them.
+type keyBinding struct {
+	mode     bindingMode
+	modifier keyModifier
+	keys     []draw.Key
+	// label replaces the key names in the help if it is set, for bindings
+	// with many keys.
+	label       string
+	description string
+}
+
+// keyBindings lists all bindings in the order of their declaration, which is
+// the order in the help.
+var keyBindings []*keyBinding
+
+func bind(mode bindingMode, modifier keyModifier, description string, keys ...draw.Key) *keyBinding {
+	b := &keyBinding{
+		mode:        mode,
+		modifier:    modifier,
+		keys:        keys,
+		description: description,
+	}
+	keyBindings = append(keyBindings, b)
+	return b
+}
+
+// bindLabeled is bind with a label for the keys in the help.
+func bindLabeled(mode bindingMode, modifier keyModifier, label, description string, keys ...draw.Key) *keyBinding {
+	b := bind(mode, modifier, description, keys...)
+	b.label = label
+	return b
+}
+
+// frameStepKeys move through the frames, Left and Right by one, Up and Down by
+// a row (or 5 in the replay), PageUp and PageDown by a screen (or 20). In the
+// column layout of the editor, Left and Right step by a column and Up and Down
+// by one.
+var frameStepKeys = []draw.Key{
+	draw.KeyLeft, draw.KeyRight, draw.KeyUp, draw.KeyDown, draw.KeyPageUp, draw.KeyPageDown,
+}
+
+const frameStepLabel = "Arrows/PageUp/PageDown"
+
+// The bindings are declared in the order of the help. Package variables are
+// initialized in declaration order, as long as they do not depend on later
+// ones, so they must only use the variables above. Bindings that the code
+// checks in a more involved way, e.g. with key repeat, are only declared for
+// the help.
+var (
+	fullscreenKeys  = bind(generalMode, noModifier, "Toggle fullscreen", draw.KeyF11, draw.KeyF)
+	newSpeedrunKeys = bind(generalMode, controlModifier, "New speedrun from a ROM file", draw.KeyN)
+	openKeys        = bind(generalMode, controlModifier, "Open a speedrun file", draw.KeyO)
+	startScreenKeys = bind(generalMode, controlModifier, "Show the recent games and sessions", draw.KeyL)
+	saveKeys        = bind(generalMode, controlModifier, "Save the speedrun", draw.KeyS)
+	sessionInfoKeys = bind(generalMode, controlModifier, "Show the session info", draw.KeyI)
+	muteKeys        = bind(generalMode, controlModifier, "Mute or unmute the sound", draw.KeyM)
+	liveRecordKeys  = bind(generalMode, controlModifier, "Record live at the end of the run", draw.KeyR)
+	renameKeys      = bind(generalMode, noModifier, "Rename the branch", draw.KeyF2)
+	verifyKeys      = bind(generalMode, noModifier, "Verify the whole run, again to cancel", draw.KeyF3)
+	profilingKeys   = bind(generalMode, noModifier, "Show or hide the profiling overlay", draw.KeyF4)
+	watchpointKeys  = bind(generalMode, noModifier, "Pause the replay on writes to a memory address", draw.KeyF8)
+	cancelKeys      = bind(generalMode, noModifier, "Close the help, cancel verifying, a desync check or saving", draw.KeyEscape)
+
+	helpKeys          = bind(editorMode, noModifier, "Show or hide this help", draw.KeyF1)
+	startReplayKeys   = bind(editorMode, noModifier, "Replay from the left-most frame", draw.KeySpace)
+	highlightKeys     = bind(editorMode, noModifier, "Highlight the selected frame or remove the highlight", draw.KeyH)
+	magnifierKeys     = bind(editorMode, noModifier, "Cycle the magnifier", draw.KeyZ)
+	onionSkinKeys     = bind(editorMode, noModifier, "Toggle the onion skin", draw.KeyO)
+	trackedValueKeys  = bind(editorMode, noModifier, "Show or hide the game's tracked values, like its RNG", draw.KeyT)
+	swapSnapshotKeys  = bind(editorMode, noModifier, "Swap the inputs with the last snapshot again", draw.KeyX)
+	syncAnchorKeys    = bind(editorMode, noModifier, "Set a sync anchor at the selected frame, accept its new screen or remove it", draw.KeyK)
+	frameHeaderKeys   = bind(editorMode, altModifier, "Show or hide the frame header above the grid", draw.KeyH)
+	pinPreviewKeys    = bind(editorMode, controlModifier, "Pin the selected frame in a preview pane or unpin it", draw.KeyP)
+	closePreviewKeys  = bind(editorMode, altModifier, "Close all preview panes", draw.KeyP)
+	_                 = bindLabeled(editorMode, noModifier, "0-9", "Type a repeat count or frame number")
+	goToFrameKeys     = bind(editorMode, noModifier, "Go to the typed frame number", draw.KeyG, draw.KeyEnter, draw.KeyNumEnter)
+	clearInfoKeys     = bind(editorMode, noModifier, "Clear the typed number or message", draw.KeyEscape)
+	_                 = bindLabeled(editorMode, noModifier, frameStepLabel, "Scroll by a frame, row or screen", frameStepKeys...)
+	_                 = bindLabeled(editorMode, shiftModifier, frameStepLabel, "Extend the selection", frameStepKeys...)
+	_                 = bindLabeled(editorMode, controlModifier, frameStepLabel, "Move the selected inputs", frameStepKeys...)
+	_                 = bindLabeled(editorMode, altModifier, "Up/Down/PageUp/PageDown", "Move the selection", frameStepKeys[2:]...)
+	backKeys          = bind(editorMode, altModifier, "Go back to before the last jump, e.g. to a typed frame", draw.KeyLeft)
+	forwardKeys       = bind(editorMode, altModifier, "Go forward again after going back", draw.KeyRight)
+	scrollToStartKeys = bind(editorMode, noModifier, "Scroll to the first frame", draw.KeyHome)
+	selectToStartKeys = bind(editorMode, shiftModifier, "Extend the selection to the first frame", draw.KeyHome)
+	scrollToEndKeys   = bind(editorMode, noModifier, "Scroll to the last frame", draw.KeyEnd)
+	selectToEndKeys   = bind(editorMode, shiftModifier, "Extend the selection to the last frame", draw.KeyEnd)
+	clearInputsKeys   = bind(editorMode, noModifier, "Release all buttons in the selection", draw.KeyBackspace, draw.KeyDelete)
+	resetZoomKeys     = bind(editorMode, controlModifier, "Reset the zoom", draw.Key0, draw.KeyNum0)
+	zoomInKeys        = bind(editorMode, controlModifier, "Zoom in", draw.KeyNumAdd)
+	zoomOutKeys       = bind(editorMode, controlModifier, "Zoom out", draw.KeyNumSubtract)
+	fitSelectionKeys  = bind(editorMode, controlModifier, "Zoom to fit the selection on screen", draw.KeyF)
+	fitRowKeys        = bind(editorMode, controlModifier, "Zoom to fit the typed number of frames, or 20, into a row", draw.KeyW)
+	editorButtonKeys  = bindLabeled(editorMode, noModifier, "", "Toggle a button in the selection")
+	chordModeKeys     = bind(editorMode, noModifier, "Toggle chord mode, hold buttons and press Enter to press them together", draw.KeyC)
+	futureButtonKeys  = bindLabeled(editorMode, shiftModifier, "", "Hold or release a button for all future frames")
+
+	stopReplayKeys      = bind(replayMode, noModifier, "Back to the editor", draw.KeyEscape)
+	editorAtFrameKeys   = bind(replayMode, noModifier, "Back to the editor at the current frame", draw.KeyF1)
+	pauseKeys           = bind(replayMode, noModifier, "Pause or continue", draw.KeySpace)
+	scopesKeys          = bind(replayMode, noModifier, "Show or hide the oscilloscopes", draw.KeyV)
+	replayHighlightKeys = bind(replayMode, noModifier, "Highlight the current frame or remove the highlight", draw.KeyH)
+	loopKeys            = bind(replayMode, noModifier, "Loop the selected frames or stop looping", draw.KeyO)
+	restartKeys         = bind(replayMode, noModifier, "Go to the first frame", draw.KeyHome)
+	fasterKeys          = bind(replayMode, noModifier, "Faster replay, while playing", draw.KeyUp)
+	slowerKeys          = bind(replayMode, noModifier, "Slower replay, while playing", draw.KeyDown)
+	_                   = bind(replayMode, noModifier, "Hold to rewind, while playing", draw.KeyLeft)
+	_                   = bindLabeled(replayMode, noModifier, frameStepLabel, "Step by 1, 5 or 20 frames, while paused", frameStepKeys...)
+	overwriteKeys       = bind(replayMode, noModifier, "Start or stop recording from the current frame", draw.KeyInsert)
+	gridFilterKeys      = bind(replayMode, noModifier, "Toggle the LCD grid", draw.KeyF5)
+	scanlinesKeys       = bind(replayMode, noModifier, "Toggle the scanlines", draw.KeyF6)
+	ghostingKeys        = bind(replayMode, noModifier, "Toggle the LCD ghosting", draw.KeyF7)
+	replayButtonKeys    = bindLabeled(replayMode, noModifier, "", "Toggle a button in the current frame")
+
+	liveButtonKeys = bindLabeled(recordingMode, noModifier, "", "Hold the Gameboy buttons")
+	_              = bind(recordingMode, noModifier, "Stop recording and pause", draw.KeyInsert)
+	_              = bind(recordingMode, noModifier, "Stop recording and go to the editor", draw.KeyEscape)
+
+	closeDialogKeys    = bind(dialogMode, noModifier, "Close the settings, audio settings, splits and start screen", draw.KeyEscape, draw.KeyEnter)
+	acceptDialogKeys   = bind(dialogMode, noModifier, "Accept the dialog", draw.KeyEnter)
+	cancelDialogKeys   = bind(dialogMode, noModifier, "Cancel the dialog or export", draw.KeyEscape)
+	previousOptionKeys = bind(dialogMode, noModifier, "Select the previous option", draw.KeyUp)
+	nextOptionKeys     = bind(dialogMode, noModifier, "Select the next option", draw.KeyDown)
+	deleteLetterKeys   = bind(dialogMode, noModifier, "Delete the last letter", draw.KeyBackspace)
+	deleteWordKeys     = bind(dialogMode, controlModifier, "Delete the last word", draw.KeyBackspace)
+)
+
+// The button labels come from keyMap and liveKeyMap, which are initialized
+// after the bindings.
+func init() {
+	editorButtonKeys.label = keyMapLabel(keyMap)
+	futureButtonKeys.label = keyMapLabel(keyMap)
+	replayButtonKeys.label = keyMapLabel(keyMap)
+	liveButtonKeys.label = keyMapLabel(liveKeyMap)
+}
+
+var helpButtonNames = [gameboy.ButtonCount]string{
+	gameboy.ButtonA:      "A",
+	gameboy.ButtonB:      "B",
+	gameboy.ButtonSelect: "Select",
+	gameboy.ButtonStart:  "Start",
+	gameboy.ButtonRight:  "Right",
+	gameboy.ButtonLeft:   "Left",
+	gameboy.ButtonUp:     "Up",
+	gameboy.ButtonDown:   "Down",
+}
+
+// keyMapLabel lists the keys of a key map with their Gameboy buttons, e.g.
+// "L=Left U=Up".
+func keyMapLabel(m map[draw.Key]gameboy.Button) string {
+	keys := make([]draw.Key, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b draw.Key) int {
+		return int(m[b]) - int(m[a])
+	})
+	var parts []string
+	for _, key := range keys {
+		parts = append(parts, key.String()+"="+helpButtonNames[m[key]])
+	}
+	return strings.Join(parts, " ")
+}
+
+func currentModifier(window draw.Window) keyModifier {
+	control := window.IsKeyDown(draw.KeyLeftControl) || window.IsKeyDown(draw.KeyRightControl)
+	shift := window.IsKeyDown(draw.KeyLeftShift) || window.IsKeyDown(draw.KeyRightShift)
+	alt := window.IsKeyDown(draw.KeyLeftAlt) || window.IsKeyDown(draw.KeyRightAlt)
+	switch {
+	case !control && !shift && !alt:
+		return noModifier
+	case control && !shift && !alt:
+		return controlModifier
+	case shift && !control && !alt:
+		return shiftModifier
+	case alt && !control && !shift:
+		return altModifier
+	}
+	return severalModifiers
+}
+
+func (b *keyBinding) wasPressed(window draw.Window) bool {
+	if currentModifier(window) != b.modifier {
+		return false
+	}
+	for _, key := range b.keys {
+		if window.WasKeyPressed(key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *keyBinding) keyText() string {
+	if b.label != "" {
+		return modifierPrefixes[b.modifier] + b.label
+	}
+	var keys []string
+	for _, key := range b.keys {
+		keys = append(keys, modifierPrefixes[b.modifier]+key.String())
+	}
+	return strings.Join(keys, " / ")
+}
+
+// renderHelpOverlay lists all keyBindings, grouped by their mode, over the
+// window while the help is shown.
+func (s *editorState) renderHelpOverlay(window draw.Window) {
+	if !s.showHelp {
+		return
+	}
+
+	windowW, windowH := window.Size()
+	box := rect(20, 20, windowW-40, windowH-40)
+	box.fill(window, draw.RGBA(0, 0, 0, 0.85))
+
+	title := "Keyboard Shortcuts (F1 or Escape to close)"
+
+	// Try smaller text until all bindings fit into the box.
+	for _, textScale := range []float32{1.5, 1.25, 1} {
+		if s.layoutHelp(window, box, title, textScale, false) ||
+			textScale == 1 {
+			s.layoutHelp(window, box, title, textScale, true)
+			return
+		}
+	}
+}
+
+// layoutHelp arranges the bindings in columns and draws them if paint is true.
+// It returns false if they do not fit into the box.
+func (s *editorState) layoutHelp(window draw.Window, box rectangle, title string, textScale float32, paint bool) bool {
+	const margin = 20
+	const gap = 20
+
+	keysW, descriptionW := 0, 0
+	for _, b := range keyBindings {
+		w, _ := window.GetScaledTextSize(b.keyText(), textScale)
+		keysW = max(keysW, w)
+		w, _ = window.GetScaledTextSize(b.description, textScale)
+		descriptionW = max(descriptionW, w)
+	}
+	_, lineH := window.GetScaledTextSize("|", textScale)
+	columnW := keysW + gap + descriptionW
+	top := box.y + margin + 2*lineH
+	bottom := box.y + box.h - margin
+
+	if paint {
+		titleW, _ := window.GetScaledTextSize(title, textScale)
+		window.DrawScaledText(title, box.x+(box.w-titleW)/2, box.y+margin, textScale, draw.White)
+	}
+
+	x, y := box.x+margin, top
+	nextColumn := func() {
+		x += columnW + 2*gap
+		y = top
+	}
+	for mode := range bindingModeCount {
+		// A mode's title moves to the next column with its first binding.
+		if y != top && y+3*lineH > bottom {
+			nextColumn()
+		} else if y != top {
+			y += lineH
+		}
+		if paint {
+			window.DrawScaledText(bindingModeNames[mode], x, y, textScale, draw.Yellow)
+		}
+		y += lineH
+
+		for _, b := range keyBindings {
+			if b.mode != mode {
+				continue
+			}
+			if y+lineH > bottom {
+				nextColumn()
+			}
+			if paint {
+				window.DrawScaledText(b.keyText(), x, y, textScale, draw.LightGray)
+				window.DrawScaledText(b.description, x+keysW+gap, y, textScale, draw.White)
+			}
+			y += lineH
+		}
+	}
+	return x+columnW <= box.x+box.w-margin
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sync/atomic"
+
+	"github.com/Humpheh/goboy/gameboy"
+	"github.com/gonutz/prototype/draw"
+)
+
+// keyFrameRebuild emulates the active branch on a background goroutine to
+// re-create the key frames of a session that was saved without them, see
+// saveInputsOnly. The UI stays usable, it appends the key frames as they
+// arrive. Scrolling past them still emulates on the UI thread as usual.
+type keyFrameRebuild struct {
+	firstFrame, lastFrame int
+	// emulatedFrames counts the rebuilt frames from firstFrame on. It is
+	// atomic because the UI shows the rebuild's progress while it runs.
+	emulatedFrames atomic.Int64
+	states         chan rebuiltKeyFrame
+	cancel         chan struct{}
+}
+
+type rebuiltKeyFrame struct {
+	index   int
+	gameboy *gameboy.Gameboy
+}
+
+// rebuildMissingKeyFrames starts rebuilding the key frames after the last one
+// that we have, up to the end of the active branch.
+func (s *editorState) rebuildMissingKeyFrames() {
+	s.cancelKeyFrameRebuild()
+
+	inputs := s.branch().frameInputs
+	have := len(s.keyFrameStates)
+	if have*keyFrameInterval >= len(inputs) {
+		return
+	}
+
+	r := &keyFrameRebuild{
+		lastFrame: len(inputs) - 1,
+		states:    make(chan rebuiltKeyFrame, 1),
+		cancel:    make(chan struct{}),
+	}
+	var start gameboy.Gameboy
+	if have > 0 {
+		start = s.keyFrameStates[have-1].gameboy()
+		r.firstFrame = (have-1)*keyFrameInterval + 1
+	} else {
+		start = s.startGameboy(s.gameboyOptions)
+	}
+	s.keyFrameRebuild = r
+	go r.run(start, slices.Clone(inputs), maps.Clone(s.branch().subframeInputs))
+}
+
+func (r *keyFrameRebuild) run(gb gameboy.Gameboy, inputs []inputState, subframes map[int][]subframeInput) {
+	defer close(r.states)
+
+	for i := r.firstFrame; i < len(inputs); i++ {
+		select {
+		case <-r.cancel:
+			return
+		default:
+		}
+
+		applyInputs(&gb, inputs[i])
+		setPollInputs(&gb, subframes[i])
+		gb.Step()
+		r.emulatedFrames.Store(int64(i + 1 - r.firstFrame))
+
+		if i%keyFrameInterval == 0 {
+			state := new(gameboy.Gameboy)
+			*state = gb
+			select {
+			case r.states <- rebuiltKeyFrame{index: i / keyFrameInterval, gameboy: state}:
+			case <-r.cancel:
+				return
+			}
+		}
+	}
+}
+
+// cancelKeyFrameRebuild stops a running rebuild. It is safe to call if none
+// is running.
+func (s *editorState) cancelKeyFrameRebuild() {
+	if s.keyFrameRebuild != nil {
+		close(s.keyFrameRebuild.cancel)
+		s.keyFrameRebuild = nil
+	}
+}
+
+// updateKeyFrameRebuild is called once per UI frame. It appends the key
+// frames that the background goroutine has produced so far.
+func (s *editorState) updateKeyFrameRebuild() {
+	r := s.keyFrameRebuild
+	if r == nil {
+		return
+	}
+
+	for {
+		select {
+		case k, ok := <-r.states:
+			if !ok {
+				s.keyFrameRebuild = nil
+				return
+			}
+			// The UI might have emulated this key frame itself in the
+			// meantime.
+			if k.index == len(s.keyFrameStates) {
+				s.addKeyFrame(*k.gameboy)
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (s *editorState) renderKeyFrameRebuildProgress(window draw.Window) {
+	r := s.keyFrameRebuild
+	if r == nil {
+		return
+	}
+
+	done := r.emulatedFrames.Load()
+	total := int64(r.lastFrame + 1 - r.firstFrame)
+	text := fmt.Sprintf(
+		"Rebuilding key frames, frame %d of %d",
+		int64(r.firstFrame)+done, r.lastFrame+1,
+	)
+	renderProgressBox(window, text, done, total, 3)
+}
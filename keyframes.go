@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"unsafe"
+
+	"github.com/Humpheh/goboy/gameboy"
+	"github.com/gonutz/prototype/draw"
+)
+
+// gameboySize is the memory that one uncompressed emulator state takes.
+const gameboySize = int(unsafe.Sizeof(gameboy.Gameboy{}))
+
+// keyFrame is one of the editorState.keyFrameStates. When the key frames and
+// the frame cache take more than the memory limit, the oldest key frames are
+// compressed, see enforceMemoryLimit.
+type keyFrame struct {
+	// state is nil if the key frame is compressed. The Gameboy it points to is
+	// never modified, snapshots of the key frames share it.
+	state      *gameboy.Gameboy
+	compressed []byte
+	// rom is the cartridge of the compressed state, it is not part of the
+	// saved state.
+	rom string
+}
+
+func newKeyFrame(gb gameboy.Gameboy) keyFrame {
+	return keyFrame{state: &gb}
+}
+
+func (k keyFrame) gameboy() gameboy.Gameboy {
+	if k.state != nil {
+		return *k.state
+	}
+
+	data, err := io.ReadAll(flate.NewReader(bytes.NewReader(k.compressed)))
+	if err != nil {
+		// We wrote the data ourselves, this cannot happen.
+		panic("decompressing key frame: " + err.Error())
+	}
+	gb, err := gameboy.LoadState(k.rom, data)
+	if err != nil {
+		panic("decoding key frame: " + err.Error())
+	}
+	return gb
+}
+
+func (k keyFrame) memory() int {
+	if k.state != nil {
+		return gameboySize
+	}
+	return len(k.compressed)
+}
+
+func (k *keyFrame) compress() {
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.BestSpeed)
+	w.Write(k.state.SaveState())
+	w.Close()
+	k.compressed = buf.Bytes()
+	k.rom = k.state.Memory.Cart.ROM()
+	k.state = nil
+}
+
+// addKeyFrame appends the next key frame.
+func (s *editorState) addKeyFrame(gb gameboy.Gameboy) {
+	s.keyFrameStates = append(s.keyFrameStates, newKeyFrame(gb))
+	s.enforceMemoryLimit()
+}
+
+// memoryUsage is the number of bytes taken by the key frames and the frame
+// cache.
+func (s *editorState) memoryUsage() int {
+	total := len(s.frameCache.gameboys) * gameboySize
+	for _, k := range s.keyFrameStates {
+		total += k.memory()
+	}
+	return total
+}
+
+// enforceMemoryLimit compresses the oldest key frames until we are below the
+// memoryLimit. Compressed key frames are slower to start emulating from,
+// but the ones near the end of the run, where we usually work, stay fast.
+func (s *editorState) enforceMemoryLimit() {
+	cache := len(s.frameCache.gameboys) * gameboySize
+	compressKeyFrames(s.keyFrameStates, memoryLimit()-cache)
+}
+
+// memoryLimit is the -memory flag if it is given, otherwise the setting.
+func memoryLimit() int {
+	mb := int(globalSettings.MemoryLimitMB)
+	if *memoryLimitMB > 0 {
+		mb = *memoryLimitMB
+	}
+	return mb * 1024 * 1024
+}
+
+// compressKeyFrames compresses the oldest key frames until they take at most
+// limit bytes or all are compressed.
+func compressKeyFrames(keyFrames []keyFrame, limit int) {
+	usage := 0
+	for _, k := range keyFrames {
+		usage += k.memory()
+	}
+	for i := range keyFrames {
+		if usage <= limit {
+			return
+		}
+		k := &keyFrames[i]
+		if k.state != nil {
+			k.compress()
+			usage += k.memory() - gameboySize
+		}
+	}
+}
+
+// renderMemoryStatus shows the memory used for emulator states at the bottom
+// left of the grid.
+func (s *editorState) renderMemoryStatus(window draw.Window, bottom int) {
+	const mb = 1024 * 1024
+	usage := s.memoryUsage()
+	limit := memoryLimit()
+	text := fmt.Sprintf("Memory: %d of %d MB", usage/mb, limit/mb)
+
+	theme := currentTheme()
+	color := theme.infoText
+	if usage > limit {
+		// Everything is compressed and it is still too much.
+		color = theme.warningText
+	}
+	const textScale = infoTextScale / 2
+	textW, textH := window.GetScaledTextSize(text, textScale)
+	window.FillRect(0, bottom-textH-1, textW+4, textH+1, theme.infoBackground)
+	window.DrawScaledText(text, 2, bottom-textH, textScale, color)
+}
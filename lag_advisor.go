@@ -0,0 +1,289 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sync/atomic"
+
+	"github.com/Humpheh/goboy/gameboy"
+	"github.com/gonutz/prototype/draw"
+)
+
+// The lag advisor looks for presses in the selected frames that cause lag. It
+// tries every press one frame later, on the emulation workers, and reports the
+// variants that have fewer lag frames in the selection and still end in the
+// same state, compared like in the input analysis. The user picks one of them
+// to get it as a new branch.
+const maxLagAdvice = 20
+
+type lagAdvisor struct {
+	branchName    string
+	branchInputs  []inputState
+	branchDefault inputState
+	first, end    int
+	totalVariants int64
+	// testedVariants is written by the background goroutine and read by the
+	// UI to display the progress.
+	testedVariants atomic.Int64
+	// result receives the advice once and is closed afterwards.
+	result chan []lagAdvice
+	cancel chan struct{}
+}
+
+type lagAdvice struct {
+	// frame is where the press of button started before it was delayed.
+	frame     int
+	button    gameboy.Button
+	lagFrames int
+	saved     int
+	// inputs are the selected frames with the press delayed.
+	inputs []inputState
+}
+
+func (a lagAdvice) String() string {
+	return fmt.Sprintf(
+		"Delay %s at frame %d: %d fewer lag frames",
+		helpButtonNames[a.button], a.frame, a.saved,
+	)
+}
+
+// delayedPresses returns a variant of the inputs for every press, where a
+// press is a run of frames that hold a button, with the press one frame
+// later.
+func delayedPresses(inputs []inputState) []lagAdvice {
+	var variants []lagAdvice
+	for b := range gameboy.ButtonCount {
+		for i := 0; i < len(inputs); i++ {
+			if !isButtonDown(inputs[i], b) || i > 0 && isButtonDown(inputs[i-1], b) {
+				continue
+			}
+			end := i
+			for end < len(inputs) && isButtonDown(inputs[end], b) {
+				end++
+			}
+			delayed := slices.Clone(inputs)
+			setButtonDown(&delayed[i], b, false)
+			if end < len(delayed) {
+				setButtonDown(&delayed[end], b, true)
+			}
+			variants = append(variants, lagAdvice{frame: i, button: b, inputs: delayed})
+		}
+	}
+	return variants
+}
+
+// startModalToolsDialog lets the user pick one of the tools that emulate the
+// active branch in the background.
+func (s *editorState) startModalToolsDialog() {
+	tools := []struct {
+		name  string
+		start func()
+	}{
+		{"Desync Check", s.startDesyncCheck},
+		{"Optimize the Selection", s.startOptimizer},
+		{"Fuzz the Selection", s.startFuzzer},
+		{"Find Dead Inputs in the Selection", s.startInputAnalysis},
+		{"Reduce Lag in the Selection", s.startLagAdvisor},
+	}
+	names := make([]string, len(tools))
+	for i := range tools {
+		names[i] = tools[i].name
+	}
+	s.startModalListDialog("Tools", names, 0, func(i int) {
+		tools[i].start()
+	})
+}
+
+// startLagAdvisor tries delaying the presses in the selected frames.
+func (s *editorState) startLagAdvisor() {
+	if s.lagAdvisor != nil {
+		s.setWarning("The lag advisor is already running.")
+		return
+	}
+
+	b := s.branch()
+	first, end := s.activeSelection.start(), s.activeSelection.end()
+	inputs := make([]inputState, end-first)
+	for i := range inputs {
+		inputs[i] = s.inputsAt(first + i)
+	}
+	variants := delayedPresses(inputs)
+	if len(variants) == 0 {
+		s.setInfo("There are no presses in the selection to delay.")
+		s.render()
+		return
+	}
+
+	var start gameboy.Gameboy
+	if first == 0 {
+		start = s.startGameboy(s.gameboyOptions)
+	} else {
+		start = s.generateFrame(first - 1)
+	}
+	// We do not need any sound to count lag frames.
+	start.Options.Sound = false
+
+	a := &lagAdvisor{
+		branchName:    b.name,
+		branchInputs:  slices.Clone(b.frameInputs),
+		branchDefault: b.defaultInputs,
+		first:         first,
+		end:           end,
+		totalVariants: int64(len(variants)),
+		result:        make(chan []lagAdvice, 1),
+		cancel:        make(chan struct{}),
+	}
+	s.lagAdvisor = a
+	go a.run(start, inputs, variants, shiftedSubframes(maps.Clone(b.subframeInputs), first))
+}
+
+func (a *lagAdvisor) run(start gameboy.Gameboy, inputs []inputState, variants []lagAdvice, subframes map[int][]subframeInput) {
+	defer close(a.result)
+
+	canceled := func() bool {
+		select {
+		case <-a.cancel:
+			return true
+		default:
+			return false
+		}
+	}
+
+	// lagJob counts the lag frames and hashes the last frame.
+	lagJob := func(inputs []inputState, lag *int, hash *uint64) emulationJob {
+		return emulationJob{
+			start:     &start,
+			inputs:    inputs,
+			subframes: subframes,
+			afterFrame: func(i int, gb *gameboy.Gameboy) bool {
+				if !gb.JoypadPolled {
+					*lag++
+				}
+				if i == len(inputs)-1 {
+					*hash = hashFrame(gb)
+				}
+				return !canceled()
+			},
+		}
+	}
+
+	var originalLag int
+	var originalHash uint64
+	emulateJobs([]emulationJob{lagJob(inputs, &originalLag, &originalHash)})
+
+	var advice []lagAdvice
+	for batch := range slices.Chunk(variants, emulationWorkerCount) {
+		if canceled() {
+			return
+		}
+		lags := make([]int, len(batch))
+		hashes := make([]uint64, len(batch))
+		jobs := make([]emulationJob, len(batch))
+		for i := range batch {
+			jobs[i] = lagJob(batch[i].inputs, &lags[i], &hashes[i])
+		}
+		emulateJobs(jobs)
+		for i, v := range batch {
+			if lags[i] < originalLag && hashes[i] == originalHash {
+				v.lagFrames = lags[i]
+				v.saved = originalLag - lags[i]
+				v.frame += a.first
+				advice = append(advice, v)
+			}
+		}
+		a.testedVariants.Add(int64(len(batch)))
+	}
+
+	if canceled() {
+		return
+	}
+	slices.SortStableFunc(advice, func(x, y lagAdvice) int {
+		return y.saved - x.saved
+	})
+	a.result <- advice[:min(len(advice), maxLagAdvice)]
+}
+
+// cancelLagAdvisor stops the lag advisor. It is safe to call if it is not
+// running.
+func (s *editorState) cancelLagAdvisor() {
+	if s.lagAdvisor != nil {
+		close(s.lagAdvisor.cancel)
+		for range s.lagAdvisor.result {
+		}
+		s.lagAdvisor = nil
+	}
+}
+
+// updateLagAdvisor is called once per UI frame and lets the user pick a
+// variant when the advisor is done. It waits for other dialogs to be closed
+// first.
+func (s *editorState) updateLagAdvisor() {
+	a := s.lagAdvisor
+	if a == nil || s.modal != nil || s.fileDialog != nil {
+		return
+	}
+
+	var advice []lagAdvice
+	select {
+	case result, ok := <-a.result:
+		if !ok {
+			return
+		}
+		advice = result
+	default:
+		return
+	}
+	s.lagAdvisor = nil
+	s.render()
+
+	if len(advice) == 0 {
+		s.setInfo(fmt.Sprintf(
+			"Delaying none of the %d presses in frames %d to %d saves lag.",
+			a.totalVariants, a.first, a.end-1,
+		))
+		return
+	}
+
+	options := make([]string, len(advice))
+	for i := range advice {
+		options[i] = advice[i].String()
+	}
+	s.startModalListDialog("Lag Advice, Pick One to Copy as a Branch", options, 0, func(i int) {
+		s.addLagAdviceBranch(a, advice[i])
+	})
+}
+
+// addLagAdviceBranch adds a branch with the advice applied to the inputs of
+// the branch that was analyzed.
+func (s *editorState) addLagAdviceBranch(a *lagAdvisor, advice lagAdvice) {
+	inputs := a.branchInputs
+	for len(inputs) < a.end {
+		inputs = append(inputs, a.branchDefault)
+	}
+	copy(inputs[a.first:], advice.inputs)
+
+	name := fmt.Sprintf("%s (delay %s at %d)", a.branchName, helpButtonNames[advice.button], advice.frame)
+	s.branches = append(s.branches, branch{
+		name:                name,
+		frameInputs:         inputs,
+		defaultInputs:       a.branchDefault,
+		highlightFrameIndex: advice.frame,
+	})
+	s.unsavedChanges = true
+	s.setInfo(fmt.Sprintf("%s, see branch \"%s\".", advice, name))
+}
+
+func (s *editorState) renderLagAdvisorProgress(window draw.Window) {
+	a := s.lagAdvisor
+	if a == nil {
+		return
+	}
+
+	done := a.testedVariants.Load()
+	text := fmt.Sprintf(
+		"Delaying presses to save lag, %d of %d (Escape to cancel)",
+		done, a.totalVariants,
+	)
+	renderProgressBox(window, text, done, a.totalVariants, 8)
+}
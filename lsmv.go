@@ -0,0 +1,148 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Humpheh/goboy/gameboy"
+)
+
+// lsmvButtons are the symbols of lsnes' Gambatte gamepad, in the order they
+// appear in an input line.
+var lsmvButtons = []struct {
+	button gameboy.Button
+	symbol byte
+}{
+	{gameboy.ButtonA, 'A'},
+	{gameboy.ButtonB, 'B'},
+	{gameboy.ButtonSelect, 's'},
+	{gameboy.ButtonStart, 'S'},
+	{gameboy.ButtonRight, 'r'},
+	{gameboy.ButtonLeft, 'l'},
+	{gameboy.ButtonUp, 'u'},
+	{gameboy.ButtonDown, 'd'},
+}
+
+// lsmvFrameCycles is the length of a frame in lsnes' Gambatte core. Its
+// frames are LCD frames, which are longer than our gameboy.CyclesPerFrame.
+const lsmvFrameCycles = 70224
+
+// lsmvFrameCount is the number of LCD frames that cover the given number of
+// editor frames.
+func lsmvFrameCount(editorFrames int) int {
+	return (editorFrames*gameboy.CyclesPerFrame + lsmvFrameCycles - 1) / lsmvFrameCycles
+}
+
+// exportLSMV saves the current branch as an lsnes movie for its Gambatte core.
+// An .lsmv file is a zip archive with one file per movie property and the
+// inputs in the "input" file, one line per LCD frame. The inputs are
+// resampled from our shorter frames, so a movie only syncs if the game reads
+// the buttons before the next editor frame starts in each LCD frame.
+func (s *editorState) exportLSMV() error {
+	if err := s.checkLSMV(); err != nil {
+		return err
+	}
+	b := s.branch()
+
+	s.startSaveDialog("Export lsnes Movie", "lsnes Movie", "lsmv", func(path string) error {
+		err := writeSessionFile(path, s.writeLSMV)
+		if err != nil {
+			return fmt.Errorf("failed to export '%s': %w", path, err)
+		}
+
+		s.setInfo(fmt.Sprintf(
+			"Exported %d frames as %d lsnes frames to %s",
+			len(b.frameInputs), lsmvFrameCount(len(b.frameInputs)), path,
+		))
+		return nil
+	})
+	return nil
+}
+
+// checkLSMV returns an error if the current branch cannot be an lsnes movie.
+func (s *editorState) checkLSMV() error {
+	if err := s.checkStart("export an lsnes movie", false); err != nil {
+		return err
+	}
+	for _, inputs := range s.branch().frameInputs {
+		if inputs&powerCycleEvent != 0 {
+			return fmt.Errorf("cannot export power cycles to lsnes movies")
+		}
+	}
+	return nil
+}
+
+func (s *editorState) writeLSMV(w io.Writer) error {
+	gameType := "gdmg"
+	if s.gameboyOptions.Model.IsColor() && len(s.rom) > 0x143 && s.rom[0x143]&0x80 != 0 {
+		gameType = "ggbc"
+		if s.gameboyOptions.Model == gameboy.ModelAGB {
+			gameType = "ggbca"
+		}
+	}
+
+	romHash := sha256.Sum256(s.rom)
+
+	// Every input line starts with the system controls: F for the frame sync,
+	// then R if the console is reset in this frame.
+	frames := s.branch().frameInputs
+	var input strings.Builder
+	for line := range lsmvFrameCount(len(frames)) {
+		// A line is an LCD frame which holds the buttons of the editor frame
+		// in which it starts. A reset in any editor frame that starts during
+		// the LCD frame resets at the start of the line.
+		start := line * lsmvFrameCycles
+		inputs := frames[start/gameboy.CyclesPerFrame]
+		reset := false
+		first := (start + gameboy.CyclesPerFrame - 1) / gameboy.CyclesPerFrame
+		for i := first; i < len(frames) && i*gameboy.CyclesPerFrame < start+lsmvFrameCycles; i++ {
+			reset = reset || frames[i]&resetEvent != 0
+		}
+
+		input.WriteString("F")
+		if reset {
+			input.WriteString("R")
+		} else {
+			input.WriteString(".")
+		}
+		input.WriteString("|")
+		for _, b := range lsmvButtons {
+			if isButtonDown(inputs, b.button) {
+				input.WriteByte(b.symbol)
+			} else {
+				input.WriteByte('.')
+			}
+		}
+		input.WriteString("\n")
+	}
+
+	files := []struct{ name, content string }{
+		{"systemid", "lsnes-rr1"},
+		{"controlsversion", "0"},
+		{"gametype", gameType},
+		{"gamename", s.metadata.gameTitle},
+		{"projectid", fmt.Sprintf("%x", romHash[:8])},
+		{"rerecords", fmt.Sprint(s.metadata.rerecordCount)},
+		{"authors", s.metadata.author + "|"},
+		{"rom.sha256", hex.EncodeToString(romHash[:])},
+		{"starttime.second", "0"},
+		{"starttime.subsecond", "0"},
+		{"input", input.String()},
+	}
+
+	z := zip.NewWriter(w)
+	for _, file := range files {
+		w, err := z.Create(file.name)
+		if err == nil {
+			_, err = w.Write([]byte(file.content))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return z.Close()
+}
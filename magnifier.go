@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/Humpheh/goboy/gameboy"
+	"github.com/gonutz/prototype/draw"
+)
+
+// magnifierZooms are the magnifications, relative to the Gameboy's resolution,
+// that Z cycles through. 0 turns the magnifier off.
+var magnifierZooms = []int{0, 2, 3, 4}
+
+func (s *editorState) magnifierZoom() int {
+	return magnifierZooms[s.magnifierIndex]
+}
+
+func (s *editorState) cycleMagnifier() {
+	s.magnifierIndex = (s.magnifierIndex + 1) % len(magnifierZooms)
+	if zoom := s.magnifierZoom(); zoom == 0 {
+		s.setInfo("Magnifier off")
+	} else {
+		s.setInfo(fmt.Sprintf("Magnifier %dx", zoom))
+	}
+	s.render()
+}
+
+// renderMagnifier draws the screen of the frame under the mouse, magnified,
+// next to the mouse cursor. The frame grid starts at gridTop and must have been
+// drawn before, with updateScreenTiles.
+func (s *editorState) renderMagnifier(
+	window draw.Window,
+	mouseX, mouseY, gridTop int,
+	frameWidth, frameHeight, fontHeight int,
+	frameCountX, frameCountY int,
+) {
+	zoom := s.magnifierZoom()
+	if zoom == 0 || mouseX < 0 || mouseY < gridTop {
+		return
+	}
+
+	frameX := mouseX / frameWidth
+	frameY := (mouseY - gridTop) / frameHeight
+	if frameX >= frameCountX || frameY >= frameCountY ||
+		(mouseY-gridTop)%frameHeight < fontHeight {
+		// Not over a Gameboy screen.
+		return
+	}
+
+	windowW, windowH := window.Size()
+	w := zoom * gameboy.ScreenWidth
+	h := zoom * gameboy.ScreenHeight
+
+	// Place the magnifier to the bottom-right of the cursor, or to the other
+	// sides if it does not fit into the window.
+	const margin = 16
+	x := mouseX + margin
+	if x+w > windowW {
+		x = mouseX - margin - w
+	}
+	y := mouseY + margin
+	if y+h > windowH {
+		y = mouseY - margin - h
+	}
+	x = max(0, x)
+	y = max(0, y)
+
+	rect(x, y, w, h).expand(2).fill(window, draw.White)
+	window.BlurImages(false)
+	s.drawGridScreen(window, gridIndex(frameX, frameY, frameCountX, frameCountY), x, y, w, h)
+}
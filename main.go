@@ -1,10 +1,16 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
+	"errors"
 	"flag"
 	"fmt"
+	"hash/crc32"
+	"io"
+	"maps"
 	"math"
 	"os"
 	"path/filepath"
@@ -13,27 +19,36 @@ import (
 	"strconv"
 	"strings"
 	"time"
-	"unicode"
-	"unicode/utf8"
 
+	"github.com/Humpheh/goboy/gameboy"
 	"github.com/gonutz/prototype/draw"
 	"github.com/sqweek/dialog"
 )
 
 var (
-	mute       = flag.Bool("mute", false, "mute sound output")
+	mute       = flag.Bool("mute", false, "start with the sound muted")
 	cpuprofile = flag.Bool("cpuprofile", false, "write cpu profile to file (debugging)")
+	// The stream flags let streamers show the replay in OBS, see streaming.go.
+	streamAddress    = flag.String("stream", "", "serve the replay as MJPEG on this address, e.g. localhost:8090")
+	streamInputsPath = flag.String("stream-inputs", "", "write the replay's pressed buttons to this text file")
+	// External tools can drive the editor over HTTP, see remote.go.
+	remoteAddress = flag.String("remote", "", "serve the remote control API on this loopback address, e.g. localhost:8091")
+	service       = flag.Bool("service", false, "run the emulator without a window, controlled over stdin/stdout, see service.go")
+	testROM       = flag.String("testrom", "", "run this blargg or mooneye test ROM without a window and exit with 0 if it passes")
+	benchmark     = flag.Int("benchmark", 0, "emulate this many frames of the ROM file argument without a window and print the frames per second")
+	audit         = flag.Int("audit", 0, "emulate this many frames of the ROM file argument in several ways at once and report if the states differ, see audit.go")
+	memoryLimitMB = flag.Int("memory", 0, "memory in MB for emulator states, older key frames are compressed to stay below it, overrides the settings")
 )
 
-var keyMap = map[draw.Key]Button{
-	draw.KeyL: ButtonLeft,
-	draw.KeyU: ButtonUp,
-	draw.KeyR: ButtonRight,
-	draw.KeyD: ButtonDown,
-	draw.KeyA: ButtonA,
-	draw.KeyB: ButtonB,
-	draw.KeyS: ButtonStart,
-	draw.KeyE: ButtonSelect,
+var keyMap = map[draw.Key]gameboy.Button{
+	draw.KeyL: gameboy.ButtonLeft,
+	draw.KeyU: gameboy.ButtonUp,
+	draw.KeyR: gameboy.ButtonRight,
+	draw.KeyD: gameboy.ButtonDown,
+	draw.KeyA: gameboy.ButtonA,
+	draw.KeyB: gameboy.ButtonB,
+	draw.KeyS: gameboy.ButtonStart,
+	draw.KeyE: gameboy.ButtonSelect,
 }
 
 const (
@@ -41,7 +56,7 @@ const (
 
 	keyFrameInterval      = 100
 	minSessionFileVersion = 1
-	sessionFileVersion    = 5
+	sessionFileVersion    = 25
 
 	baseTextScale  = 0.8
 	baseFontHeight = 13
@@ -61,10 +76,9 @@ const (
 	startSelectButtonDistX = startButtonH / 2
 )
 
-var (
-	selectionColor = draw.RGBA(1, 0.5, 0.5, 0.2)
-	highlightColor = draw.RGBA(1, 0.5, 1, 0.25)
-)
+// defaultGameboyOptions are the options of new sessions and of the command
+// line modes. Every session has its own, see editorState.gameboyOptions.
+var defaultGameboyOptions = gameboy.GameboyOptions{Sound: true}
 
 var scalePercentages = []int{
 	50,
@@ -116,169 +130,259 @@ func bestFitScale(destScale float64) float64 {
 func main() {
 	flag.Parse()
 
+	if *testROM != "" {
+		os.Exit(runTestROM(*testROM))
+	}
+	if *benchmark > 0 {
+		os.Exit(runBenchmark(*benchmark, flag.Arg(0)))
+	}
+	if *audit > 0 {
+		os.Exit(runAudit(*audit, flag.Arg(0)))
+	}
+
 	if *cpuprofile {
 		startProfiling()
 		defer stopProfiling()
 	}
 
+	if *service {
+		if flag.Arg(0) == "" {
+			fmt.Println("the service mode needs a ROM file argument")
+			return
+		}
+		rom, err := os.ReadFile(flag.Arg(0))
+		check(err)
+		runService(rom, os.Stdin, os.Stdout)
+		return
+	}
+
+	globalSettings = loadSettings()
+	globalAudioSettings = loadAudioSettings()
+	if *mute {
+		globalAudioSettings.Muted = true
+	}
+	if err := startAudio(globalAudioSettings); err != nil {
+		fmt.Println("starting sound output failed:", err)
+	}
+
+	if *streamAddress != "" {
+		startStreaming(*streamAddress)
+	}
+	if *remoteAddress != "" {
+		startRemoteControl(*remoteAddress)
+	}
+
 	state := newEditorState()
 	state.loadLastSpeedrun()
 	defer state.saveCurrentSpeedrun()
+	if len(state.rom) > 0 {
+		state.openStartScreen(lastSessionPath())
+	}
 
-	if len(globalROM) == 0 {
+	if len(state.rom) == 0 {
 		var err error
-		globalROM, err = getRom()
+		state.rom, err = getRom()
 		check(err)
+		state.resetForNewGame()
+		state.showROMInfo()
 	}
 
 	check(draw.RunWindow(windowTitle, 1540, 800, func(window draw.Window) {
+		defer state.recoverFromPanic()
+		acceptDroppedFiles()
 		windowW, windowH := window.Size()
 		defer func() {
 			state.lastWindowW, state.lastWindowH = windowW, windowH
 		}()
 
-		if state.isModalDialogOpen {
+		if state.fileDialog != nil {
+			state.executeFileDialogFrame(window)
+		} else if state.modal != nil {
 			state.executeModalDialogFrame(window)
+		} else if state.startScreen != nil {
+			state.executeStartScreenFrame(window)
+		} else if state.audioSettingsOpen {
+			state.executeAudioSettingsFrame(window)
+		} else if state.settingsOpen {
+			state.executeSettingsFrame(window)
+		} else if state.splitsOpen {
+			state.executeSplitsFrame(window)
+		} else if state.branchStats != nil {
+			state.executeBranchStatsFrame(window)
+		} else if state.exportOpen {
+			state.executeExportFrame(window)
 		} else {
 			state.executeMainFrame(window)
 		}
-	}))
-}
-
-func (state *editorState) executeModalDialogFrame(window draw.Window) {
-	if state.replayingGame {
-		state.executeReplayFrame(newReadOnlyWindow(window))
-	} else {
-		state.executeEditorFrame(newReadOnlyWindow(window))
-	}
-
-	for _, r := range window.Characters() {
-		if r == '\b' {
-			// Backspace deletes the last character.
-			_, size := utf8.DecodeLastRuneInString(state.dialogText)
-			state.dialogText = state.dialogText[:len(state.dialogText)-size]
-		} else if r == 127 {
-			// Control + Backspace deletes the last word.
-			letters := []rune(state.dialogText)
-			end := len(letters)
-			for end > 0 && letters[end-1] == ' ' {
-				end--
-			}
-			for end > 0 && letters[end-1] != ' ' {
-				end--
-			}
-			state.dialogText = string(letters[:end])
-		} else if r == 27 {
-			// Escape cancels the dialog.
-			state.cancelBranchRenameDialog()
-		} else if r == '\r' {
-			// Enter accepts the dialog.
-			state.acceptBranchRenameDialog()
-		} else if unicode.IsGraphic(r) {
-			// Non-control characters get appended to the text.
-			state.dialogText += string(r)
-		}
-	}
-
-	windowW, windowH := window.Size()
-	dialogW, dialogH := 500, 200
-	dialogX := (windowW - dialogW) / 2
-	dialogY := (windowH - dialogH) / 2
-
-	dialogR := rect(dialogX, dialogY, dialogW, dialogH)
-
-	dialogR.fill(window, draw.Black)
-	dialogR.inset(5).fill(window, draw.White)
 
-	const textScale = 2
-
-	title := "Enter new Branch Name"
-	titleW, titleH := window.GetScaledTextSize(title, textScale)
-	titleX := dialogX + (dialogW-titleW)/2
-	titleY := dialogY + dialogH/2 - titleH - 10
-	window.DrawScaledText(title, titleX, titleY, textScale, draw.Black)
-
-	textR := rect(dialogX+30, dialogY+dialogH/2+10, dialogW-60, titleH+10)
-	textR.fill(window, draw.Black)
-	textR.inset(3).fill(window, draw.White)
-
-	clip := textR.inset(5)
-	window.SetClipRect(clip.x, clip.y, clip.w, clip.h)
-	text := state.dialogText
-	if time.Now().Unix()%2 == 0 {
-		text += "|"
-	}
-	textW, _ := window.GetScaledTextSize(state.dialogText+"|", textScale)
-	// Draw the text left-aligned except if it gets longer than the rectangle,
-	// then draw it right-aligned so we can see the end of the text.
-	textX := clip.x - max(0, textW-clip.w)
-	window.DrawScaledText(text, textX, clip.y, textScale, draw.Black)
+		state.handleRemoteCalls()
+		state.updateVerification()
+		state.renderVerificationProgress(window)
+		state.updateDesyncCheck()
+		state.renderDesyncProgress(window)
+		state.updateOptimizer()
+		state.renderOptimizerProgress(window)
+		state.updateFuzzer()
+		state.renderFuzzerProgress(window)
+		state.updateRetimeReport()
+		state.renderRetimeReportProgress(window)
+		state.updateInputAnalysis()
+		state.renderInputAnalysisProgress(window)
+		state.updateLagAdvisor()
+		state.renderLagAdvisorProgress(window)
+		state.updateSave()
+		state.renderSaveProgress(window)
+		state.updateKeyFrameRebuild()
+		state.renderKeyFrameRebuildProgress(window)
+		state.updateSyncAnchorCheck()
+		state.updateThumbnails(window)
+		state.updateAutosave()
+		state.renderProfilingOverlay(window)
+		state.renderHelpOverlay(window)
+	}))
 }
 
 func (state *editorState) executeMainFrame(window draw.Window) {
-	if window.WasKeyPressed(draw.KeyF11) || window.WasKeyPressed(draw.KeyF) {
+	if fullscreenKeys.wasPressed(window) {
 		state.fullscreen = !state.fullscreen
 		window.SetFullscreen(state.fullscreen)
 	}
 
-	controlDown := window.IsKeyDown(draw.KeyLeftControl) || window.IsKeyDown(draw.KeyRightControl)
-
-	// When saving/loading a file, we return from the current frame,
-	// otherwise the last event from the dialog (like pressing Escape) will
-	// be forwarded to our editor. The one exception is the double-click.
-	// See the comment on waitForLeftMouseRelease.
-	if controlDown && window.WasKeyPressed(draw.KeyN) {
-		err := state.createNewSpeedrun()
-		if err != nil {
-			state.setWarning(err.Error())
-			state.render()
-		} else {
-			window.SetTitle(windowTitle)
-		}
+	// F3 starts verifying the whole run. While verifying, F3 and Escape cancel
+	// the verification. We return right away so Escape is not also handled by
+	// the editor or replay.
+	if state.verification != nil &&
+		(verifyKeys.wasPressed(window) || cancelKeys.wasPressed(window)) {
+		state.cancelVerification()
+		state.setInfo("Verification cancelled.")
+		state.render()
+		return
+	}
+	if verifyKeys.wasPressed(window) {
+		state.startVerification()
+	}
+	if profilingKeys.wasPressed(window) {
+		state.showProfiling = !state.showProfiling
+	}
+	if state.desyncCheck != nil && cancelKeys.wasPressed(window) {
+		state.cancelDesyncCheck()
+		state.setInfo("Desync check cancelled.")
+		state.render()
+		return
+	}
+	if state.optimizer != nil && cancelKeys.wasPressed(window) {
+		state.stopOptimizer()
+		return
+	}
+	if state.fuzzer != nil && cancelKeys.wasPressed(window) {
+		state.stopFuzzer()
+		return
+	}
+	if state.inputAnalysis != nil && cancelKeys.wasPressed(window) {
+		state.cancelInputAnalysis()
+		state.setInfo("Input analysis cancelled.")
+		state.render()
+		return
+	}
+	if state.lagAdvisor != nil && cancelKeys.wasPressed(window) {
+		state.cancelLagAdvisor()
+		state.setInfo("Lag advisor cancelled.")
 		state.render()
-		state.waitForLeftMouseRelease = true
 		return
 	}
-	if controlDown && window.WasKeyPressed(draw.KeyS) {
+	if state.retimeReport != nil && cancelKeys.wasPressed(window) {
+		state.cancelRetimeReport()
+		state.setInfo("Retime report cancelled.")
+		state.render()
+		return
+	}
+	if state.saving != nil && cancelKeys.wasPressed(window) {
+		state.cancelSave()
+		return
+	}
+
+	// F1 in the replay goes back to the editor, see below, only the editor
+	// shows the help.
+	if state.showHelp && cancelKeys.wasPressed(window) {
+		state.showHelp = false
+		return
+	}
+	if !state.replayingGame && helpKeys.wasPressed(window) {
+		state.showHelp = !state.showHelp
+	}
+
+	// The file dialogs run in the background, see file_dialog.go. We return
+	// from the current frame so the editor does not react to the same keys.
+	if newSpeedrunKeys.wasPressed(window) {
+		state.createNewSpeedrun(window)
+		return
+	}
+	if saveKeys.wasPressed(window) {
 		err := state.saveFile()
 		if err != nil {
 			state.setWarning(err.Error())
 			state.render()
 		}
-		state.waitForLeftMouseRelease = true
 		return
 	}
-	if controlDown && window.WasKeyPressed(draw.KeyO) {
-		path, err := state.openFile()
-		if err != nil {
-			state.setWarning(err.Error())
-		} else {
-			window.SetTitle(windowTitle + " - " + path)
+	if liveRecordKeys.wasPressed(window) {
+		state.startLiveRecording()
+		return
+	}
+	if muteKeys.wasPressed(window) {
+		state.toggleMute()
+		return
+	}
+	if sessionInfoKeys.wasPressed(window) {
+		state.showSessionInfo()
+		return
+	}
+	if watchpointKeys.wasPressed(window) {
+		state.startModalWatchpointDialog()
+		return
+	}
+	if openKeys.wasPressed(window) {
+		state.openFile(window)
+		return
+	}
+	if startScreenKeys.wasPressed(window) {
+		state.openStartScreen("")
+		if state.startScreen == nil {
+			state.setInfo("There are no recent games or sessions yet.")
 		}
 		state.render()
-		state.waitForLeftMouseRelease = true
+		return
+	}
+	if path := takeDroppedFile(); path != "" {
+		state.openDroppedFile(window, path)
+		state.render()
 		return
 	}
 
 	// Escape goes back to the last editor view.
 	// F1 goes to the editor at the current replay position.
-	esc := window.WasKeyPressed(draw.KeyEscape)
-	f1 := window.WasKeyPressed(draw.KeyF1)
+	esc := stopReplayKeys.wasPressed(window)
+	f1 := editorAtFrameKeys.wasPressed(window)
 	goToEditor := state.replayingGame && (esc || f1)
 	if goToEditor {
 		state.replayingGame = false
 		state.lastReplayPaused = state.replayPaused
 
-		if f1 {
+		if state.recording {
+			// Show the frames we just recorded.
+			state.stopRecording()
+			state.recordSeek()
+			state.leftMostFrame = state.lastReplayedFrame
+		} else if f1 {
+			state.recordSeek()
 			state.leftMostFrame = state.lastReplayedFrame
 		}
 
 		state.resetInfoText()
-		muteSound()
 		state.render()
 	}
 
-	goToGameReplay := !state.replayingGame && window.WasKeyPressed(draw.KeySpace)
+	goToGameReplay := !state.replayingGame && startReplayKeys.wasPressed(window)
 	if goToGameReplay {
 		state.replayingGame = true
 
@@ -307,40 +411,54 @@ func newEditorState() *editorState {
 		dragStartFrame:          -1,
 		frameCache:              newFrameCache(),
 		pendingDoubleClickFrame: -1,
+		emulatingFrame:          -1,
+		gameboyOptions:          defaultGameboyOptions,
 		draggingFrameIndex:      -1,
-		infoTextColor:           draw.White,
+		draggingBranch:          -1,
 		screenDirty:             true,
+		replaySpeedIndex:        normalReplaySpeed,
+		customPalette:           dmgPalettes[0].colors,
+		paletteIndex:            int(globalSettings.Palette),
 	}
 }
 
 type editorState struct {
+	// rom is the cartridge data of the game that the session is played on.
+	rom []byte
+	// gameboyOptions are used for every Gameboy that we emulate in the
+	// session.
+	gameboyOptions gameboy.GameboyOptions
+
 	leftMostFrame   int
 	activeSelection frameSelection
 	branches        []branch
 	branchIndex     int
 	// keyFrameStates are the states at every keyFrameInterval-th frame. The
 	// very first item in keyFrameStates is for frame 0.
-	keyFrameStates []Gameboy
+	keyFrameStates []keyFrame
 	scaleFactor    float64
 
-	frameCache          *frameCache
-	singleScreenBuffer  [4 * ScreenWidth * ScreenHeight]byte
-	gameboyScreenBuffer []byte
-	// We generate Gameboy screens to be display in our editor.
-	// screenBuffer is a temporary buffer that we reuse in every frame.
-	screenBuffer []gameboyScreen
-	screenDirty  bool
-	lastWindowW  int
-	lastWindowH  int
-	fullscreen   bool
-	// waitForLeftMouseRelease is a hack to fix an issue after opening a load or
-	// save dialog. Double clicking a file in those dialogs will trigger on the
-	// second time the mouse button goes down. It will thus still be down when
-	// we get back to our editor. This means window.IsMouseDown(draw.LeftButton)
-	// will be true after that double-click, resulting in an unwanted selection
-	// in the editor. This flag tells the editor to wait for a mouse up event
-	// before accepting a new mouse down event.
-	waitForLeftMouseRelease bool
+	frameCache *frameCache
+	// workingAreaStates are saved with the session, see cached_states.go.
+	// They are only set in a sessionSnapshot.
+	workingAreaStates []cachedState
+	// singleScreenBuffer is the RGBA replay screen. uploadedScreen is the
+	// one in the "gameboyScreen" image, we only upload a changed screen.
+	// SetImagePixels swaps the red and blue bytes of what it is given, it
+	// gets screenUploadBuffer.
+	singleScreenBuffer [4 * gameboy.ScreenWidth * gameboy.ScreenHeight]byte
+	uploadedScreen     [4 * gameboy.ScreenWidth * gameboy.ScreenHeight]byte
+	screenUploadBuffer [4 * gameboy.ScreenWidth * gameboy.ScreenHeight]byte
+	// lostImages remembers for each view that the window lost its images,
+	// see imagesLost.
+	lostImages [imageViewCount]bool
+	// screenTextures holds the Gameboy screens that we display in the grid,
+	// see updateScreenTiles.
+	screenTextures screenTextures
+	screenDirty    bool
+	lastWindowW    int
+	lastWindowH    int
+	fullscreen     bool
 
 	// dragStart... are for dragging frame inputs.
 	dragStartFrame     int
@@ -354,34 +472,202 @@ type editorState struct {
 	// draggingFrameIndex is for moving the current position in time (the
 	// left-most visible frame). It is NOT for dragging inputs.
 	draggingFrameIndex int
-	lastLeftClick      mouseClick
-	lastAction         inputAction
+	// draggingBranch is the index of the branch that is being dragged to a
+	// new position in the branch list, or -1.
+	draggingBranch int
+	lastLeftClick  mouseClick
+	lastAction     inputAction
+	// lastActionReleased holds, for each frame of lastAction, the opposing
+	// directions that the combo policy released when doing it.
+	lastActionReleased []inputState
+
+	// previewPanes show pinned frames below the grid, see preview_panes.go.
+	previewPanes []previewPane
+	// navigationHistory is for going back and forward through large seeks,
+	// see navigation_history.go.
+	navigationHistory navigationHistory
+
+	// gridLineFrames and gridFrames are the layout of the editor grid when
+	// it was last shown, see grid_layout.go.
+	gridLineFrames, gridFrames int
 
 	// We can toggle between the editor which freezes time and shows multiple
 	// frames at once and running the emulator which replays the game in
 	// real-time using our edited inputs.
 	replayingGame     bool
 	replayPaused      bool
+	loopReplay        bool
 	lastReplayPaused  bool
 	lastReplayedFrame int
-	isModalDialogOpen bool
+	audioSettingsOpen bool
+	settingsOpen      bool
+	splitsOpen        bool
+	exportOpen        bool
+	// reference is nil if we do not compare against a reference run.
+	reference *referenceRun
+	// replaySpeedIndex is the index into replaySpeeds.
+	replaySpeedIndex int
+	// replayFrameProgress accumulates fractions of frames for replay speeds
+	// below 1x.
+	replayFrameProgress float64
+	replayAudio         []byte
+	// recording is set while the replay takes the user's live inputs instead
+	// of playing back the stored ones.
+	recording bool
+	// showScopes toggles the oscilloscope views of the sound channels in
+	// replay mode. scopeSamples are the latest samples of each channel.
+	showScopes   bool
+	scopeSamples [4][]byte
+	// onionSkin blends the previous and next frames into the selected frame.
+	onionSkin bool
+	// hideTrackedValues hides the values of the game's stateTracker in the
+	// grid, see state_trackers.go.
+	hideTrackedValues bool
+	// hideFrameHeader hides the header above the grid, headerFrame is the
+	// frame that it showed last, see frame_header.go.
+	hideFrameHeader bool
+	headerFrame     int
+	// showProfiling toggles the overlay with the profiling stats.
+	showProfiling bool
+	// showHelp toggles the overlay with all keyBindings.
+	showHelp  bool
+	profiling profilingStats
+	// magnifierIndex is the index into magnifierZooms.
+	magnifierIndex         int
+	lastMouseX, lastMouseY int
+	// displayFilters are applied to the screen in replay mode.
+	displayFilters displayFilters
+
+	// reversePlayback buffers frames while Left plays the replay backwards,
+	// see replay_reverse.go.
+	reversePlayback *reversePlayback
+
+	// verification is non-nil while we re-emulate the run in the background
+	// to check it against our stored states.
+	verification *verification
+	// desyncCheck is non-nil while we compare the run on another revision of
+	// the game.
+	desyncCheck *desyncCheck
+	// optimizer is non-nil while the optimizer searches for better inputs,
+	// see optimizer.go.
+	optimizer *optimizer
+	// fuzzer is non-nil while the fuzzer hunts for glitches, see fuzzer.go.
+	fuzzer *fuzzer
+	// retimeReport is non-nil while the lag frames for a report are counted,
+	// see retime_report.go.
+	retimeReport *retimeReport
+	// inputAnalysis is non-nil while dead inputs are searched and deadInputs
+	// is its last result, see input_analysis.go.
+	inputAnalysis *inputAnalysis
+	deadInputs    deadInputs
+	// lagAdvisor is non-nil while presses are delayed to find less lag, see
+	// lag_advisor.go.
+	lagAdvisor *lagAdvisor
+	// syncAnchorCheck is non-nil while the sync anchors are checked after an
+	// edit. recheckAnchors starts a new check from recheckAnchorsFrom in the
+	// next UI frame, see sync_anchors.go.
+	syncAnchorCheck    *syncAnchorCheck
+	recheckAnchors     bool
+	recheckAnchorsFrom int
+	// saving is non-nil while a session file is written in the background.
+	saving *sessionSave
+	// unsavedChanges is set when the inputs change and cleared when the user
+	// saves the session or it is replaced by another one.
+	unsavedChanges bool
+	// keyFrameRebuild is non-nil while key frames of a loaded session are
+	// re-created in the background.
+	keyFrameRebuild *keyFrameRebuild
+	// lastAutosave is when the session was last saved automatically, see
+	// updateAutosave.
+	lastAutosave time.Time
+	// thumbnails is non-nil while visible frames are emulated in the
+	// background, see updateScreenTiles.
+	thumbnails *thumbnailWorker
 
 	infoText      string
-	infoTextColor draw.Color
-	dialogText    string
+	infoIsWarning bool
+	// modal is the open modal dialog or nil.
+	modal *modalDialog
+	// startScreen lists the recent games and sessions, see start_screen.go.
+	// It is nil while closed.
+	startScreen *startScreen
+	// panicDialog is the message about the last panic, see crash.go.
+	panicDialog *modalDialog
+	// emulatingFrame is the frame that updateGameboy is emulating, -1 while
+	// it is not emulating. A panic message names it.
+	emulatingFrame int
+	// fileDialog is the open native file dialog or nil, see file_dialog.go.
+	fileDialog *fileDialog
+	// branchStats is non-nil while the branch statistics are shown.
+	branchStats *branchStats
+	// watchpoint pauses the replay when the game writes to an address, it is
+	// nil if there is none.
+	watchpoint *watchpoint
+	// splitNameTemplate are the names of the splits of the last session of
+	// the game, see game_profiles.go.
+	splitNameTemplate []string
+	// ramMap names the game's memory addresses, see ram_map.go.
+	ramMap ramMap
+	// snapshots are named copies of branch inputs, lastSnapshot is the name
+	// of the one that was saved or swapped last, see snapshots.go.
+	snapshots    []inputSnapshot
+	lastSnapshot string
+	// lastInputSearch is the last pattern that was searched for, see
+	// input_search.go.
+	lastInputSearch string
+	// edit groups changes of the inputs into one, see edit_transaction.go.
+	edit editTransaction
+	// chordMode applies held buttons together with Enter, see chords.go.
+	chordMode bool
+	// repeatEntry is the number in the side menu that the editor keys repeat
+	// by, see repeat_entry.go.
+	repeatEntry repeatEntry
+
+	metadata    sessionMetadata
+	comboPolicy comboPolicy
+	pollCadence pollCadence
+	// start is what the session starts from instead of power on, see
+	// session_start.go.
+	start sessionStart
+	// paletteIndex selects one of dmgPalettes or, if it is customPaletteIndex,
+	// customPalette.
+	paletteIndex  int
+	customPalette dmgPalette
 }
 
 type branch struct {
 	name                string
 	frameInputs         []inputState // Holds the state of all the Gameboy buttons for each frame.
-	defaultInputs       inputState   // Button states for future frames that are not yet generated.
+	defaultInputs       inputState   // Button states for future frames that are not yet generated, see default_inputs.go.
 	highlightFrameIndex int
+	// splits are sorted by frame index.
+	splits []split
+	// color is an index into branchColors.
+	color int
+	// locked branches cannot be edited, see branchLocked.
+	locked bool
+	// subframeInputs change the buttons between the joypad reads of a frame,
+	// keyed by the frame index, see subframe_inputs.go.
+	subframeInputs map[int][]subframeInput
+	// anchors are sorted by frame index, see sync_anchors.go.
+	anchors []syncAnchor
 }
 
 func (s *editorState) branch() *branch {
 	return &s.branches[s.branchIndex]
 }
 
+// branchLocked returns true and warns the user if the active branch is
+// locked. Everything that edits the inputs checks it first.
+func (s *editorState) branchLocked() bool {
+	if !s.branch().locked {
+		return false
+	}
+	s.setWarning(fmt.Sprintf("\"%s\" is locked, unlock it to edit its inputs.", s.branch().name))
+	s.render()
+	return true
+}
+
 func (s *editorState) inputsAt(frameIndex int) inputState {
 	s.createInputsUpTo(frameIndex)
 	return s.branch().frameInputs[frameIndex]
@@ -395,6 +681,18 @@ func (s *editorState) createInputsUpTo(frameIndex int) {
 }
 
 func (s *editorState) resetForNewGame() {
+	s.cancelVerification()
+	s.cancelDesyncCheck()
+	s.cancelOptimizer()
+	s.cancelFuzzer()
+	s.cancelRetimeReport()
+	s.cancelInputAnalysis()
+	s.deadInputs = deadInputs{}
+	s.cancelLagAdvisor()
+	s.cancelKeyFrameRebuild()
+	s.cancelSyncAnchorCheck()
+	s.recheckAnchors = false
+	s.cancelThumbnails()
 	s.leftMostFrame = 0
 	s.activeSelection = frameSelection{}
 	for i := range s.branches {
@@ -406,10 +704,20 @@ func (s *editorState) resetForNewGame() {
 	s.branches[0].name = "Branch 1"
 	s.branches[0].frameInputs = s.branches[0].frameInputs[:0]
 	s.branches[0].highlightFrameIndex = -1
+	s.branches[0].splits = nil
+	s.branches[0].color = 0
+	s.branches[0].locked = false
+	s.branches[0].subframeInputs = nil
+	s.branches[0].anchors = nil
+	s.watchpoint = nil
+	s.ramMap = nil
+	s.snapshots = nil
+	s.lastSnapshot = ""
+	s.previewPanes = nil
+	s.navigationHistory = navigationHistory{}
 	s.keyFrameStates = s.keyFrameStates[:0]
 	s.frameCache.clear()
-	s.gameboyScreenBuffer = s.gameboyScreenBuffer[:0]
-	s.screenBuffer = s.screenBuffer[:0]
+	s.invalidateScreenTilesFrom(0)
 	s.screenDirty = true
 	s.dragStartFrame = -1
 	s.dragStartSelection = frameSelection{}
@@ -419,23 +727,34 @@ func (s *editorState) resetForNewGame() {
 	s.controlWasDown = false
 	s.keyRepeatCountdown = 0
 	s.draggingFrameIndex = -1
+	s.draggingBranch = -1
 	s.lastLeftClick = mouseClick{}
 	s.lastAction = inputAction{}
+	s.lastActionReleased = nil
 	s.replayingGame = false
 	s.replayPaused = false
 	s.lastReplayPaused = false
 	s.lastReplayedFrame = -1
 	s.infoText = ""
+	s.metadata = newSessionMetadata(s.rom)
+	s.pollCadence = pollCadence{}
+	s.start = sessionStart{}
+	s.reference = nil
+	s.paletteIndex = int(globalSettings.Palette)
+	s.splitNameTemplate = nil
+	s.applyGameProfile()
+	s.unsavedChanges = false
+	s.gameboyOptions.Model = gameboy.DefaultConsoleModel(s.rom)
 }
 
 func (s *editorState) setInfo(msg string) {
 	s.infoText = msg
-	s.infoTextColor = draw.RGBA(1, 1, 1, 1)
+	s.infoIsWarning = false
 }
 
 func (s *editorState) setWarning(msg string) {
 	s.infoText = msg
-	s.infoTextColor = draw.RGBA(1, 92/255.0, 92/255.0, 1)
+	s.infoIsWarning = true
 }
 
 func (s *editorState) resetInfoText() {
@@ -443,24 +762,35 @@ func (s *editorState) resetInfoText() {
 }
 
 func (s *editorState) render() {
+	if s.edit.depth > 0 {
+		s.edit.render = true
+		return
+	}
 	s.screenDirty = true
 }
 
-func (s *editorState) updateGameboy(gameboy *Gameboy, frameIndex int) {
-	inputs := s.inputsAt(frameIndex)
+func (s *editorState) updateGameboy(gb *gameboy.Gameboy, frameIndex int) {
+	start := time.Now()
+	applyInputs(gb, s.inputsAt(frameIndex))
+	setPollInputs(gb, s.branch().subframeInputs[frameIndex])
+	s.emulatingFrame = frameIndex
+	gb.Step()
+	s.emulatingFrame = -1
+	s.profiling.current.emulatedFrames++
+	s.profiling.current.emulationTime += time.Since(start)
+}
 
-	for b := range buttonCount {
-		if isButtonDown(inputs, b) {
-			gameboy.PressButton(b)
-		} else {
-			gameboy.ReleaseButton(b)
-		}
+// applyInputs presses and releases the Gameboy's buttons to match inputs.
+func applyInputs(gb *gameboy.Gameboy, inputs inputState) {
+	if inputs&powerCycleEvent != 0 {
+		gb.PowerCycle()
+	} else if inputs&resetEvent != 0 {
+		gb.Reset()
 	}
-
-	gameboy.Update()
+	gb.SetButtons(byte(inputs &^ frameEvents))
 }
 
-func (s *editorState) generateFrame(frameIndex int) Gameboy {
+func (s *editorState) generateFrame(frameIndex int) gameboy.Gameboy {
 	// There are three possible scenarios:
 	//
 	// 1. No frame up to frameIndex is cached, so we have to go from the latest
@@ -478,6 +808,11 @@ func (s *editorState) generateFrame(frameIndex int) Gameboy {
 
 	gb, currentIndex := s.frameCache.latestFrameUpTo(frameIndex)
 
+	s.profiling.current.frameRequests++
+	if currentIndex == frameIndex || latestKeyFrame == frameIndex {
+		s.profiling.current.cacheHits++
+	}
+
 	if currentIndex != -1 && currentIndex >= latestKeyFrame {
 		// Scenario 2: emulate forward from the cached frame.
 		for currentIndex < frameIndex {
@@ -486,7 +821,7 @@ func (s *editorState) generateFrame(frameIndex int) Gameboy {
 			s.frameCache.set(currentIndex, gb)
 			if currentIndex%keyFrameInterval == 0 &&
 				currentIndex/keyFrameInterval == len(s.keyFrameStates) {
-				s.keyFrameStates = append(s.keyFrameStates, gb)
+				s.addKeyFrame(gb)
 			}
 		}
 		return gb
@@ -501,21 +836,21 @@ func (s *editorState) generateFrame(frameIndex int) Gameboy {
 		last := len(s.keyFrameStates) - 1
 
 		if last == -1 {
-			gb := NewGameboy(globalROM, GameboyOptions{})
+			gb := s.startGameboy(s.gameboyOptions)
 			s.updateGameboy(&gb, 0)
-			s.keyFrameStates = append(s.keyFrameStates, gb)
+			s.addKeyFrame(gb)
 		} else {
-			gb := s.keyFrameStates[last]
+			gb := s.keyFrameStates[last].gameboy()
 			for i := range keyFrameInterval {
 				s.updateGameboy(&gb, last*keyFrameInterval+i+1)
 			}
-			s.keyFrameStates = append(s.keyFrameStates, gb)
+			s.addKeyFrame(gb)
 		}
 	}
 
 	// Now the key frame we need exists. We start from there, create frames up
 	// to where we want to go, while putting those frames in the cache as well.
-	gb = s.keyFrameStates[keyFrameIndex]
+	gb = s.keyFrameStates[keyFrameIndex].gameboy()
 
 	// Emulate frames until we reach our destination.
 	currentIndex = keyFrameIndex * keyFrameInterval
@@ -527,7 +862,7 @@ func (s *editorState) generateFrame(frameIndex int) Gameboy {
 		s.frameCache.set(currentIndex, gb)
 		if currentIndex%keyFrameInterval == 0 &&
 			currentIndex/keyFrameInterval == len(s.keyFrameStates) {
-			s.keyFrameStates = append(s.keyFrameStates, gb)
+			s.addKeyFrame(gb)
 		}
 	}
 
@@ -548,133 +883,171 @@ func (s *editorState) setDirtyFrame(frameIndex int) {
 	//         200 | 2
 	//         201 | 3
 	//
+	s.beginEdit()
+	s.deferDirtyFrame(frameIndex)
+	s.endEdit()
+}
+
+// invalidateFramesFrom throws away all emulated states starting at frameIndex.
+// Unlike an edit, it does not count as a rerecord.
+func (s *editorState) invalidateFramesFrom(frameIndex int) {
 	keep := (frameIndex + keyFrameInterval - 1) / keyFrameInterval
 	if keep < len(s.keyFrameStates) {
 		s.keyFrameStates = s.keyFrameStates[:keep]
 	}
 
 	s.frameCache.removeFramesStartingAt(frameIndex)
+	s.invalidateScreenTilesFrom(frameIndex)
+	s.reversePlayback = nil
+	s.cancelThumbnails()
+	s.deadInputs = deadInputs{}
+	s.recheckSyncAnchorsFrom(frameIndex)
+	s.unsavedChanges = true
+
+	if s.keyFrameRebuild != nil {
+		// Continue from the last key frame that is still valid.
+		s.rebuildMissingKeyFrames()
+	}
+
+	if s.verification != nil {
+		s.cancelVerification()
+		s.setWarning("Verification cancelled because the inputs changed.")
+	}
+}
+
+// toggleFrameEvent adds the event to or removes it from the first selected
+// frame.
+func (s *editorState) toggleFrameEvent(event inputState) {
+	if s.branchLocked() {
+		return
+	}
+	frameIndex := s.activeSelection.start()
+	s.createInputsUpTo(frameIndex)
+	s.branch().frameInputs[frameIndex] ^= event
+	s.setDirtyFrame(frameIndex)
+	s.render()
 }
 
 func (s *editorState) setInputsRange(firstFrameIndex, lastFrameIndex int, setTo inputState) {
+	if s.branchLocked() {
+		return
+	}
 	s.createInputsUpTo(lastFrameIndex)
 
 	b := s.branch()
 	for i := firstFrameIndex; i <= lastFrameIndex; i++ {
 		b.frameInputs[i] = setTo
 	}
+	s.applyComboPolicyToButtons(firstFrameIndex, lastFrameIndex-firstFrameIndex+1, setTo)
 
 	s.setDirtyFrame(firstFrameIndex)
 }
 
-func (s *editorState) toggleButton(frameIndex int, button Button) {
+func (s *editorState) toggleButton(frameIndex int, button gameboy.Button) {
+	if s.branchLocked() {
+		return
+	}
 	s.createInputsUpTo(frameIndex)
-	toggleButton(&s.branch().frameInputs[frameIndex], button)
+	inputs := &s.branch().frameInputs[frameIndex]
+	toggleButton(inputs, button)
+	if isButtonDown(*inputs, button) {
+		s.applyComboPolicy(frameIndex, 1, button)
+	}
 	s.setDirtyFrame(frameIndex)
 }
 
-func (s *editorState) isButtonDown(frameIndex int, button Button) bool {
+func (s *editorState) isButtonDown(frameIndex int, button gameboy.Button) bool {
 	return isButtonDown(s.inputsAt(frameIndex), button)
 }
 
-func (s *editorState) setButtonDown(frameIndex, count int, button Button, down bool) {
+// setButtonsDown presses or releases all the buttons in count frames. It
+// counts as a single edit.
+func (s *editorState) setButtonsDown(frameIndex, count int, buttons inputState, down bool) {
 	s.createInputsUpTo(frameIndex + count - 1)
 
 	b := s.branch()
 	for i := range count {
-		setButtonDown(&b.frameInputs[frameIndex+i], button, down)
+		if down {
+			b.frameInputs[frameIndex+i] |= buttons
+		} else {
+			b.frameInputs[frameIndex+i] &^= buttons
+		}
+	}
+	if down {
+		s.applyComboPolicyToButtons(frameIndex, count, buttons)
 	}
 
 	s.setDirtyFrame(frameIndex)
 }
 
-func (state *editorState) executeReplayFrame(window draw.Window) {
-	windowW, windowH := window.Size()
-
-	window.BlurImages(false)
+// doAction presses or releases the action's buttons and makes it the last
+// action. It remembers the opposing directions that the combo policy
+// released, so undoLastAction can press them again.
+func (s *editorState) doAction(a inputAction) {
+	s.createInputsUpTo(a.frameIndex + a.count - 1)
+	frames := s.branch().frameInputs[a.frameIndex:][:a.count]
+	before := slices.Clone(frames)
+	s.setButtonsDown(a.frameIndex, a.count, a.buttons, a.down)
 
-	if window.WasKeyPressed(draw.KeySpace) {
-		state.replayPaused = !state.replayPaused
-		if state.replayPaused {
-			muteSound()
-		} else {
-			unmuteSound()
-		}
+	s.lastActionReleased = s.lastActionReleased[:0]
+	for i := range frames {
+		s.lastActionReleased = append(s.lastActionReleased, before[i]&^frames[i]&^a.buttons)
 	}
+	a.valid = true
+	s.lastAction = a
+}
 
-	if window.WasKeyPressed(draw.KeyF3) {
-		state.checkFrames(state.lastReplayedFrame)
+// undoLastAction reverts the buttons of the last action, including the
+// opposing directions that it released.
+func (s *editorState) undoLastAction() {
+	a := s.lastAction
+	s.setButtonsDown(a.frameIndex, a.count, a.buttons, !a.down)
+	b := s.branch()
+	for i, released := range s.lastActionReleased {
+		b.frameInputs[a.frameIndex+i] |= released
 	}
+}
 
-	if window.WasKeyPressed(draw.KeyH) {
-		if state.branch().highlightFrameIndex == state.lastReplayedFrame {
-			state.branch().highlightFrameIndex = -1
-		} else {
-			state.branch().highlightFrameIndex = state.lastReplayedFrame
-		}
-	}
+func (state *editorState) executeReplayFrame(window draw.Window) {
+	windowW, windowH := window.Size()
 
-	// Let the user toggle buttons for the current frame.
-	for key, b := range keyMap {
-		if window.WasKeyPressed(key) {
-			state.toggleButton(state.lastReplayedFrame, b)
-		}
-	}
+	window.BlurImages(false)
 
-	// When replay is paused, we use a key repeat counter to skip through single
-	// frames in stop-motion.
-	// While replaying (non-paused) simply holding down a key will change the
-	// speed.
-	state.keyRepeatCountdown--
-	keyTriggered := func(key draw.Key) bool {
-		if state.replayPaused {
-			if window.WasKeyPressed(key) ||
-				window.IsKeyDown(key) && state.keyRepeatCountdown <= 0 {
-				state.keyRepeatCountdown = 10
-				return true
-			}
-			return false
-		} else {
-			return window.IsKeyDown(key)
-		}
+	if overwriteKeys.wasPressed(window) {
+		state.toggleOverwriteRecording()
 	}
+	state.handleDisplayFilterKeys(window)
 
-	// Handle keys to accelerate/decelerate the playback.
-	nextFrameIndex := state.lastReplayedFrame + 1
-
-	if state.replayPaused {
-		nextFrameIndex = state.lastReplayedFrame
+	if state.recording && state.branch().locked {
+		// The user switched to a locked branch while recording.
+		state.stopRecording()
+		state.branchLocked()
 	}
 
-	if window.WasKeyPressed(draw.KeyHome) {
-		nextFrameIndex = 0
-	} else if keyTriggered(draw.KeyLeft) {
-		nextFrameIndex = max(0, state.lastReplayedFrame-1)
-	} else if keyTriggered(draw.KeyUp) {
-		nextFrameIndex = max(0, state.lastReplayedFrame-5)
-	} else if keyTriggered(draw.KeyPageUp) {
-		nextFrameIndex = max(0, state.lastReplayedFrame-20)
-	} else if keyTriggered(draw.KeyRight) {
-		if state.replayPaused {
-			nextFrameIndex = state.lastReplayedFrame + 1
-		} else {
-			nextFrameIndex = state.lastReplayedFrame + 2
-		}
-	} else if keyTriggered(draw.KeyDown) {
-		nextFrameIndex = state.lastReplayedFrame + 5
-	} else if keyTriggered(draw.KeyPageDown) {
-		nextFrameIndex = state.lastReplayedFrame + 20
+	var gb gameboy.Gameboy
+	if state.recording {
+		gb = state.recordFrame(window)
+	} else {
+		gb = state.controlReplay(window)
+	}
+	if globalSettings.EditorFollowsReplay {
+		state.followReplay()
 	}
-
-	gb := state.generateFrame(nextFrameIndex)
-	state.lastReplayedFrame = nextFrameIndex
 
 	// Render the current screen.
-	window.CreateImage("gameboyScreen", ScreenWidth, ScreenHeight)
+	screen := gb.PreparedData
+	state.applyPalette(&screen, &gb)
+	streamReplayFrame(&screen, state.inputsAt(state.lastReplayedFrame))
 	i := 0
-	for y := range ScreenHeight {
-		for x := range ScreenWidth {
-			color := gb.PreparedData[x][y]
+	for y := range gameboy.ScreenHeight {
+		for x := range gameboy.ScreenWidth {
+			color := screen.Pixel(x, y)
+			if state.displayFilters.ghosting {
+				// The buffer still holds the last displayed frame.
+				for c := range color {
+					color[c] = ghostPixel(state.singleScreenBuffer[i+c], color[c])
+				}
+			}
 			state.singleScreenBuffer[i+0] = color[0]
 			state.singleScreenBuffer[i+1] = color[1]
 			state.singleScreenBuffer[i+2] = color[2]
@@ -682,55 +1055,188 @@ func (state *editorState) executeReplayFrame(window draw.Window) {
 			i += 4
 		}
 	}
-	window.SetImagePixels("gameboyScreen", state.singleScreenBuffer[:])
+	// A paused replay shows the same screen in every frame.
+	if state.imagesLost(window, replayImages) || state.singleScreenBuffer != state.uploadedScreen {
+		state.uploadedScreen = state.singleScreenBuffer
+		state.screenUploadBuffer = state.singleScreenBuffer
+		window.CreateImage("gameboyScreen", gameboy.ScreenWidth, gameboy.ScreenHeight)
+		window.SetImagePixels("gameboyScreen", state.screenUploadBuffer[:])
+	}
 
-	window.FillRect(0, 0, windowW, windowH, toColor(ColorPalette[3]))
+	window.FillRect(0, 0, windowW, windowH, toColor(state.displayPalette()[3]))
+
+	// The oscilloscopes are at the bottom, below the Gameboy screen.
+	screenAreaH := windowH
+	if state.showScopes {
+		screenAreaH -= scopeHeight
+		state.renderScopes(window, rect(0, screenAreaH, windowW-inputMenuW-inputMenuMargin, scopeHeight))
+	}
 
 	// Letterbox the Gameboy screen into our window.
-	xScale := float64(windowW-inputMenuW-inputMenuMargin) / ScreenWidth
-	yScale := float64(windowH) / ScreenHeight
+	xScale := float64(windowW-inputMenuW-inputMenuMargin) / gameboy.ScreenWidth
+	yScale := float64(screenAreaH) / gameboy.ScreenHeight
 	scale := math.Min(yScale, xScale)
-	screenW := round(scale * ScreenWidth)
-	screenH := round(scale * ScreenHeight)
+	screenW := round(scale * gameboy.ScreenWidth)
+	screenH := round(scale * gameboy.ScreenHeight)
 	screenX := (windowW - inputMenuW - inputMenuMargin - screenW) / 2
-	screenY := (windowH - screenH) / 2
+	screenY := (screenAreaH - screenH) / 2
+	if gb.IsRumbling() {
+		// Shake the screen while the game runs the cartridge's rumble motor.
+		screenX += 3 * (state.lastReplayedFrame%2*2 - 1)
+	}
 	window.DrawImageFileTo("gameboyScreen", screenX, screenY, screenW, screenH, 0)
+	state.displayFilters.render(window, rect(screenX, screenY, screenW, screenH))
 	if state.lastReplayedFrame == state.branch().highlightFrameIndex {
-		window.FillRect(screenX, screenY, screenW, screenH, highlightColor)
+		window.FillRect(screenX, screenY, screenW, screenH, currentTheme().highlight)
+	}
+
+	if state.recording {
+		state.renderRecordingOverlay(window, screenX, screenY)
+	} else if w := state.watchpoint; w != nil && state.replayPaused && w.hitFrame == state.lastReplayedFrame {
+		hit := state.watchpointHitText()
+		hitW, hitH := window.GetScaledTextSize(hit, infoTextScale)
+		window.FillRect(screenX, screenY, hitW+2, hitH+2, draw.RGBA(0, 0, 0, 0.8))
+		window.DrawScaledText(hit, screenX+1, screenY+1, infoTextScale, draw.Yellow)
+	} else if !state.replayPaused {
+		speed := "Speed " + replaySpeeds[state.replaySpeedIndex].name
+		if state.loopReplay {
+			speed += fmt.Sprintf(" Loop %d-%d", state.activeSelection.start(), state.activeSelection.end()-1)
+		}
+		speedW, speedH := window.GetScaledTextSize(speed, infoTextScale)
+		window.FillRect(screenX, screenY, speedW+2, speedH+2, draw.RGBA(0, 0, 0, 0.8))
+		window.DrawScaledText(speed, screenX+1, screenY+1, infoTextScale, draw.White)
 	}
 
 	// Draw the inputs as a menu.
 	inputs := state.inputsAt(state.lastReplayedFrame)
 	inputMenuX := screenX + screenW + inputMenuMargin
 	frameNumber := fmt.Sprintf("Frame %d", state.lastReplayedFrame)
-	buttonCallback := func(button Button) {
+	buttonCallback := func(button gameboy.Button) {
 		state.toggleButton(state.lastReplayedFrame, button)
 	}
 	state.renderMenu(window, inputs, inputMenuX, frameNumber, buttonCallback)
 }
 
+// controlReplay handles the replay keys and returns the frame to display.
+func (state *editorState) controlReplay(window draw.Window) gameboy.Gameboy {
+	if pauseKeys.wasPressed(window) {
+		state.replayPaused = !state.replayPaused
+	}
+
+	if scopesKeys.wasPressed(window) {
+		state.showScopes = !state.showScopes
+	}
+
+	if loopKeys.wasPressed(window) {
+		state.toggleReplayLoop()
+	}
+
+	if replayHighlightKeys.wasPressed(window) {
+		if state.branch().highlightFrameIndex == state.lastReplayedFrame {
+			state.branch().highlightFrameIndex = -1
+		} else {
+			state.branch().highlightFrameIndex = state.lastReplayedFrame
+		}
+	}
+
+	// Let the user toggle buttons for the current frame. Keys pressed at the
+	// same time are one edit.
+	state.beginEdit()
+	for key, b := range keyMap {
+		if window.WasKeyPressed(key) {
+			state.toggleButton(state.lastReplayedFrame, b)
+		}
+	}
+	state.endEdit()
+
+	// While replaying (non-paused), Up and Down change the replay speed and
+	// holding Left goes back in time.
+	// When replay is paused, we use a key repeat counter to skip through single
+	// frames in stop-motion.
+	if !state.replayPaused {
+		if fasterKeys.wasPressed(window) {
+			state.changeReplaySpeed(1)
+		}
+		if slowerKeys.wasPressed(window) {
+			state.changeReplaySpeed(-1)
+		}
+	}
+
+	state.keyRepeatCountdown--
+	keyTriggered := func(key draw.Key) bool {
+		if window.WasKeyPressed(key) ||
+			window.IsKeyDown(key) && state.keyRepeatCountdown <= 0 {
+			state.keyRepeatCountdown = int(globalSettings.ReplayKeyRepeat)
+			return true
+		}
+		return false
+	}
+
+	var gb gameboy.Gameboy
+	if restartKeys.wasPressed(window) {
+		state.lastReplayedFrame = 0
+		gb = state.generateFrame(0)
+	} else if state.replayPaused {
+		nextFrameIndex := state.lastReplayedFrame
+		if keyTriggered(draw.KeyLeft) {
+			nextFrameIndex = max(0, state.lastReplayedFrame-1)
+		} else if keyTriggered(draw.KeyUp) {
+			nextFrameIndex = max(0, state.lastReplayedFrame-5)
+		} else if keyTriggered(draw.KeyPageUp) {
+			nextFrameIndex = max(0, state.lastReplayedFrame-20)
+		} else if keyTriggered(draw.KeyRight) {
+			nextFrameIndex = state.lastReplayedFrame + 1
+		} else if keyTriggered(draw.KeyDown) {
+			nextFrameIndex = state.lastReplayedFrame + 5
+		} else if keyTriggered(draw.KeyPageDown) {
+			nextFrameIndex = state.lastReplayedFrame + 20
+		}
+		gb = state.generateFrame(nextFrameIndex)
+		// When stepping through single frames, we play the sound of just
+		// that frame so sound cues can be matched to their exact frame.
+		if abs(nextFrameIndex-state.lastReplayedFrame) == 1 {
+			queueAudio(gb.Sound.FrameSamples())
+		}
+		state.resetScopes(&gb)
+		state.lastReplayedFrame = nextFrameIndex
+	} else if window.IsKeyDown(draw.KeyLeft) {
+		gb = state.stepReplayBackwards()
+		state.resetScopes(&gb)
+	} else {
+		gb = state.playReplayFrames()
+	}
+
+	if !window.IsKeyDown(draw.KeyLeft) {
+		// The frames for playing backwards take a lot of memory.
+		state.reversePlayback = nil
+	}
+
+	return gb
+}
+
 func (state *editorState) renderMenu(
 	window draw.Window,
 	inputs inputState,
 	inputMenuX int,
 	frameNumber string,
-	buttonCallback func(button Button),
+	buttonCallback func(button gameboy.Button),
 ) {
 	_, windowH := window.Size()
 	mouseX, mouseY := window.MousePosition()
 	leftClick := wasLeftClicked(window)
 
 	_, baseFontHeight := window.GetTextSize("|")
-	hoverColor := draw.RGBA(0, 0.5, 0, 0.3)
+	theme := currentTheme()
+	hoverColor := theme.menuHover
 
 	// Clear the menu background.
-	window.FillRect(inputMenuX, 0, inputMenuW, windowH, rgb(224, 248, 208))
+	window.FillRect(inputMenuX, 0, inputMenuW, windowH, theme.menuBackground)
 
 	frameNumberW, frameNumberH := window.GetScaledTextSize(frameNumber, frameNumberScale)
 	frameNumberX := inputMenuX + (inputMenuW-frameNumberW)/2
-	window.DrawScaledText(frameNumber, frameNumberX, 0, frameNumberScale, draw.Black)
+	window.DrawScaledText(frameNumber, frameNumberX, 0, frameNumberScale, theme.menuText)
 
-	drawAB := func(r rectangle, text string, button Button) {
+	drawAB := func(r rectangle, text string, button gameboy.Button) {
 		textColor := draw.Gray
 		backColor := draw.DarkRed
 		if isButtonDown(inputs, button) {
@@ -772,8 +1278,8 @@ func (state *editorState) renderMenu(
 	aButtonY := frameNumberH * 3 / 2
 	bButtonY := aButtonY + abButtonSize/2
 
-	drawAB(rect(aButtonX, aButtonY, abButtonSize, abButtonSize), "A", ButtonA)
-	drawAB(rect(bButtonX, bButtonY, abButtonSize, abButtonSize), "B", ButtonB)
+	drawAB(rect(aButtonX, aButtonY, abButtonSize, abButtonSize), "A", gameboy.ButtonA)
+	drawAB(rect(bButtonX, bButtonY, abButtonSize, abButtonSize), "B", gameboy.ButtonB)
 
 	// Draw the D-Pad.
 	dpadX := inputMenuX + (inputMenuW-3*dpadButtonSize)/2
@@ -792,7 +1298,7 @@ func (state *editorState) renderMenu(
 		dpadButtonSize,
 		draw.Black,
 	)
-	drawPressedDPad := func(button Button, x, y int, text string) {
+	drawPressedDPad := func(button gameboy.Button, x, y int, text string) {
 		r := rect(x, y, dpadButtonSize, dpadButtonSize)
 		innerR := r.expand(-5)
 		outerR := r.expand(hoverMargin)
@@ -817,13 +1323,13 @@ func (state *editorState) renderMenu(
 			}
 		}
 	}
-	drawPressedDPad(ButtonLeft, dpadX, dpadY+dpadButtonSize, "L")
-	drawPressedDPad(ButtonUp, dpadX+dpadButtonSize, dpadY, "U")
-	drawPressedDPad(ButtonRight, dpadX+2*dpadButtonSize, dpadY+dpadButtonSize, "R")
-	drawPressedDPad(ButtonDown, dpadX+dpadButtonSize, dpadY+2*dpadButtonSize, "D")
+	drawPressedDPad(gameboy.ButtonLeft, dpadX, dpadY+dpadButtonSize, "L")
+	drawPressedDPad(gameboy.ButtonUp, dpadX+dpadButtonSize, dpadY, "U")
+	drawPressedDPad(gameboy.ButtonRight, dpadX+2*dpadButtonSize, dpadY+dpadButtonSize, "R")
+	drawPressedDPad(gameboy.ButtonDown, dpadX+dpadButtonSize, dpadY+2*dpadButtonSize, "D")
 
 	// Draw Start and Select buttons.
-	drawStartSelect := func(r rectangle, text string, button Button) {
+	drawStartSelect := func(r rectangle, text string, button gameboy.Button) {
 		backColor := draw.Gray
 		textColor := draw.LightGray
 		if isButtonDown(inputs, button) {
@@ -859,26 +1365,32 @@ func (state *editorState) renderMenu(
 	startButtonRect := rect(startButtonX, startButtonY, startButtonW, startButtonH)
 	selectButtonRect := rect(selectButtonX, startButtonY, startButtonW, startButtonH)
 
-	drawStartSelect(startButtonRect, "Start", ButtonStart)
-	drawStartSelect(selectButtonRect, "Select", ButtonSelect)
+	drawStartSelect(startButtonRect, "Start", gameboy.ButtonStart)
+	drawStartSelect(selectButtonRect, "Select", gameboy.ButtonSelect)
 
 	// Draw the branch menu.
 	const menuTextScale = 1.5
 
 	y := selectButtonRect.y + selectButtonRect.h + 10
 
+	if !state.replayingGame {
+		y += state.renderRepeatEntry(window, inputMenuX, y, menuTextScale) + 4
+		y += state.renderDefaultInputs(window, inputMenuX, y) + 6
+		y += state.renderChordMode(window, inputMenuX, y)
+	}
+
 	button := func(text string) bool {
 		textW, textH := window.GetScaledTextSize(text, menuTextScale)
 		newBranchButton := rect(0, y, textW+20, textH+10)
 		newBranchButton.x = inputMenuX + (inputMenuW-newBranchButton.w)/2
-		color := draw.LightPurple
+		color := theme.button
 		if newBranchButton.contains(mouseX, mouseY) {
-			color = draw.Purple
+			color = theme.buttonHover
 		}
 		newBranchButton.fill(window, color)
 		textX := newBranchButton.x + (newBranchButton.w-textW)/2
 		textY := newBranchButton.y + (newBranchButton.h-textH)/2
-		window.DrawScaledText(text, textX, textY, menuTextScale, draw.Black)
+		window.DrawScaledText(text, textX, textY, menuTextScale, theme.menuText)
 
 		y += newBranchButton.h + 2
 
@@ -892,64 +1404,156 @@ func (state *editorState) renderMenu(
 			frameInputs:         slices.Clone(b.frameInputs),
 			defaultInputs:       b.defaultInputs,
 			highlightFrameIndex: b.highlightFrameIndex,
+			splits:              slices.Clone(b.splits),
+			color:               b.color,
+			subframeInputs:      cloneSubframeInputs(b.subframeInputs),
+			anchors:             slices.Clone(b.anchors),
 		})
 		state.branchIndex = len(state.branches) - 1
 	}
 
-	if button("Rename Branch") || window.WasKeyPressed(draw.KeyF2) {
+	if button("Rename Branch") || renameKeys.wasPressed(window) {
 		state.startModalBranchRenameDialog()
 	}
 
-	if len(state.branches) > 1 && button("Delete Branch") {
-		skipConfirmation := false
+	if button("Color: " + branchColors[state.branch().color].name) {
+		state.cycleBranchColor()
+	}
 
-		// If the current branch is an exact copy of another branch, we delete
-		// it without asking confirmation because no progress is really lost.
-		for i := range state.branches {
-			if i != state.branchIndex &&
-				equalBranches(state.branches[i], state.branches[state.branchIndex]) {
-				skipConfirmation = true
-			}
-		}
+	lockText := "Lock Branch"
+	if state.branch().locked {
+		lockText = "Unlock Branch"
+	}
+	if button(lockText) {
+		state.toggleBranchLock()
+	}
 
-		msg := fmt.Sprintf("Do you really want to delete \"%s\"?", state.branch().name)
+	if button("Set Author") {
+		state.startModalAuthorDialog()
+	}
 
-		if skipConfirmation || dialog.Message(msg).YesNo() {
-			del := state.branchIndex
+	if button("Combos: " + state.comboPolicy.String()) {
+		state.cycleComboPolicy()
+	}
 
-			if del == 0 {
-				state.switchToBranch(1)
-			} else {
-				state.switchToBranch(del - 1)
-			}
+	if button("Cadence: " + state.pollCadence.String()) {
+		state.startModalPollCadenceDialog()
+	}
 
-			state.branches = slices.Delete(state.branches, del, del+1)
-			state.branchIndex = max(0, del-1)
-		}
+	if button("Model: " + state.gameboyOptions.Model.String()) {
+		state.cycleConsoleModel()
 	}
 
-	minHighlight := -1
-	for _, b := range state.branches {
-		h := b.highlightFrameIndex
-		if minHighlight == -1 || (h >= 0 && h < minHighlight) {
-			minHighlight = h
-		}
+	if button("Palette: " + state.paletteName()) {
+		state.cyclePalette()
 	}
 
-	for i, b := range state.branches {
-		name := b.name
-		if i == state.branchIndex {
-			name = ">" + name + "<"
-		}
-		textW, textH := window.GetScaledTextSize(name, menuTextScale)
-		textX := inputMenuX + (inputMenuW-textW)/2
-		color := draw.Black
-		branchBounds := rect(textX, y, textW, textH)
-		if branchBounds.contains(mouseX, mouseY) {
-			color = draw.Gray
-		}
-		window.DrawScaledText(name, textX, y, menuTextScale, color)
-		y += textH
+	if button("Sanitize Branch") {
+		state.sanitizeBranch()
+	}
+
+	if button("Toggle Reset") {
+		state.toggleFrameEvent(resetEvent)
+	}
+
+	if button("Toggle Power Cycle") {
+		state.toggleFrameEvent(powerCycleEvent)
+	}
+
+	if button("Export") {
+		state.exportOpen = true
+	}
+
+	if button("Tools") {
+		state.startModalToolsDialog()
+	}
+
+	if button("Snapshot") {
+		state.startModalSnapshotDialog()
+	}
+
+	if len(state.snapshots) > 0 && button("Swap Snapshot") {
+		state.startModalSwapSnapshotDialog()
+	}
+
+	if button("Find Inputs") {
+		state.startModalInputSearchDialog()
+	}
+
+	if button("Subframe Inputs") {
+		state.startModalSubframeDialog()
+	}
+
+	if button("Splits") {
+		state.splitsOpen = true
+	}
+
+	if button("Branch Stats") {
+		state.openBranchStats()
+	}
+
+	if button("Settings") {
+		state.settingsOpen = true
+	}
+
+	if len(state.branches) > 1 && button("Delete Branch") && !state.branchLocked() {
+		skipConfirmation := false
+
+		// If the current branch is an exact copy of another branch, we delete
+		// it without asking confirmation because no progress is really lost.
+		for i := range state.branches {
+			if i != state.branchIndex &&
+				equalBranches(state.branches[i], state.branches[state.branchIndex]) {
+				skipConfirmation = true
+			}
+		}
+
+		msg := fmt.Sprintf("Do you really want to delete \"%s\"?", state.branch().name)
+
+		if skipConfirmation {
+			state.deleteBranch()
+		} else {
+			state.startModalConfirmDialog("Delete Branch", msg, state.deleteBranch)
+		}
+	}
+
+	minHighlight := -1
+	for _, b := range state.branches {
+		h := b.highlightFrameIndex
+		if minHighlight == -1 || (h >= 0 && h < minHighlight) {
+			minHighlight = h
+		}
+	}
+
+	// Dragging a branch with the left mouse button moves it to where it is
+	// dropped.
+	listTop := y
+	dropIndex := -1
+	var dropLineY int
+
+	for i, b := range state.branches {
+		rowTop := y
+
+		name := b.name
+		if b.locked {
+			name += " (locked)"
+		}
+		if i == state.branchIndex {
+			name = ">" + name + "<"
+		}
+		textW, textH := window.GetScaledTextSize(name, menuTextScale)
+		textX := inputMenuX + (inputMenuW-textW)/2
+		color := theme.menuText
+		branchBounds := rect(textX, y, textW, textH)
+		if branchBounds.contains(mouseX, mouseY) {
+			color = draw.Gray
+		}
+		if b.color != 0 {
+			swatch := rect(textX-textH, y+textH/4, textH/2, textH/2)
+			swatch.fill(window, branchColors[b.color].color)
+		}
+		window.DrawScaledText(name, textX, y, menuTextScale, color)
+		y += textH
 
 		highlight := "no highlight"
 		if b.highlightFrameIndex >= 0 {
@@ -969,12 +1573,82 @@ func (state *editorState) renderMenu(
 		window.DrawScaledText(highlight, textX, y, menuTextScale, color)
 		y += textH
 
-		if i != state.branchIndex && leftClick && branchBounds.contains(mouseX, mouseY) {
-			state.switchToBranch(i)
+		if leftClick && branchBounds.contains(mouseX, mouseY) {
+			state.draggingBranch = i
+			if i != state.branchIndex {
+				state.switchToBranch(i)
+			}
+		}
+
+		if rowTop <= mouseY && mouseY < y {
+			dropIndex = i
+			dropLineY = rowTop
+			if i > state.draggingBranch {
+				dropLineY = y
+			}
+		}
+	}
+
+	if state.draggingBranch != -1 {
+		last := len(state.branches) - 1
+		if mouseY < listTop {
+			dropIndex = 0
+			dropLineY = listTop
+		} else if mouseY >= y {
+			dropIndex = last
+			dropLineY = y
+		}
+		moved := dropIndex != -1 && dropIndex != state.draggingBranch
+		if window.IsMouseDown(draw.LeftButton) {
+			if moved {
+				window.FillRect(inputMenuX+10, dropLineY-1, inputMenuW-20, 2, theme.menuText)
+			}
+		} else {
+			if moved {
+				state.moveBranch(state.draggingBranch, dropIndex)
+			}
+			state.draggingBranch = -1
 		}
 	}
 }
 
+// moveBranch moves the branch at index from to index to, the active branch
+// stays active.
+func (s *editorState) moveBranch(from, to int) {
+	moved := s.branches[from]
+	s.branches = slices.Delete(s.branches, from, from+1)
+	s.branches = slices.Insert(s.branches, to, moved)
+
+	switch {
+	case s.branchIndex == from:
+		s.branchIndex = to
+	case from < s.branchIndex && s.branchIndex <= to:
+		s.branchIndex--
+	case to <= s.branchIndex && s.branchIndex < from:
+		s.branchIndex++
+	}
+	s.render()
+}
+
+// toggleBranchLock locks or unlocks the active branch.
+func (s *editorState) toggleBranchLock() {
+	b := s.branch()
+	b.locked = !b.locked
+	if b.locked {
+		s.setInfo(fmt.Sprintf("Locked \"%s\", its inputs cannot be edited.", b.name))
+	} else {
+		s.setInfo(fmt.Sprintf("Unlocked \"%s\".", b.name))
+	}
+	s.render()
+}
+
+// cycleBranchColor gives the active branch the next of the branchColors.
+func (s *editorState) cycleBranchColor() {
+	b := s.branch()
+	b.color = (b.color + 1) % len(branchColors)
+	s.render()
+}
+
 func (s *editorState) switchToBranch(index int) {
 	oldBranch := s.branch()
 	s.branchIndex = index
@@ -991,34 +1665,56 @@ func (s *editorState) switchToBranch(index int) {
 			break
 		}
 	}
+	for _, frameIndex := range subframeDifferences(
+		oldBranch.subframeInputs,
+		newBranch.subframeInputs,
+	) {
+		dirty = min(dirty, frameIndex)
+	}
 
 	s.setDirtyFrame(dirty)
 	s.render()
 }
 
-func (s *editorState) startModalBranchRenameDialog() {
-	s.isModalDialogOpen = true
-	s.dialogText = ""
-}
+// deleteBranch deletes the active branch and switches to the one before it.
+func (s *editorState) deleteBranch() {
+	del := s.branchIndex
+
+	if del == 0 {
+		s.switchToBranch(1)
+	} else {
+		s.switchToBranch(del - 1)
+	}
 
-func (s *editorState) acceptBranchRenameDialog() {
-	s.branch().name = s.dialogText
-	s.cancelBranchRenameDialog()
+	s.branches = slices.Delete(s.branches, del, del+1)
+	s.branchIndex = max(0, del-1)
 }
 
-func (s *editorState) cancelBranchRenameDialog() {
-	s.isModalDialogOpen = false
-	s.dialogText = ""
-	s.render()
+func (s *editorState) startModalBranchRenameDialog() {
+	s.startModalTextDialog("Enter new Branch Name", "", func(name string) {
+		s.branch().name = name
+	})
 }
 
 func equalBranches(a, b branch) bool {
 	if a.highlightFrameIndex != b.highlightFrameIndex {
 		return false
 	}
+	if !slices.Equal(a.splits, b.splits) {
+		return false
+	}
 	if a.defaultInputs != b.defaultInputs {
 		return false
 	}
+	if a.color != b.color {
+		return false
+	}
+	if !slices.Equal(a.anchors, b.anchors) {
+		return false
+	}
+	if len(subframeDifferences(a.subframeInputs, b.subframeInputs)) > 0 {
+		return false
+	}
 	if len(a.frameInputs) != len(b.frameInputs) {
 		return false
 	}
@@ -1044,9 +1740,7 @@ func (state *editorState) executeEditorFrame(window draw.Window) {
 	mouseX, mouseY := window.MousePosition()
 	rightMouseButtonDown := window.IsMouseDown(draw.RightButton)
 
-	leftDown := window.IsMouseDown(draw.LeftButton)
-	state.waitForLeftMouseRelease = state.waitForLeftMouseRelease && leftDown
-	leftMouseButtonDown := leftDown && !state.waitForLeftMouseRelease
+	leftMouseButtonDown := window.IsMouseDown(draw.LeftButton)
 
 	leftClick := wasLeftClicked(window)
 	shiftDown := window.IsKeyDown(draw.KeyLeftShift) || window.IsKeyDown(draw.KeyRightShift)
@@ -1058,12 +1752,8 @@ func (state *editorState) executeEditorFrame(window draw.Window) {
 
 	// Handle inputs.
 
-	if window.WasKeyPressed(draw.KeyF3) {
-		state.checkFrames(state.leftMostFrame)
-	}
-
 	// TODO Maybe only use H to toggle the highlight, and Ctrl+H to jump to it?
-	if window.WasKeyPressed(draw.KeyH) && state.activeSelection.count() == 1 {
+	if highlightKeys.wasPressed(window) && state.activeSelection.count() == 1 {
 		if state.branch().highlightFrameIndex == state.activeSelection.first {
 			state.branch().highlightFrameIndex = -1
 		} else {
@@ -1072,24 +1762,83 @@ func (state *editorState) executeEditorFrame(window draw.Window) {
 		state.render()
 	}
 
+	if magnifierKeys.wasPressed(window) {
+		state.cycleMagnifier()
+	}
+
+	if onionSkinKeys.wasPressed(window) {
+		state.onionSkin = !state.onionSkin
+		if state.onionSkin {
+			state.setInfo("Onion skin on")
+		} else {
+			state.setInfo("Onion skin off")
+		}
+		state.render()
+	}
+
+	if pinPreviewKeys.wasPressed(window) {
+		state.togglePreviewPane()
+	}
+	if closePreviewKeys.wasPressed(window) {
+		state.closePreviewPanes()
+	}
+
+	if swapSnapshotKeys.wasPressed(window) {
+		state.swapLastSnapshot()
+	}
+
+	if trackedValueKeys.wasPressed(window) {
+		state.hideTrackedValues = !state.hideTrackedValues
+		if findStateTracker(state.rom) == nil {
+			state.setInfo("There is no state tracker for this game")
+		} else if state.hideTrackedValues {
+			state.setInfo("Tracked values hidden")
+		} else {
+			state.setInfo("Tracked values shown")
+		}
+		state.render()
+	}
+
+	if chordModeKeys.wasPressed(window) {
+		state.toggleChordMode()
+	}
+
+	if syncAnchorKeys.wasPressed(window) {
+		state.toggleSyncAnchor()
+	}
+
+	if frameHeaderKeys.wasPressed(window) {
+		state.hideFrameHeader = !state.hideFrameHeader
+		state.render()
+	}
+	// The frame header is above the grid, the grid starts below it.
+	gridTop := state.frameHeaderHeight(window)
+
+	// These set their own info text, so they come before the zoom keys.
+	if fitSelectionKeys.wasPressed(window) {
+		state.zoomToFitSelection(inputMenuX, windowH-gridTop)
+	}
+	if fitRowKeys.wasPressed(window) {
+		state.zoomToFitRow(inputMenuX, windowH-gridTop)
+	}
+
 	oldScaleFactor := bestFitScale(state.scaleFactor)
 
-	zeroDown := window.WasKeyPressed(draw.Key0) || window.WasKeyPressed(draw.KeyNum0)
-	if controlDown && zeroDown {
+	if resetZoomKeys.wasPressed(window) {
 		state.scaleFactor = 1
 	}
 
-	if controlDown && window.WasKeyPressed(draw.KeyNumAdd) {
-		state.scaleFactor = min(8, max(0.5, state.scaleFactor*1.0905))
+	if zoomInKeys.wasPressed(window) {
+		state.scaleFactor = min(8, max(0.5, state.scaleFactor*zoomFactor()))
 	}
-	if controlDown && window.WasKeyPressed(draw.KeyNumSubtract) {
-		state.scaleFactor = min(8, max(0.5, state.scaleFactor/1.0905))
+	if zoomOutKeys.wasPressed(window) {
+		state.scaleFactor = min(8, max(0.5, state.scaleFactor/zoomFactor()))
 	}
 
 	scrollY := window.MouseWheelY()
 	if controlDown && scrollY != 0 {
 		// We use the control key for zooming.
-		state.scaleFactor = min(8, max(0.5, state.scaleFactor*math.Pow(1.0905, scrollY)))
+		state.scaleFactor = min(8, max(0.5, state.scaleFactor*math.Pow(zoomFactor(), scrollY)))
 	}
 
 	scaleFactor := bestFitScale(state.scaleFactor)
@@ -1101,89 +1850,99 @@ func (state *editorState) executeEditorFrame(window draw.Window) {
 
 	textScale := float32(scaleFactor * baseTextScale)
 	fontHeight := round(scaleFactor * baseFontHeight)
-	screenWidth := round(scaleFactor * ScreenWidth)
-	screenHeight := round(scaleFactor * ScreenHeight)
+	screenWidth := round(scaleFactor * gameboy.ScreenWidth)
+	screenHeight := round(scaleFactor * gameboy.ScreenHeight)
 	frameWidth := 1 + screenWidth + 1
 	frameHeight := fontHeight + screenHeight + 1
 
-	integerScaleUp := scaleFactor > 0 && screenWidth%ScreenWidth == 0
+	integerScaleUp := scaleFactor > 0 && screenWidth%gameboy.ScreenWidth == 0
 	window.BlurImages(!integerScaleUp)
 
 	frameCountX := inputMenuX / frameWidth
-	frameCountY := windowH / frameHeight
+	frameCountY := (windowH - gridTop) / frameHeight
+	lineFrames := gridLineFrames(frameCountX, frameCountY)
+	state.gridLineFrames, state.gridFrames = lineFrames, frameCountX*frameCountY
 
 	if controlDown && !state.controlWasDown {
 		state.startDraggingFrameInputs(state.activeSelection.first)
 	}
 
-	if state.infoText != "" && window.WasKeyPressed(draw.KeyEscape) {
-		state.resetInfoText()
+	if clearInfoKeys.wasPressed(window) {
+		if state.repeatEntry.text != "" || state.repeatEntry.focused {
+			state.repeatEntry.clear()
+		} else {
+			state.resetInfoText()
+		}
 		state.render()
 	}
 
-	// Append digits to the repeat counter text.
+	// Digits go into the repeat entry, see repeat_entry.go.
 	if !controlDown {
 		for i := range 10 {
 			if window.WasKeyPressed(draw.Key0+draw.Key(i)) ||
 				window.WasKeyPressed(draw.KeyNum0+draw.Key(i)) {
-
-				digit := strconv.Itoa(i)
-
-				n, err := strconv.Atoi(state.infoText + digit)
-				isValidNumber := err == nil && 1 <= n && n <= 216000
-
-				if !isValidNumber {
-					state.setInfo(digit)
-				} else {
-					state.setInfo(state.infoText + digit)
-				}
-				state.render()
+				state.repeatEntry.typeDigit(i)
 			}
 		}
 	}
+	if leftClick {
+		state.repeatEntry.focused = state.repeatEntry.r.contains(mouseX, mouseY)
+	}
+	if state.repeatEntry.r.contains(mouseX, mouseY) && scrollY != 0 {
+		state.repeatEntry.scroll(int(scrollY))
+		// The wheel does not also scroll the grid.
+		scrollY = 0
+	}
 
-	repeatCount, err := strconv.Atoi(state.infoText)
-	repeatCountValid := err == nil
+	// In chord mode the held buttons are pressed together with Enter, see
+	// chords.go.
+	chord := state.heldChord(window)
+	chordEntered := chord != 0 &&
+		(window.WasKeyPressed(draw.KeyEnter) || window.WasKeyPressed(draw.KeyNumEnter))
+
+	repeatCount, repeatCountValid := state.repeatEntry.number()
 	repeatCount = max(repeatCount, 1)
+	// Edits repeat whole poll groups, see poll_cadence.go.
+	groupFrames := state.pollCadence.frames()
+	repeatFrames := repeatCount * groupFrames
 
 	if state.lastAction.valid {
 		newAction := state.lastAction
 
 		if strings.ContainsAny(window.Characters(), "+p") {
 			// Append input to the end.
-			newAction.count += repeatCount
+			newAction.count += repeatFrames
 		}
 
 		if strings.Contains(window.Characters(), "P") {
 			// Prepend input to the start.
-			newAction.count += repeatCount
-			newAction.frameIndex -= repeatCount
+			newAction.count += repeatFrames
+			newAction.frameIndex -= repeatFrames
 		}
 
 		if strings.ContainsAny(window.Characters(), "-m") {
 			// Remove inputs from the end.
-			newAction.count = max(1, newAction.count-repeatCount)
+			newAction.count = max(groupFrames, newAction.count-repeatFrames)
 		}
 
 		if strings.Contains(window.Characters(), "M") {
-			delta := min(repeatCount, state.lastAction.count-1)
+			delta := min(repeatFrames, state.lastAction.count-groupFrames)
 			newAction.frameIndex += delta
 			newAction.count -= delta
 		}
 
-		if newAction != state.lastAction {
-			b := state.lastAction.button
-			down := state.lastAction.down
-
+		if newAction != state.lastAction && !state.branchLocked() {
 			// First undo the last action, then apply the new action.
-			state.setButtonDown(state.lastAction.frameIndex, state.lastAction.count, b, !down)
-			state.setButtonDown(newAction.frameIndex, newAction.count, b, down)
+			state.beginEdit()
+			state.undoLastAction()
+			state.doAction(newAction)
+			state.endEdit()
 
 			state.activeSelection.first = newAction.frameIndex
 			state.activeSelection.last = newAction.frameIndex + newAction.count - 1
-			state.lastAction = newAction
 
 			state.resetInfoText()
+			state.repeatEntry.clear()
 			state.render()
 		}
 	}
@@ -1191,24 +1950,41 @@ func (state *editorState) executeEditorFrame(window draw.Window) {
 	state.keyRepeatCountdown--
 	keyTriggered := func(key draw.Key) bool {
 		if window.WasKeyPressed(key) || window.IsKeyDown(key) && state.keyRepeatCountdown <= 0 {
-			state.keyRepeatCountdown = 8
+			state.keyRepeatCountdown = int(globalSettings.EditorKeyRepeat)
 			return true
 		}
 		return false
 	}
 
-	frameDelta := 0
-	if keyTriggered(draw.KeyLeft) {
-		frameDelta = -repeatCount
+	// In the row layout Left and Right step a single frame and Up and Down
+	// step a row. In the column layout it is the other way around.
+	horizontalStep, verticalStep := 1, frameCountX
+	if globalSettings.ColumnLayout {
+		horizontalStep, verticalStep = frameCountY, 1
 	}
-	if keyTriggered(draw.KeyRight) {
-		frameDelta = repeatCount
+
+	frameDelta := 0
+	if altDown {
+		// Alt+Left and Alt+Right go through the navigation history.
+		if backKeys.wasPressed(window) {
+			state.navigateBack()
+		}
+		if forwardKeys.wasPressed(window) {
+			state.navigateForward()
+		}
+	} else {
+		if keyTriggered(draw.KeyLeft) {
+			frameDelta = -horizontalStep * repeatCount
+		}
+		if keyTriggered(draw.KeyRight) {
+			frameDelta = horizontalStep * repeatCount
+		}
 	}
 	if keyTriggered(draw.KeyUp) {
-		frameDelta = -frameCountX * repeatCount
+		frameDelta = -verticalStep * repeatCount
 	}
 	if keyTriggered(draw.KeyDown) {
-		frameDelta = frameCountX * repeatCount
+		frameDelta = verticalStep * repeatCount
 	}
 	if keyTriggered(draw.KeyPageUp) {
 		frameDelta = -frameCountX * frameCountY * repeatCount
@@ -1220,11 +1996,11 @@ func (state *editorState) executeEditorFrame(window draw.Window) {
 	if scrollY != 0 && !controlDown {
 		ticks := -int(scrollY)
 
-		// By default we scroll down a whole line of frames.
+		// By default we scroll down a whole row, or column, of frames.
 		// Holding Shift will scroll a single frame at a time.
 		// Holding Control will scroll a whole screen full of frames at
 		// a time.
-		delta := ticks * frameCountX
+		delta := ticks * lineFrames
 		if shiftDown {
 			delta = ticks
 		}
@@ -1235,12 +2011,11 @@ func (state *editorState) executeEditorFrame(window draw.Window) {
 	// On Enter and G we go to the frame number that was typed in. In
 	// this case it is not a repeat count but an absolute frame number
 	// (index + 1).
-	if repeatCountValid &&
-		(window.WasKeyPressed(draw.KeyG) ||
-			window.WasKeyPressed(draw.KeyEnter) ||
-			window.WasKeyPressed(draw.KeyNumEnter)) {
+	if repeatCountValid && !chordEntered && goToFrameKeys.wasPressed(window) {
+		state.recordSeek()
 		frameDelta = -state.leftMostFrame + repeatCount
 		state.resetInfoText()
+		state.repeatEntry.clear()
 		state.render()
 	}
 
@@ -1270,28 +2045,28 @@ func (state *editorState) executeEditorFrame(window draw.Window) {
 		}
 	}
 
-	if window.WasKeyPressed(draw.KeyHome) {
-		if shiftDown {
-			state.activeSelection.last = 0
-		} else {
-			state.leftMostFrame = 0
-		}
+	if selectToStartKeys.wasPressed(window) {
+		state.activeSelection.last = 0
+	}
+	if scrollToStartKeys.wasPressed(window) {
+		state.recordSeek()
+		state.leftMostFrame = 0
 	}
 
-	if window.WasKeyPressed(draw.KeyEnd) {
-		if shiftDown {
-			state.activeSelection.last = len(state.branch().frameInputs) - 1
-		} else {
-			state.leftMostFrame = len(state.branch().frameInputs) - frameCountX*frameCountY - 1
-		}
+	if selectToEndKeys.wasPressed(window) {
+		state.activeSelection.last = len(state.branch().frameInputs) - 1
+	}
+	if scrollToEndKeys.wasPressed(window) {
+		state.recordSeek()
+		state.leftMostFrame = len(state.branch().frameInputs) - frameCountX*frameCountY - 1
 	}
 
 	frameX := mouseX / frameWidth
-	frameY := mouseY / frameHeight
+	frameY := (mouseY - gridTop) / frameHeight
 	frameUnderMouse := -1
-	if 0 <= frameX && frameX < frameCountX &&
+	if 0 <= frameX && frameX < frameCountX && mouseY >= gridTop &&
 		0 <= frameY && frameY < frameCountY {
-		frameUnderMouse = state.leftMostFrame + frameY*frameCountX + frameX
+		frameUnderMouse = state.leftMostFrame + gridIndex(frameX, frameY, frameCountX, frameCountY)
 	}
 
 	if leftClick {
@@ -1357,7 +2132,7 @@ func (state *editorState) executeEditorFrame(window draw.Window) {
 		if state.draggingFrameIndex == -1 {
 			state.draggingFrameIndex = frameUnderMouse
 		} else {
-			screenIndex := frameY*frameCountX + frameX
+			screenIndex := gridIndex(frameX, frameY, frameCountX, frameCountY)
 			state.leftMostFrame = state.draggingFrameIndex - screenIndex
 		}
 	}
@@ -1369,75 +2144,79 @@ func (state *editorState) executeEditorFrame(window draw.Window) {
 	if state.leftMostFrame != lastLeftMostFrame ||
 		state.activeSelection != lastActiveSelection {
 		state.resetInfoText()
+		state.repeatEntry.clear()
 		state.render()
 	}
 
-	if window.WasKeyPressed(draw.KeyBackspace) ||
-		window.WasKeyPressed(draw.KeyDelete) {
-		state.setInputsRange(
+	if state.repeatEntry.focused && window.WasKeyPressed(draw.KeyBackspace) {
+		state.repeatEntry.deleteDigit()
+	} else if clearInputsKeys.wasPressed(window) {
+		first, count := state.pollCadence.snap(
 			state.activeSelection.start(),
-			state.activeSelection.end()-1,
-			0,
+			state.activeSelection.count(),
 		)
+		state.setInputsRange(first, first+count-1, 0)
 		state.render()
 	}
 
-	buttonWasPressed := func(button Button) {
+	// pressButtons presses the buttons in the selection, or releases them if
+	// the first selected frame already has all of them pressed.
+	pressButtons := func(buttons inputState) {
 		state.resetInfoText()
+		state.repeatEntry.clear()
+		if state.branchLocked() {
+			return
+		}
 
-		firstFrameIndex := state.activeSelection.start()
-		down := !state.isButtonDown(firstFrameIndex, button)
-
+		down := state.inputsAt(state.activeSelection.start())&buttons != buttons
 		singleFrameSelected := state.activeSelection.first == state.activeSelection.last
 
-		if shiftDown && singleFrameSelected {
-			// Toggle button for all the future if we do not overwrite any
-			// existing future button of this kind.
-			// TODO Allow toggling even though the button is pressed in the
-			// future already.
-			canToggle := true
-			for i := firstFrameIndex + 2; i < len(state.branch().frameInputs); i++ {
-				canToggle = canToggle && state.isButtonDown(i, button) == state.isButtonDown(i-1, button)
-			}
-
-			if canToggle {
-				state.setButtonDown(firstFrameIndex, len(state.branch().frameInputs)-firstFrameIndex, button, down)
-				setButtonDown(&state.branch().defaultInputs, button, down)
-			} else {
-				state.setWarning("Cannot toggle button, it is already used in the future.")
-			}
-		} else if singleFrameSelected {
-			// Toggle button for the active frame.
-			state.setButtonDown(state.activeSelection.first, repeatCount, button, down)
-
-			state.lastAction = inputAction{
-				valid:      true,
-				frameIndex: state.activeSelection.first,
-				button:     button,
+		if singleFrameSelected {
+			// Toggle the buttons for the active frame.
+			first, count := state.pollCadence.snap(state.activeSelection.first, repeatFrames-groupFrames+1)
+			state.doAction(inputAction{
+				frameIndex: first,
+				buttons:    buttons,
 				down:       down,
-				count:      repeatCount,
-			}
+				count:      count,
+			})
 
 			state.activeSelection.first = state.lastAction.frameIndex
 			state.activeSelection.last = state.lastAction.frameIndex + state.lastAction.count - 1
 		} else {
 			// We have multiple frames selected.
-			state.setButtonDown(state.activeSelection.start(), state.activeSelection.count(), button, down)
-			state.lastAction = inputAction{
-				valid:      true,
-				frameIndex: state.activeSelection.start(),
-				button:     button,
+			first, count := state.pollCadence.snap(state.activeSelection.start(), state.activeSelection.count())
+			state.doAction(inputAction{
+				frameIndex: first,
+				buttons:    buttons,
 				down:       down,
-				count:      state.activeSelection.count(),
-			}
+				count:      count,
+			})
 		}
 
 		state.render()
 	}
 
-	for key, b := range keyMap {
-		if window.WasKeyPressed(key) {
-			buttonWasPressed(b)
+	buttonWasPressed := func(button gameboy.Button) {
+		if shiftDown && state.activeSelection.first == state.activeSelection.last {
+			state.resetInfoText()
+			state.repeatEntry.clear()
+			// Toggle the button for all the future, see default_inputs.go.
+			firstFrameIndex := state.activeSelection.start()
+			state.setFutureButton(firstFrameIndex, button, !state.isButtonDown(firstFrameIndex, button))
+			state.render()
+			return
+		}
+		pressButtons(1 << button)
+	}
+
+	if chordEntered {
+		pressButtons(chord)
+	} else if !state.chordMode {
+		for key, b := range keyMap {
+			if window.WasKeyPressed(key) {
+				buttonWasPressed(b)
+			}
 		}
 	}
 
@@ -1456,170 +2235,145 @@ func (state *editorState) executeEditorFrame(window draw.Window) {
 		state.render()
 	}
 
-	if state.screenDirty || window.NeedsReRendering() {
-		state.screenDirty = false
+	// The magnifier follows the mouse, so we have to redraw when it moves.
+	if state.magnifierZoom() != 0 &&
+		(mouseX != state.lastMouseX || mouseY != state.lastMouseY) {
+		state.lastMouseX, state.lastMouseY = mouseX, mouseY
+		state.render()
+	}
 
-		// We need to create the Gameboy screens for these frames:
-		// [leftMostFrame..lastVisibleFrame]
-		lastVisibleFrame := state.leftMostFrame + frameCountX*frameCountY - 1
+	// The frame header shows the frame under the mouse, or the selected
+	// frame if the mouse is not over the grid.
+	headerFrame := frameUnderMouse
+	if headerFrame == -1 {
+		headerFrame = state.activeSelection.start()
+	}
+	if headerFrame != state.headerFrame {
+		state.headerFrame = headerFrame
+		state.render()
+	}
 
-		// TODO Remember these until we change frames.
-		state.screenBuffer = state.screenBuffer[:0]
-		for i := state.leftMostFrame; i <= lastVisibleFrame; i++ {
-			gb := state.generateFrame(i)
-			state.screenBuffer = append(state.screenBuffer, gb.PreparedData)
-		}
+	// After the window lost its device, we have to upload our images again.
+	forceUpload := state.imagesLost(window, gridImages)
+	if state.screenDirty || forceUpload {
+		state.screenDirty = false
 
-		screenCount := frameCountX * frameCountY
-		bytesPerScreen := ScreenWidth * ScreenHeight * 4
-		screenBufferSize := screenCount * bytesPerScreen
-		if cap(state.gameboyScreenBuffer) < screenBufferSize {
-			state.gameboyScreenBuffer = make([]byte, screenBufferSize)
-			for i := 3; i < len(state.gameboyScreenBuffer); i += 4 {
-				state.gameboyScreenBuffer[i] = 255
-			}
-		}
-		state.gameboyScreenBuffer = state.gameboyScreenBuffer[:screenBufferSize]
+		state.updateScreenTiles(window, frameCountX, frameCountY, forceUpload)
 
-		bufferW := frameCountX * ScreenWidth
-		bufferH := frameCountY * ScreenHeight
-		for frameY := range frameCountY {
-			for frameX := range frameCountX {
-				screenOffsetX := frameX * ScreenWidth
-				screenOffsetY := frameY * ScreenHeight
-				screen := state.screenBuffer[frameX+frameY*frameCountX]
-				for y := range ScreenHeight {
-					for x := range ScreenWidth {
-						c := screen[x][y]
-						destX := screenOffsetX + x
-						destY := screenOffsetY + y
-						dest := 4 * (destX + destY*bufferW)
-						copy(state.gameboyScreenBuffer[dest:], c[:])
-					}
-				}
-			}
+		var tracker stateTracker
+		if !state.hideTrackedValues {
+			tracker = findStateTracker(state.rom)
 		}
 
-		window.CreateImage("gameboyScreens", bufferW, bufferH)
-		window.SetImagePixels("gameboyScreens", state.gameboyScreenBuffer)
-
-		frameIndex := state.leftMostFrame
 		for frameY := range frameCountY {
 			for frameX := range frameCountX {
+				screenIndex := gridIndex(frameX, frameY, frameCountX, frameCountY)
+				frameIndex := state.leftMostFrame + screenIndex
 				frameOffsetX := frameX * frameWidth
-				frameOffsetY := frameY * frameHeight
+				frameOffsetY := gridTop + frameY*frameHeight
 				screenOffsetX := frameOffsetX + 1
 				screenOffsetY := frameOffsetY + fontHeight
 				inputs := state.inputsAt(frameIndex)
 
-				// Determine color by button state for this frame.
-				borderColor := draw.RGBA(0, 0, 0, 1)
+				// The border shows the pressed buttons.
+				titleColor := drawFrameBorder(
+					window,
+					rect(frameOffsetX, frameOffsetY, frameWidth, frameHeight),
+					fontHeight,
+					inputs,
+				)
 
-				// Create a 4 bit value for the directional keys: DURL
-				// (down up right left).
-				var directionalButtons byte
-				if isButtonDown(inputs, ButtonLeft) {
-					directionalButtons += 1
-				}
-				if isButtonDown(inputs, ButtonRight) {
-					directionalButtons += 2
-				}
-				if isButtonDown(inputs, ButtonUp) {
-					directionalButtons += 4
+				// Render the Gameboy screen.
+
+				state.drawGridScreen(window, screenIndex, screenOffsetX, screenOffsetY, screenWidth, screenHeight)
+				// Frames in which the game does not read the joypad are
+				// darker, so the poll groups stand out.
+				if !state.pollCadence.isPollFrame(frameIndex) {
+					window.FillRect(screenOffsetX, screenOffsetY, screenWidth, screenHeight, draw.RGBA(0, 0, 0, 0.35))
 				}
-				if isButtonDown(inputs, ButtonDown) {
-					directionalButtons += 8
+				isActiveFrame := state.activeSelection.start() <= frameIndex && frameIndex < state.activeSelection.end()
+				if isActiveFrame {
+					window.FillRect(screenOffsetX, screenOffsetY, screenWidth, screenHeight, currentTheme().selection)
 				}
 
-				// Valid combinations, which you could actually press on
-				// a real Gameboy, get a green tint between 100 and 200.
-				// Illegal combinations, like Left+Right, get 255 so
-				// they stand out as a very bright green.
-				borderColor.G = []float32{
-					0,           // durl
-					100 / 255.0, // durL
-					157 / 255.0, // duRl
-					255 / 255.0, // duRL
-					114 / 255.0, // dUrl
-					128 / 255.0, // dUrL
-					142 / 255.0, // dURl
-					255 / 255.0, // dURL
-					171 / 255.0, // Durl
-					200 / 255.0, // DurL
-					185 / 255.0, // DuRl
-					255 / 255.0, // DuRL
-					255 / 255.0, // DUrl
-					255 / 255.0, // DUrL
-					255 / 255.0, // DURl
-					255 / 255.0, // DURL
-				}[directionalButtons]
-
-				if isButtonDown(inputs, ButtonA) ||
-					isButtonDown(inputs, ButtonStart) ||
-					isButtonDown(inputs, ButtonSelect) {
-					borderColor.B = 192 / 255.0
+				if frameIndex == state.branch().highlightFrameIndex {
+					window.FillRect(frameOffsetX, frameOffsetY, frameWidth, frameHeight, currentTheme().highlight)
 				}
 
-				if isButtonDown(inputs, ButtonB) {
-					borderColor.R = 192 / 255.0
+				if i := state.branch().splitIndex(frameIndex); i != -1 {
+					window.FillRect(frameOffsetX, frameOffsetY, 4, frameHeight, draw.Cyan)
+
+					if delta, ok := state.referenceDelta(state.branch().splits[i]); ok {
+						color := draw.White
+						if delta < 0 {
+							color = draw.LightGreen
+						} else if delta > 0 {
+							color = draw.LightRed
+						}
+						text := formatFrameDelta(delta)
+						w, h := window.GetScaledTextSize(text, textScale)
+						x := screenOffsetX + screenWidth - w
+						y := screenOffsetY + screenHeight - h
+						window.FillRect(x, y, w, h, draw.RGBA(0, 0, 0, 0.8))
+						window.DrawScaledText(text, x, y, textScale, color)
+					}
 				}
 
-				// Color the frame border.
-				window.FillRect(frameOffsetX, frameOffsetY, frameWidth, fontHeight, borderColor)
-				window.FillRect(frameOffsetX, frameOffsetY, 1, frameHeight, borderColor)
-				window.FillRect(frameOffsetX, frameOffsetY+frameHeight-1, frameWidth, 1, borderColor)
-				window.FillRect(frameOffsetX+frameWidth-1, frameOffsetY, 1, frameHeight, borderColor)
-
-				// Render the Gameboy screen.
+				if tracker != nil {
+					gb := state.generateFrame(frameIndex)
+					text := formatTrackedValues(tracker.track(&gb))
+					w, h := window.GetScaledTextSize(text, textScale)
+					y := screenOffsetY + screenHeight - h
+					window.FillRect(screenOffsetX, y, w, h, draw.RGBA(0, 0, 0, 0.8))
+					window.DrawScaledText(text, screenOffsetX, y, textScale, draw.White)
+				}
 
-				window.DrawImageFilePart(
-					"gameboyScreens",
-					frameX*ScreenWidth, frameY*ScreenHeight, ScreenWidth, ScreenHeight,
-					screenOffsetX, screenOffsetY, screenWidth, screenHeight,
-					0,
-				)
-				isActiveFrame := state.activeSelection.start() <= frameIndex && frameIndex < state.activeSelection.end()
-				if isActiveFrame {
-					window.FillRect(screenOffsetX, screenOffsetY, screenWidth, screenHeight, selectionColor)
+				if anchor := state.syncAnchorState(frameIndex); anchor != "" {
+					background := draw.RGBA(0, 0, 0, 0.8)
+					if anchor == "anchor DESYNCED" {
+						background = draw.RGBA(0.5, 0, 0, 0.8)
+					}
+					w, h := window.GetScaledTextSize(anchor, textScale)
+					x := screenOffsetX + screenWidth - w
+					window.FillRect(x, screenOffsetY, w, h, background)
+					window.DrawScaledText(anchor, x, screenOffsetY, textScale, draw.White)
 				}
 
-				if frameIndex == state.branch().highlightFrameIndex {
-					window.FillRect(frameOffsetX, frameOffsetY, frameWidth, frameHeight, highlightColor)
+				if dead := state.deadButtonsAt(frameIndex); dead != 0 {
+					text := "dead" + formatFrameInputs(dead)
+					w, h := window.GetScaledTextSize(text, textScale)
+					window.FillRect(screenOffsetX, screenOffsetY, w, h, draw.RGBA(0.5, 0, 0, 0.8))
+					window.DrawScaledText(text, screenOffsetX, screenOffsetY, textScale, draw.White)
 				}
 
 				// Render the text above the frame.
-				textY := frameY * frameHeight
+				textY := frameOffsetY
 
 				topLeftText := strconv.Itoa(frameIndex)
-				window.DrawScaledText(topLeftText, screenOffsetX, textY, textScale, draw.White)
+				window.DrawScaledText(topLeftText, screenOffsetX, textY, textScale, titleColor)
 				topLeftTextWidth, _ := window.GetScaledTextSize(topLeftText, textScale)
 
-				text := ""
-				add := func(b Button, pressed string) {
-					if isButtonDown(inputs, b) {
-						text += " " + pressed
-					}
-				}
-				add(ButtonLeft, "<")
-				add(ButtonUp, "^")
-				add(ButtonRight, ">")
-				add(ButtonDown, "v")
-				add(ButtonA, "A")
-				add(ButtonB, "B")
-				add(ButtonSelect, "Sel")
-				add(ButtonStart, "Start")
-
+				text := formatFrameInputs(inputs)
 				textWidth, _ := window.GetScaledTextSize(text, textScale)
 				textX := screenOffsetX + (topLeftTextWidth+screenWidth-textWidth)/2
-				window.DrawScaledText(text, textX, textY, textScale, draw.White)
-
-				frameIndex++
+				window.DrawScaledText(text, textX, textY, textScale, titleColor)
 			}
 		}
 
 		right := frameCountX * frameWidth
-		window.FillRect(right, 0, inputMenuX+inputMenuMargin-right, windowH, draw.Black)
-		window.FillRect(0, frameCountY*frameHeight, inputMenuX+inputMenuMargin, windowH, draw.Black)
+		background := currentTheme().background
+		window.FillRect(right, 0, inputMenuX+inputMenuMargin-right, windowH, background)
+		window.FillRect(0, gridTop+frameCountY*frameHeight, inputMenuX+inputMenuMargin, windowH, background)
+		state.renderFrameHeader(window, headerFrame, right)
+
+		// The branch color runs along the right and bottom of the grid.
+		if c := state.branch().color; c != 0 {
+			const accentW = 4
+			bottom := gridTop + frameCountY*frameHeight
+			color := branchColors[c].color
+			window.FillRect(right, 0, accentW, bottom+accentW, color)
+			window.FillRect(0, bottom, right, accentW, color)
+		}
 
 		if state.infoText == "" && state.activeSelection.count() > 1 {
 			state.infoText = fmt.Sprintf("%d frames selected", state.activeSelection.count())
@@ -1629,8 +2383,24 @@ func (state *editorState) executeEditorFrame(window draw.Window) {
 			textW, textH := window.GetScaledTextSize(state.infoText, infoTextScale)
 			textX := frameCountX*frameWidth - textW
 			textY := windowH - textH
-			window.FillRect(textX-1, textY-1, textW+2, textH+2, draw.RGBA(0, 0, 0, 0.8))
-			window.DrawScaledText(state.infoText, textX, textY, infoTextScale, state.infoTextColor)
+			theme := currentTheme()
+			color := theme.infoText
+			if state.infoIsWarning {
+				color = theme.warningText
+			}
+			window.FillRect(textX-1, textY-1, textW+2, textH+2, theme.infoBackground)
+			window.DrawScaledText(state.infoText, textX, textY, infoTextScale, color)
+		}
+		state.renderMemoryStatus(window, windowH)
+		state.renderPreviewPanes(window, frameCountX*frameWidth, windowH, forceUpload)
+
+		if !leftMouseButtonDown {
+			state.renderMagnifier(
+				window,
+				mouseX, mouseY, gridTop,
+				frameWidth, frameHeight, fontHeight,
+				frameCountX, frameCountY,
+			)
 		}
 	}
 
@@ -1650,17 +2420,24 @@ func (state *editorState) dragFrameInputsTo(selectionOffset int, lastActiveSelec
 	// the earliest frame from where we move back to the future.
 	affectedFrame := state.activeSelection.start()
 
-	state.activeSelection = frameSelection{
+	moved := frameSelection{
 		first: max(0, state.dragStartSelection.first+selectionOffset),
 		last:  max(0, state.dragStartSelection.last+selectionOffset),
 	}
 
-	if state.activeSelection == lastActiveSelection {
+	if moved == lastActiveSelection {
 		// No real dragging has occurred, e.g. if the mouse cursor is still
 		// inside the start frame and has only been moved one pixel.
 		return
 	}
 
+	if state.branchLocked() {
+		// Stop dragging so we do not warn again for every mouse move.
+		state.dragStartFrame = -1
+		return
+	}
+	state.activeSelection = moved
+
 	// TODO We could allow changing the last action after dragging it, in case
 	// the last action is the one that was being dragged.
 	state.lastAction.valid = false
@@ -1720,13 +2497,11 @@ type mouseClick struct {
 type inputAction struct {
 	valid      bool
 	frameIndex int
-	button     Button
+	buttons    inputState
 	down       bool
 	count      int
 }
 
-type gameboyScreen [ScreenWidth][ScreenHeight][3]uint8
-
 // frameSelection has the first and last selected frame indices where first was
 // selected before (in time) last. They can be in any order. If first == last
 // then a single frame is selected. If first < last the selection was done
@@ -1752,17 +2527,19 @@ func lastSessionPath() string {
 	return filepath.Join(os.Getenv("APPDATA"), "gameboy.speedrun")
 }
 
-func (s *editorState) createNewSpeedrun() error {
-	path, err := dialog.File().
-		Title("Load GameBoy ROM File").
-		Filter("GameBoy ROM", "gb", "gbc", "bin", "speedrun").
-		Load()
-
-	if err != nil {
-		// User cancelled the dialog.
+func (s *editorState) createNewSpeedrun(window draw.Window) {
+	s.startLoadDialog("Load GameBoy ROM File", "GameBoy ROM", []string{"gb", "gbc", "bin", "speedrun"}, func(path string) error {
+		if err := s.newSpeedrun(path); err != nil {
+			return err
+		}
+		window.SetTitle(windowTitle)
 		return nil
-	}
+	})
+}
 
+// newSpeedrun starts a new session for the ROM file at path, which can also
+// be a speedrun file that includes the ROM.
+func (s *editorState) newSpeedrun(path string) error {
 	if strings.HasSuffix(strings.ToLower(path), ".speedrun") {
 		// Load game from a speedrun file. This has to be a file version that
 		// includes the game.
@@ -1781,44 +2558,148 @@ func (s *editorState) createNewSpeedrun() error {
 		}
 
 		romSize := binary.LittleEndian.Uint32(data[4:])
+		if romSize == 0 {
+			return fmt.Errorf("speedrun file was saved for sharing, it does not contain the Gameboy ROM")
+		}
 		if len(data) < int(8+romSize) {
 			return fmt.Errorf("corrupt speedrun file (incomplete Gameboy ROM)")
 		}
 
-		globalROM = slices.Clone(data[8 : 8+romSize])
+		s.rom = slices.Clone(data[8 : 8+romSize])
 	} else {
 		// Load a Gameboy ROM.
 		rom, err := os.ReadFile(path)
 		if err != nil {
 			return err
 		}
-		globalROM = rom
+		s.rom = rom
 	}
 
 	s.resetForNewGame()
+	s.showROMInfo()
+	if !strings.HasSuffix(strings.ToLower(path), ".speedrun") {
+		s.addToLibrary(path)
+	}
 	return nil
 }
 
-func (s *editorState) openFile() (string, error) {
-	path, err := dialog.File().
-		Title("Load Speedrun").
-		Filter("GameBoy Speedrun", "speedrun").
-		Load()
+func (s *editorState) openFile(window draw.Window) {
+	s.startLoadDialog("Load Speedrun", "GameBoy Speedrun", []string{"speedrun"}, func(path string) error {
+		return s.openSpeedrun(window, path)
+	})
+}
 
-	if err != nil {
-		// User cancelled the dialog.
-		return "", nil
+// openDroppedFile opens a file that was dropped onto the window. Speedrun
+// files are opened like with Ctrl+O, other files are loaded as a ROM for a new
+// speedrun like with Ctrl+N.
+func (s *editorState) openDroppedFile(window draw.Window, path string) {
+	ext := strings.ToLower(filepath.Ext(path))
+	if !slices.Contains([]string{".gb", ".gbc", ".bin", ".speedrun"}, ext) {
+		s.setWarning("Cannot open " + filepath.Base(path) + ", drop a ROM or speedrun file.")
+		return
 	}
 
-	err = s.open(path)
-	if err != nil {
-		return "", fmt.Errorf("failed to load '%s': %w", path, err)
+	open := func() {
+		if ext == ".speedrun" {
+			if err := s.openSpeedrun(window, path); err != nil {
+				s.setWarning(err.Error())
+			}
+		} else {
+			if err := s.newSpeedrun(path); err != nil {
+				s.setWarning(err.Error())
+				return
+			}
+			window.SetTitle(windowTitle)
+		}
 	}
 
-	return path, nil
+	s.askToSaveBefore("opening "+filepath.Base(path), "Open", open)
 }
 
-func (state *editorState) open(path string) error {
+// askToSaveBefore lets the user save unsaved changes before the current
+// speedrun is replaced. action describes what replaces it, e.g. "opening
+// run.speedrun", verb names it on the buttons, e.g. "Open". replace is not
+// called if the user cancels or saving fails.
+func (s *editorState) askToSaveBefore(action, verb string, replace func()) {
+	if s.saving != nil {
+		// Whether there are unsaved changes depends on how the running save
+		// ends, so we ask once it is done.
+		s.saving.afterSave = func(error) {
+			s.askToSaveBefore(action, verb, replace)
+		}
+		return
+	}
+	if !s.unsavedChanges {
+		replace()
+		return
+	}
+
+	s.startModalListDialog(
+		"The current speedrun has unsaved changes. Save them before "+action+"?",
+		[]string{"Save, then " + strings.ToLower(verb), verb + " without saving", "Cancel"},
+		0,
+		func(option int) {
+			if option == 0 {
+				s.startSaveDialog("Save Speedrun", "GameBoy Speedrun", "speedrun", func(savePath string) error {
+					s.startSave(savePath, saveEverything)
+					if s.saving != nil {
+						s.saving.afterSave = func(err error) {
+							// If saving failed we keep the current
+							// speedrun.
+							if err == nil && !s.unsavedChanges {
+								replace()
+							}
+						}
+					}
+					return nil
+				})
+			}
+			if option == 1 {
+				replace()
+			}
+		},
+	)
+}
+
+// openSpeedrun loads the session file at path and rebuilds the key frames
+// that were not saved with it. If the file was saved for sharing with another
+// ROM than ours, the user locates the ROM first and the session is loaded
+// after that.
+func (s *editorState) openSpeedrun(window draw.Window, path string) error {
+	opened := func(err error) error {
+		if err != nil {
+			return fmt.Errorf("failed to load '%s': %w", path, err)
+		}
+		s.rebuildMissingKeyFrames()
+		s.addToLibrary(path)
+		window.SetTitle(windowTitle + " - " + path)
+		return nil
+	}
+
+	err := s.open(path, nil)
+	var missing missingROMError
+	if errors.As(err, &missing) {
+		s.locateROM(missing.hash, func(rom []byte) error {
+			return opened(s.open(path, rom))
+		})
+		return nil
+	}
+	return opened(err)
+}
+
+// missingROMError is returned by open for a file saved for sharing that was
+// made with another ROM than ours, see locateROM.
+type missingROMError struct {
+	hash [sha256.Size]byte
+}
+
+func (missingROMError) Error() string {
+	return "the file does not contain the ROM, select the ROM to load it"
+}
+
+// open loads the session file at path. located is the ROM that the user
+// selected for a file saved for sharing, nil if there is none yet.
+func (state *editorState) open(path string, located []byte) error {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return err
@@ -1909,10 +2790,59 @@ func (state *editorState) open(path string) error {
 		)
 	}
 
+	// Since version 13 the file ends in a CRC32 of everything before it.
+	// If it does not match, the file is damaged and we salvage what we can.
+	// damage describes the first part of the file that we could not read.
+	checksumOK := true
+	damage := ""
+	if fileVersion >= 13 {
+		if len(data) < 8 {
+			return fmt.Errorf("short read: the checksum is missing")
+		}
+		payload := data[:len(data)-4]
+		if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(data[len(payload):]) {
+			checksumOK = false
+		}
+		rest = rest[:len(rest)-4]
+	}
+
+	// count reads a number of items that each take at least minSize bytes in
+	// the file. A damaged file could otherwise make us allocate huge slices.
+	count := func(minSize int) int {
+		c := n()
+		if loadErr == nil && (c < 0 || c*minSize > len(rest)) {
+			loadErr = fmt.Errorf("invalid count %d with only %d bytes left", c, len(rest))
+			return 0
+		}
+		return c
+	}
+
+	// rom only replaces our ROM once the whole file is loaded, a file that
+	// fails to load leaves the current session as it is.
+	rom := state.rom
 	if fileVersion >= 2 {
-		romSize := n()
-		globalROM = make([]byte, romSize)
-		v(globalROM)
+		romSize := count(1)
+		rom = make([]byte, romSize)
+		v(rom)
+	}
+
+	if fileVersion >= 14 {
+		// Files saved for sharing only have the ROM's hash, the user has to
+		// provide the ROM.
+		var romHash [sha256.Size]byte
+		v(&romHash)
+		if loadErr == nil && len(rom) == 0 {
+			switch {
+			case len(located) > 0 && sha256.Sum256(located) == romHash:
+				rom = located
+			case len(state.rom) > 0 && sha256.Sum256(state.rom) == romHash:
+				rom = state.rom
+			default:
+				return missingROMError{hash: romHash}
+			}
+		} else if loadErr == nil && sha256.Sum256(rom) != romHash {
+			return fmt.Errorf("the ROM in the file is damaged, it does not match its hash")
+		}
 	}
 
 	leftMostFrameTemp := n()
@@ -1924,6 +2854,11 @@ func (state *editorState) open(path string) error {
 		scaleFactorTemp = float64(f())
 	}
 
+	// Without the ROM and the first branch there is nothing to salvage.
+	if loadErr != nil {
+		return loadErr
+	}
+
 	branchIndexTemp := 0
 	var branchesTemp []branch
 	if fileVersion < 3 {
@@ -1934,14 +2869,14 @@ func (state *editorState) open(path string) error {
 		branch.name = "Branch 1"
 		branch.highlightFrameIndex = -1
 		branch.defaultInputs = inputState(b())
-		branch.frameInputs = make([]inputState, n())
+		branch.frameInputs = make([]inputState, count(1))
 		for i := range branch.frameInputs {
 			branch.frameInputs[i] = inputState(b())
 		}
 	} else {
 		// This version supports multiple branches.
 		branchIndexTemp = n()
-		branchesTemp = make([]branch, n())
+		branchesTemp = make([]branch, count(9))
 		for i := range branchesTemp {
 			branch := &branchesTemp[i]
 			branch.name = s()
@@ -1950,37 +2885,341 @@ func (state *editorState) open(path string) error {
 				branch.highlightFrameIndex = n()
 			}
 			branch.defaultInputs = inputState(b())
-			branch.frameInputs = make([]inputState, n())
+			branch.frameInputs = make([]inputState, count(1))
 			for i := range branch.frameInputs {
 				branch.frameInputs[i] = inputState(b())
 			}
+
+			if loadErr != nil && i > 0 {
+				// Keep the branches before the damaged one.
+				branchesTemp = branchesTemp[:i]
+				damage = fmt.Sprintf("only %d branches could be read: %v", i, loadErr)
+				break
+			}
+		}
+	}
+	if loadErr != nil && damage == "" {
+		// The first branch is damaged.
+		return loadErr
+	}
+
+	// The rest of the file is read section by section. If one is damaged, it
+	// and all sections after it keep their defaults.
+	intact := func(section string) bool {
+		if loadErr != nil && damage == "" {
+			damage = fmt.Sprintf("the %s could not be read: %v", section, loadErr)
+		}
+		return loadErr == nil
+	}
+
+	metadataTemp := sessionMetadata{gameTitle: romTitle(rom)}
+	if fileVersion >= 6 {
+		var m sessionMetadata
+		m.author = s()
+		m.gameTitle = s()
+		if created := s(); created != "" {
+			m.created, _ = time.Parse(time.RFC3339, created)
+		}
+		m.rerecordCount = n()
+		if intact("metadata") {
+			metadataTemp = m
+		}
+	}
+
+	comboPolicyTemp := allowCombos
+	if fileVersion >= 7 {
+		comboPolicyTemp = comboPolicy(b())
+		if comboPolicyTemp >= comboPolicyCount || !intact("combo policy") {
+			comboPolicyTemp = allowCombos
+		}
+	}
+
+	if fileVersion >= 8 {
+		// Frame events are rare so we only store the frames that have any.
+		type frameEvent struct {
+			branch, frame int
+			events        inputState
+		}
+		var events []frameEvent
+		for i := range branchesTemp {
+			eventCount := count(5)
+			for range eventCount {
+				frame := n()
+				e := inputState(b()) << 8 & frameEvents
+				events = append(events, frameEvent{branch: i, frame: frame, events: e})
+			}
+		}
+		if intact("frame events") {
+			for _, e := range events {
+				branch := &branchesTemp[e.branch]
+				if 0 <= e.frame && e.frame < len(branch.frameInputs) {
+					branch.frameInputs[e.frame] |= e.events
+				}
+			}
+		}
+	}
+
+	paletteIndexTemp := 0
+	customPaletteTemp := dmgPalettes[0].colors
+	if fileVersion >= 9 {
+		paletteIndexTemp = int(b())
+		if paletteIndexTemp > customPaletteIndex {
+			paletteIndexTemp = 0
+		}
+		v(&customPaletteTemp)
+		if !intact("palette") {
+			paletteIndexTemp = 0
+			customPaletteTemp = dmgPalettes[0].colors
+		}
+	}
+
+	if fileVersion >= 10 {
+		splits := make([][]split, len(branchesTemp))
+		for i := range splits {
+			splits[i] = make([]split, count(8))
+			for j := range splits[i] {
+				splits[i][j].frameIndex = n()
+				splits[i][j].name = s()
+			}
+		}
+		if intact("splits") {
+			for i := range branchesTemp {
+				branchesTemp[i].splits = splits[i]
+			}
+		}
+	}
+
+	// Older versions had no console models and booted with their own mix of
+	// DMG and CGB register values, which the legacy model reproduces.
+	modelTemp := gameboy.ModelLegacy
+	if fileVersion >= 11 {
+		modelTemp = gameboy.ConsoleModel(b())
+		if modelTemp >= gameboy.ConsoleModelCount || !intact("console model") {
+			modelTemp = gameboy.ModelDMG
+		}
+	}
+
+	if fileVersion >= 15 {
+		colors := make([]int, len(branchesTemp))
+		for i := range colors {
+			colors[i] = int(b())
+			if colors[i] >= len(branchColors) {
+				colors[i] = 0
+			}
+		}
+		if intact("branch colors") {
+			for i := range branchesTemp {
+				branchesTemp[i].color = colors[i]
+			}
+		}
+	}
+
+	if fileVersion >= 16 {
+		locked := make([]bool, len(branchesTemp))
+		for i := range locked {
+			locked[i] = b() != 0
+		}
+		if intact("branch locks") {
+			for i := range branchesTemp {
+				branchesTemp[i].locked = locked[i]
+			}
+		}
+	}
+
+	var ramMapTemp ramMap
+	if fileVersion >= 17 {
+		names := ramMap{}
+		for range count(8) {
+			address := n()
+			names[uint16(address)] = s()
+		}
+		if intact("RAM map") && len(names) > 0 {
+			ramMapTemp = names
+		}
+	}
+
+	var snapshotsTemp []inputSnapshot
+	if fileVersion >= 18 {
+		snapshots := make([]inputSnapshot, count(10))
+		for i := range snapshots {
+			snapshot := &snapshots[i]
+			snapshot.name = s()
+			snapshot.defaultInputs = inputState(b()) | inputState(b())<<8
+			snapshot.frameInputs = make([]inputState, count(2))
+			for i := range snapshot.frameInputs {
+				snapshot.frameInputs[i] = inputState(b()) | inputState(b())<<8
+			}
+		}
+		if intact("snapshots") {
+			snapshotsTemp = snapshots
+		}
+	}
+
+	if fileVersion >= 19 {
+		subframes := make([]map[int][]subframeInput, len(branchesTemp))
+		for i := range subframes {
+			for range count(8) {
+				frame := n()
+				changes := make([]subframeInput, count(6))
+				for j := range changes {
+					changes[j].fromPoll = int32(n())
+					changes[j].inputs = inputState(b()) | inputState(b())<<8
+				}
+				if subframes[i] == nil {
+					subframes[i] = make(map[int][]subframeInput)
+				}
+				subframes[i][frame] = changes
+			}
+		}
+		if intact("subframe inputs") {
+			for i := range branchesTemp {
+				branchesTemp[i].subframeInputs = subframes[i]
+			}
+		}
+	}
+
+	if fileVersion >= 20 {
+		anchors := make([][]syncAnchor, len(branchesTemp))
+		for i := range anchors {
+			anchors[i] = make([]syncAnchor, count(13))
+			for j := range anchors[i] {
+				anchors[i][j].frameIndex = n()
+				v(&anchors[i][j].screenHash)
+				anchors[i][j].desynced = b() != 0
+			}
+		}
+		if intact("sync anchors") {
+			for i := range branchesTemp {
+				branchesTemp[i].anchors = anchors[i]
+			}
+		}
+	}
+
+	var pollCadenceTemp pollCadence
+	if fileVersion >= 21 {
+		c := pollCadence{every: int(b()), phase: int(b())}
+		valid := c.every <= maxPollCadence && (c.every <= 1 && c.phase == 0 || c.phase < c.every)
+		if valid && intact("poll cadence") {
+			pollCadenceTemp = c
+		}
+	}
+
+	var startTemp sessionStart
+	startFileTemp := ""
+	if fileVersion >= 22 {
+		// Version 22 could only start from a savestate.
+		var start sessionStart
+		if fileVersion >= 23 {
+			start.kind = startKind(b())
+		}
+		name := s()
+		start.data = make([]byte, count(1))
+		v(start.data)
+		if fileVersion < 23 && len(start.data) > 0 {
+			start.kind = startFromSavestate
+		}
+		if loadErr == nil && start.kind >= startKindCount {
+			loadErr = fmt.Errorf("unknown session start %d", start.kind)
+		}
+		if loadErr == nil && start.kind != startAtPowerOn {
+			_, loadErr = start.gameboy(rom, gameboy.GameboyOptions{Model: modelTemp})
+		}
+		if intact("session start") && start.kind != startAtPowerOn {
+			startTemp = start
+			startFileTemp = name
+		}
+	}
+
+	if fileVersion >= 25 {
+		subframes := make([]map[int][]subframeInput, len(snapshotsTemp))
+		for i := range subframes {
+			for range count(8) {
+				frame := n()
+				changes := make([]subframeInput, count(6))
+				for j := range changes {
+					changes[j].fromPoll = int32(n())
+					changes[j].inputs = inputState(b()) | inputState(b())<<8
+				}
+				if subframes[i] == nil {
+					subframes[i] = make(map[int][]subframeInput)
+				}
+				subframes[i][frame] = changes
+			}
+		}
+		if intact("snapshot subframe inputs") {
+			for i := range snapshotsTemp {
+				snapshotsTemp[i].subframeInputs = subframes[i]
+			}
 		}
 	}
 
 	haveKeyFrameInterval := n()
 	haveGameboyStateVersion := n()
-	var keyFrameStatesTemp []Gameboy
-	if haveKeyFrameInterval == keyFrameInterval &&
-		haveGameboyStateVersion == gameboyStateVersion {
-		// The binary Gameboy state on disk might be old. We might have changed
-		// the Gameboy struct. After a change we will have incremented
-		// gameboyStateVersion so in that case we do NOT read the key frames
-		// from disk. In that case we need to re-generate them.
-		keyFrameStatesTemp = make([]Gameboy, n())
+	var keyFrameStatesTemp []keyFrame
+	var workingAreaStatesTemp []cachedState
+	if checksumOK && damage == "" &&
+		haveKeyFrameInterval == keyFrameInterval &&
+		haveGameboyStateVersion == gameboy.StateVersion {
+		// The emulator might behave differently than the one that created
+		// the key frames. After such a change we will have incremented
+		// gameboy.StateVersion so in that case we do NOT read the key frames
+		// from disk. In that case we need to re-generate them. We also do not
+		// trust the key frames of damaged files.
+		// loadState reads the size of a saved Gameboy state and the state.
+		// All loaded states share one copy of the ROM.
+		romData := string(rom)
+		loadState := func(gb *gameboy.Gameboy, what string) bool {
+			size := n()
+			if loadErr != nil {
+				return false
+			}
+			if size < 0 || size > len(rest) {
+				loadErr = fmt.Errorf("short read: %s is longer than remaining bytes", what)
+				return false
+			}
+			*gb, loadErr = gameboy.LoadState(romData, rest[:size])
+			rest = rest[size:]
+			return loadErr == nil
+		}
+		keyFrameStatesTemp = make([]keyFrame, count(1))
 		for i := range keyFrameStatesTemp {
-			v(&keyFrameStatesTemp[i])
+			var gb gameboy.Gameboy
+			if !loadState(&gb, "key frame") {
+				break
+			}
+			keyFrameStatesTemp[i] = newKeyFrame(gb)
+
+			// Do not run out of memory while loading a long run.
+			if i%64 == 63 {
+				compressKeyFrames(keyFrameStatesTemp[:i+1], memoryLimit())
+			}
+		}
+		if !intact("key frames") {
+			keyFrameStatesTemp = nil
 		}
-	}
 
-	if !(0 <= branchIndexTemp && branchIndexTemp < len(branchesTemp)) {
-		loadErr = fmt.Errorf(
-			"invalid branch index %d %d branches exist",
-			branchIndexTemp, len(branchesTemp),
-		)
+		if fileVersion >= 24 {
+			workingAreaStatesTemp = make([]cachedState, count(8))
+			for i := range workingAreaStatesTemp {
+				workingAreaStatesTemp[i].frameIndex = n()
+				if !loadState(&workingAreaStatesTemp[i].gameboy, "cached state") {
+					break
+				}
+			}
+			if !intact("cached states") {
+				workingAreaStatesTemp = nil
+			}
+		}
 	}
 
-	if loadErr != nil {
-		return loadErr
+	if !(0 <= branchIndexTemp && branchIndexTemp < len(branchesTemp)) {
+		if damage == "" {
+			return fmt.Errorf(
+				"invalid branch index %d %d branches exist",
+				branchIndexTemp, len(branchesTemp),
+			)
+		}
+		branchIndexTemp = 0
 	}
 
 	state.leftMostFrame = leftMostFrameTemp
@@ -1989,58 +3228,133 @@ func (state *editorState) open(path string) error {
 	state.scaleFactor = scaleFactorTemp
 	state.branchIndex = branchIndexTemp
 	state.branches = branchesTemp
+	state.rom = rom
 	state.keyFrameStates = keyFrameStatesTemp
-
+	state.metadata = metadataTemp
+	state.comboPolicy = comboPolicyTemp
+	state.pollCadence = pollCadenceTemp
+	state.start = startTemp
+	state.metadata.startFile = startFileTemp
+	state.paletteIndex = paletteIndexTemp
+	state.customPalette = customPaletteTemp
+	state.gameboyOptions.Model = modelTemp
+	state.ramMap = ramMapTemp
+	state.splitNameTemplate = loadGameProfiles()[romKey(rom)].SplitNames
+	state.snapshots = snapshotsTemp
+	state.lastSnapshot = ""
+
+	state.cancelKeyFrameRebuild()
+	state.cancelThumbnails()
 	state.frameCache.clear()
+	frameCount := len(state.branch().frameInputs)
+	for _, c := range workingAreaStatesTemp {
+		if 0 <= c.frameIndex && c.frameIndex < frameCount {
+			state.frameCache.set(c.frameIndex, c.gameboy)
+		}
+	}
+	state.enforceMemoryLimit()
+	state.invalidateScreenTilesFrom(0)
 	state.dragStartFrame = -1
 	state.doubleClickPending = false
 	state.controlWasDown = false
 	state.keyRepeatCountdown = 0
 	state.draggingFrameIndex = -1
+	state.draggingBranch = -1
+	state.previewPanes = nil
 	state.lastLeftClick = mouseClick{}
 	state.lastAction = inputAction{}
+	state.lastActionReleased = nil
 	state.replayingGame = false
 	state.replayPaused = false
+	state.unsavedChanges = false
 	state.infoText = ""
+	if damage != "" {
+		state.setWarning("The file is damaged, " + damage + ".")
+	} else if !checksumOK {
+		state.setWarning("The file is damaged, its checksum does not match. Key frames are rebuilt.")
+	}
 
 	return nil
 }
 
 func (s *editorState) loadLastSpeedrun() {
-	err := s.open(lastSessionPath())
+	err := s.open(lastSessionPath(), nil)
 	if err != nil {
 		fmt.Println("loading last session failed:", err)
+	} else {
+		s.rebuildMissingKeyFrames()
 	}
 }
 
 func (s *editorState) saveFile() error {
-	path, err := dialog.File().
-		Title("Save Speedrun").
-		Filter("GameBoy Speedrun", "speedrun").
-		Save()
-
-	if err != nil {
-		// User cancelled the dialog.
+	s.startSaveDialog("Save Speedrun", "GameBoy Speedrun", "speedrun", func(path string) error {
+		s.startSave(path, saveEverything)
 		return nil
-	}
+	})
+	return nil
+}
 
-	if !strings.HasSuffix(strings.ToLower(path), ".speedrun") {
-		path += ".speedrun"
-	}
+// saveInputsOnly saves the session without key frames. The file is much
+// smaller, the key frames are rebuilt in the background after loading it.
+func (s *editorState) saveInputsOnly() error {
+	s.startSaveDialog("Save Speedrun Without Key Frames", "GameBoy Speedrun", "speedrun", func(path string) error {
+		s.startSave(path, saveROM)
+		return nil
+	})
+	return nil
+}
 
-	err = s.save(path)
-	if err != nil {
-		return fmt.Errorf("failed to save '%s': %w", path, err)
-	}
+// saveForSharing saves the session without the ROM and key frames, so runs
+// can be shared without distributing the game. Key frames hold the game's
+// graphics and code in RAM as well.
+func (s *editorState) saveForSharing() error {
+	s.startSaveDialog("Save Speedrun for Sharing", "GameBoy Speedrun", "speedrun", func(path string) error {
+		s.startSave(path, 0)
+		return nil
+	})
 	return nil
 }
 
+// locateROM lets the user select the ROM file with the given SHA-256 hash.
+// located is called with the ROM if it is the right one.
+func (s *editorState) locateROM(hash [sha256.Size]byte, located func(rom []byte) error) {
+	s.startLoadDialog("Locate the ROM for this Speedrun", "GameBoy ROM", []string{"gb", "gbc", "bin"}, func(path string) error {
+		rom, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if sha256.Sum256(rom) != hash {
+			return fmt.Errorf("'%s' is not the ROM that the run was made with", path)
+		}
+		return located(rom)
+	})
+}
+
+// save writes the session file on the UI thread. Use startSave to save in the
+// background.
 func (state *editorState) save(path string) error {
-	// Create a buffer and helper functions:
+	session := state.sessionSnapshot(saveEverything)
+	return writeSessionFile(path, func(w io.Writer) error {
+		return session.writeSession(w, state.rom, true, state.gameboyOptions.Model, nil)
+	})
+}
+
+// writeSession serializes the session. Without embedROM, only the ROM's hash
+// is saved. progress is called with the number of key frames written so far,
+// it can return an error to stop writing.
+func (state *editorState) writeSession(
+	w io.Writer,
+	rom []byte,
+	embedROM bool,
+	model gameboy.ConsoleModel,
+	progress func(keyFrames int) error,
+) error {
+	// Create helper functions:
 	// n() saves a number as uint32
 	// b() saves a single byte
 	// v() saves an arbitrary value.
-	var buf bytes.Buffer
+	checksum := crc32.NewIEEE()
+	buf := bufio.NewWriter(io.MultiWriter(w, checksum))
 	var saveErr error
 	setErr := func(err error) {
 		if saveErr == nil {
@@ -2048,7 +3362,7 @@ func (state *editorState) save(path string) error {
 		}
 	}
 	n := func(n int) {
-		setErr(binary.Write(&buf, binary.LittleEndian, int32(n)))
+		setErr(binary.Write(buf, binary.LittleEndian, int32(n)))
 	}
 	b := func(b byte) {
 		setErr(buf.WriteByte(b))
@@ -2061,16 +3375,21 @@ func (state *editorState) save(path string) error {
 	}
 	f := func(x float32) {
 		n := math.Float32bits(x)
-		setErr(binary.Write(&buf, binary.LittleEndian, n))
+		setErr(binary.Write(buf, binary.LittleEndian, n))
 	}
 	v := func(x any) {
-		setErr(binary.Write(&buf, binary.LittleEndian, x))
+		setErr(binary.Write(buf, binary.LittleEndian, x))
 	}
 
 	// Serialize the data.
 	n(sessionFileVersion)
-	n(len(globalROM))
-	v(globalROM)
+	if embedROM {
+		n(len(rom))
+		v(rom)
+	} else {
+		n(0)
+	}
+	v(sha256.Sum256(rom))
 	n(state.leftMostFrame)
 	n(state.activeSelection.first)
 	n(state.activeSelection.last)
@@ -2087,63 +3406,157 @@ func (state *editorState) save(path string) error {
 			b(byte(inputs))
 		}
 	}
+	s(state.metadata.author)
+	s(state.metadata.gameTitle)
+	created := ""
+	if !state.metadata.created.IsZero() {
+		created = state.metadata.created.Format(time.RFC3339)
+	}
+	s(created)
+	n(state.metadata.rerecordCount)
+	b(byte(state.comboPolicy))
+	for i := range state.branches {
+		branch := &state.branches[i]
+		eventCount := 0
+		for _, inputs := range branch.frameInputs {
+			if inputs&frameEvents != 0 {
+				eventCount++
+			}
+		}
+		n(eventCount)
+		for frame, inputs := range branch.frameInputs {
+			if inputs&frameEvents != 0 {
+				n(frame)
+				b(byte(inputs >> 8))
+			}
+		}
+	}
+	b(byte(state.paletteIndex))
+	v(state.customPalette)
+	for i := range state.branches {
+		branch := &state.branches[i]
+		n(len(branch.splits))
+		for _, sp := range branch.splits {
+			n(sp.frameIndex)
+			s(sp.name)
+		}
+	}
+	b(byte(model))
+	for i := range state.branches {
+		b(byte(state.branches[i].color))
+	}
+	for i := range state.branches {
+		locked := byte(0)
+		if state.branches[i].locked {
+			locked = 1
+		}
+		b(locked)
+	}
+	n(len(state.ramMap))
+	for _, address := range slices.Sorted(maps.Keys(state.ramMap)) {
+		n(int(address))
+		s(state.ramMap[address])
+	}
+	n(len(state.snapshots))
+	for _, snapshot := range state.snapshots {
+		s(snapshot.name)
+		b(byte(snapshot.defaultInputs))
+		b(byte(snapshot.defaultInputs >> 8))
+		n(len(snapshot.frameInputs))
+		for _, inputs := range snapshot.frameInputs {
+			b(byte(inputs))
+			b(byte(inputs >> 8))
+		}
+	}
+	for i := range state.branches {
+		branch := &state.branches[i]
+		n(len(branch.subframeInputs))
+		for _, frame := range branch.subframeFrames() {
+			changes := branch.subframeInputs[frame]
+			n(frame)
+			n(len(changes))
+			for _, c := range changes {
+				n(int(c.fromPoll))
+				b(byte(c.inputs))
+				b(byte(c.inputs >> 8))
+			}
+		}
+	}
+	for i := range state.branches {
+		branch := &state.branches[i]
+		n(len(branch.anchors))
+		for _, a := range branch.anchors {
+			n(a.frameIndex)
+			v(a.screenHash)
+			desynced := byte(0)
+			if a.desynced {
+				desynced = 1
+			}
+			b(desynced)
+		}
+	}
+	b(byte(state.pollCadence.every))
+	b(byte(state.pollCadence.phase))
+	b(byte(state.start.kind))
+	s(state.metadata.startFile)
+	n(len(state.start.data))
+	v(state.start.data)
+	for _, snapshot := range state.snapshots {
+		n(len(snapshot.subframeInputs))
+		for _, frame := range slices.Sorted(maps.Keys(snapshot.subframeInputs)) {
+			changes := snapshot.subframeInputs[frame]
+			n(frame)
+			n(len(changes))
+			for _, c := range changes {
+				n(int(c.fromPoll))
+				b(byte(c.inputs))
+				b(byte(c.inputs >> 8))
+			}
+		}
+	}
 	n(keyFrameInterval)
-	n(gameboyStateVersion)
+	n(gameboy.StateVersion)
 	n(len(state.keyFrameStates))
-	for _, frame := range state.keyFrameStates {
-		v(frame)
+	for i := range state.keyFrameStates {
+		gb := state.keyFrameStates[i].gameboy()
+		data := gb.SaveState()
+		n(len(data))
+		v(data)
+		if progress != nil && saveErr == nil {
+			setErr(progress(i + 1))
+		}
 	}
-
-	if saveErr == nil {
-		setErr(os.WriteFile(path, buf.Bytes(), 0666))
+	n(len(state.workingAreaStates))
+	for _, c := range state.workingAreaStates {
+		n(c.frameIndex)
+		data := c.gameboy.SaveState()
+		n(len(data))
+		v(data)
 	}
 
+	setErr(buf.Flush())
+
+	// The checksum of everything before it comes last.
+	setErr(binary.Write(w, binary.LittleEndian, checksum.Sum32()))
+
 	return saveErr
 }
 
 func (s *editorState) saveCurrentSpeedrun() {
+	if s.saving != nil {
+		// We are closing, nothing replaces the current speedrun anymore.
+		s.saving.afterSave = nil
+	}
+	s.waitForSave()
+	s.rememberGameProfile()
 	err := s.save(lastSessionPath())
 	if err != nil {
 		fmt.Println("saving current session failed:", err)
+	} else {
+		s.addToLibrary(lastSessionPath())
 	}
 }
 
-func (state *editorState) checkFrames(upTo int) {
-	// TODO Remove debug code from final product.
-
-	fmt.Println("checking states up to frame", upTo)
-
-	branch := state.branch()
-
-	wantGB := NewGameboy(globalROM, GameboyOptions{})
-	for i := range upTo + 1 {
-		inputs := branch.frameInputs[i]
-
-		for b := range buttonCount {
-			if isButtonDown(inputs, b) {
-				wantGB.PressButton(b)
-			} else {
-				wantGB.ReleaseButton(b)
-			}
-		}
-
-		wantGB.Update()
-	}
-
-	haveGB := state.generateFrame(upTo)
-
-	var have, want bytes.Buffer
-	binary.Write(&have, binary.LittleEndian, &haveGB)
-	binary.Write(&want, binary.LittleEndian, &wantGB)
-	if !bytes.Equal(have.Bytes(), want.Bytes()) {
-		panic("Gameboys are not equal")
-	}
-
-	fmt.Println("no problems encountered")
-	state.setInfo("no problems encountered")
-	state.render()
-}
-
 func startProfiling() {
 	path := time.Now().Format("profile_2006_01_02_15_04_05.prof")
 	f, err := os.Create(path)
@@ -2177,13 +3590,22 @@ func getRomPath() (string, error) {
 		Load()
 }
 
-type inputState byte
+// inputState holds the Gameboy buttons in the lower 8 bits, one bit per Button.
+// The upper bits hold events that happen at the start of a frame.
+type inputState uint16
+
+const (
+	resetEvent inputState = 1 << (8 + iota)
+	powerCycleEvent
 
-func isButtonDown(s inputState, b Button) bool {
+	frameEvents = resetEvent | powerCycleEvent
+)
+
+func isButtonDown(s inputState, b gameboy.Button) bool {
 	return s&(1<<b) != 0
 }
 
-func setButtonDown(s *inputState, b Button, down bool) {
+func setButtonDown(s *inputState, b gameboy.Button, down bool) {
 	if down {
 		*s |= 1 << b
 	} else {
@@ -2191,7 +3613,7 @@ func setButtonDown(s *inputState, b Button, down bool) {
 	}
 }
 
-func toggleButton(s *inputState, b Button) {
+func toggleButton(s *inputState, b gameboy.Button) {
 	setButtonDown(s, b, !isButtonDown(*s, b))
 }
 
@@ -2199,11 +3621,9 @@ func newFrameCache() *frameCache {
 	return &frameCache{}
 }
 
-const frameCacheSize = 500
-
 type frameCache struct {
 	frameIndices      []int
-	gameboys          []Gameboy
+	gameboys          []gameboy.Gameboy
 	nextIndexToRemove int
 }
 
@@ -2231,7 +3651,7 @@ func (c *frameCache) clear() {
 // be the Gameboy at frameIndex and frameIndex; if the frame right before that
 // is cached, it will be the Gameboy right before frameIndex and frameIndex-1,
 // and so on.
-func (c *frameCache) latestFrameUpTo(frameIndex int) (Gameboy, int) {
+func (c *frameCache) latestFrameUpTo(frameIndex int) (gameboy.Gameboy, int) {
 	bestIndex := -1
 	bestFrameIndex := -1
 
@@ -2243,25 +3663,25 @@ func (c *frameCache) latestFrameUpTo(frameIndex int) (Gameboy, int) {
 	}
 
 	if bestIndex == -1 {
-		return Gameboy{}, -1
+		return gameboy.Gameboy{}, -1
 	}
 
 	return c.gameboys[bestIndex], c.frameIndices[bestIndex]
 }
 
-func (c *frameCache) set(frameIndex int, gb Gameboy) {
+func (c *frameCache) set(frameIndex int, gb gameboy.Gameboy) {
 	i := slices.Index(c.frameIndices, frameIndex)
 	if i != -1 {
 		c.gameboys[i] = gb
 	} else {
-		if len(c.gameboys) < frameCacheSize {
+		if len(c.gameboys) < int(globalSettings.FrameCacheSize) {
 			c.frameIndices = append(c.frameIndices, frameIndex)
 			c.gameboys = append(c.gameboys, gb)
 		} else {
 			j := c.nextIndexToRemove
 			c.frameIndices[j] = frameIndex
 			c.gameboys[j] = gb
-			c.nextIndexToRemove = (c.nextIndexToRemove + 1) % frameCacheSize
+			c.nextIndexToRemove = (c.nextIndexToRemove + 1) % len(c.gameboys)
 		}
 	}
 }
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// mergeConflict is a range of frames in which a branch of the other session
+// has different inputs than our branch of the same name.
+type mergeConflict struct {
+	branchIndex int
+	first, last int
+	// theirs are the other branch's inputs for the frames first to last.
+	theirs []inputState
+	// theirSubframes are the other branch's subframe inputs for the frames
+	// first to last, keyed by the frame index.
+	theirSubframes map[int][]subframeInput
+}
+
+const (
+	mergeKeepOurs = iota
+	mergeTakeTheirs
+	mergeKeepOursForAll
+	mergeTakeTheirsForAll
+)
+
+// mergeSession asks for another speedrun file of the same ROM and imports its
+// branches. Branches with new names are copied. For branches that we have as
+// well, the user decides for every range of differing frames whose inputs to
+// keep.
+func (s *editorState) mergeSession() error {
+	s.startLoadDialog("Merge Speedrun", "GameBoy Speedrun", []string{"speedrun"}, func(path string) error {
+		return s.loadOtherSession(path, func(other *editorState) error {
+			if sha256.Sum256(other.rom) != sha256.Sum256(s.rom) {
+				return errors.New("the speedrun to merge was made with a different ROM")
+			}
+			if !other.start.equal(s.start) {
+				return errors.New("the speedrun to merge has a different start")
+			}
+
+			imported := 0
+			var conflicts []mergeConflict
+			for _, theirs := range other.branches {
+				i := slices.IndexFunc(s.branches, func(b branch) bool { return b.name == theirs.name })
+				if i == -1 {
+					s.branches = append(s.branches, theirs)
+					imported++
+					continue
+				}
+
+				if s.branches[i].locked {
+					// We do not touch our locked branch, theirs is imported
+					// under a new name instead.
+					theirs.name += " (merged)"
+					s.branches = append(s.branches, theirs)
+					imported++
+					continue
+				}
+
+				conflicts = append(conflicts, findMergeConflicts(i, &s.branches[i], &theirs)...)
+			}
+
+			if imported > 0 {
+				s.unsavedChanges = true
+			}
+			s.resolveMergeConflicts(conflicts, 0, -1, imported, 0)
+			return nil
+		})
+	})
+	return nil
+}
+
+// findMergeConflicts returns the ranges of frames in which the inputs or
+// subframe inputs of ours and theirs differ. Frames after the end of a branch
+// have its default inputs.
+func findMergeConflicts(branchIndex int, ours, theirs *branch) []mergeConflict {
+	inputsAt := func(b *branch, i int) inputState {
+		if i < len(b.frameInputs) {
+			return b.frameInputs[i]
+		}
+		return b.defaultInputs
+	}
+
+	end := max(len(ours.frameInputs), len(theirs.frameInputs))
+	subframesDiffer := make(map[int]bool)
+	for _, frameIndex := range subframeDifferences(ours.subframeInputs, theirs.subframeInputs) {
+		subframesDiffer[frameIndex] = true
+		end = max(end, frameIndex+1)
+	}
+
+	var conflicts []mergeConflict
+	for i := range end {
+		in := inputsAt(theirs, i)
+		if inputsAt(ours, i) == in && !subframesDiffer[i] {
+			continue
+		}
+		n := len(conflicts)
+		if n == 0 || conflicts[n-1].last != i-1 {
+			conflicts = append(conflicts, mergeConflict{
+				branchIndex:    branchIndex,
+				first:          i,
+				last:           i - 1,
+				theirSubframes: make(map[int][]subframeInput),
+			})
+			n++
+		}
+		c := &conflicts[n-1]
+		c.last = i
+		c.theirs = append(c.theirs, in)
+		if changes, ok := theirs.subframeInputs[i]; ok {
+			c.theirSubframes[i] = slices.Clone(changes)
+		}
+	}
+	return conflicts
+}
+
+// resolveMergeConflicts asks the user about conflicts[next] and continues with
+// the next one after the dialog. Once the user decided for all remaining
+// conflicts, forAll is mergeKeepOurs or mergeTakeTheirs, before that it is -1.
+// Cancelling the dialog keeps our inputs in the remaining conflicts.
+func (s *editorState) resolveMergeConflicts(conflicts []mergeConflict, next, forAll, imported, taken int) {
+	s.beginEdit()
+	for next < len(conflicts) && forAll != -1 {
+		if forAll == mergeTakeTheirs {
+			s.takeTheirInputs(conflicts[next])
+			taken++
+		}
+		next++
+	}
+	s.endEdit()
+
+	if next == len(conflicts) {
+		s.setInfo(fmt.Sprintf(
+			"Merged: imported %d branches, took their inputs in %d of %d differing ranges.",
+			imported, taken, len(conflicts),
+		))
+		s.render()
+		return
+	}
+
+	c := conflicts[next]
+	s.startModalListDialog(
+		fmt.Sprintf(
+			"\"%s\" differs in frames %d to %d (conflict %d of %d)",
+			s.branches[c.branchIndex].name, c.first, c.last, next+1, len(conflicts),
+		),
+		[]string{
+			"Keep our inputs",
+			"Take their inputs",
+			"Keep our inputs for all remaining conflicts",
+			"Take their inputs for all remaining conflicts",
+		},
+		mergeKeepOurs,
+		func(option int) {
+			switch option {
+			case mergeTakeTheirs:
+				s.takeTheirInputs(c)
+				taken++
+			case mergeKeepOursForAll:
+				forAll = mergeKeepOurs
+			case mergeTakeTheirsForAll:
+				forAll = mergeTakeTheirs
+				s.takeTheirInputs(c)
+				taken++
+			}
+			s.resolveMergeConflicts(conflicts, next+1, forAll, imported, taken)
+		},
+	)
+}
+
+func (s *editorState) takeTheirInputs(c mergeConflict) {
+	b := &s.branches[c.branchIndex]
+	for len(b.frameInputs) <= c.last {
+		b.frameInputs = append(b.frameInputs, b.defaultInputs)
+	}
+	copy(b.frameInputs[c.first:], c.theirs)
+	for i := c.first; i <= c.last; i++ {
+		if changes, ok := c.theirSubframes[i]; ok {
+			if b.subframeInputs == nil {
+				b.subframeInputs = make(map[int][]subframeInput)
+			}
+			b.subframeInputs[i] = slices.Clone(changes)
+		} else {
+			delete(b.subframeInputs, i)
+		}
+	}
+
+	// Only the active branch is emulated.
+	if c.branchIndex == s.branchIndex {
+		s.setDirtyFrame(c.first)
+	} else {
+		s.unsavedChanges = true
+	}
+}
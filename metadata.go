@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// sessionMetadata describes a speedrun session. Movie formats and TAS
+// submissions require this information.
+type sessionMetadata struct {
+	author    string
+	gameTitle string
+	created   time.Time
+	// rerecordCount is incremented once for every edit that forces us to
+	// throw away emulated states, i.e. every time we go back in time, see
+	// endEdit.
+	rerecordCount int
+	// startFile is the file name of the battery save or savestate that the
+	// session starts from, empty if it starts at power on.
+	startFile string
+}
+
+func newSessionMetadata(rom []byte) sessionMetadata {
+	return sessionMetadata{
+		gameTitle: romTitle(rom),
+		created:   time.Now(),
+	}
+}
+
+func (m *sessionMetadata) createdText() string {
+	if m.created.IsZero() {
+		return "unknown"
+	}
+	return m.created.Format("2006-01-02 15:04")
+}
+
+func (s *editorState) showSessionInfo() {
+	m := &s.metadata
+
+	author := m.author
+	if author == "" {
+		author = "unknown"
+	}
+
+	cartridge := "unknown"
+	if h, ok := parseROMHeader(s.rom); ok {
+		cartridge = h.summary()
+	}
+
+	s.startModalMessageDialog("Session Info", fmt.Sprintf(
+		"Game: %s\nCartridge: %s\nStarts from: %s\nAuthor: %s\nCreated: %s\nRerecords: %d\nBranches: %d\nFrames in \"%s\": %d",
+		m.gameTitle,
+		cartridge,
+		s.startText(),
+		author,
+		m.createdText(),
+		m.rerecordCount,
+		len(s.branches),
+		s.branch().name,
+		len(s.branch().frameInputs),
+	))
+}
+
+func (s *editorState) startModalAuthorDialog() {
+	s.startModalTextDialog("Enter Author Name", s.metadata.author, func(author string) {
+		s.metadata.author = author
+	})
+}
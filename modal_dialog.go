@@ -0,0 +1,354 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gonutz/prototype/draw"
+)
+
+type modalDialogKind int
+
+const (
+	textDialog modalDialogKind = iota
+	numberDialog
+	confirmDialog
+	listDialog
+)
+
+// modalDialog is drawn on top of the editor or replay, which do not get any
+// input while it is open. Unlike the native dialogs it does not take the focus
+// away from our window. Open one with the startModal...Dialog functions.
+type modalDialog struct {
+	kind  modalDialogKind
+	title string
+	// message is shown below the title of confirm dialogs, it is wrapped to
+	// the dialog width.
+	message string
+	// text is what the user typed into text and number dialogs.
+	text string
+	// minNumber and maxNumber are the range of a number dialog.
+	minNumber, maxNumber int
+	// options are the choices of a list dialog, selected is the current one.
+	options  []string
+	selected int
+	// errorText tells the user why the dialog could not be accepted.
+	errorText string
+	// accept is called when the user accepts the dialog. It returns false to
+	// keep the dialog open. If it is nil, the confirm dialog is only a message
+	// with an OK button.
+	accept func() bool
+}
+
+// startModalTextDialog opens a modal dialog asking the user to enter a line of
+// text. The text starts out as the given text. If the user accepts the
+// dialog, accept is called with the entered text.
+func (s *editorState) startModalTextDialog(title, text string, accept func(text string)) {
+	d := &modalDialog{kind: textDialog, title: title, text: text}
+	d.accept = func() bool {
+		accept(d.text)
+		return true
+	}
+	s.modal = d
+}
+
+// startModalNumberDialog asks the user for a whole number in the range
+// [minNumber..maxNumber]. The dialog only accepts valid numbers.
+func (s *editorState) startModalNumberDialog(title string, value, minNumber, maxNumber int, accept func(n int)) {
+	d := &modalDialog{
+		kind:      numberDialog,
+		title:     fmt.Sprintf("%s (%d to %d)", title, minNumber, maxNumber),
+		text:      strconv.Itoa(value),
+		minNumber: minNumber,
+		maxNumber: maxNumber,
+	}
+	d.accept = func() bool {
+		n, err := strconv.Atoi(d.text)
+		if err != nil || n < minNumber || n > maxNumber {
+			d.errorText = fmt.Sprintf("Enter a number from %d to %d.", minNumber, maxNumber)
+			return false
+		}
+		accept(n)
+		return true
+	}
+	s.modal = d
+}
+
+// startModalConfirmDialog asks a yes or no question. yes is only called if
+// the user answers yes, no and Escape close the dialog.
+func (s *editorState) startModalConfirmDialog(title, message string, yes func()) {
+	s.modal = &modalDialog{
+		kind:    confirmDialog,
+		title:   title,
+		message: message,
+		accept: func() bool {
+			yes()
+			return true
+		},
+	}
+}
+
+// startModalMessageDialog shows a message which the user closes with OK.
+func (s *editorState) startModalMessageDialog(title, message string) {
+	s.modal = &modalDialog{
+		kind:    confirmDialog,
+		title:   title,
+		message: message,
+	}
+}
+
+// startModalListDialog lets the user pick one of the options, which starts
+// out as the selected one. accept is called with its index.
+func (s *editorState) startModalListDialog(title string, options []string, selected int, accept func(index int)) {
+	d := &modalDialog{
+		kind:     listDialog,
+		title:    title,
+		options:  options,
+		selected: selected,
+	}
+	d.accept = func() bool {
+		accept(d.selected)
+		return true
+	}
+	s.modal = d
+}
+
+func (s *editorState) acceptModalDialog() {
+	d := s.modal
+	if d.accept == nil {
+		s.cancelModalDialog()
+		return
+	}
+	// The dialog is closed first so accept can open the next one.
+	s.modal = nil
+	if !d.accept() {
+		s.modal = d
+	}
+	s.render()
+}
+
+func (s *editorState) cancelModalDialog() {
+	s.modal = nil
+	s.render()
+}
+
+func (state *editorState) executeModalDialogFrame(window draw.Window) {
+	if state.replayingGame {
+		state.executeReplayFrame(newReadOnlyWindow(window))
+	} else {
+		state.executeEditorFrame(newReadOnlyWindow(window))
+	}
+
+	d := state.modal
+	if d.kind == textDialog || d.kind == numberDialog {
+		d.editText(window)
+	}
+	if d.kind == listDialog {
+		if previousOptionKeys.wasPressed(window) {
+			d.selected = max(0, d.selected-1)
+		}
+		if nextOptionKeys.wasPressed(window) {
+			d.selected = min(len(d.options)-1, d.selected+1)
+		}
+		if wheel := window.MouseWheelY(); wheel != 0 {
+			d.selected = min(max(0, d.selected-int(wheel)), len(d.options)-1)
+		}
+	}
+
+	windowW, windowH := window.Size()
+	mouseX, mouseY := window.MousePosition()
+	leftClick := wasLeftClicked(window)
+
+	const textScale = 2
+	_, lineH := window.GetScaledTextSize("|", textScale)
+	rowH := lineH + 10
+	// Long lists scroll to keep the selected option visible.
+	listRows := min(len(d.options), max(1, (windowH-300)/rowH))
+	firstRow := min(max(0, d.selected-listRows/2), len(d.options)-listRows)
+	dialogW := max(500, min(800, windowW-40))
+	contentX := 30
+	contentW := dialogW - 2*contentX
+
+	// Lay out the content first, the dialog height depends on it.
+	var messageLines []string
+	contentH := 0
+	switch d.kind {
+	case textDialog, numberDialog:
+		contentH = rowH
+	case confirmDialog:
+		messageLines = wrapText(window, d.message, contentW, textScale)
+		contentH = len(messageLines)*lineH + 20 + rowH
+	case listDialog:
+		contentH = listRows * rowH
+	}
+	if d.errorText != "" {
+		contentH += lineH + 10
+	}
+
+	titleLines := wrapText(window, d.title, contentW, textScale)
+	dialogH := 30 + len(titleLines)*lineH + 20 + contentH + 30
+	dialogR := rect((windowW-dialogW)/2, (windowH-dialogH)/2, dialogW, dialogH)
+	dialogR.fill(window, draw.Black)
+	dialogR.inset(5).fill(window, draw.White)
+
+	y := dialogR.y + 30
+	for _, line := range titleLines {
+		w, _ := window.GetScaledTextSize(line, textScale)
+		window.DrawScaledText(line, dialogR.x+(dialogW-w)/2, y, textScale, draw.Black)
+		y += lineH
+	}
+	y += 20
+	x := dialogR.x + contentX
+
+	button := func(r rectangle, text string) bool {
+		hover := r.contains(mouseX, mouseY)
+		color := draw.LightPurple
+		if hover {
+			color = draw.Purple
+		}
+		r.fill(window, color)
+		w, _ := window.GetScaledTextSize(text, textScale)
+		window.DrawScaledText(text, r.x+(r.w-w)/2, r.y+(r.h-lineH)/2, textScale, draw.Black)
+		return leftClick && hover
+	}
+
+	switch d.kind {
+	case textDialog, numberDialog:
+		textR := rect(x, y, contentW, rowH)
+		textR.fill(window, draw.Black)
+		textR.inset(3).fill(window, draw.White)
+
+		clip := textR.inset(5)
+		window.SetClipRect(clip.x, clip.y, clip.w, clip.h)
+		text := d.text
+		if time.Now().Unix()%2 == 0 {
+			text += "|"
+		}
+		textW, _ := window.GetScaledTextSize(d.text+"|", textScale)
+		// Draw the text left-aligned except if it gets longer than the
+		// rectangle, then draw it right-aligned so we can see the end of the
+		// text.
+		textX := clip.x - max(0, textW-clip.w)
+		window.DrawScaledText(text, textX, clip.y, textScale, draw.Black)
+		window.SetClipRect(0, 0, windowW, windowH)
+		y += rowH
+
+	case confirmDialog:
+		for _, line := range messageLines {
+			window.DrawScaledText(line, x, y, textScale, draw.Black)
+			y += lineH
+		}
+		y += 20
+
+		if d.accept == nil {
+			if button(rect(dialogR.x+(dialogW-120)/2, y, 120, rowH), "OK") {
+				state.cancelModalDialog()
+			}
+		} else {
+			if button(rect(dialogR.x+dialogW/2-130, y, 120, rowH), "Yes") {
+				state.acceptModalDialog()
+			}
+			if button(rect(dialogR.x+dialogW/2+10, y, 120, rowH), "No") {
+				state.cancelModalDialog()
+			}
+		}
+		y += rowH
+
+	case listDialog:
+		for i := firstRow; i < firstRow+listRows; i++ {
+			option := d.options[i]
+			// The first and last row hint at more options above and below,
+			// clicking them scrolls there.
+			more := listRows >= 3 &&
+				(i == firstRow && i > 0 || i == firstRow+listRows-1 && i < len(d.options)-1)
+			if more {
+				option = "..."
+			}
+			r := rect(x, y, contentW, rowH)
+			if r.contains(mouseX, mouseY) {
+				r.fill(window, draw.LightPurple)
+				if leftClick {
+					d.selected = i
+					if !more {
+						state.acceptModalDialog()
+					}
+				}
+			}
+			if i == d.selected {
+				r.fill(window, draw.Purple)
+			}
+			window.DrawScaledText(option, r.x+10, r.y+5, textScale, draw.Black)
+			y += rowH
+		}
+	}
+
+	if d.errorText != "" {
+		y += 10
+		window.DrawScaledText(d.errorText, x, y, textScale, draw.DarkRed)
+	}
+
+	// A button click might have closed the dialog already.
+	if state.modal != d {
+		return
+	}
+	if cancelDialogKeys.wasPressed(window) {
+		state.cancelModalDialog()
+	} else if acceptDialogKeys.wasPressed(window) {
+		state.acceptModalDialog()
+	}
+}
+
+// editText handles typing into a text or number dialog.
+func (d *modalDialog) editText(window draw.Window) {
+	for _, r := range window.Characters() {
+		if d.kind == numberDialog && !(unicode.IsDigit(r) || r == '-' && d.minNumber < 0) {
+			continue
+		}
+		if unicode.IsGraphic(r) {
+			// Non-control characters get appended to the text.
+			d.text += string(r)
+		}
+	}
+
+	if deleteLetterKeys.wasPressed(window) {
+		_, size := utf8.DecodeLastRuneInString(d.text)
+		d.text = d.text[:len(d.text)-size]
+	}
+	if deleteWordKeys.wasPressed(window) {
+		letters := []rune(d.text)
+		end := len(letters)
+		for end > 0 && letters[end-1] == ' ' {
+			end--
+		}
+		for end > 0 && letters[end-1] != ' ' {
+			end--
+		}
+		d.text = string(letters[:end])
+	}
+}
+
+// wrapText breaks the text into lines no wider than width. It keeps the line
+// breaks of the text and only breaks lines between words.
+func wrapText(window draw.Window, text string, width int, textScale float32) []string {
+	var lines []string
+	for paragraph := range strings.SplitSeq(text, "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			next := word
+			if line != "" {
+				next = line + " " + word
+			}
+			if w, _ := window.GetScaledTextSize(next, textScale); w > width && line != "" {
+				lines = append(lines, line)
+				next = word
+			}
+			line = next
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
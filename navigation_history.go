@@ -0,0 +1,71 @@
+package main
+
+// The navigation history remembers where we were before large seeks, like
+// going to a typed frame number, to the start or end of the run or to the
+// replay position. Alt+Left and Alt+Right go back and forward through it, like
+// in a web browser.
+const maxNavigationHistory = 100
+
+type navigationHistory struct {
+	back    []navigationPosition
+	forward []navigationPosition
+}
+
+type navigationPosition struct {
+	leftMostFrame int
+	selection     frameSelection
+}
+
+func (s *editorState) navigationPosition() navigationPosition {
+	return navigationPosition{
+		leftMostFrame: s.leftMostFrame,
+		selection:     s.activeSelection,
+	}
+}
+
+// recordSeek has to be called right before a large seek. Going back after it
+// returns to where we are now.
+func (s *editorState) recordSeek() {
+	h := &s.navigationHistory
+	pos := s.navigationPosition()
+	if n := len(h.back); n > 0 && h.back[n-1] == pos {
+		return
+	}
+	if len(h.back) == maxNavigationHistory {
+		h.back = h.back[1:]
+	}
+	h.back = append(h.back, pos)
+	h.forward = h.forward[:0]
+}
+
+func (s *editorState) navigateBack() {
+	h := &s.navigationHistory
+	if len(h.back) == 0 {
+		s.setInfo("There is nothing to go back to")
+		s.render()
+		return
+	}
+	h.forward = append(h.forward, s.navigationPosition())
+	pos := h.back[len(h.back)-1]
+	h.back = h.back[:len(h.back)-1]
+	s.goToPosition(pos)
+}
+
+func (s *editorState) navigateForward() {
+	h := &s.navigationHistory
+	if len(h.forward) == 0 {
+		s.setInfo("There is nothing to go forward to")
+		s.render()
+		return
+	}
+	h.back = append(h.back, s.navigationPosition())
+	pos := h.forward[len(h.forward)-1]
+	h.forward = h.forward[:len(h.forward)-1]
+	s.goToPosition(pos)
+}
+
+func (s *editorState) goToPosition(pos navigationPosition) {
+	s.leftMostFrame = pos.leftMostFrame
+	s.activeSelection = pos.selection
+	s.render()
+}
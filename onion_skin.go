@@ -0,0 +1,31 @@
+package main
+
+import "github.com/Humpheh/goboy/gameboy"
+
+// displayScreen returns the screen of a frame like we display it, i.e. with
+// our display palette.
+func (s *editorState) displayScreen(frameIndex int) gameboy.Screen {
+	gb := s.generateFrame(frameIndex)
+	screen := gb.PreparedData
+	s.applyPalette(&screen, &gb)
+	return screen
+}
+
+// applyOnionSkin blends the screens of the frames before and after frameIndex
+// translucently into its screen, which makes motion from one frame to the
+// next visible.
+func (s *editorState) applyOnionSkin(screen *gameboy.Screen, frameIndex int) {
+	next := s.displayScreen(frameIndex + 1)
+	prev := next
+	if frameIndex > 0 {
+		prev = s.displayScreen(frameIndex - 1)
+	}
+
+	for y := range screen {
+		for i := range screen[y] {
+			screen[y][i] = byte((2*int(screen[y][i]) +
+				int(prev[y][i]) +
+				int(next[y][i])) / 4)
+		}
+	}
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unicode"
+
+	"github.com/Humpheh/goboy/gameboy"
+	"github.com/gonutz/prototype/draw"
+)
+
+// The optimizer changes the inputs of a frame range to maximize or minimize
+// an objective over the memory at the end of the range, e.g. the player's X
+// position. It is a hill climber: every round it tries random changes of the
+// best inputs so far on the emulation workers and keeps the best of them if it
+// improves the objective. It stops after optimizerPatience rounds without
+// improvement or when the user stops it. The improved inputs go into a new
+// branch.
+const optimizerPatience = 200
+
+// objective is an expression over the memory, like "[C0A2]*256 + [C0A3]".
+type objective struct {
+	maximize bool
+	text     string
+	eval     func(gb *gameboy.Gameboy) int
+}
+
+// better reports whether score a is better than score b.
+func (o *objective) better(a, b int) bool {
+	if o.maximize {
+		return a > b
+	}
+	return a < b
+}
+
+// parseObjective parses "max" or "min" followed by an expression of numbers,
+// memory reads in brackets, + - * and parentheses. Memory reads are addresses
+// in hex or names from the RAM map, e.g. "max [C0A2] + 2*[Player X]".
+func parseObjective(text string, names ramMap) (objective, error) {
+	o := objective{text: strings.TrimSpace(text)}
+	goal, expr, _ := strings.Cut(o.text, " ")
+	switch strings.ToLower(goal) {
+	case "max":
+		o.maximize = true
+	case "min":
+	default:
+		return o, errors.New("the objective must start with max or min")
+	}
+
+	p := objectiveParser{text: expr, names: names}
+	eval, err := p.sum()
+	if err == nil && p.skipSpace() < len(p.text) {
+		err = fmt.Errorf("unexpected '%s'", p.text[p.pos:])
+	}
+	if err != nil {
+		return o, fmt.Errorf("invalid objective: %w", err)
+	}
+	o.eval = eval
+	return o, nil
+}
+
+type objectiveParser struct {
+	text  string
+	pos   int
+	names ramMap
+}
+
+type objectiveFunc = func(gb *gameboy.Gameboy) int
+
+// skipSpace skips white space and returns the new position.
+func (p *objectiveParser) skipSpace() int {
+	for p.pos < len(p.text) && p.text[p.pos] == ' ' {
+		p.pos++
+	}
+	return p.pos
+}
+
+func (p *objectiveParser) peek() byte {
+	if p.skipSpace() < len(p.text) {
+		return p.text[p.pos]
+	}
+	return 0
+}
+
+func (p *objectiveParser) sum() (objectiveFunc, error) {
+	left, err := p.product()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == '+' || p.peek() == '-' {
+		op := p.text[p.pos]
+		p.pos++
+		right, err := p.product()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		if op == '+' {
+			left = func(gb *gameboy.Gameboy) int { return l(gb) + right(gb) }
+		} else {
+			left = func(gb *gameboy.Gameboy) int { return l(gb) - right(gb) }
+		}
+	}
+	return left, nil
+}
+
+func (p *objectiveParser) product() (objectiveFunc, error) {
+	left, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == '*' {
+		p.pos++
+		right, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(gb *gameboy.Gameboy) int { return l(gb) * right(gb) }
+	}
+	return left, nil
+}
+
+func (p *objectiveParser) operand() (objectiveFunc, error) {
+	switch c := p.peek(); {
+	case c == '(':
+		p.pos++
+		inner, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, errors.New("missing )")
+		}
+		p.pos++
+		return inner, nil
+
+	case c == '-':
+		p.pos++
+		inner, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		return func(gb *gameboy.Gameboy) int { return -inner(gb) }, nil
+
+	case c == '[':
+		end := strings.IndexByte(p.text[p.pos:], ']')
+		if end == -1 {
+			return nil, errors.New("missing ]")
+		}
+		name := p.text[p.pos+1 : p.pos+end]
+		p.pos += end + 1
+		address, ok := p.names.lookup(name)
+		if !ok {
+			var err error
+			address, err = parseRAMMapAddress(name)
+			if err != nil {
+				return nil, fmt.Errorf("'%s' is neither an address in hex nor in the RAM map", name)
+			}
+		}
+		return func(gb *gameboy.Gameboy) int { return int(gb.ReadMemory(address)) }, nil
+
+	case unicode.IsDigit(rune(c)):
+		start := p.pos
+		for p.pos < len(p.text) && (unicode.IsDigit(rune(p.text[p.pos])) ||
+			unicode.IsLetter(rune(p.text[p.pos]))) {
+			p.pos++
+		}
+		n, err := strconv.ParseInt(p.text[start:p.pos], 0, 32)
+		if err != nil {
+			return nil, fmt.Errorf("'%s' is not a number", p.text[start:p.pos])
+		}
+		return func(*gameboy.Gameboy) int { return int(n) }, nil
+
+	case c == 0:
+		return nil, errors.New("unexpected end")
+	default:
+		return nil, fmt.Errorf("unexpected '%s'", p.text[p.pos:])
+	}
+}
+
+// optimizer runs the search on a background goroutine.
+type optimizer struct {
+	objective objective
+	first     int
+	// branchName, branchInputs and branchDefaults are the branch at the
+	// start, the result is a copy of it with the optimized frames.
+	branchName     string
+	branchInputs   []inputState
+	branchDefaults inputState
+	// subframes are the branch's subframe inputs in the range, keyed by the
+	// index in the range. Every trial keeps them.
+	subframes map[int][]subframeInput
+	// trials, startScore, bestScore and staleRounds are written by the
+	// background goroutine and read by the UI to display the progress.
+	trials      atomic.Int64
+	startScore  atomic.Int64
+	bestScore   atomic.Int64
+	staleRounds atomic.Int64
+	// result receives the best inputs for the range once and is closed
+	// afterwards.
+	result chan []inputState
+	cancel chan struct{}
+}
+
+// startOptimizer asks for the objective and the buttons that may change and
+// optimizes the selected frames.
+func (s *editorState) startOptimizer() {
+	if s.optimizer != nil {
+		s.setWarning("The optimizer is already running.")
+		return
+	}
+
+	first, end := s.activeSelection.start(), s.activeSelection.end()
+	s.startModalTextDialog(
+		fmt.Sprintf("Optimize Frames %d to %d, e.g. max [C0A2] or min [D35E]*256+[D35D]", first, end-1),
+		"max ",
+		func(text string) {
+			o, err := parseObjective(text, s.ramMap)
+			if err != nil {
+				s.setWarning(err.Error())
+				return
+			}
+			s.startModalTextDialog("Buttons the Optimizer May Change, e.g. a,b,left,right", "a,b,left,right,up,down", func(text string) {
+				buttons, err := parseButtonList(text)
+				if err != nil {
+					s.setWarning(err.Error())
+					return
+				}
+				if buttons == 0 {
+					s.setWarning("The optimizer needs at least one button to change.")
+					return
+				}
+				s.runOptimizer(o, first, end, buttons)
+			})
+		},
+	)
+}
+
+func (s *editorState) runOptimizer(o objective, first, end int, buttons inputState) {
+	inputs := make([]inputState, end-first)
+	for i := range inputs {
+		inputs[i] = s.inputsAt(first + i)
+	}
+
+	var start gameboy.Gameboy
+	if first == 0 {
+		start = s.startGameboy(s.gameboyOptions)
+	} else {
+		start = s.generateFrame(first - 1)
+	}
+	// We do not need any sound to optimize.
+	start.Options.Sound = false
+
+	opt := &optimizer{
+		objective:      o,
+		first:          first,
+		branchName:     s.branch().name,
+		branchInputs:   slices.Clone(s.branch().frameInputs),
+		branchDefaults: s.branch().defaultInputs,
+		subframes:      shiftedSubframes(maps.Clone(s.branch().subframeInputs), first),
+		result:         make(chan []inputState, 1),
+		cancel:         make(chan struct{}),
+	}
+	s.optimizer = opt
+	go opt.run(start, inputs, buttons)
+}
+
+// scoreJob emulates the inputs from start and stores the objective's score at
+// the end in score.
+func (opt *optimizer) scoreJob(start *gameboy.Gameboy, inputs []inputState, score *int) emulationJob {
+	return emulationJob{
+		start:     start,
+		inputs:    inputs,
+		subframes: opt.subframes,
+		afterFrame: func(i int, gb *gameboy.Gameboy) bool {
+			if i == len(inputs)-1 {
+				*score = opt.objective.eval(gb)
+			}
+			return true
+		},
+	}
+}
+
+// mutate flips a mutable button over a random run of frames.
+func mutate(inputs []inputState, buttons inputState, rng *rand.Rand) []inputState {
+	var choices []gameboy.Button
+	for b := range gameboy.ButtonCount {
+		if isButtonDown(buttons, b) {
+			choices = append(choices, b)
+		}
+	}
+
+	result := slices.Clone(inputs)
+	for range 1 + rng.IntN(3) {
+		b := choices[rng.IntN(len(choices))]
+		first := rng.IntN(len(result))
+		count := 1 + rng.IntN(min(8, len(result)-first))
+		down := !isButtonDown(result[first], b)
+		for i := first; i < first+count; i++ {
+			setButtonDown(&result[i], b, down)
+		}
+	}
+	return result
+}
+
+func (opt *optimizer) run(start gameboy.Gameboy, inputs []inputState, buttons inputState) {
+	defer close(opt.result)
+
+	best := inputs
+	var bestScore int
+	emulateJobs([]emulationJob{opt.scoreJob(&start, best, &bestScore)})
+	opt.startScore.Store(int64(bestScore))
+	opt.bestScore.Store(int64(bestScore))
+
+	rng := rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+	candidates := make([][]inputState, emulationWorkerCount)
+	scores := make([]int, emulationWorkerCount)
+	jobs := make([]emulationJob, emulationWorkerCount)
+
+	for stale := 0; stale < optimizerPatience; {
+		select {
+		case <-opt.cancel:
+			opt.result <- best
+			return
+		default:
+		}
+
+		for i := range jobs {
+			candidates[i] = mutate(best, buttons, rng)
+			jobs[i] = opt.scoreJob(&start, candidates[i], &scores[i])
+		}
+		emulateJobs(jobs)
+		opt.trials.Add(int64(len(jobs)))
+
+		stale++
+		for i := range candidates {
+			if opt.objective.better(scores[i], bestScore) {
+				best, bestScore = candidates[i], scores[i]
+				stale = 0
+			}
+		}
+		opt.bestScore.Store(int64(bestScore))
+		opt.staleRounds.Store(int64(stale))
+	}
+
+	opt.result <- best
+}
+
+// stopOptimizer stops a running search early, the best inputs so far are
+// kept.
+func (s *editorState) stopOptimizer() {
+	close(s.optimizer.cancel)
+	s.finishOptimizer(<-s.optimizer.result)
+}
+
+// cancelOptimizer stops a running search and drops its result. It is safe to
+// call if no search is running.
+func (s *editorState) cancelOptimizer() {
+	if s.optimizer != nil {
+		close(s.optimizer.cancel)
+		for range s.optimizer.result {
+		}
+		s.optimizer = nil
+	}
+}
+
+// updateOptimizer is called once per UI frame to create the new branch when
+// the search is done.
+func (s *editorState) updateOptimizer() {
+	if s.optimizer == nil {
+		return
+	}
+	select {
+	case best, ok := <-s.optimizer.result:
+		if ok {
+			s.finishOptimizer(best)
+		}
+	default:
+	}
+}
+
+func (s *editorState) finishOptimizer(best []inputState) {
+	opt := s.optimizer
+	s.optimizer = nil
+	s.render()
+
+	startScore, bestScore := int(opt.startScore.Load()), int(opt.bestScore.Load())
+	if !opt.objective.better(bestScore, startScore) {
+		s.setInfo(fmt.Sprintf("The optimizer found no improvement over %d.", startScore))
+		return
+	}
+
+	inputs := opt.branchInputs
+	for len(inputs) < opt.first+len(best) {
+		inputs = append(inputs, best[len(inputs)-opt.first])
+	}
+	copy(inputs[opt.first:], best)
+
+	s.branches = append(s.branches, branch{
+		name:                opt.branchName + " (optimized)",
+		frameInputs:         inputs,
+		defaultInputs:       opt.branchDefaults,
+		highlightFrameIndex: -1,
+	})
+	s.unsavedChanges = true
+	s.setInfo(fmt.Sprintf(
+		"The optimizer improved %s from %d to %d, see branch \"%s\".",
+		opt.objective.text, startScore, bestScore, s.branches[len(s.branches)-1].name,
+	))
+}
+
+func (s *editorState) renderOptimizerProgress(window draw.Window) {
+	opt := s.optimizer
+	if opt == nil {
+		return
+	}
+
+	text := fmt.Sprintf(
+		"Optimizing, best %d (start %d) after %d trials (Escape to stop)",
+		opt.bestScore.Load(), opt.startScore.Load(), opt.trials.Load(),
+	)
+	renderProgressBox(window, text, opt.staleRounds.Load(), optimizerPatience, 4)
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// A session patch holds the input changes of a session compared to a baseline
+// session file, so collaborators can exchange small edits instead of whole
+// speedrun files. It is a text file that can be reviewed, e.g.:
+//
+//	gameboy speedrun patch 1
+//	rom 5f1f2c...
+//	branch Any%
+//	length 12000
+//	default 0000
+//	change 1200 5 0011
+//	subframe 1203 3:a,right 5:
+//	subframe 1400
+//
+// Branches are matched by their names. A branch lists its length and default
+// inputs after the patch is applied and the runs of changed frames as first
+// frame, frame count and inputs in hex. Frames with changed subframe inputs
+// list them like the subframe dialog does, no changes clear them. Branches
+// without changes are left out. Deleting branches is not part of a patch.
+// Version 1 had no subframe inputs.
+const patchFileVersion = 2
+
+type branchPatch struct {
+	name          string
+	length        int
+	defaultInputs inputState
+	changes       []inputRun
+	// subframes are the new subframe inputs of the changed frames, nil
+	// clears a frame's changes.
+	subframes map[int][]subframeInput
+}
+
+// inputRun is a run of count frames, starting at first, with the same inputs.
+type inputRun struct {
+	first, count int
+	inputs       inputState
+}
+
+// exportPatch asks for the baseline session file and then for the file to
+// save the patch to.
+func (s *editorState) exportPatch() error {
+	s.startLoadDialog("Load Baseline Speedrun", "GameBoy Speedrun", []string{"speedrun"}, func(path string) error {
+		return s.loadOtherSession(path, func(baseline *editorState) error {
+			if sha256.Sum256(baseline.rom) != sha256.Sum256(s.rom) {
+				return errors.New("the baseline was made with a different ROM")
+			}
+			if !baseline.start.equal(s.start) {
+				return errors.New("the baseline has a different start")
+			}
+
+			var patches []branchPatch
+			for i := range s.branches {
+				if p, changed := diffBranch(findBranch(baseline.branches, s.branches[i].name), &s.branches[i]); changed {
+					patches = append(patches, p)
+				}
+			}
+			if len(patches) == 0 {
+				s.setInfo("There are no changes compared to " + path)
+				return nil
+			}
+
+			s.startSaveDialog("Export Session Patch", "Speedrun Patch", "speedpatch", func(path string) error {
+				if err := os.WriteFile(path, formatPatch(patches, s.rom), 0666); err != nil {
+					return fmt.Errorf("failed to export '%s': %w", path, err)
+				}
+				s.setInfo(fmt.Sprintf("Exported changes of %d branches to %s", len(patches), path))
+				return nil
+			})
+			return nil
+		})
+	})
+	return nil
+}
+
+// findBranch returns the first branch with the given name or nil.
+func findBranch(branches []branch, name string) *branch {
+	for i := range branches {
+		if branches[i].name == name {
+			return &branches[i]
+		}
+	}
+	return nil
+}
+
+// diffBranch returns the patch that turns base into b. base may be nil for a
+// new branch.
+func diffBranch(base, b *branch) (branchPatch, bool) {
+	p := branchPatch{
+		name:          b.name,
+		length:        len(b.frameInputs),
+		defaultInputs: b.defaultInputs,
+	}
+	if base == nil {
+		base = &branch{}
+	}
+
+	for i, in := range b.frameInputs {
+		baseInputs := base.defaultInputs
+		if i < len(base.frameInputs) {
+			baseInputs = base.frameInputs[i]
+		}
+		if in == baseInputs {
+			continue
+		}
+		if n := len(p.changes); n > 0 &&
+			p.changes[n-1].first+p.changes[n-1].count == i &&
+			p.changes[n-1].inputs == in {
+			p.changes[n-1].count++
+		} else {
+			p.changes = append(p.changes, inputRun{first: i, count: 1, inputs: in})
+		}
+	}
+
+	for _, frameIndex := range subframeDifferences(base.subframeInputs, b.subframeInputs) {
+		if frameIndex >= p.length {
+			// Applying the patch drops these with the frames.
+			continue
+		}
+		if p.subframes == nil {
+			p.subframes = make(map[int][]subframeInput)
+		}
+		p.subframes[frameIndex] = b.subframeInputs[frameIndex]
+	}
+
+	changed := len(p.changes) > 0 ||
+		len(p.subframes) > 0 ||
+		len(base.frameInputs) != len(b.frameInputs) ||
+		base.defaultInputs != b.defaultInputs ||
+		base.name != b.name
+	return p, changed
+}
+
+func formatPatch(patches []branchPatch, rom []byte) []byte {
+	var buf bytes.Buffer
+	romHash := sha256.Sum256(rom)
+	fmt.Fprintf(&buf, "gameboy speedrun patch %d\n", patchFileVersion)
+	fmt.Fprintf(&buf, "rom %s\n", hex.EncodeToString(romHash[:]))
+	for _, p := range patches {
+		fmt.Fprintf(&buf, "branch %s\n", p.name)
+		fmt.Fprintf(&buf, "length %d\n", p.length)
+		fmt.Fprintf(&buf, "default %04x\n", uint16(p.defaultInputs))
+		for _, c := range p.changes {
+			fmt.Fprintf(&buf, "change %d %d %04x\n", c.first, c.count, uint16(c.inputs))
+		}
+		for _, frameIndex := range slices.Sorted(maps.Keys(p.subframes)) {
+			line := fmt.Sprintf("subframe %d %s", frameIndex, formatSubframeInputs(p.subframes[frameIndex]))
+			fmt.Fprintln(&buf, strings.TrimSpace(line))
+		}
+	}
+	return buf.Bytes()
+}
+
+func parsePatch(data, rom []byte) ([]branchPatch, error) {
+	var patches []branchPatch
+	header := fmt.Sprintf("gameboy speedrun patch %d", patchFileVersion)
+	romHash := sha256.Sum256(rom)
+
+	lines := bufio.NewScanner(bytes.NewReader(data))
+	lineNumber := 0
+	for lines.Scan() {
+		lineNumber++
+		line := strings.TrimRight(lines.Text(), "\r")
+		fail := func(format string, a ...any) error {
+			return fmt.Errorf("line %d: %s", lineNumber, fmt.Sprintf(format, a...))
+		}
+
+		if lineNumber == 1 {
+			if line != header && line != "gameboy speedrun patch 1" {
+				return nil, errors.New("this is not a speedrun patch of a supported version")
+			}
+			continue
+		}
+
+		keyword, rest, _ := strings.Cut(line, " ")
+		if keyword == "" {
+			continue
+		}
+		if keyword == "rom" {
+			if rest != hex.EncodeToString(romHash[:]) {
+				return nil, errors.New("the patch was made with a different ROM")
+			}
+			continue
+		}
+		if keyword == "branch" {
+			patches = append(patches, branchPatch{name: rest})
+			continue
+		}
+		if len(patches) == 0 {
+			return nil, fail("'%s' before the first branch", keyword)
+		}
+		p := &patches[len(patches)-1]
+
+		if keyword == "subframe" {
+			frame, text, _ := strings.Cut(rest, " ")
+			frameIndex, err := strconv.Atoi(frame)
+			if err != nil || frameIndex < 0 {
+				return nil, fail("invalid number '%s'", frame)
+			}
+			if frameIndex >= p.length {
+				return nil, fail("the subframe inputs are outside the branch")
+			}
+			changes, err := parseSubframeInputs(text)
+			if err != nil {
+				return nil, fail("%v", err)
+			}
+			if p.subframes == nil {
+				p.subframes = make(map[int][]subframeInput)
+			}
+			p.subframes[frameIndex] = changes
+			continue
+		}
+
+		fields := strings.Fields(rest)
+		numbers := make([]int, len(fields))
+		for i, f := range fields {
+			base := 10
+			if i == len(fields)-1 && keyword != "length" {
+				// Inputs are in hex.
+				base = 16
+			}
+			n, err := strconv.ParseInt(f, base, 64)
+			if err != nil || n < 0 {
+				return nil, fail("invalid number '%s'", f)
+			}
+			numbers[i] = int(n)
+		}
+
+		switch {
+		case keyword == "length" && len(numbers) == 1:
+			p.length = numbers[0]
+		case keyword == "default" && len(numbers) == 1:
+			p.defaultInputs = inputState(numbers[0])
+		case keyword == "change" && len(numbers) == 3:
+			c := inputRun{first: numbers[0], count: numbers[1], inputs: inputState(numbers[2])}
+			if c.count == 0 || c.first+c.count > p.length {
+				return nil, fail("the change is outside the branch")
+			}
+			p.changes = append(p.changes, c)
+		default:
+			return nil, fail("invalid line '%s'", line)
+		}
+	}
+	if err := lines.Err(); err != nil {
+		return nil, err
+	}
+	if lineNumber == 0 {
+		return nil, errors.New("the patch file is empty")
+	}
+	return patches, nil
+}
+
+// applyPatch asks for a patch file and applies it to our branches. Branches
+// that do not exist yet are created.
+func (s *editorState) applyPatch() error {
+	s.startLoadDialog("Apply Session Patch", "Speedrun Patch", []string{"speedpatch"}, func(path string) error {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		patches, err := parsePatch(data, s.rom)
+		if err != nil {
+			return fmt.Errorf("failed to apply '%s': %w", path, err)
+		}
+
+		for _, p := range patches {
+			if b := findBranch(s.branches, p.name); b != nil && b.locked {
+				return fmt.Errorf("\"%s\" is locked, unlock it to apply the patch", b.name)
+			}
+		}
+
+		active := s.branch()
+		activeDirty := -1
+		for _, p := range patches {
+			b := findBranch(s.branches, p.name)
+			if b == nil {
+				s.branches = append(s.branches, branch{name: p.name, highlightFrameIndex: -1})
+				b = &s.branches[len(s.branches)-1]
+				// Appending might have moved the branches.
+				active = s.branch()
+			}
+
+			dirty := min(len(b.frameInputs), p.length)
+			for len(b.frameInputs) < p.length {
+				b.frameInputs = append(b.frameInputs, b.defaultInputs)
+			}
+			b.frameInputs = b.frameInputs[:p.length]
+			b.defaultInputs = p.defaultInputs
+			for _, c := range p.changes {
+				for i := c.first; i < c.first+c.count; i++ {
+					b.frameInputs[i] = c.inputs
+				}
+				dirty = min(dirty, c.first)
+			}
+			maps.DeleteFunc(b.subframeInputs, func(frameIndex int, _ []subframeInput) bool {
+				return frameIndex >= p.length
+			})
+			for frameIndex, changes := range p.subframes {
+				if changes == nil {
+					delete(b.subframeInputs, frameIndex)
+				} else {
+					if b.subframeInputs == nil {
+						b.subframeInputs = make(map[int][]subframeInput)
+					}
+					b.subframeInputs[frameIndex] = changes
+				}
+				dirty = min(dirty, frameIndex)
+			}
+
+			// Only the active branch is emulated.
+			if b == active {
+				activeDirty = dirty
+			}
+		}
+
+		if activeDirty != -1 {
+			s.setDirtyFrame(activeDirty)
+		}
+		s.unsavedChanges = true
+		s.setInfo(fmt.Sprintf("Applied changes of %d branches from %s", len(patches), path))
+		return nil
+	})
+	return nil
+}
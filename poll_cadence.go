@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Some games only read the joypad every few frames, the buttons in the frames
+// between two reads do not matter. The poll cadence of a session describes
+// this: the game reads the joypad in every frame i with i%every == phase. A
+// poll group is such a frame and the frames up to the next read. Edits snap
+// to whole poll groups so they do not create inputs that the game never sees.
+type pollCadence struct {
+	every, phase int
+}
+
+// maxPollCadence is the longest cadence that can be set, no game we know of
+// reads the joypad less often.
+const maxPollCadence = 8
+
+// frames returns the number of frames in a poll group, the zero value reads
+// every frame.
+func (c pollCadence) frames() int {
+	return max(1, c.every)
+}
+
+// groupStart returns the first frame of the poll group with the frame. The
+// first group can start before frame 0.
+func (c pollCadence) groupStart(frameIndex int) int {
+	n := c.frames()
+	return frameIndex - ((frameIndex-c.phase)%n+n)%n
+}
+
+// snap grows the count frames from first so they cover whole poll groups.
+func (c pollCadence) snap(first, count int) (int, int) {
+	start := max(0, c.groupStart(first))
+	end := c.groupStart(first+count-1) + c.frames()
+	return start, end - start
+}
+
+// isPollFrame returns true if the game reads the joypad in the frame.
+func (c pollCadence) isPollFrame(frameIndex int) bool {
+	return c.groupStart(frameIndex) == frameIndex
+}
+
+func (c pollCadence) String() string {
+	if c.frames() == 1 {
+		return "every frame"
+	}
+	if c.phase == 0 {
+		return strconv.Itoa(c.every)
+	}
+	return fmt.Sprintf("%d+%d", c.every, c.phase)
+}
+
+// parsePollCadence parses "2" for every second frame starting at frame 0 or
+// "2+1" for every second frame starting at frame 1. "1" reads every frame.
+func parsePollCadence(text string) (pollCadence, error) {
+	every, phase, hasPhase := strings.Cut(strings.TrimSpace(text), "+")
+	var c pollCadence
+	var err error
+	c.every, err = strconv.Atoi(strings.TrimSpace(every))
+	if err != nil || c.every < 1 || c.every > maxPollCadence {
+		return pollCadence{}, fmt.Errorf("'%s' is not a number of frames from 1 to %d", every, maxPollCadence)
+	}
+	if hasPhase {
+		c.phase, err = strconv.Atoi(strings.TrimSpace(phase))
+		if err != nil || c.phase < 0 || c.phase >= c.every {
+			return pollCadence{}, fmt.Errorf("'%s' is not a frame offset from 0 to %d", phase, c.every-1)
+		}
+	}
+	if c.every == 1 {
+		return pollCadence{}, nil
+	}
+	return c, nil
+}
+
+// detectPollCadence guesses the cadence from the frames in which the game
+// read the joypad. The most common distance between two reads is the cadence.
+// It returns false if the game did not read the joypad often enough.
+func detectPollCadence(polled []bool, first int) (pollCadence, bool) {
+	var gaps [maxPollCadence + 1]int
+	last := -1
+	for i, p := range polled {
+		if !p {
+			continue
+		}
+		if last != -1 && i-last <= maxPollCadence {
+			gaps[i-last]++
+		}
+		last = i
+	}
+
+	every := 0
+	for gap := 1; gap <= maxPollCadence; gap++ {
+		if gaps[gap] > gaps[every] {
+			every = gap
+		}
+	}
+	if every == 0 || gaps[every] < 2 {
+		return pollCadence{}, false
+	}
+	if every == 1 {
+		return pollCadence{}, true
+	}
+	return pollCadence{every: every, phase: (first + last) % every}, true
+}
+
+// startModalPollCadenceDialog asks for the session's poll cadence and suggests
+// the one of the 60 frames from the first selected frame on.
+func (s *editorState) startModalPollCadenceDialog() {
+	first := s.activeSelection.start()
+	polled := make([]bool, 60)
+	for i := range polled {
+		gb := s.generateFrame(first + i)
+		polled[i] = gb.JoypadPolled
+	}
+
+	title := "Frames per Joypad Read, e.g. 2, or 2+1 to Start at Odd Frames"
+	if c, ok := detectPollCadence(polled, first); ok {
+		title += fmt.Sprintf(" (%s Detected)", c)
+	}
+	text := ""
+	if s.pollCadence.frames() > 1 {
+		text = s.pollCadence.String()
+	}
+	s.startModalTextDialog(title, text, func(text string) {
+		if strings.TrimSpace(text) == "" {
+			text = "1"
+		}
+		c, err := parsePollCadence(text)
+		if err != nil {
+			s.setWarning(err.Error())
+			return
+		}
+		s.pollCadence = c
+		s.unsavedChanges = true
+		s.setInfo("The game reads the joypad " + s.pollCadenceText() + ".")
+		s.render()
+	})
+}
+
+// pollCadenceText describes the cadence for the user.
+func (s *editorState) pollCadenceText() string {
+	c := s.pollCadence
+	if c.frames() == 1 {
+		return "every frame"
+	}
+	return fmt.Sprintf("every %d frames, from frame %d", c.every, c.phase)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/Humpheh/goboy/gameboy"
+	"github.com/gonutz/prototype/draw"
+)
+
+// Preview panes show the screens of pinned frames below the grid, e.g. before
+// and after a trick, to compare them while editing. A pane shows the frame of
+// the branch that was active when it was pinned, even after switching to
+// another branch.
+const maxPreviewPanes = 4
+
+type previewPane struct {
+	branchName string
+	frameIndex int
+	// dirty is set when the frames changed and the screen has to be rendered
+	// again.
+	dirty bool
+}
+
+// togglePreviewPane pins the selected frame of the active branch or unpins it
+// if it is already pinned. If all panes are in use, the oldest one is
+// replaced.
+func (s *editorState) togglePreviewPane() {
+	frameIndex := s.activeSelection.start()
+	name := s.branch().name
+	for i, p := range s.previewPanes {
+		if p.branchName == name && p.frameIndex == frameIndex {
+			s.previewPanes = append(s.previewPanes[:i], s.previewPanes[i+1:]...)
+			// The panes after it moved to other images.
+			s.invalidatePreviewPanes()
+			s.setInfo(fmt.Sprintf("Unpinned frame %d", frameIndex))
+			s.render()
+			return
+		}
+	}
+
+	if len(s.previewPanes) == maxPreviewPanes {
+		s.previewPanes = s.previewPanes[1:]
+		s.invalidatePreviewPanes()
+	}
+	s.previewPanes = append(s.previewPanes, previewPane{
+		branchName: name,
+		frameIndex: frameIndex,
+		dirty:      true,
+	})
+	s.setInfo(fmt.Sprintf("Pinned frame %d of \"%s\"", frameIndex, name))
+	s.render()
+}
+
+func (s *editorState) closePreviewPanes() {
+	s.previewPanes = nil
+	s.render()
+}
+
+// previewScreen emulates the frame of the pane. Only the active branch is
+// emulated and cached. For other branches we emulate from the last frame
+// before they diverge from the active branch.
+func (s *editorState) previewScreen(p *previewPane) (gameboy.Screen, bool) {
+	b := findBranch(s.branches, p.branchName)
+	if b == nil {
+		return gameboy.Screen{}, false
+	}
+
+	d := firstDivergence(s.branch(), b)
+	if b == s.branch() || d == -1 || d > p.frameIndex {
+		return s.displayScreen(p.frameIndex), true
+	}
+
+	var gb gameboy.Gameboy
+	if d == 0 {
+		gb = s.startGameboy(s.gameboyOptions)
+	} else {
+		gb = s.generateFrame(d - 1)
+	}
+	for i := d; i <= p.frameIndex; i++ {
+		in := b.defaultInputs
+		if i < len(b.frameInputs) {
+			in = b.frameInputs[i]
+		}
+		applyInputs(&gb, in)
+		setPollInputs(&gb, b.subframeInputs[i])
+		gb.Step()
+	}
+	screen := gb.PreparedData
+	s.applyPalette(&screen, &gb)
+	return screen, true
+}
+
+// renderPreviewPanes draws the panes side by side in the bottom-left corner of
+// the grid, which is gridW wide. forceUpload renders all screens again, after
+// the window lost its images.
+func (s *editorState) renderPreviewPanes(window draw.Window, gridW, windowH int, forceUpload bool) {
+	if len(s.previewPanes) == 0 {
+		return
+	}
+	if forceUpload {
+		s.invalidatePreviewPanes()
+	}
+
+	zoom := 2
+	const margin = 8
+	if len(s.previewPanes)*(2*gameboy.ScreenWidth+margin) > gridW {
+		zoom = 1
+	}
+	w, h := zoom*gameboy.ScreenWidth, zoom*gameboy.ScreenHeight
+	_, textH := window.GetTextSize("|")
+
+	for i := range s.previewPanes {
+		p := &s.previewPanes[i]
+		image := fmt.Sprintf("previewPane%d", i)
+		title := fmt.Sprintf("%s @ %d", p.branchName, p.frameIndex)
+		if findBranch(s.branches, p.branchName) == nil {
+			title = p.branchName + " was deleted"
+		}
+
+		if p.dirty {
+			p.dirty = false
+			screen, _ := s.previewScreen(p)
+			pixels := make([]byte, 0, gameboy.ScreenWidth*gameboy.ScreenHeight*4)
+			for y := range gameboy.ScreenHeight {
+				for x := range gameboy.ScreenWidth {
+					c := screen.Pixel(x, y)
+					pixels = append(pixels, c[0], c[1], c[2], 255)
+				}
+			}
+			window.CreateImage(image, gameboy.ScreenWidth, gameboy.ScreenHeight)
+			window.SetImagePixels(image, pixels)
+		}
+
+		x := margin + i*(w+margin)
+		y := windowH - h - margin
+		rect(x, y-textH, w, h+textH).expand(2).fill(window, draw.White)
+		window.DrawText(title, x, y-textH, draw.Black)
+		window.DrawImageFileTo(image, x, y, w, h, 0)
+	}
+}
+
+// invalidatePreviewPanes makes the panes render their screens again, e.g.
+// after the inputs or the palette changed.
+func (s *editorState) invalidatePreviewPanes() {
+	for i := range s.previewPanes {
+		s.previewPanes[i].dirty = true
+	}
+}
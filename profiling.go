@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gonutz/prototype/draw"
+)
+
+// profilingCounters measure the editor's performance over one second.
+type profilingCounters struct {
+	// emulatedFrames were emulated on the UI thread in emulationTime.
+	emulatedFrames int
+	emulationTime  time.Duration
+	// frameRequests is the number of calls to generateFrame, cacheHits is how
+	// many of them found their frame in the frame cache or key frames.
+	frameRequests int
+	cacheHits     int
+	// gridRefreshes is the number of times the grid image was updated, which
+	// took gridRefreshTime in total.
+	gridRefreshes   int
+	gridRefreshTime time.Duration
+	// screenUploads is the number of Gameboy screens that were uploaded to
+	// textures for the grid.
+	screenUploads int
+}
+
+// profilingStats are shown in an overlay, toggled by F4, to help tune the key
+// frame interval and cache size.
+type profilingStats struct {
+	// current counts this second, last is what we display, the counters of the
+	// second before.
+	current, last profilingCounters
+	since         time.Time
+}
+
+func (p *profilingStats) update() {
+	if time.Since(p.since) >= time.Second {
+		p.last = p.current
+		p.current = profilingCounters{}
+		p.since = time.Now()
+	}
+}
+
+func (s *editorState) renderProfilingOverlay(window draw.Window) {
+	s.profiling.update()
+	if !s.showProfiling {
+		return
+	}
+
+	p := s.profiling.last
+	const mb = 1024 * 1024
+	keyFrameMemory := 0
+	compressed := 0
+	for _, k := range s.keyFrameStates {
+		keyFrameMemory += k.memory()
+		if k.state == nil {
+			compressed++
+		}
+	}
+
+	emulationFPS := "-"
+	if p.emulationTime > 0 {
+		emulationFPS = fmt.Sprintf("%.0f", float64(p.emulatedFrames)/p.emulationTime.Seconds())
+	}
+	hitRate := "-"
+	if p.frameRequests > 0 {
+		hitRate = fmt.Sprintf("%d%%", 100*p.cacheHits/p.frameRequests)
+	}
+	gridRefresh := "-"
+	if p.gridRefreshes > 0 {
+		average := p.gridRefreshTime / time.Duration(p.gridRefreshes)
+		gridRefresh = fmt.Sprintf("%.1f ms", float64(average.Microseconds())/1000)
+	}
+
+	lines := []string{
+		fmt.Sprintf("Emulation: %s frames/s, %d frames in the last second", emulationFPS, p.emulatedFrames),
+		fmt.Sprintf("Frame cache hits: %s of %d requests", hitRate, p.frameRequests),
+		fmt.Sprintf(
+			"Key frames: %d every %d frames, %d compressed, %.1f MB",
+			len(s.keyFrameStates), keyFrameInterval, compressed,
+			float64(keyFrameMemory)/mb,
+		),
+		fmt.Sprintf(
+			"Frame cache: %d of %d frames, %.1f MB",
+			len(s.frameCache.frameIndices), globalSettings.FrameCacheSize,
+			float64(len(s.frameCache.frameIndices)*gameboySize)/mb,
+		),
+		fmt.Sprintf("Grid refresh: %s on average, %d refreshes, %d screens uploaded", gridRefresh, p.gridRefreshes, p.screenUploads),
+	}
+
+	const textScale = 1.5
+	lineH := 0
+	boxW := 0
+	for _, line := range lines {
+		w, h := window.GetScaledTextSize(line, textScale)
+		boxW = max(boxW, w)
+		lineH = h
+	}
+	box := rect(10, 10, boxW+20, len(lines)*lineH+20)
+	box.fill(window, draw.RGBA(0, 0, 0, 0.75))
+	for i, line := range lines {
+		window.DrawScaledText(line, box.x+10, box.y+10+i*lineH, textScale, draw.White)
+	}
+}
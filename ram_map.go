@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A ramMap names the memory addresses that a game uses, e.g. C0A2 is "Player
+// HP". Community RAM maps, like the ones from Data Crystal, are imported from
+// simple text files, either lines of address and name:
+//
+//	C0A2,Player HP
+//	0xC0A3=Player Max HP
+//	; comments start with ; or # or //
+//
+// or JSON, as an object or a list of objects:
+//
+//	{"C0A2": "Player HP", "C0A3": "Player Max HP"}
+//	[{"address": "C0A2", "name": "Player HP"}]
+//
+// Addresses are in hex, with or without 0x or $. The RAM map is saved in the
+// session so the names stay with the game.
+type ramMap map[uint16]string
+
+// name returns the name of address or "" if it has none.
+func (m ramMap) name(address uint16) string {
+	return m[address]
+}
+
+// describe formats an address in hex, followed by its name if it has one.
+func (m ramMap) describe(address uint16) string {
+	if name := m.name(address); name != "" {
+		return fmt.Sprintf("%04X (%s)", address, name)
+	}
+	return fmt.Sprintf("%04X", address)
+}
+
+// lookup finds an address by its name, ignoring case.
+func (m ramMap) lookup(name string) (uint16, bool) {
+	name = strings.TrimSpace(name)
+	for address, n := range m {
+		if strings.EqualFold(n, name) {
+			return address, true
+		}
+	}
+	return 0, false
+}
+
+func parseRAMMapAddress(text string) (uint16, error) {
+	text = strings.TrimSpace(text)
+	hex := strings.TrimPrefix(strings.TrimPrefix(strings.TrimPrefix(text, "0x"), "0X"), "$")
+	a, err := strconv.ParseUint(hex, 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("'%s' is not an address in hex", text)
+	}
+	return uint16(a), nil
+}
+
+func parseRAMMap(data []byte) (ramMap, error) {
+	data = bytes.TrimPrefix(data, []byte("\xEF\xBB\xBF"))
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		return parseRAMMapJSON(trimmed)
+	}
+
+	m := ramMap{}
+	lines := bufio.NewScanner(bytes.NewReader(data))
+	lineNumber := 0
+	for lines.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(lines.Text())
+		if line == "" ||
+			strings.HasPrefix(line, ";") ||
+			strings.HasPrefix(line, "#") ||
+			strings.HasPrefix(line, "//") {
+			continue
+		}
+
+		address, name, ok := strings.Cut(line, ",")
+		if !ok {
+			address, name, ok = strings.Cut(line, "=")
+		}
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected address,name or address=name", lineNumber)
+		}
+		a, err := parseRAMMapAddress(address)
+		if err != nil {
+			if lineNumber == 1 {
+				// The first line of a CSV file may be the column names.
+				continue
+			}
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		name = strings.Trim(strings.TrimSpace(name), "\"")
+		if name != "" {
+			m[a] = name
+		}
+	}
+	if err := lines.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func parseRAMMapJSON(data []byte) (ramMap, error) {
+	m := ramMap{}
+
+	if data[0] == '{' {
+		var names map[string]string
+		if err := json.Unmarshal(data, &names); err != nil {
+			return nil, err
+		}
+		for address, name := range names {
+			a, err := parseRAMMapAddress(address)
+			if err != nil {
+				return nil, err
+			}
+			if name = strings.TrimSpace(name); name != "" {
+				m[a] = name
+			}
+		}
+		return m, nil
+	}
+
+	var entries []struct {
+		Address string `json:"address"`
+		Name    string `json:"name"`
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		a, err := parseRAMMapAddress(e.Address)
+		if err != nil {
+			return nil, err
+		}
+		if name := strings.TrimSpace(e.Name); name != "" {
+			m[a] = name
+		}
+	}
+	return m, nil
+}
+
+// importRAMMap asks for a RAM map file. Its names are added to the session's
+// RAM map, replacing the names of addresses that are in both.
+func (s *editorState) importRAMMap() error {
+	s.startLoadDialog("Import RAM Map", "RAM Map", []string{"csv", "txt", "json"}, func(path string) error {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		m, err := parseRAMMap(data)
+		if err != nil {
+			return fmt.Errorf("failed to import '%s': %w", path, err)
+		}
+		if len(m) == 0 {
+			return errors.New("the RAM map has no names")
+		}
+
+		if s.ramMap == nil {
+			s.ramMap = ramMap{}
+		}
+		for address, name := range m {
+			s.ramMap[address] = name
+		}
+		s.unsavedChanges = true
+		s.setInfo(fmt.Sprintf("Imported %d names from %s", len(m), path))
+		return nil
+	})
+	return nil
+}
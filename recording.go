@@ -0,0 +1,99 @@
+package main
+
+import (
+	"github.com/gonutz/prototype/draw"
+
+	"github.com/Humpheh/goboy/gameboy"
+)
+
+// liveKeyMap maps keyboard keys to Gameboy buttons while recording. Unlike
+// keyMap, which toggles buttons in the editor, these keys are held down like
+// on a real Gameboy.
+var liveKeyMap = map[draw.Key]gameboy.Button{
+	draw.KeyLeft:      gameboy.ButtonLeft,
+	draw.KeyUp:        gameboy.ButtonUp,
+	draw.KeyRight:     gameboy.ButtonRight,
+	draw.KeyDown:      gameboy.ButtonDown,
+	draw.KeyX:         gameboy.ButtonA,
+	draw.KeyZ:         gameboy.ButtonB,
+	draw.KeyEnter:     gameboy.ButtonStart,
+	draw.KeyBackspace: gameboy.ButtonSelect,
+}
+
+const recordingHelp = "REC  Arrows, X=A, Z=B, Enter=Start, Backspace=Select, Insert/Escape to stop"
+
+// startLiveRecording switches to the replay at the end of the branch, from
+// where the user plays the game in real time. Every frame is appended to the
+// branch.
+func (s *editorState) startLiveRecording() {
+	if s.branchLocked() {
+		return
+	}
+	s.replayingGame = true
+	s.replayPaused = false
+	s.recording = true
+	s.lastReplayedFrame = len(s.branch().frameInputs) - 1
+	s.render()
+}
+
+// toggleOverwriteRecording starts or stops recording in the replay. Recording
+// starts after the current frame and overwrites the stored inputs from there
+// on. When we stop, the replay is paused so the result can be inspected.
+func (s *editorState) toggleOverwriteRecording() {
+	if s.recording {
+		s.stopRecording()
+		s.replayPaused = true
+		return
+	}
+
+	if s.branchLocked() {
+		return
+	}
+	s.recording = true
+	s.replayPaused = false
+	if s.lastReplayedFrame+1 < len(s.branch().frameInputs) {
+		// Going back in time to record over existing frames is a rerecord.
+		s.metadata.rerecordCount++
+	}
+	s.render()
+}
+
+func (s *editorState) stopRecording() {
+	s.recording = false
+	s.resetInfoText()
+	s.render()
+}
+
+// recordFrame emulates the next frame with the buttons that the user is
+// holding right now and stores them in the branch, overwriting what was there
+// before.
+func (s *editorState) recordFrame(window draw.Window) gameboy.Gameboy {
+	var inputs inputState
+	for key, b := range liveKeyMap {
+		if window.IsKeyDown(key) {
+			setButtonDown(&inputs, b, true)
+		}
+	}
+
+	frameIndex := s.lastReplayedFrame + 1
+	s.createInputsUpTo(frameIndex)
+	b := s.branch()
+	// Frame events stay where they are, we only record the buttons.
+	inputs |= b.frameInputs[frameIndex] & frameEvents
+	if b.frameInputs[frameIndex] != inputs {
+		b.frameInputs[frameIndex] = inputs
+		s.invalidateFramesFrom(frameIndex)
+	}
+	s.lastReplayedFrame = frameIndex
+
+	gb := s.generateFrame(frameIndex)
+	queueAudio(gb.Sound.FrameSamples())
+	s.feedScopes(&gb)
+	return gb
+}
+
+func (s *editorState) renderRecordingOverlay(window draw.Window, x, y int) {
+	w, h := window.GetScaledTextSize(recordingHelp, infoTextScale)
+	window.FillRect(x, y, w+2, h+2, draw.RGBA(0, 0, 0, 0.8))
+	window.DrawScaledText(recordingHelp, x+1, y+1, infoTextScale, draw.Red)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+)
+
+// referenceRun is a read-only speedrun that we compare our splits against,
+// like the ghost of a world record in a speedrun timer.
+type referenceRun struct {
+	name       string
+	splits     []split
+	frameCount int
+}
+
+// loadReference lets the user pick a speedrun file and uses its current
+// branch as the reference run.
+func (s *editorState) loadReference() error {
+	s.startLoadDialog("Load Reference Speedrun", "GameBoy Speedrun", []string{"speedrun"}, func(path string) error {
+		return s.loadOtherSession(path, func(ref *editorState) error {
+
+			b := ref.branch()
+			s.reference = &referenceRun{
+				name:       filepath.Base(path) + " - " + b.name,
+				splits:     b.splits,
+				frameCount: len(b.frameInputs),
+			}
+			if len(b.splits) == 0 {
+				s.setWarning("The reference run has no splits to compare against.")
+			} else {
+				s.setInfo(fmt.Sprintf("Comparing against %d splits of %s", len(b.splits), s.reference.name))
+			}
+			return nil
+		})
+	})
+	return nil
+}
+
+// loadOtherSession loads a speedrun file without replacing ours and calls
+// loaded with it. A file saved for sharing needs no ROM file if it was made
+// with our ROM, otherwise the user locates the ROM first.
+func (s *editorState) loadOtherSession(path string, loaded func(other *editorState) error) error {
+	// The other session has its own ROM and console model, ours and the
+	// background work that uses them are not touched.
+	other := newEditorState()
+	other.rom = s.rom
+	opened := func(err error) error {
+		if err != nil {
+			return fmt.Errorf("failed to load '%s': %w", path, err)
+		}
+		return loaded(other)
+	}
+
+	err := other.open(path, nil)
+	var missing missingROMError
+	if errors.As(err, &missing) {
+		s.locateROM(missing.hash, func(rom []byte) error {
+			return opened(other.open(path, rom))
+		})
+		return nil
+	}
+	return opened(err)
+}
+
+// referenceDelta is the number of frames that our split is behind (positive)
+// or ahead (negative) of the reference split with the same name.
+func (s *editorState) referenceDelta(sp split) (int, bool) {
+	if s.reference == nil {
+		return 0, false
+	}
+	for _, ref := range s.reference.splits {
+		if ref.name == sp.name {
+			return sp.frameIndex - ref.frameIndex, true
+		}
+	}
+	return 0, false
+}
+
+func formatFrameDelta(delta int) string {
+	return fmt.Sprintf("%+d (%+.2fs)", delta, framesToDuration(delta).Seconds())
+}
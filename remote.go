@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Humpheh/goboy/gameboy"
+)
+
+// The remote control API lets external tools like route planners and solvers
+// drive the editor over HTTP. All frame numbers are frame indices, starting
+// at 0 like the editor's frames.
+//
+//	GET  /inputs?frame=N                         the buttons pressed in frame N
+//	POST /inputs?frame=N&buttons=a,up            sets the buttons of frame N
+//	GET  /memory?frame=N&address=0xC000&length=16 memory after frame N as hex, with RAM map names
+//	POST /seek?frame=N                           shows frame N in the editor and replay
+//
+// The editor state is not thread-safe so the HTTP handlers pass each request
+// to the main loop which runs it in handleRemoteCalls.
+//
+// The API has no authentication, anyone who can connect to it can change the
+// inputs. It therefore only listens on loopback addresses like localhost.
+// Frames may be at most remoteFrameMargin past the end of the branch, so a
+// request cannot make the editor create or emulate frames without bounds.
+
+type remoteCall struct {
+	run    func(s *editorState) (any, error)
+	result chan remoteResult
+}
+
+type remoteResult struct {
+	value any
+	err   error
+}
+
+// remoteCalls is nil if the remote control API is disabled.
+var remoteCalls chan remoteCall
+
+// remoteFrameMargin is how far past the end of the branch a request may set,
+// read or seek to, one minute of frames.
+const remoteFrameMargin = 60 * gameboy.FramesSecond
+
+var remoteButtonNames = [gameboy.ButtonCount]string{
+	gameboy.ButtonA:      "a",
+	gameboy.ButtonB:      "b",
+	gameboy.ButtonSelect: "select",
+	gameboy.ButtonStart:  "start",
+	gameboy.ButtonRight:  "right",
+	gameboy.ButtonLeft:   "left",
+	gameboy.ButtonUp:     "up",
+	gameboy.ButtonDown:   "down",
+}
+
+// parseButtonList parses a comma-separated list of remoteButtonNames, e.g.
+// "a,up". An empty list means no buttons are pressed.
+func parseButtonList(list string) (inputState, error) {
+	var buttons inputState
+	if list == "" {
+		return buttons, nil
+	}
+	for name := range strings.SplitSeq(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		b := gameboy.Button(0)
+		for b < gameboy.ButtonCount && remoteButtonNames[b] != name {
+			b++
+		}
+		if b == gameboy.ButtonCount {
+			return 0, fmt.Errorf("unknown button '%s'", name)
+		}
+		setButtonDown(&buttons, b, true)
+	}
+	return buttons, nil
+}
+
+type remoteInputs struct {
+	Frame   int      `json:"frame"`
+	Buttons []string `json:"buttons"`
+}
+
+type remoteMemory struct {
+	Frame   int    `json:"frame"`
+	Address uint16 `json:"address"`
+	Data    string `json:"data"`
+	// Names are the names from the RAM map of the addresses in the range,
+	// keyed by the address in hex.
+	Names map[string]string `json:"names,omitempty"`
+}
+
+// startRemoteControl serves the remote control API on the given address, e.g.
+// "localhost:8091", in the background. It does not start for addresses that
+// other computers can connect to.
+func startRemoteControl(address string) {
+	if err := checkLoopbackAddress(address); err != nil {
+		fmt.Println("remote control server not started:", err)
+		return
+	}
+	remoteCalls = make(chan remoteCall)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /inputs", remoteHandler(getRemoteInputs))
+	mux.HandleFunc("POST /inputs", remoteHandler(setRemoteInputs))
+	mux.HandleFunc("GET /memory", remoteHandler(readRemoteMemory))
+	mux.HandleFunc("POST /seek", remoteHandler(remoteSeek))
+	go func() {
+		if err := http.ListenAndServe(address, mux); err != nil {
+			fmt.Println("remote control server failed:", err)
+		}
+	}()
+}
+
+// checkLoopbackAddress returns an error unless address is a host and port that
+// only this computer can connect to.
+func checkLoopbackAddress(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("'%s' is not a loopback address like localhost:8091 or 127.0.0.1:8091", address)
+	}
+	return nil
+}
+
+// handleRemoteCalls runs the pending remote requests. It is called once per
+// frame from the main loop.
+func (s *editorState) handleRemoteCalls() {
+	for {
+		select {
+		case call := <-remoteCalls:
+			value, err := call.run(s)
+			call.result <- remoteResult{value: value, err: err}
+		default:
+			return
+		}
+	}
+}
+
+// remoteHandler parses the request on the HTTP goroutine and returns a call
+// that is run on the main loop, its result is written back as JSON.
+func remoteHandler(
+	parse func(r *http.Request) (func(s *editorState) (any, error), error),
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		run, err := parse(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		call := remoteCall{run: run, result: make(chan remoteResult, 1)}
+		select {
+		case remoteCalls <- call:
+		case <-r.Context().Done():
+			return
+		}
+		result := <-call.result
+		if result.err != nil {
+			http.Error(w, result.err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result.value)
+	}
+}
+
+func remoteFrameParam(r *http.Request) (int, error) {
+	frame, err := strconv.Atoi(r.URL.Query().Get("frame"))
+	if err != nil || frame < 0 {
+		return 0, errors.New("frame must be a frame index >= 0")
+	}
+	return frame, nil
+}
+
+// checkRemoteFrame returns an error if frame is more than remoteFrameMargin
+// past the end of the branch.
+func checkRemoteFrame(s *editorState, frame int) error {
+	if last := len(s.branch().frameInputs) + remoteFrameMargin; frame > last {
+		return fmt.Errorf("frame must be at most %d, %d frames past the end of the branch", last, remoteFrameMargin)
+	}
+	return nil
+}
+
+func remoteInputsAt(s *editorState, frame int) remoteInputs {
+	inputs := s.inputsAt(frame)
+	result := remoteInputs{Frame: frame, Buttons: []string{}}
+	for b := range gameboy.ButtonCount {
+		if isButtonDown(inputs, b) {
+			result.Buttons = append(result.Buttons, remoteButtonNames[b])
+		}
+	}
+	return result
+}
+
+func getRemoteInputs(r *http.Request) (func(s *editorState) (any, error), error) {
+	frame, err := remoteFrameParam(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(s *editorState) (any, error) {
+		return remoteInputsAt(s, frame), nil
+	}, nil
+}
+
+func setRemoteInputs(r *http.Request) (func(s *editorState) (any, error), error) {
+	frame, err := remoteFrameParam(r)
+	if err != nil {
+		return nil, err
+	}
+
+	buttons, err := parseButtonList(r.URL.Query().Get("buttons"))
+	if err != nil {
+		return nil, err
+	}
+
+	return func(s *editorState) (any, error) {
+		if s.recording {
+			return nil, errors.New("cannot set inputs while recording")
+		}
+		if s.branch().locked {
+			return nil, errors.New("the branch is locked")
+		}
+		if err := checkRemoteFrame(s, frame); err != nil {
+			return nil, err
+		}
+
+		s.createInputsUpTo(frame)
+		inputs := &s.branch().frameInputs[frame]
+		// Frame events are kept, only the buttons are replaced.
+		newInputs := buttons | *inputs&frameEvents
+		if *inputs != newInputs {
+			*inputs = newInputs
+			s.setDirtyFrame(frame)
+			s.render()
+		}
+		return remoteInputsAt(s, frame), nil
+	}, nil
+}
+
+func readRemoteMemory(r *http.Request) (func(s *editorState) (any, error), error) {
+	frame, err := remoteFrameParam(r)
+	if err != nil {
+		return nil, err
+	}
+
+	address, err := strconv.ParseUint(r.URL.Query().Get("address"), 0, 16)
+	if err != nil {
+		return nil, errors.New("address must be a number in 0..0xFFFF")
+	}
+
+	length := 1
+	if l := r.URL.Query().Get("length"); l != "" {
+		length, err = strconv.Atoi(l)
+		if err != nil || length < 1 || int(address)+length > 0x10000 {
+			return nil, errors.New("length must be >= 1 and stay in the address space")
+		}
+	}
+
+	return func(s *editorState) (any, error) {
+		if err := checkRemoteFrame(s, frame); err != nil {
+			return nil, err
+		}
+		gb := s.generateFrame(frame)
+		data := make([]byte, length)
+		var names map[string]string
+		for i := range data {
+			a := uint16(int(address) + i)
+			data[i] = gb.ReadMemory(a)
+			if name := s.ramMap.name(a); name != "" {
+				if names == nil {
+					names = make(map[string]string)
+				}
+				names[fmt.Sprintf("%04X", a)] = name
+			}
+		}
+		return remoteMemory{
+			Frame:   frame,
+			Address: uint16(address),
+			Data:    hex.EncodeToString(data),
+			Names:   names,
+		}, nil
+	}, nil
+}
+
+func remoteSeek(r *http.Request) (func(s *editorState) (any, error), error) {
+	frame, err := remoteFrameParam(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(s *editorState) (any, error) {
+		if s.recording {
+			return nil, errors.New("cannot seek while recording")
+		}
+		if err := checkRemoteFrame(s, frame); err != nil {
+			return nil, err
+		}
+
+		s.recordSeek()
+		s.leftMostFrame = frame
+		s.activeSelection = frameSelection{first: frame, last: frame}
+		s.lastReplayedFrame = frame
+		s.render()
+		return remoteInputsAt(s, frame), nil
+	}, nil
+}
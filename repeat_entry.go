@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gonutz/prototype/draw"
+)
+
+// The repeat entry in the side menu holds the number that the editor keys use
+// as a repeat count, or as the frame to go to with G. Digits can be typed at
+// any time. Clicking the entry focuses it, then Backspace deletes a digit
+// instead of the selected inputs. The mouse wheel over it counts up and down.
+const maxRepeatCount = 216000
+
+type repeatEntry struct {
+	text    string
+	focused bool
+	// r is where the entry was drawn last. The editor handles the mouse
+	// before the menu is drawn, so it uses the last frame's position.
+	r rectangle
+}
+
+// number returns the entered number, false if there is none.
+func (e *repeatEntry) number() (int, bool) {
+	n, err := strconv.Atoi(e.text)
+	return n, err == nil
+}
+
+func (e *repeatEntry) clear() {
+	e.text = ""
+	e.focused = false
+}
+
+// typeDigit appends the digit. If that makes the number invalid, the digit
+// starts a new number.
+func (e *repeatEntry) typeDigit(digit int) {
+	text := e.text + strconv.Itoa(digit)
+	if n, err := strconv.Atoi(text); err == nil && 1 <= n && n <= maxRepeatCount {
+		e.text = text
+	} else {
+		e.text = strconv.Itoa(digit)
+	}
+}
+
+func (e *repeatEntry) deleteDigit() {
+	if e.text != "" {
+		e.text = e.text[:len(e.text)-1]
+	}
+}
+
+// scroll counts up for positive ticks and down for negative ones. Counting
+// down below 1 clears the number.
+func (e *repeatEntry) scroll(ticks int) {
+	n, _ := e.number()
+	n = min(n+ticks, maxRepeatCount)
+	if n < 1 {
+		e.text = ""
+	} else {
+		e.text = strconv.Itoa(n)
+	}
+}
+
+// renderRepeatEntry draws the entry at y in the side menu and returns its
+// height.
+func (s *editorState) renderRepeatEntry(window draw.Window, menuX, y int, textScale float32) int {
+	e := &s.repeatEntry
+	text := "Count: " + e.text
+	color := draw.Black
+	if e.text == "" && !e.focused {
+		text = "Count: type or scroll"
+		color = draw.DarkGray
+	}
+	if e.focused && time.Now().Unix()%2 == 0 {
+		text += "|"
+	}
+
+	_, textH := window.GetScaledTextSize("|", textScale)
+	e.r = rect(menuX+20, y, inputMenuW-40, textH+10)
+	frameColor := draw.DarkGray
+	if e.focused {
+		frameColor = draw.Black
+	}
+	e.r.fill(window, frameColor)
+	e.r.inset(2).fill(window, draw.White)
+	window.DrawScaledText(text, e.r.x+8, e.r.y+5, textScale, color)
+	return e.r.h
+}
@@ -0,0 +1,100 @@
+package main
+
+import "github.com/Humpheh/goboy/gameboy"
+
+// Holding Left in the replay plays the run backwards. The emulator can only go
+// forwards, so the frames before the replay position have to be emulated from
+// the key frame before them. Doing that when a frame is needed makes the
+// replay hitch every time it crosses a key frame. Instead we keep the frames of
+// one key frame interval in a buffer and emulate the interval before it ahead
+// of time, a few frames per window frame.
+
+// reversePrefetchFrames is how many frames we emulate per window frame for the
+// next interval. It has to be larger than 1 so the prefetch is done before the
+// playback reaches it.
+const reversePrefetchFrames = 4
+
+type reversePlayback struct {
+	// current holds the frames of the key frame interval that is played now,
+	// current[i] is frame currentFirst+i.
+	current      []gameboy.Gameboy
+	currentFirst int
+	// next is the interval before current, it is complete once it has
+	// keyFrameInterval frames.
+	next      []gameboy.Gameboy
+	nextFirst int
+}
+
+// reverseChunkContains reports whether frameIndex is in frames, which start at
+// first.
+func reverseChunkContains(frames []gameboy.Gameboy, first, frameIndex int) bool {
+	return first <= frameIndex && frameIndex < first+len(frames)
+}
+
+// stepReplayBackwards goes back one frame in the replay and returns it.
+func (s *editorState) stepReplayBackwards() gameboy.Gameboy {
+	s.lastReplayedFrame = max(0, s.lastReplayedFrame-1)
+	frameIndex := s.lastReplayedFrame
+
+	if s.reversePlayback == nil {
+		s.reversePlayback = &reversePlayback{currentFirst: -1, nextFirst: -1}
+	}
+	r := s.reversePlayback
+
+	if !reverseChunkContains(r.current, r.currentFirst, frameIndex) &&
+		len(r.next) == keyFrameInterval &&
+		reverseChunkContains(r.next, r.nextFirst, frameIndex) {
+		r.current, r.currentFirst = r.next, r.nextFirst
+		r.next, r.nextFirst = nil, -1
+	}
+
+	var gb gameboy.Gameboy
+	if reverseChunkContains(r.current, r.currentFirst, frameIndex) {
+		gb = r.current[frameIndex-r.currentFirst]
+	} else {
+		// This only happens when we start playing backwards or if the
+		// prefetch could not keep up.
+		gb = s.generateFrame(frameIndex)
+	}
+
+	// Prefetch the interval that we need next. If we have no current interval
+	// yet, that is the one with frameIndex.
+	want := frameIndex / keyFrameInterval * keyFrameInterval
+	if reverseChunkContains(r.current, r.currentFirst, frameIndex) {
+		want = r.currentFirst - keyFrameInterval
+	}
+	if want >= 0 {
+		s.prefetchReverseFrames(want)
+	}
+
+	return gb
+}
+
+// prefetchReverseFrames emulates a few more frames of the key frame interval
+// that starts at first.
+func (s *editorState) prefetchReverseFrames(first int) {
+	r := s.reversePlayback
+	if r.nextFirst != first {
+		r.next, r.nextFirst = r.next[:0], first
+	}
+
+	for range reversePrefetchFrames {
+		n := len(r.next)
+		if n == keyFrameInterval {
+			return
+		}
+		if n == 0 {
+			keyFrame := first / keyFrameInterval
+			if keyFrame >= len(s.keyFrameStates) {
+				// We have not been this far yet, generateFrame will create
+				// the key frame when we get there.
+				return
+			}
+			r.next = append(r.next, s.keyFrameStates[keyFrame].gameboy())
+			continue
+		}
+		gb := r.next[n-1]
+		s.updateGameboy(&gb, first+n)
+		r.next = append(r.next, gb)
+	}
+}
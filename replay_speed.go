@@ -0,0 +1,132 @@
+package main
+
+import (
+	"time"
+
+	"github.com/Humpheh/goboy/gameboy"
+)
+
+// turboTimeBudget is the time we spend emulating frames per window frame when
+// replaying at turbo speed.
+const turboTimeBudget = 12 * time.Millisecond
+
+type replaySpeed struct {
+	name string
+	// framesPerTick is the number of emulated frames per window frame.
+	framesPerTick float64
+	// turbo means emulating as many frames as we can.
+	turbo bool
+}
+
+var replaySpeeds = []replaySpeed{
+	{name: "0.25x", framesPerTick: 0.25},
+	{name: "0.5x", framesPerTick: 0.5},
+	{name: "1x", framesPerTick: 1},
+	{name: "2x", framesPerTick: 2},
+	{name: "4x", framesPerTick: 4},
+	{name: "8x", framesPerTick: 8},
+	{name: "Turbo", turbo: true},
+}
+
+// normalReplaySpeed is the index of 1x in replaySpeeds.
+const normalReplaySpeed = 2
+
+func (s *editorState) changeReplaySpeed(delta int) {
+	s.replaySpeedIndex = max(0, min(len(replaySpeeds)-1, s.replaySpeedIndex+delta))
+	s.replayFrameProgress = 0
+}
+
+// playReplayFrames advances the replay by as many frames as the replay speed
+// asks for in this window frame and plays their sound, resampled to the length
+// of one window frame. It returns the last emulated frame.
+func (s *editorState) playReplayFrames() gameboy.Gameboy {
+	speed := replaySpeeds[s.replaySpeedIndex]
+
+	if speed.turbo {
+		// We do not play sound in turbo mode.
+		start := time.Now()
+		var gb gameboy.Gameboy
+		for time.Since(start) < turboTimeBudget {
+			s.advanceReplay()
+			gb = s.generateFrame(s.lastReplayedFrame)
+			if s.watchpointHit(s.lastReplayedFrame) {
+				s.replayPaused = true
+				break
+			}
+		}
+		return gb
+	}
+
+	s.replayFrameProgress += speed.framesPerTick
+	count := int(s.replayFrameProgress)
+	s.replayFrameProgress -= float64(count)
+
+	if count == 0 {
+		return s.generateFrame(s.lastReplayedFrame)
+	}
+
+	var gb gameboy.Gameboy
+	s.replayAudio = s.replayAudio[:0]
+	for range count {
+		s.advanceReplay()
+		gb = s.generateFrame(s.lastReplayedFrame)
+		s.replayAudio = append(s.replayAudio, gb.Sound.FrameSamples()...)
+		s.feedScopes(&gb)
+		if s.watchpointHit(s.lastReplayedFrame) {
+			s.replayPaused = true
+			break
+		}
+	}
+
+	length := round(float64(len(s.replayAudio)) / speed.framesPerTick)
+	queueAudio(resampleAudio(s.replayAudio, length))
+
+	return gb
+}
+
+// advanceReplay moves the replay to the next frame. While looping it goes back
+// to the first selected frame after the last one. The selection can change in
+// the editor between loops, looping stops if it shrinks to a single frame.
+func (s *editorState) advanceReplay() {
+	s.lastReplayedFrame++
+	if s.loopReplay && s.activeSelection.count() < 2 {
+		s.loopReplay = false
+	}
+	if s.loopReplay && s.lastReplayedFrame >= s.activeSelection.end() {
+		s.lastReplayedFrame = s.activeSelection.start()
+	}
+}
+
+// toggleReplayLoop starts or stops looping over the selected frames. Looping a
+// single frame is not useful, the selection has to have at least two.
+func (s *editorState) toggleReplayLoop() {
+	if s.loopReplay || s.activeSelection.count() < 2 {
+		s.loopReplay = false
+		return
+	}
+	s.loopReplay = true
+	if s.lastReplayedFrame < s.activeSelection.start() ||
+		s.lastReplayedFrame >= s.activeSelection.end() {
+		s.lastReplayedFrame = s.activeSelection.start()
+	}
+}
+
+// followReplay selects the replayed frame in the editor and scrolls the grid by
+// whole rows, or columns, to keep it visible, so going back to the editor lands
+// on it. While looping, the selection is the loop and stays as it is.
+func (s *editorState) followReplay() {
+	f := s.lastReplayedFrame
+	if !s.loopReplay {
+		s.activeSelection = frameSelection{first: f, last: f}
+	}
+
+	if s.gridLineFrames == 0 {
+		// The editor was never shown.
+		return
+	}
+	if f < s.leftMostFrame {
+		s.leftMostFrame = f
+	} else if end := s.leftMostFrame + s.gridFrames; f >= end {
+		s.leftMostFrame += ((f-end)/s.gridLineFrames + 1) * s.gridLineFrames
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/Humpheh/goboy/gameboy"
+	"github.com/gonutz/prototype/draw"
+)
+
+// The retime report describes the time between two splits of the active
+// branch, the way leaderboards want it for a submission. The real time counts
+// all frames, the time without lag leaves out the frames in which the game did
+// not read the joypad, which is what in-game timers usually measure. Counting
+// the lag means emulating the range, this runs on an emulation worker.
+type retimeReport struct {
+	branchName string
+	from, to   string
+	first, end int
+	// emulatedFrames and lagFrames are written by the worker and read by the
+	// UI to display the progress.
+	emulatedFrames atomic.Int64
+	lagFrames      atomic.Int64
+	// done is closed once the range was emulated or the report was cancelled.
+	done   chan struct{}
+	cancel chan struct{}
+}
+
+// reportMarker is a frame that a report can start or end at.
+type reportMarker struct {
+	name       string
+	frameIndex int
+}
+
+// startRetimeReport asks for the splits to time from and to.
+func (s *editorState) startRetimeReport() {
+	if s.retimeReport != nil {
+		s.setWarning("A retime report is already being made.")
+		return
+	}
+
+	b := s.branch()
+	markers := []reportMarker{{name: "Power on", frameIndex: 0}}
+	for _, sp := range b.splits {
+		markers = append(markers, reportMarker{name: sp.name, frameIndex: sp.frameIndex})
+	}
+	markers = append(markers, reportMarker{name: "End of the branch", frameIndex: len(b.frameInputs)})
+
+	names := make([]string, len(markers))
+	for i, m := range markers {
+		names[i] = fmt.Sprintf("%s (frame %d)", m.name, m.frameIndex)
+	}
+
+	s.startModalListDialog("Retime From", names, 0, func(from int) {
+		s.startModalListDialog("Retime To", names, len(names)-1, func(to int) {
+			if markers[to].frameIndex <= markers[from].frameIndex {
+				s.setWarning("The end of the report has to be after its start.")
+				return
+			}
+			s.runRetimeReport(markers[from], markers[to])
+		})
+	})
+}
+
+func (s *editorState) runRetimeReport(from, to reportMarker) {
+	r := &retimeReport{
+		branchName: s.branch().name,
+		from:       from.name,
+		to:         to.name,
+		first:      from.frameIndex,
+		end:        to.frameIndex,
+		done:       make(chan struct{}),
+		cancel:     make(chan struct{}),
+	}
+	s.retimeReport = r
+
+	var start gameboy.Gameboy
+	if r.first == 0 {
+		start = s.startGameboy(s.gameboyOptions)
+	} else {
+		start = s.generateFrame(r.first - 1)
+	}
+	// We do not need any sound to count lag frames.
+	start.Options.Sound = false
+
+	inputs := make([]inputState, r.end-r.first)
+	for i := range inputs {
+		inputs[i] = s.inputsAt(r.first + i)
+	}
+	subframes := shiftedSubframes(maps.Clone(s.branch().subframeInputs), r.first)
+
+	go func() {
+		defer close(r.done)
+		emulateJobs([]emulationJob{{
+			start:     &start,
+			inputs:    inputs,
+			subframes: subframes,
+			afterFrame: func(i int, gb *gameboy.Gameboy) bool {
+				select {
+				case <-r.cancel:
+					return false
+				default:
+				}
+				if !gb.JoypadPolled {
+					r.lagFrames.Add(1)
+				}
+				r.emulatedFrames.Store(int64(i + 1))
+				return true
+			},
+		}})
+	}()
+}
+
+// cancelRetimeReport stops making the report. It is safe to call if there is
+// none.
+func (s *editorState) cancelRetimeReport() {
+	if s.retimeReport != nil {
+		close(s.retimeReport.cancel)
+		<-s.retimeReport.done
+		s.retimeReport = nil
+	}
+}
+
+// updateRetimeReport is called once per UI frame and shows the report when it
+// is done. It waits for other dialogs to be closed first.
+func (s *editorState) updateRetimeReport() {
+	r := s.retimeReport
+	if r == nil || s.modal != nil || s.fileDialog != nil {
+		return
+	}
+
+	select {
+	case <-r.done:
+	default:
+		return
+	}
+	s.retimeReport = nil
+
+	text := r.format(s.metadata.gameTitle)
+	s.startModalConfirmDialog("Retime Report", text+"\n\nSave the report to a text file?", func() {
+		s.startSaveDialog("Save Retime Report", "Text File", "txt", func(path string) error {
+			if err := os.WriteFile(path, []byte(text), 0666); err != nil {
+				return fmt.Errorf("failed to save '%s': %w", path, err)
+			}
+			s.setInfo("Saved the retime report to " + path)
+			return nil
+		})
+	})
+	s.render()
+}
+
+// format writes the report as lines that can be pasted into a submission.
+func (r *retimeReport) format(gameTitle string) string {
+	frames := r.end - r.first
+	lag := int(r.lagFrames.Load())
+	lines := []string{
+		fmt.Sprintf("Game: %s", gameTitle),
+		fmt.Sprintf("Branch: %s", r.branchName),
+		fmt.Sprintf("From: %s (frame %d)", r.from, r.first),
+		fmt.Sprintf("To: %s (frame %d)", r.to, r.end),
+		fmt.Sprintf("Frames: %d", frames),
+		fmt.Sprintf("Lag frames: %d", lag),
+		fmt.Sprintf("Real time: %s", formatRunTime(framesToDuration(frames))),
+		fmt.Sprintf("Time without lag: %s", formatRunTime(framesToDuration(frames-lag))),
+		fmt.Sprintf("Frame rate: %d fps", gameboy.FramesSecond),
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (s *editorState) renderRetimeReportProgress(window draw.Window) {
+	r := s.retimeReport
+	if r == nil {
+		return
+	}
+
+	done := r.emulatedFrames.Load()
+	total := int64(r.end - r.first)
+	text := fmt.Sprintf(
+		"Counting lag frames for the retime report, %d of %d (Escape to cancel)",
+		done, total,
+	)
+	renderProgressBox(window, text, done, total, 6)
+}
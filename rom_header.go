@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// romTitle returns the game title stored in the ROM header at 0x134. Older
+// games use all 16 bytes, Gameboy Color games use the last byte as the CGB
+// flag.
+func romTitle(rom []byte) string {
+	if len(rom) < 0x150 {
+		return ""
+	}
+
+	title := rom[0x134:0x144]
+	if rom[0x143]&0x80 != 0 {
+		title = title[:15]
+	}
+
+	if end := strings.IndexByte(string(title), 0); end != -1 {
+		title = title[:end]
+	}
+
+	return strings.TrimSpace(string(title))
+}
+
+// romHeader holds the cartridge information stored at 0x134 to 0x14F of every
+// Gameboy ROM.
+type romHeader struct {
+	title          string
+	cgbFlag        byte
+	sgbFlag        byte
+	cartType       byte
+	romSizeCode    byte
+	ramSizeCode    byte
+	headerChecksum byte
+	// validChecksum is false if the checksum over 0x134 to 0x14C does not
+	// match headerChecksum. A real Gameboy does not boot such a ROM.
+	validChecksum bool
+}
+
+func parseROMHeader(rom []byte) (romHeader, bool) {
+	if len(rom) < 0x150 {
+		return romHeader{}, false
+	}
+
+	var checksum byte
+	for _, b := range rom[0x134:0x14D] {
+		checksum = checksum - b - 1
+	}
+
+	return romHeader{
+		title:          romTitle(rom),
+		cgbFlag:        rom[0x143],
+		sgbFlag:        rom[0x146],
+		cartType:       rom[0x147],
+		romSizeCode:    rom[0x148],
+		ramSizeCode:    rom[0x149],
+		headerChecksum: rom[0x14D],
+		validChecksum:  checksum == rom[0x14D],
+	}, true
+}
+
+var cartTypeNames = map[byte]string{
+	0x00: "ROM ONLY",
+	0x01: "MBC1",
+	0x02: "MBC1+RAM",
+	0x03: "MBC1+RAM+BATTERY",
+	0x05: "MBC2",
+	0x06: "MBC2+BATTERY",
+	0x08: "ROM+RAM",
+	0x09: "ROM+RAM+BATTERY",
+	0x0B: "MMM01",
+	0x0C: "MMM01+RAM",
+	0x0D: "MMM01+RAM+BATTERY",
+	0x0F: "MBC3+TIMER+BATTERY",
+	0x10: "MBC3+TIMER+RAM+BATTERY",
+	0x11: "MBC3",
+	0x12: "MBC3+RAM",
+	0x13: "MBC3+RAM+BATTERY",
+	0x15: "MBC4",
+	0x16: "MBC4+RAM",
+	0x17: "MBC4+RAM+BATTERY",
+	0x19: "MBC5",
+	0x1A: "MBC5+RAM",
+	0x1B: "MBC5+RAM+BATTERY",
+	0x1C: "MBC5+RUMBLE",
+	0x1D: "MBC5+RUMBLE+RAM",
+	0x1E: "MBC5+RUMBLE+RAM+BATTERY",
+	0x20: "MBC6",
+	0x22: "MBC7+SENSOR+RUMBLE+RAM+BATTERY",
+	0xFC: "POCKET CAMERA",
+	0xFD: "BANDAI TAMA5",
+	0xFE: "HuC3",
+	0xFF: "HuC1+RAM+BATTERY",
+}
+
+// unsupportedCartTypes are the cartridge types that our emulator does not
+// implement. Games using them will not run correctly, if at all.
+var unsupportedCartTypes = []byte{
+	0x0B, 0x0C, 0x0D, // MMM01
+	0x15, 0x16, 0x17, // MBC4
+	0x20, // MBC6
+	0x22, // MBC7
+	0xFD, // TAMA5
+	0xFE, // HuC3
+	0xFF, // HuC1
+}
+
+func (h romHeader) cartTypeName() string {
+	if name, ok := cartTypeNames[h.cartType]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown (0x%02X)", h.cartType)
+}
+
+func (h romHeader) isSupported() bool {
+	_, known := cartTypeNames[h.cartType]
+	return known && !slices.Contains(unsupportedCartTypes, h.cartType)
+}
+
+// romSize returns the ROM size in bytes, 0 if the size code is invalid.
+func (h romHeader) romSize() int {
+	if h.romSizeCode > 8 {
+		return 0
+	}
+	return 32 * 1024 << h.romSizeCode
+}
+
+// ramSize returns the cartridge RAM size in bytes. MBC2 has 512 half-bytes of
+// RAM built in which are not listed in the header.
+func (h romHeader) ramSize() int {
+	switch h.ramSizeCode {
+	case 2:
+		return 8 * 1024
+	case 3:
+		return 32 * 1024
+	case 4:
+		return 128 * 1024
+	case 5:
+		return 64 * 1024
+	default:
+		return 0
+	}
+}
+
+// hasBattery returns true if the cartridge keeps its RAM when it is switched
+// off, i.e. the game saves in it.
+func (h romHeader) hasBattery() bool {
+	return strings.Contains(h.cartTypeName(), "BATTERY") || h.cartType == 0xFC
+}
+
+// batteryRAMSize returns the size of the battery save file of the cartridge,
+// the RAM size including the RAM built into MBC2.
+func (h romHeader) batteryRAMSize() int {
+	if h.cartType == 0x05 || h.cartType == 0x06 {
+		return 512
+	}
+	return h.ramSize()
+}
+
+func (h romHeader) consoles() string {
+	consoles := "DMG"
+	switch h.cgbFlag {
+	case 0x80:
+		consoles = "DMG, CGB"
+	case 0xC0:
+		consoles = "CGB only"
+	}
+	if h.sgbFlag == 0x03 {
+		consoles += ", SGB"
+	}
+	return consoles
+}
+
+// summary is a single line describing the cartridge.
+func (h romHeader) summary() string {
+	s := fmt.Sprintf(
+		"%s: %s, %s ROM, %s RAM, %s",
+		h.title,
+		h.cartTypeName(),
+		formatByteSize(h.romSize()),
+		formatByteSize(h.ramSize()),
+		h.consoles(),
+	)
+	if !h.validChecksum {
+		s += ", bad header checksum"
+	}
+	return s
+}
+
+func formatByteSize(size int) string {
+	switch {
+	case size == 0:
+		return "no"
+	case size >= 1024*1024:
+		return fmt.Sprintf("%d MB", size/(1024*1024))
+	default:
+		return fmt.Sprintf("%d KB", size/1024)
+	}
+}
+
+// showROMInfo displays the header of the loaded ROM and warns the user with a
+// dialog if the cartridge type is not supported, before any editing time is
+// invested.
+func (s *editorState) showROMInfo() {
+	h, ok := parseROMHeader(s.rom)
+	if !ok {
+		s.setWarning("The ROM is too small to contain a header.")
+		return
+	}
+
+	if h.isSupported() && h.validChecksum {
+		s.setInfo(h.summary())
+	} else {
+		s.setWarning(h.summary())
+	}
+
+	if !h.isSupported() {
+		s.startModalMessageDialog("Unsupported Cartridge", fmt.Sprintf(
+			"The cartridge type %s of \"%s\" is not supported by the emulator. "+
+				"The game will probably not run correctly.",
+			h.cartTypeName(), h.title,
+		))
+	}
+}
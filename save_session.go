@@ -0,0 +1,238 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/gonutz/prototype/draw"
+)
+
+var errSaveCancelled = errors.New("saving was cancelled")
+
+// sessionSave writes a session file on a background goroutine so big sessions
+// do not freeze the window.
+type sessionSave struct {
+	path           string
+	totalKeyFrames int
+	// autosave saves do not report their success, the user did not ask for
+	// them.
+	autosave bool
+	// savesChanges is set if this save cleared editorState.unsavedChanges,
+	// they are set again if it fails.
+	savesChanges bool
+	// afterSave is called on the UI goroutine once the save is done, with
+	// its error. It is not called if the save is cancelled. It may be nil.
+	afterSave func(err error)
+	// writtenKeyFrames is written by the background goroutine and read by
+	// the UI to display the progress.
+	writtenKeyFrames atomic.Int64
+	cancel           chan struct{}
+	done             chan error
+}
+
+// sessionContent selects the optional parts of a session file.
+type sessionContent byte
+
+const (
+	saveKeyFrames sessionContent = 1 << iota
+	saveROM
+
+	saveEverything = saveKeyFrames | saveROM
+)
+
+// startSave writes the session to path in the background. Without key frames
+// the file only holds the ROM, branches and metadata. Without the ROM, only
+// its hash is saved.
+func (s *editorState) startSave(path string, content sessionContent) {
+	if s.saving != nil {
+		s.setWarning("Still saving " + s.saving.path)
+		s.render()
+		return
+	}
+
+	s.rememberGameProfile()
+
+	// The editor keeps changing its state while we save, so we save a copy.
+	snapshot := s.sessionSnapshot(content)
+	rom, model := s.rom, s.gameboyOptions.Model
+
+	save := &sessionSave{
+		path:           path,
+		totalKeyFrames: len(snapshot.keyFrameStates),
+		cancel:         make(chan struct{}),
+		done:           make(chan error, 1),
+	}
+	s.saving = save
+
+	// The last session file is restored on startup, it does not keep the
+	// user's work.
+	if path != lastSessionPath() {
+		save.savesChanges = s.unsavedChanges
+		s.unsavedChanges = false
+	}
+	if content&saveROM != 0 {
+		s.addToLibrary(path)
+	}
+
+	go func() {
+		save.done <- writeSessionFile(path, func(w io.Writer) error {
+			return snapshot.writeSession(w, rom, content&saveROM != 0, model, func(keyFrames int) error {
+				save.writtenKeyFrames.Store(int64(keyFrames))
+				select {
+				case <-save.cancel:
+					return errSaveCancelled
+				default:
+					return nil
+				}
+			})
+		})
+	}()
+}
+
+// sessionSnapshot copies what writeSession saves, the copy can be written on
+// another goroutine. Key frames are only copied with saveKeyFrames, they are
+// copied as well because truncating and re-appending them re-uses their
+// memory.
+func (s *editorState) sessionSnapshot(content sessionContent) *editorState {
+	snapshot := &editorState{
+		leftMostFrame:   s.leftMostFrame,
+		activeSelection: s.activeSelection,
+		branchIndex:     s.branchIndex,
+		scaleFactor:     s.scaleFactor,
+		metadata:        s.metadata,
+		comboPolicy:     s.comboPolicy,
+		pollCadence:     s.pollCadence,
+		start:           s.start,
+		paletteIndex:    s.paletteIndex,
+		customPalette:   s.customPalette,
+		ramMap:          maps.Clone(s.ramMap),
+	}
+	if content&saveKeyFrames != 0 {
+		snapshot.keyFrameStates = slices.Clone(s.keyFrameStates)
+		snapshot.workingAreaStates = s.collectWorkingAreaStates()
+	}
+	for _, b := range s.branches {
+		b.frameInputs = slices.Clone(b.frameInputs)
+		b.splits = slices.Clone(b.splits)
+		b.subframeInputs = cloneSubframeInputs(b.subframeInputs)
+		b.anchors = slices.Clone(b.anchors)
+		snapshot.branches = append(snapshot.branches, b)
+	}
+	for _, in := range s.snapshots {
+		in.frameInputs = slices.Clone(in.frameInputs)
+		in.subframeInputs = cloneSubframeInputs(in.subframeInputs)
+		snapshot.snapshots = append(snapshot.snapshots, in)
+	}
+	return snapshot
+}
+
+// writeSessionFile writes the file next to path under a temporary name and
+// renames it when it is complete. This way a crash or error while saving
+// never leaves a half written session file behind.
+func writeSessionFile(path string, write func(w io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	err = write(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// cancelSave stops a running save, the existing file stays unchanged.
+func (s *editorState) cancelSave() {
+	if s.saving != nil {
+		close(s.saving.cancel)
+		<-s.saving.done
+		s.unsavedChanges = s.unsavedChanges || s.saving.savesChanges
+		s.saving = nil
+		s.setInfo("Saving cancelled.")
+		s.render()
+	}
+}
+
+// waitForSave blocks until a running save is done.
+func (s *editorState) waitForSave() {
+	if s.saving != nil {
+		s.reportSave(<-s.saving.done)
+	}
+}
+
+// updateSave is called once per UI frame to report a finished save.
+func (s *editorState) updateSave() {
+	if s.saving == nil {
+		return
+	}
+
+	select {
+	case err := <-s.saving.done:
+		s.reportSave(err)
+		s.render()
+	default:
+	}
+}
+
+func (s *editorState) reportSave(err error) {
+	save := s.saving
+	s.saving = nil
+	if err != nil {
+		s.unsavedChanges = s.unsavedChanges || save.savesChanges
+		s.setWarning(fmt.Sprintf("failed to save '%s': %v", save.path, err))
+	} else if !save.autosave {
+		s.setInfo("Saved " + save.path)
+	}
+	if save.afterSave != nil {
+		save.afterSave(err)
+	}
+}
+
+// updateAutosave is called once per UI frame. It saves the session to the
+// last session file in the background at the interval from the settings.
+func (s *editorState) updateAutosave() {
+	minutes := globalSettings.AutosaveMinutes
+	if minutes <= 0 || len(s.rom) == 0 {
+		s.lastAutosave = time.Now()
+		return
+	}
+	if s.saving != nil || time.Since(s.lastAutosave) < time.Duration(minutes)*time.Minute {
+		return
+	}
+
+	s.lastAutosave = time.Now()
+	s.startSave(lastSessionPath(), saveEverything)
+	s.saving.autosave = true
+}
+
+func (s *editorState) renderSaveProgress(window draw.Window) {
+	save := s.saving
+	if save == nil || save.totalKeyFrames == 0 {
+		return
+	}
+
+	done := save.writtenKeyFrames.Load()
+	total := int64(save.totalKeyFrames)
+	text := fmt.Sprintf(
+		"Saving key frame %d of %d (Escape to cancel)",
+		done, total,
+	)
+	renderProgressBox(window, text, done, total, 2)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"github.com/gonutz/prototype/draw"
+
+	"github.com/Humpheh/goboy/gameboy"
+)
+
+const (
+	// scopeSampleCount is the number of samples shown in the oscilloscope
+	// views, 50 ms worth of sound.
+	scopeSampleCount = gameboy.SampleRate / 20
+	scopeHeight      = 80
+	scopeMargin      = 4
+)
+
+var scopeNames = [4]string{"Square 1", "Square 2", "Wave", "Noise"}
+
+// feedScopes appends the sound of the given frame to the oscilloscope views,
+// keeping only the latest scopeSampleCount samples per channel.
+func (s *editorState) feedScopes(gb *gameboy.Gameboy) {
+	for i := range s.scopeSamples {
+		samples := append(s.scopeSamples[i], gb.Sound.FrameChannelSamples(i)...)
+		if len(samples) > scopeSampleCount {
+			samples = samples[len(samples)-scopeSampleCount:]
+		}
+		s.scopeSamples[i] = samples
+	}
+}
+
+// resetScopes shows only the sound of the given frame in the oscilloscopes.
+func (s *editorState) resetScopes(gb *gameboy.Gameboy) {
+	for i := range s.scopeSamples {
+		s.scopeSamples[i] = s.scopeSamples[i][:0]
+	}
+	s.feedScopes(gb)
+}
+
+// renderScopes draws one oscilloscope view per sound channel side by side
+// into the given area.
+func (s *editorState) renderScopes(window draw.Window, area rectangle) {
+	area.fill(window, draw.Black)
+
+	scopeW := (area.w - 5*scopeMargin) / 4
+	scopeH := area.h - 2*scopeMargin
+	for channel, samples := range s.scopeSamples {
+		r := rect(
+			area.x+scopeMargin+channel*(scopeW+scopeMargin),
+			area.y+scopeMargin,
+			scopeW,
+			scopeH,
+		)
+		r.fill(window, rgb(8, 24, 32))
+
+		lastX, lastY := -1, -1
+		for x := range r.w {
+			if len(samples) == 0 {
+				break
+			}
+			sample := samples[x*len(samples)/r.w]
+			y := r.y + r.h - 1 - int(sample)*(r.h-1)/255
+			if lastX != -1 {
+				window.DrawLine(r.x+lastX, lastY, r.x+x, y, rgb(136, 192, 112))
+			}
+			lastX, lastY = x, y
+		}
+
+		window.DrawText(scopeNames[channel], r.x+2, r.y+2, draw.White)
+	}
+}
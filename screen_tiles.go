@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Humpheh/goboy/gameboy"
+	"github.com/gonutz/prototype/draw"
+)
+
+// screenTile identifies what one Gameboy screen in the grid shows. Two equal
+// tiles have the same pixels.
+type screenTile struct {
+	frameIndex   int
+	paletteIndex int
+	// palette is only set for the custom palette, the others are fixed.
+	palette   dmgPalette
+	onionSkin bool
+}
+
+var noScreenTile = screenTile{frameIndex: -1}
+
+// screenTextures are the uploaded Gameboy screens. Every texture holds a
+// single screen, so a changed screen or a new row after scrolling only uploads
+// those screens, not the whole grid. A 4K window at the smallest zoom shows
+// hundreds of screens.
+//
+// The draw package cannot delete images, so there is a fixed number of
+// textures, which grows with the grid but never shrinks. When all are used,
+// the one that was displayed the longest time ago is replaced.
+type screenTextures struct {
+	textures []screenTexture
+	// index finds the texture of a tile.
+	index map[screenTile]int
+	// cells has the texture of every grid index, -1 for a placeholder.
+	cells []int
+	// clock counts the grid refreshes, textures remember when they were
+	// displayed last.
+	clock  uint64
+	pixels [4 * gameboy.ScreenWidth * gameboy.ScreenHeight]byte
+}
+
+type screenTexture struct {
+	tile     screenTile
+	lastUsed uint64
+}
+
+// minScreenTextures keeps the screens of a few grids around in small windows,
+// scrolling back and forth there does not upload the screens again.
+const minScreenTextures = 256
+
+func screenTextureName(i int) string {
+	return fmt.Sprintf("gameboyScreen%d", i)
+}
+
+// resize makes room for a grid of count screens. We keep the screens of two
+// grids, so scrolling back by a page does not upload it again.
+func (t *screenTextures) resize(count int) {
+	if t.index == nil {
+		t.index = make(map[screenTile]int)
+	}
+	for len(t.textures) < max(2*count, minScreenTextures) {
+		t.textures = append(t.textures, screenTexture{tile: noScreenTile})
+	}
+	if len(t.cells) != count {
+		t.cells = make([]int, count)
+	}
+	for i := range t.cells {
+		t.cells[i] = -1
+	}
+}
+
+// clear forgets all uploaded screens, e.g. after the window lost its textures.
+func (t *screenTextures) clear() {
+	clear(t.index)
+	for i := range t.textures {
+		t.textures[i] = screenTexture{tile: noScreenTile}
+	}
+	for i := range t.cells {
+		t.cells[i] = -1
+	}
+}
+
+// use returns the texture of the tile, false if it was not uploaded.
+func (t *screenTextures) use(tile screenTile) (int, bool) {
+	i, ok := t.index[tile]
+	if ok {
+		t.textures[i].lastUsed = t.clock
+	}
+	return i, ok
+}
+
+// replace returns the texture that was displayed the longest time ago, or
+// was never used, for the tile. Textures that are displayed in the current
+// refresh are never replaced, there are more textures than grid cells.
+func (t *screenTextures) replace(tile screenTile) int {
+	oldest := 0
+	for i, tex := range t.textures {
+		if tex.tile == noScreenTile {
+			oldest = i
+			break
+		}
+		if tex.lastUsed < t.textures[oldest].lastUsed {
+			oldest = i
+		}
+	}
+	delete(t.index, t.textures[oldest].tile)
+	t.textures[oldest] = screenTexture{tile: tile, lastUsed: t.clock}
+	t.index[tile] = oldest
+	return oldest
+}
+
+func (t *screenTextures) invalidateFrom(frameIndex int) {
+	for tile, i := range t.index {
+		// The onion skin blends in the next frame as well.
+		last := tile.frameIndex
+		if tile.onionSkin {
+			last++
+		}
+		if last >= frameIndex {
+			delete(t.index, tile)
+			t.textures[i] = screenTexture{tile: noScreenTile}
+		}
+	}
+	for i, tex := range t.cells {
+		if tex != -1 && t.textures[tex].tile == noScreenTile {
+			t.cells[i] = -1
+		}
+	}
+}
+
+// wantedScreenTile returns the tile that we have to display for frameIndex
+// with the current display settings.
+func (s *editorState) wantedScreenTile(frameIndex int) screenTile {
+	tile := screenTile{
+		frameIndex:   frameIndex,
+		paletteIndex: s.paletteIndex,
+		onionSkin: s.onionSkin &&
+			s.activeSelection.count() == 1 &&
+			s.activeSelection.first == frameIndex,
+	}
+	if s.paletteIndex == customPaletteIndex {
+		tile.palette = s.customPalette
+	}
+	return tile
+}
+
+// updateScreenTiles finds the textures of the countX by countY visible
+// frames. Screens that were uploaded before are re-used, only the others are
+// emulated and uploaded.
+//
+// Only a few frames are emulated right here, the others are shown as
+// placeholders and emulated by a thumbnailWorker.
+func (s *editorState) updateScreenTiles(window draw.Window, countX, countY int, forceUpload bool) {
+	t := &s.screenTextures
+	t.resize(countX * countY)
+	if forceUpload {
+		t.clear()
+	}
+	t.clock++
+
+	start := time.Now()
+	firstMissing := -1
+	for i := range t.cells {
+		want := s.wantedScreenTile(s.leftMostFrame + i)
+		if tex, ok := t.use(want); ok {
+			t.cells[i] = tex
+			continue
+		}
+
+		cost := s.emulationCost(want.frameIndex)
+		if want.onionSkin {
+			cost += s.emulationCost(want.frameIndex + 1)
+		}
+		if cost <= maxSyncThumbnailFrames && time.Since(start) < syncThumbnailTime {
+			gb := s.generateFrame(want.frameIndex)
+			t.cells[i] = s.uploadScreenTile(window, want, &gb)
+		} else if firstMissing == -1 {
+			firstMissing = want.frameIndex
+		}
+	}
+	if firstMissing != -1 {
+		s.requestThumbnails(firstMissing, s.leftMostFrame+len(t.cells))
+	}
+
+	s.profiling.current.gridRefreshes++
+	s.profiling.current.gridRefreshTime += time.Since(start)
+}
+
+// uploadScreenTile puts the screen of the Gameboy, as the tile wants to show
+// it, into a texture and returns it.
+func (s *editorState) uploadScreenTile(window draw.Window, tile screenTile, gb *gameboy.Gameboy) int {
+	screen := gb.PreparedData
+	s.applyPalette(&screen, gb)
+	if tile.onionSkin {
+		s.applyOnionSkin(&screen, tile.frameIndex)
+	}
+
+	t := &s.screenTextures
+	for y := range gameboy.ScreenHeight {
+		for x := range gameboy.ScreenWidth {
+			i := 4 * (x + y*gameboy.ScreenWidth)
+			copy(t.pixels[i:], screen[y][3*x:3*x+3])
+			t.pixels[i+3] = 255
+		}
+	}
+	tex := t.replace(tile)
+	name := screenTextureName(tex)
+	window.CreateImage(name, gameboy.ScreenWidth, gameboy.ScreenHeight)
+	window.SetImagePixels(name, t.pixels[:])
+	s.profiling.current.screenUploads++
+	return tex
+}
+
+// drawGridScreen draws the screen of the frame with the grid index i to the
+// rectangle, or a placeholder while it is emulated.
+func (s *editorState) drawGridScreen(window draw.Window, i, x, y, w, h int) {
+	t := &s.screenTextures
+	if i < 0 || i >= len(t.cells) || t.cells[i] == -1 {
+		window.FillRect(x, y, w, h, placeholderColor)
+		return
+	}
+	window.DrawImageFilePart(
+		screenTextureName(t.cells[i]),
+		0, 0, gameboy.ScreenWidth, gameboy.ScreenHeight,
+		x, y, w, h,
+		0,
+	)
+}
+
+// imageView is a part of the editor that keeps its own images in the window.
+type imageView int
+
+const (
+	gridImages imageView = iota
+	replayImages
+	startScreenImages
+
+	imageViewCount
+)
+
+// imagesLost reports whether the window lost its images since the view asked
+// last, e.g. after its device was reset. The window only reports this once, we
+// remember it for the other views.
+func (s *editorState) imagesLost(window draw.Window, view imageView) bool {
+	if window.NeedsReRendering() {
+		for i := range s.lostImages {
+			s.lostImages[i] = true
+		}
+	}
+	lost := s.lostImages[view]
+	s.lostImages[view] = false
+	return lost
+}
+
+// invalidateScreenTilesFrom makes us regenerate the displayed screens of all
+// frames starting at frameIndex.
+func (s *editorState) invalidateScreenTilesFrom(frameIndex int) {
+	s.screenTextures.invalidateFrom(frameIndex)
+	s.invalidatePreviewPanes()
+}
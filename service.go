@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/Humpheh/goboy/gameboy"
+)
+
+// runService runs the emulator without a window. It reads one command per
+// line and writes one response line per command, which starts with "ok" or
+// "error". This lets search experiments and other tools use the exact same
+// emulator as the editor, so their results can be replayed bit-identically
+// in a speedrun file.
+//
+//	model NAME          power on a new console of this model: dmg, mgb, cgb,
+//	                    agb or legacy, the default is dmg
+//	inputs a,up         hold these buttons in the following frames, "inputs"
+//	                    alone releases all buttons
+//	subframe 3:a 5:     change the buttons from these reads of the joypad on,
+//	                    only in the next frame, see parseSubframeInputs
+//	reset               reset the Gameboy at the start of the next frame
+//	power               power cycle at the start of the next frame
+//	advance N           emulate N frames, responds with the last frame index
+//	screen              the screen as hex RGB, row by row
+//	memory ADDR LENGTH  memory as hex, e.g. "memory 0xC000 16"
+//	save NAME           remember the current state under this name
+//	load NAME           go back to a saved state
+//	quit                stop the service
+//
+// The first frame emulated by "advance" is frame index 0, like in the editor.
+func runService(rom []byte, in io.Reader, out io.Writer) {
+	options := defaultGameboyOptions
+	gb := gameboy.NewGameboy(rom, options)
+	frameIndex := -1
+	var buttons, events inputState
+	var subframes []subframeInput
+	type savedState struct {
+		gb         gameboy.Gameboy
+		frameIndex int
+	}
+	saved := make(map[string]savedState)
+
+	w := bufio.NewWriter(out)
+	defer w.Flush()
+	respond := func(format string, a ...any) {
+		fmt.Fprintf(w, format+"\n", a...)
+		w.Flush()
+	}
+
+	lines := bufio.NewScanner(in)
+	for lines.Scan() {
+		args := strings.Fields(lines.Text())
+		if len(args) == 0 {
+			continue
+		}
+
+		switch cmd := args[0]; cmd {
+		case "model":
+			if len(args) != 2 {
+				respond("error usage: model NAME")
+				continue
+			}
+			model, err := gameboy.ParseConsoleModel(args[1])
+			if err != nil {
+				respond("error %v", err)
+				continue
+			}
+			options.Model = model
+			gb = gameboy.NewGameboy(rom, options)
+			frameIndex = -1
+			respond("ok")
+
+		case "inputs":
+			list := ""
+			if len(args) >= 2 {
+				list = args[1]
+			}
+			b, err := parseButtonList(list)
+			if err != nil {
+				respond("error %v", err)
+			} else {
+				buttons = b
+				respond("ok")
+			}
+
+		case "subframe":
+			changes, err := parseSubframeInputs(strings.Join(args[1:], " "))
+			if err != nil {
+				respond("error %v", err)
+			} else {
+				subframes = changes
+				respond("ok")
+			}
+
+		case "reset":
+			events |= resetEvent
+			respond("ok")
+
+		case "power":
+			events |= powerCycleEvent
+			respond("ok")
+
+		case "advance":
+			n := 1
+			if len(args) >= 2 {
+				var err error
+				n, err = strconv.Atoi(args[1])
+				if err != nil || n < 0 {
+					respond("error frame count must be >= 0")
+					continue
+				}
+			}
+			for range n {
+				applyInputs(&gb, buttons|events)
+				setPollInputs(&gb, subframes)
+				events, subframes = 0, nil
+				gb.Step()
+				frameIndex++
+			}
+			respond("ok %d", frameIndex)
+
+		case "screen":
+			rgb := make([]byte, 0, gameboy.ScreenWidth*gameboy.ScreenHeight*3)
+			for _, row := range gb.PreparedData {
+				rgb = append(rgb, row[:]...)
+			}
+			respond("ok %s", hex.EncodeToString(rgb))
+
+		case "memory":
+			if len(args) != 3 {
+				respond("error usage: memory ADDRESS LENGTH")
+				continue
+			}
+			address, err := strconv.ParseUint(args[1], 0, 16)
+			if err != nil {
+				respond("error address must be a number in 0..0xFFFF")
+				continue
+			}
+			length, err := strconv.Atoi(args[2])
+			if err != nil || length < 1 || int(address)+length > 0x10000 {
+				respond("error length must be >= 1 and stay in the address space")
+				continue
+			}
+			// Reading some registers has side effects, we do not want them to
+			// change the emulation.
+			peek := gb
+			data := make([]byte, length)
+			for i := range data {
+				data[i] = peek.ReadMemory(uint16(int(address) + i))
+			}
+			respond("ok %s", hex.EncodeToString(data))
+
+		case "save", "load":
+			if len(args) != 2 {
+				respond("error usage: %s NAME", cmd)
+				continue
+			}
+			if cmd == "save" {
+				saved[args[1]] = savedState{gb: gb, frameIndex: frameIndex}
+				respond("ok")
+			} else if state, ok := saved[args[1]]; ok {
+				gb, frameIndex = state.gb, state.frameIndex
+				respond("ok %d", frameIndex)
+			} else {
+				respond("error no state named '%s'", args[1])
+			}
+
+		case "quit":
+			respond("ok")
+			return
+
+		default:
+			respond("error unknown command '%s'", cmd)
+		}
+	}
+}
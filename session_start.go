@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/Humpheh/goboy/gameboy"
+	"github.com/gonutz/prototype/draw"
+)
+
+// A session usually starts at power on. Categories like New Game+ or glitched
+// saves start with a battery save on the cartridge instead, and practicing a
+// segment late in the game can start from a savestate of Gambatte. The session
+// keeps the file as it is and converts it whenever it needs the Gameboy at the
+// start, so the session still works after our own state format changes.
+
+type startKind byte
+
+const (
+	startAtPowerOn startKind = iota
+	// startFromBatterySave powers on with the battery save in the cartridge
+	// RAM.
+	startFromBatterySave
+	// startFromSavestate starts from a Gambatte savestate.
+	startFromSavestate
+
+	startKindCount
+)
+
+func (k startKind) String() string {
+	switch k {
+	case startFromBatterySave:
+		return "battery save"
+	case startFromSavestate:
+		return "savestate"
+	}
+	return "power on"
+}
+
+// sessionStart is what the session starts from. data is the battery save or
+// savestate file, nil at power on.
+type sessionStart struct {
+	kind startKind
+	data []byte
+}
+
+func (a sessionStart) equal(b sessionStart) bool {
+	return a.kind == b.kind && bytes.Equal(a.data, b.data)
+}
+
+// startGameboy returns the Gameboy before frame 0 of the session.
+func (s *editorState) startGameboy(options gameboy.GameboyOptions) gameboy.Gameboy {
+	gb, _ := s.start.gameboy(s.rom, options)
+	return gb
+}
+
+// gameboy returns the Gameboy at the start of the game rom. The data is
+// checked when it is imported or loaded, the error is only for that.
+func (start sessionStart) gameboy(rom []byte, options gameboy.GameboyOptions) (gameboy.Gameboy, error) {
+	switch start.kind {
+	case startFromBatterySave:
+		h, ok := parseROMHeader(rom)
+		if !ok || !h.hasBattery() || h.batteryRAMSize() == 0 {
+			return gameboy.Gameboy{}, fmt.Errorf("the cartridge has no battery, the game cannot save")
+		}
+		if len(start.data) < h.batteryRAMSize() {
+			return gameboy.Gameboy{}, fmt.Errorf(
+				"the battery save has %d bytes but the cartridge has %d bytes of RAM",
+				len(start.data), h.batteryRAMSize(),
+			)
+		}
+		gb := gameboy.NewGameboy(rom, options)
+		// Other emulators append the clock of MBC3 cartridges, we only take
+		// the RAM.
+		gb.Memory.Cart.LoadSaveData(start.data[:h.batteryRAMSize()])
+		return gb, nil
+	case startFromSavestate:
+		return gameboy.LoadGambatteState(rom, start.data, options)
+	}
+	return gameboy.NewGameboy(rom, options), nil
+}
+
+// checkStart returns an error that names what cannot be done with a session
+// that does not start at power on. A battery save only changes the cartridge,
+// so some things work with it.
+func (s *editorState) checkStart(what string, batterySaveOK bool) error {
+	switch s.start.kind {
+	case startAtPowerOn:
+		return nil
+	case startFromBatterySave:
+		if batterySaveOK {
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot %s, the session starts from the %s %s, not at power on", what, s.start.kind, s.metadata.startFile)
+}
+
+// startNewSessionFrom asks for a battery save or a Gambatte savestate and
+// starts a new session for the current ROM from it.
+func (s *editorState) startNewSessionFrom(window draw.Window) error {
+	options := []string{
+		"Battery save (.sav), power on with the game's save",
+		"Gambatte savestate (.gqs)",
+		"Cancel",
+	}
+	s.startModalListDialog("Start a New Session From", options, 0, func(i int) {
+		switch i {
+		case 0:
+			s.startLoadDialog("Start From Battery Save", "Battery Save", []string{"sav"}, func(path string) error {
+				return s.startNewSession(window, startFromBatterySave, path)
+			})
+		case 1:
+			s.startLoadDialog("Start From Gambatte Savestate", "Gambatte Savestate", []string{"gqs"}, func(path string) error {
+				return s.startNewSession(window, startFromSavestate, path)
+			})
+		}
+	})
+	return nil
+}
+
+func (s *editorState) startNewSession(window draw.Window, kind startKind, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	start := sessionStart{kind: kind, data: data}
+	gb, err := start.gameboy(s.rom, s.gameboyOptions)
+	if err != nil {
+		return fmt.Errorf("cannot start from '%s': %w", path, err)
+	}
+	name := filepath.Base(path)
+	s.askToSaveBefore("starting from "+name, "Start", func() {
+		s.resetForNewGame()
+		window.SetTitle(windowTitle)
+		if kind == startFromSavestate {
+			s.gameboyOptions.Model = gb.Options.Model
+		}
+		s.start = start
+		s.metadata.startFile = name
+		s.setInfo(fmt.Sprintf("The new session starts from the %s %s.", kind, name))
+	})
+	return nil
+}
+
+// startText describes the start of the session for the session info.
+func (s *editorState) startText() string {
+	if s.start.kind == startAtPowerOn {
+		return s.start.kind.String()
+	}
+	return s.start.kind.String() + " " + s.metadata.startFile
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/gonutz/prototype/draw"
+)
+
+const settingsFileVersion = 5
+
+// settingsSizes are the sizes of the encoded editorSettings in each file
+// version. New versions only append fields, so an older file holds the first
+// fields of the current settings. Add the new size when adding fields.
+var settingsSizes = [settingsFileVersion + 1]int{
+	1:                   28,
+	2:                   33,
+	3:                   34,
+	4:                   35,
+	settingsFileVersion: binary.Size(editorSettings{}),
+}
+
+// editorSettings are the user's preferences. Like the audioSettings they are
+// stored independently of the speedrun files and are read and written with
+// encoding/binary.
+type editorSettings struct {
+	// ZoomStepPercent is how much one step of Ctrl+Plus, Ctrl+Minus or
+	// Ctrl+mouse wheel zooms.
+	ZoomStepPercent int32
+	// EditorKeyRepeat and ReplayKeyRepeat are the number of UI frames between
+	// repetitions of a key that is held down.
+	EditorKeyRepeat int32
+	ReplayKeyRepeat int32
+	// AutosaveMinutes is the interval at which the session is saved to the
+	// last session file. 0 only saves it when closing the editor.
+	AutosaveMinutes int32
+	// Palette is the display palette for new sessions.
+	Palette int32
+	// MemoryLimitMB is the memory for emulator states, see keyframes.go. The
+	// -memory flag overrides it.
+	MemoryLimitMB int32
+	// FrameCacheSize is the number of recently emulated frames that we keep.
+	FrameCacheSize int32
+	// Theme is the index into uiThemes.
+	Theme int32
+	// ColorBlindBorders replaces the mixed colors of the frame borders by
+	// stripes, see drawFrameBorder.
+	ColorBlindBorders bool
+	// EditorFollowsReplay selects the replayed frame in the editor and
+	// scrolls the grid to keep it visible while replaying.
+	EditorFollowsReplay bool
+	// ColumnLayout lets the frames flow top to bottom in columns instead of
+	// left to right in rows, see gridCell.
+	ColumnLayout bool
+	// SavedCacheStates is the number of cached states near the working area
+	// that are saved with the session, see cached_states.go.
+	SavedCacheStates int32
+}
+
+var defaultSettings = editorSettings{
+	ZoomStepPercent: 9,
+	EditorKeyRepeat: 8,
+	ReplayKeyRepeat: 10,
+	AutosaveMinutes: 0,
+	Palette:         0,
+	MemoryLimitMB:   1024,
+	FrameCacheSize:  500,
+}
+
+var (
+	zoomStepPercents = []int32{5, 9, 20, 50}
+	keyRepeatFrames  = []int32{4, 6, 8, 10, 15, 20}
+	autosaveMinutes  = []int32{0, 1, 2, 5, 10, 30}
+	memoryLimitsMB   = []int32{256, 512, 1024, 2048, 4096, 8192}
+	frameCacheSizes  = []int32{100, 250, 500, 1000, 2000}
+	savedCacheStates = []int32{0, 8, 16, 32}
+	globalSettings   = defaultSettings
+)
+
+func settingsPath() string {
+	return filepath.Join(os.Getenv("APPDATA"), "gameboy.settings")
+}
+
+// loadSettings returns the default settings if there are no valid settings
+// stored yet. Invalid values are replaced by their defaults.
+func loadSettings() editorSettings {
+	data, err := os.ReadFile(settingsPath())
+	if err != nil {
+		return defaultSettings
+	}
+
+	r := bytes.NewReader(data)
+	var version uint32
+	if binary.Read(r, binary.LittleEndian, &version) != nil ||
+		!(1 <= version && version <= settingsFileVersion) {
+		return defaultSettings
+	}
+	stored := make([]byte, settingsSizes[version])
+	if _, err := io.ReadFull(r, stored); err != nil {
+		return defaultSettings
+	}
+	// Fields that are newer than the file keep their defaults.
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, defaultSettings)
+	copy(buf.Bytes(), stored)
+	var settings editorSettings
+	if binary.Read(&buf, binary.LittleEndian, &settings) != nil {
+		return defaultSettings
+	}
+
+	check := func(value *int32, options []int32, def int32) {
+		if !slices.Contains(options, *value) {
+			*value = def
+		}
+	}
+	check(&settings.ZoomStepPercent, zoomStepPercents, defaultSettings.ZoomStepPercent)
+	check(&settings.EditorKeyRepeat, keyRepeatFrames, defaultSettings.EditorKeyRepeat)
+	check(&settings.ReplayKeyRepeat, keyRepeatFrames, defaultSettings.ReplayKeyRepeat)
+	check(&settings.AutosaveMinutes, autosaveMinutes, defaultSettings.AutosaveMinutes)
+	check(&settings.MemoryLimitMB, memoryLimitsMB, defaultSettings.MemoryLimitMB)
+	check(&settings.FrameCacheSize, frameCacheSizes, defaultSettings.FrameCacheSize)
+	check(&settings.SavedCacheStates, savedCacheStates, defaultSettings.SavedCacheStates)
+	if !(0 <= settings.Palette && int(settings.Palette) <= customPaletteIndex) {
+		settings.Palette = defaultSettings.Palette
+	}
+	if !(0 <= settings.Theme && int(settings.Theme) < len(uiThemes)) {
+		settings.Theme = defaultSettings.Theme
+	}
+	return settings
+}
+
+func saveSettings(settings editorSettings) {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint32(settingsFileVersion))
+	binary.Write(&buf, binary.LittleEndian, settings)
+	if err := os.WriteFile(settingsPath(), buf.Bytes(), 0666); err != nil {
+		fmt.Println("saving settings failed:", err)
+	}
+}
+
+// zoomFactor is what one zoom step multiplies the scale factor by.
+func zoomFactor() float64 {
+	return 1 + float64(globalSettings.ZoomStepPercent)/100
+}
+
+// changeSettings applies and stores new settings.
+func (s *editorState) changeSettings(settings editorSettings) {
+	old := globalSettings
+	globalSettings = settings
+	saveSettings(settings)
+
+	if settings.Palette != old.Palette {
+		s.paletteIndex = int(settings.Palette)
+	}
+	if settings.FrameCacheSize != old.FrameCacheSize {
+		s.frameCache.clear()
+	}
+	if settings.MemoryLimitMB != old.MemoryLimitMB {
+		s.enforceMemoryLimit()
+	}
+	s.render()
+}
+
+// executeSettingsFrame shows the settings panel on top of the editor or
+// replay.
+func (state *editorState) executeSettingsFrame(window draw.Window) {
+	if state.replayingGame {
+		state.executeReplayFrame(newReadOnlyWindow(window))
+	} else {
+		state.executeEditorFrame(newReadOnlyWindow(window))
+	}
+
+	if closeDialogKeys.wasPressed(window) {
+		state.settingsOpen = false
+		state.render()
+		return
+	}
+
+	windowW, windowH := window.Size()
+	mouseX, mouseY := window.MousePosition()
+	leftClick := wasLeftClicked(window)
+
+	const textScale = 2
+	_, textH := window.GetScaledTextSize("|", textScale)
+	rowH := textH + 16
+
+	panel := rect(0, 0, 640, 15*rowH+40)
+	panel.x = (windowW - panel.w) / 2
+	panel.y = (windowH - panel.h) / 2
+	panel.fill(window, draw.Black)
+	panel.inset(5).fill(window, draw.White)
+
+	title := "Settings"
+	titleW, _ := window.GetScaledTextSize(title, textScale)
+	y := panel.y + 20
+	window.DrawScaledText(title, panel.x+(panel.w-titleW)/2, y, textScale, draw.Black)
+	y += rowH
+
+	clicked := func(r rectangle) bool {
+		hover := r.contains(mouseX, mouseY)
+		color := draw.LightPurple
+		if hover {
+			color = draw.Purple
+		}
+		r.fill(window, color)
+		return leftClick && hover
+	}
+
+	// row draws a setting with buttons to decrease and increase it. It
+	// returns -1, 0 or 1 for the button that was clicked.
+	row := func(name, value string) int32 {
+		window.DrawScaledText(name, panel.x+30, y+8, textScale, draw.Black)
+
+		valueX := panel.x + 340
+		valueW := panel.w - 340 - 30
+		delta := int32(0)
+		less := rect(valueX, y+4, rowH-8, rowH-8)
+		more := rect(valueX+valueW-less.w, y+4, less.w, less.h)
+		if clicked(less) {
+			delta = -1
+		}
+		if clicked(more) {
+			delta = 1
+		}
+		window.DrawScaledText("<", less.x+8, y+8, textScale, draw.Black)
+		window.DrawScaledText(">", more.x+8, y+8, textScale, draw.Black)
+		w, _ := window.GetScaledTextSize(value, textScale)
+		window.DrawScaledText(value, valueX+(valueW-w)/2, y+8, textScale, draw.Black)
+
+		y += rowH
+		return delta
+	}
+
+	settings := globalSettings
+
+	if d := row("Zoom Step", fmt.Sprintf("%d%%", settings.ZoomStepPercent)); d != 0 {
+		settings.ZoomStepPercent = nextOption(zoomStepPercents, settings.ZoomStepPercent, d)
+	}
+
+	repeat := func(frames int32) string {
+		return fmt.Sprintf("every %d frames", frames)
+	}
+	if d := row("Key Repeat Editor", repeat(settings.EditorKeyRepeat)); d != 0 {
+		settings.EditorKeyRepeat = nextOption(keyRepeatFrames, settings.EditorKeyRepeat, d)
+	}
+	if d := row("Key Repeat Replay", repeat(settings.ReplayKeyRepeat)); d != 0 {
+		settings.ReplayKeyRepeat = nextOption(keyRepeatFrames, settings.ReplayKeyRepeat, d)
+	}
+
+	autosave := "off"
+	if settings.AutosaveMinutes > 0 {
+		autosave = fmt.Sprintf("every %d min", settings.AutosaveMinutes)
+	}
+	if d := row("Autosave", autosave); d != 0 {
+		settings.AutosaveMinutes = nextOption(autosaveMinutes, settings.AutosaveMinutes, d)
+	}
+
+	paletteName := "Custom"
+	if int(settings.Palette) < len(dmgPalettes) {
+		paletteName = dmgPalettes[settings.Palette].name
+	}
+	if d := row("Palette", paletteName); d != 0 {
+		settings.Palette = min(int32(customPaletteIndex), max(0, settings.Palette+d))
+	}
+
+	memory := fmt.Sprintf("%d MB", settings.MemoryLimitMB)
+	if *memoryLimitMB > 0 {
+		memory = fmt.Sprintf("%d MB (-memory)", *memoryLimitMB)
+	}
+	if d := row("Memory Limit", memory); d != 0 {
+		settings.MemoryLimitMB = nextOption(memoryLimitsMB, settings.MemoryLimitMB, d)
+	}
+
+	if d := row("Frame Cache", fmt.Sprintf("%d frames", settings.FrameCacheSize)); d != 0 {
+		settings.FrameCacheSize = nextOption(frameCacheSizes, settings.FrameCacheSize, d)
+	}
+
+	savedStates := "Off"
+	if settings.SavedCacheStates > 0 {
+		savedStates = fmt.Sprintf("%d states", settings.SavedCacheStates)
+	}
+	if d := row("Save Cached States", savedStates); d != 0 {
+		settings.SavedCacheStates = nextOption(savedCacheStates, settings.SavedCacheStates, d)
+	}
+
+	if d := row("Theme", uiThemes[settings.Theme].name); d != 0 {
+		settings.Theme = min(int32(len(uiThemes)-1), max(0, settings.Theme+d))
+	}
+
+	borders := "Colors"
+	if settings.ColorBlindBorders {
+		borders = "Stripes"
+	}
+	if d := row("Input Borders", borders); d != 0 {
+		settings.ColorBlindBorders = !settings.ColorBlindBorders
+	}
+
+	follow := "Off"
+	if settings.EditorFollowsReplay {
+		follow = "On"
+	}
+	if d := row("Editor Follows Replay", follow); d != 0 {
+		settings.EditorFollowsReplay = !settings.EditorFollowsReplay
+	}
+
+	layout := "Rows"
+	if settings.ColumnLayout {
+		layout = "Columns"
+	}
+	if d := row("Grid Layout", layout); d != 0 {
+		settings.ColumnLayout = !settings.ColumnLayout
+	}
+
+	if settings != globalSettings {
+		state.changeSettings(settings)
+	}
+
+	// The audio settings have their own panel.
+	audioText := "Audio Settings"
+	audioW, _ := window.GetScaledTextSize(audioText, textScale)
+	audioButton := rect(panel.x+(panel.w-audioW-40)/2, y+4, audioW+40, rowH-8)
+	if clicked(audioButton) {
+		state.settingsOpen = false
+		state.audioSettingsOpen = true
+		state.render()
+	}
+	window.DrawScaledText(audioText, audioButton.x+20, y+8, textScale, draw.Black)
+	y += rowH
+
+	closeText := "Close"
+	closeW, _ := window.GetScaledTextSize(closeText, textScale)
+	closeButton := rect(panel.x+(panel.w-closeW-40)/2, y+4, closeW+40, rowH-8)
+	if clicked(closeButton) {
+		state.settingsOpen = false
+		state.render()
+	}
+	window.DrawScaledText(closeText, closeButton.x+20, y+8, textScale, draw.Black)
+}
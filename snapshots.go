@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Snapshots hold the inputs of a branch under a name. They are lighter than
+// branches: they have no splits, highlight or color and are not emulated.
+// Swapping the active branch's inputs with a snapshot, and swapping again,
+// flips between two candidate edits of the same section.
+type inputSnapshot struct {
+	name           string
+	frameInputs    []inputState
+	defaultInputs  inputState
+	subframeInputs map[int][]subframeInput
+}
+
+func findSnapshot(snapshots []inputSnapshot, name string) int {
+	return slices.IndexFunc(snapshots, func(s inputSnapshot) bool {
+		return s.name == name
+	})
+}
+
+// startModalSnapshotDialog asks for a name and saves the inputs of the active
+// branch under it. An existing snapshot with that name is replaced.
+func (s *editorState) startModalSnapshotDialog() {
+	name := fmt.Sprintf("Snapshot %d", len(s.snapshots)+1)
+	s.startModalTextDialog("Snapshot the Branch's Inputs as", name, func(name string) {
+		b := s.branch()
+		snapshot := inputSnapshot{
+			name:           name,
+			frameInputs:    slices.Clone(b.frameInputs),
+			defaultInputs:  b.defaultInputs,
+			subframeInputs: cloneSubframeInputs(b.subframeInputs),
+		}
+		if i := findSnapshot(s.snapshots, name); i != -1 {
+			s.snapshots[i] = snapshot
+		} else {
+			s.snapshots = append(s.snapshots, snapshot)
+		}
+		s.lastSnapshot = name
+		s.unsavedChanges = true
+		s.setInfo(fmt.Sprintf("Saved the inputs of \"%s\" as \"%s\"", b.name, name))
+	})
+}
+
+// startModalSwapSnapshotDialog lets the user pick the snapshot to swap the
+// active branch's inputs with.
+func (s *editorState) startModalSwapSnapshotDialog() {
+	if len(s.snapshots) == 0 {
+		s.setWarning("There are no snapshots yet.")
+		s.render()
+		return
+	}
+	names := make([]string, len(s.snapshots))
+	for i := range s.snapshots {
+		names[i] = s.snapshots[i].name
+	}
+	selected := max(0, findSnapshot(s.snapshots, s.lastSnapshot))
+	s.startModalListDialog("Swap the Branch's Inputs with", names, selected, func(i int) {
+		s.swapSnapshot(i)
+	})
+}
+
+// swapLastSnapshot swaps with the snapshot that was saved or swapped last, so
+// pressing it repeatedly flips between two versions.
+func (s *editorState) swapLastSnapshot() {
+	i := findSnapshot(s.snapshots, s.lastSnapshot)
+	if i == -1 {
+		s.setWarning("There is no snapshot to swap with, save one first.")
+		s.render()
+		return
+	}
+	s.swapSnapshot(i)
+}
+
+// swapSnapshot exchanges the inputs of the active branch and the snapshot.
+func (s *editorState) swapSnapshot(i int) {
+	if s.branchLocked() {
+		return
+	}
+
+	b := s.branch()
+	snapshot := &s.snapshots[i]
+	old := branch{
+		frameInputs:    b.frameInputs,
+		defaultInputs:  b.defaultInputs,
+		subframeInputs: b.subframeInputs,
+	}
+	b.frameInputs, snapshot.frameInputs = snapshot.frameInputs, b.frameInputs
+	b.defaultInputs, snapshot.defaultInputs = snapshot.defaultInputs, b.defaultInputs
+	b.subframeInputs, snapshot.subframeInputs = snapshot.subframeInputs, b.subframeInputs
+	s.lastSnapshot = snapshot.name
+
+	if d := firstDivergence(&old, b); d != -1 {
+		s.setDirtyFrame(d)
+		s.setInfo(fmt.Sprintf("Swapped with \"%s\", the inputs differ from frame %d", snapshot.name, d))
+	} else {
+		s.setInfo(fmt.Sprintf("Swapped with \"%s\", the inputs are the same", snapshot.name))
+	}
+	s.unsavedChanges = true
+	s.render()
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/Humpheh/goboy/gameboy"
+	"github.com/gonutz/prototype/draw"
+)
+
+// A split marks the frame at which a segment of the run ends. The run's time
+// at a split is the number of frames before it.
+type split struct {
+	frameIndex int
+	name       string
+}
+
+// framesToDuration converts a number of frames to the time it takes on the
+// real Gameboy. Editor frames are not LCD frames, each one takes 1/60 second.
+func framesToDuration(frames int) time.Duration {
+	return time.Duration(frames) * time.Second / gameboy.FramesSecond
+}
+
+// formatRunTime formats the duration like speedrun timers do, e.g. 1:23.45.
+func formatRunTime(d time.Duration) string {
+	centis := d.Milliseconds() / 10
+	minutes := centis / 6000
+	seconds := centis / 100 % 60
+	return fmt.Sprintf("%d:%02d.%02d", minutes, seconds, centis%100)
+}
+
+func (b *branch) splitIndex(frameIndex int) int {
+	return slices.IndexFunc(b.splits, func(s split) bool {
+		return s.frameIndex == frameIndex
+	})
+}
+
+// toggleSplit removes the split at the first selected frame or asks for a name
+// and adds a new split there.
+func (s *editorState) toggleSplit() {
+	frameIndex := s.activeSelection.start()
+	b := s.branch()
+
+	if i := b.splitIndex(frameIndex); i != -1 {
+		s.setInfo(fmt.Sprintf("Removed split \"%s\"", b.splits[i].name))
+		b.splits = slices.Delete(b.splits, i, i+1)
+		s.render()
+		return
+	}
+
+	name := fmt.Sprintf("Split %d", len(b.splits)+1)
+	if len(b.splits) < len(s.splitNameTemplate) {
+		name = s.splitNameTemplate[len(b.splits)]
+	}
+	s.startModalTextDialog("Enter Split Name", name, func(name string) {
+		b := s.branch()
+		i := 0
+		for i < len(b.splits) && b.splits[i].frameIndex < frameIndex {
+			i++
+		}
+		b.splits = slices.Insert(b.splits, i, split{frameIndex: frameIndex, name: name})
+	})
+}
+
+// executeSplitsFrame shows the split times of the current branch on top of the
+// editor or replay.
+func (state *editorState) executeSplitsFrame(window draw.Window) {
+	if state.replayingGame {
+		state.executeReplayFrame(newReadOnlyWindow(window))
+	} else {
+		state.executeEditorFrame(newReadOnlyWindow(window))
+	}
+
+	if closeDialogKeys.wasPressed(window) {
+		state.splitsOpen = false
+		state.render()
+		return
+	}
+
+	windowW, windowH := window.Size()
+	mouseX, mouseY := window.MousePosition()
+	leftClick := wasLeftClicked(window)
+
+	const textScale = 1.5
+	_, textH := window.GetScaledTextSize("|", textScale)
+	rowH := textH + 8
+
+	panel := rect(0, 0, min(windowW-40, 960), windowH-80)
+	panel.x = (windowW - panel.w) / 2
+	panel.y = (windowH - panel.h) / 2
+	panel.fill(window, draw.Black)
+	panel.inset(5).fill(window, draw.White)
+
+	b := state.branch()
+	title := "Splits of " + b.name
+	titleW, _ := window.GetScaledTextSize(title, textScale)
+	y := panel.y + 20
+	window.DrawScaledText(title, panel.x+(panel.w-titleW)/2, y, textScale, draw.Black)
+	y += rowH
+
+	reference := "No reference run loaded"
+	if state.reference != nil {
+		reference = fmt.Sprintf(
+			"Reference: %s (%d frames)",
+			state.reference.name, state.reference.frameCount,
+		)
+	}
+	referenceW, _ := window.GetScaledTextSize(reference, textScale)
+	window.DrawScaledText(reference, panel.x+(panel.w-referenceW)/2, y, textScale, draw.DarkGray)
+	y += 2 * rowH
+
+	columnX := []int{30, 290, 390, 500, 610, 760}
+	row := func(color draw.Color, columns ...string) {
+		for i, text := range columns {
+			window.DrawScaledText(text, panel.x+columnX[i], y, textScale, color)
+		}
+		y += rowH
+	}
+
+	row(draw.DarkGray, "Name", "Frame", "Segment", "Time", "Seg. Time", "Reference")
+
+	// Leave room for the buttons at the bottom.
+	maxRows := (panel.y + panel.h - 3*rowH - 20 - y) / rowH
+	lastFrame := 0
+	for i, sp := range b.splits {
+		if i == maxRows-1 && len(b.splits) > maxRows {
+			row(draw.DarkGray, fmt.Sprintf("... %d more", len(b.splits)-i))
+			break
+		}
+
+		segment := sp.frameIndex - lastFrame
+		lastFrame = sp.frameIndex
+		color := draw.Black
+		if state.activeSelection.start() == sp.frameIndex {
+			color = draw.DarkRed
+		}
+		rowY := y
+		row(
+			color,
+			sp.name,
+			fmt.Sprint(sp.frameIndex),
+			fmt.Sprint(segment),
+			formatRunTime(framesToDuration(sp.frameIndex)),
+			formatRunTime(framesToDuration(segment)),
+		)
+
+		if delta, ok := state.referenceDelta(sp); ok {
+			deltaColor := draw.Black
+			if delta < 0 {
+				deltaColor = draw.DarkGreen
+			} else if delta > 0 {
+				deltaColor = draw.Red
+			}
+			text := formatFrameDelta(delta)
+			window.DrawScaledText(text, panel.x+columnX[5], rowY, textScale, deltaColor)
+		}
+	}
+	if len(b.splits) == 0 {
+		row(draw.DarkGray, "Select a frame and click Toggle Split to add a split.")
+	}
+
+	buttonY := panel.y + panel.h - 2*rowH - 10
+	buttonX := panel.x + 30
+	button := func(text string) bool {
+		w, _ := window.GetScaledTextSize(text, textScale)
+		r := rect(buttonX, buttonY, w+20, rowH+6)
+		buttonX += r.w + 10
+		hover := r.contains(mouseX, mouseY)
+		color := draw.LightPurple
+		if hover {
+			color = draw.Purple
+		}
+		r.fill(window, color)
+		window.DrawScaledText(text, r.x+10, r.y+7, textScale, draw.Black)
+		return leftClick && hover
+	}
+
+	if button(fmt.Sprintf("Toggle Split at %d", state.activeSelection.start())) {
+		state.toggleSplit()
+	}
+
+	if button("Export LiveSplit") {
+		if err := state.exportLiveSplit(); err != nil {
+			state.setWarning(err.Error())
+		}
+	}
+
+	if button("Load Reference") {
+		if err := state.loadReference(); err != nil {
+			state.setWarning(err.Error())
+		}
+	}
+
+	if button("Retime Report") {
+		state.startRetimeReport()
+	}
+
+	if state.reference != nil && button("Clear Reference") {
+		state.reference = nil
+	}
+
+	if button("Close") {
+		state.splitsOpen = false
+		state.render()
+	}
+}
+
+// lssTime is the time format used in LiveSplit's .lss files.
+func lssTime(d time.Duration) string {
+	h := int(d.Hours())
+	m := int(d.Minutes()) % 60
+	sec := d - d.Truncate(time.Minute)
+	return fmt.Sprintf("%02d:%02d:%010.7f", h, m, sec.Seconds())
+}
+
+type lssRun struct {
+	XMLName      xml.Name     `xml:"Run"`
+	Version      string       `xml:"version,attr"`
+	GameIcon     string       `xml:"GameIcon"`
+	GameName     string       `xml:"GameName"`
+	CategoryName string       `xml:"CategoryName"`
+	Offset       string       `xml:"Offset"`
+	AttemptCount int          `xml:"AttemptCount"`
+	Segments     []lssSegment `xml:"Segments>Segment"`
+}
+
+type lssSegment struct {
+	Name            string       `xml:"Name"`
+	Icon            string       `xml:"Icon"`
+	SplitTimes      lssSplitTime `xml:"SplitTimes>SplitTime"`
+	BestSegmentTime lssTimes     `xml:"BestSegmentTime"`
+	SegmentHistory  string       `xml:"SegmentHistory"`
+}
+
+type lssSplitTime struct {
+	Name string `xml:"name,attr"`
+	lssTimes
+}
+
+type lssTimes struct {
+	RealTime string `xml:"RealTime"`
+	GameTime string `xml:"GameTime"`
+}
+
+// exportLiveSplit saves the current branch's splits as a LiveSplit file with
+// the split times as the personal best.
+func (s *editorState) exportLiveSplit() error {
+	b := s.branch()
+	if len(b.splits) == 0 {
+		return errors.New("there are no splits to export")
+	}
+
+	s.startSaveDialog("Export LiveSplit Splits", "LiveSplit Splits", "lss", func(path string) error {
+		run := lssRun{
+			Version:      "1.7.0",
+			GameName:     s.metadata.gameTitle,
+			CategoryName: b.name,
+			Offset:       "00:00:00",
+		}
+		lastFrame := 0
+		for _, sp := range b.splits {
+			total := lssTime(framesToDuration(sp.frameIndex))
+			segment := lssTime(framesToDuration(sp.frameIndex - lastFrame))
+			lastFrame = sp.frameIndex
+			run.Segments = append(run.Segments, lssSegment{
+				Name: sp.name,
+				SplitTimes: lssSplitTime{
+					Name:     "Personal Best",
+					lssTimes: lssTimes{RealTime: total, GameTime: total},
+				},
+				BestSegmentTime: lssTimes{RealTime: segment, GameTime: segment},
+			})
+		}
+
+		data, err := xml.MarshalIndent(run, "", "  ")
+		if err != nil {
+			return err
+		}
+		data = append([]byte(xml.Header), data...)
+		if err := os.WriteFile(path, data, 0666); err != nil {
+			return fmt.Errorf("failed to export '%s': %w", path, err)
+		}
+
+		s.setInfo(fmt.Sprintf("Exported %d splits to %s", len(b.splits), path))
+		return nil
+	})
+	return nil
+}
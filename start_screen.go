@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Humpheh/goboy/gameboy"
+	"github.com/gonutz/prototype/draw"
+)
+
+// The library remembers the ROMs and sessions that were opened or saved
+// recently. The start screen shows them with the game's first picture, so
+// going back to a project is one click. It opens on startup, on top of the
+// last session, which it continues when it is closed.
+
+// libraryEntry is a ROM or session file. Session files end in .speedrun.
+type libraryEntry struct {
+	Path  string
+	Title string
+	Used  time.Time
+	// ROM is the SHA-256 of the ROM, see romKey, and Start the start of the
+	// session. Entries of the same game and start share the thumbnail.
+	ROM   string
+	Start string
+	// Thumbnail is a PNG of the first screen of the game that is not a single
+	// color, see boxArt.
+	Thumbnail []byte
+}
+
+func (e *libraryEntry) isSession() bool {
+	return strings.EqualFold(filepath.Ext(e.Path), ".speedrun")
+}
+
+// name is what the start screen calls the file.
+func (e *libraryEntry) name() string {
+	if e.Path == lastSessionPath() {
+		return "Last session"
+	}
+	return filepath.Base(e.Path)
+}
+
+const (
+	maxLibraryEntries = 12
+	// maxBoxArtFrames is how long the game can show a blank screen at the
+	// start, the title screen usually follows within a few seconds.
+	maxBoxArtFrames = 300
+)
+
+func libraryPath() string {
+	return filepath.Join(os.Getenv("APPDATA"), "gameboy.library")
+}
+
+// loadLibrary returns the entries with the last used first. Files that no
+// longer exist are left out.
+func loadLibrary() []libraryEntry {
+	data, err := os.ReadFile(libraryPath())
+	if err != nil {
+		return nil
+	}
+	var entries []libraryEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		fmt.Println("reading the library failed:", err)
+		return nil
+	}
+	entries = slices.DeleteFunc(entries, func(e libraryEntry) bool {
+		_, err := os.Stat(e.Path)
+		return err != nil
+	})
+	slices.SortStableFunc(entries, func(a, b libraryEntry) int {
+		return b.Used.Compare(a.Used)
+	})
+	return entries
+}
+
+// addToLibrary puts the ROM or session file at path at the top of the
+// library, with the current session's game.
+func (s *editorState) addToLibrary(path string) {
+	if len(s.rom) == 0 {
+		return
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+
+	entries := loadLibrary()
+	entry := libraryEntry{
+		Path:  path,
+		Title: s.metadata.gameTitle,
+		Used:  time.Now(),
+		ROM:   romKey(s.rom),
+		Start: s.startText(),
+	}
+	for _, e := range entries {
+		if e.ROM == entry.ROM && e.Start == entry.Start && len(e.Thumbnail) > 0 {
+			entry.Thumbnail = e.Thumbnail
+			break
+		}
+	}
+	if entry.Thumbnail == nil {
+		entry.Thumbnail = s.boxArt()
+	}
+
+	entries = slices.DeleteFunc(entries, func(e libraryEntry) bool {
+		return e.Path == path
+	})
+	entries = append([]libraryEntry{entry}, entries...)
+	entries = entries[:min(len(entries), maxLibraryEntries)]
+
+	data, err := json.Marshal(entries)
+	if err == nil {
+		err = os.WriteFile(libraryPath(), data, 0666)
+	}
+	if err != nil {
+		fmt.Println("saving the library failed:", err)
+	}
+}
+
+// boxArt returns the first screen after the start of the session, without
+// inputs, that is not a single color, as a PNG. At power on, frame 0 is
+// blank in every game, the first picture is usually the publisher's logo or
+// the title screen.
+func (s *editorState) boxArt() []byte {
+	gb := s.startGameboy(s.gameboyOptions)
+	for range maxBoxArtFrames {
+		gb.Step()
+		if !isBlankScreen(&gb.PreparedData) {
+			break
+		}
+	}
+
+	screen := gb.PreparedData
+	s.applyPalette(&screen, &gb)
+	img := image.NewRGBA(image.Rect(0, 0, gameboy.ScreenWidth, gameboy.ScreenHeight))
+	for y := range gameboy.ScreenHeight {
+		for x := range gameboy.ScreenWidth {
+			i := img.PixOffset(x, y)
+			copy(img.Pix[i:], screen[y][3*x:3*x+3])
+			img.Pix[i+3] = 255
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+func isBlankScreen(screen *gameboy.Screen) bool {
+	first := [3]byte(screen[0][:3])
+	for y := range screen {
+		row := screen[y][:]
+		for ; len(row) > 0; row = row[3:] {
+			if [3]byte(row) != first {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// startScreen is open while the start screen is shown.
+type startScreen struct {
+	entries []libraryEntry
+	// loadedPath is the file that the editor loaded behind the start screen,
+	// choosing it only closes the start screen.
+	loadedPath string
+	// uploaded is set when the thumbnails are in the window's images.
+	uploaded bool
+}
+
+func startScreenThumbnailName(i int) string {
+	return fmt.Sprintf("libraryThumbnail%d", i)
+}
+
+// openStartScreen shows the library, if there is anything in it. loadedPath is
+// the file that was just loaded, "" if the user works on the session.
+func (s *editorState) openStartScreen(loadedPath string) {
+	entries := loadLibrary()
+	if len(entries) == 0 {
+		return
+	}
+	s.startScreen = &startScreen{
+		entries:    entries,
+		loadedPath: loadedPath,
+	}
+	s.render()
+}
+
+func (s *editorState) closeStartScreen() {
+	s.startScreen = nil
+	s.render()
+}
+
+// openLibraryEntry opens a session file like Ctrl+O or starts a new session
+// for a ROM like Ctrl+N.
+func (s *editorState) openLibraryEntry(window draw.Window, e libraryEntry) {
+	loaded := s.startScreen.loadedPath
+	s.closeStartScreen()
+	if e.Path != loaded {
+		s.openDroppedFile(window, e.Path)
+	}
+}
+
+// uploadThumbnails puts the PNG thumbnails of the entries into the window's
+// images. Entries without a valid thumbnail show a placeholder.
+func (screen *startScreen) uploadThumbnails(window draw.Window) {
+	rgba := image.NewRGBA(image.Rect(0, 0, gameboy.ScreenWidth, gameboy.ScreenHeight))
+	for i := range screen.entries {
+		e := &screen.entries[i]
+		img, err := png.Decode(bytes.NewReader(e.Thumbnail))
+		if err != nil || img.Bounds().Size() != rgba.Rect.Size() {
+			e.Thumbnail = nil
+			continue
+		}
+		b := img.Bounds()
+		for y := range gameboy.ScreenHeight {
+			for x := range gameboy.ScreenWidth {
+				c := color.RGBAModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.RGBA)
+				i := rgba.PixOffset(x, y)
+				rgba.Pix[i+0] = c.R
+				rgba.Pix[i+1] = c.G
+				rgba.Pix[i+2] = c.B
+				rgba.Pix[i+3] = 255
+			}
+		}
+		name := startScreenThumbnailName(i)
+		window.CreateImage(name, gameboy.ScreenWidth, gameboy.ScreenHeight)
+		// SetImagePixels swaps the red and blue bytes of the slice.
+		window.SetImagePixels(name, rgba.Pix)
+	}
+	screen.uploaded = true
+}
+
+// executeStartScreenFrame shows the library on top of the editor. Escape,
+// Enter or the Continue button close it.
+func (state *editorState) executeStartScreenFrame(window draw.Window) {
+	if state.replayingGame {
+		state.executeReplayFrame(newReadOnlyWindow(window))
+	} else {
+		state.executeEditorFrame(newReadOnlyWindow(window))
+	}
+
+	if closeDialogKeys.wasPressed(window) {
+		state.closeStartScreen()
+		return
+	}
+
+	screen := state.startScreen
+	if state.imagesLost(window, startScreenImages) || !screen.uploaded {
+		screen.uploadThumbnails(window)
+	}
+
+	windowW, windowH := window.Size()
+	mouseX, mouseY := window.MousePosition()
+	leftClick := wasLeftClicked(window)
+
+	const textScale = 1.5
+	_, textH := window.GetScaledTextSize("|", textScale)
+	rowH := textH + 8
+
+	panel := rect(0, 0, min(windowW-40, 1300), windowH-80)
+	panel.x = (windowW - panel.w) / 2
+	panel.y = (windowH - panel.h) / 2
+	panel.fill(window, draw.Black)
+	panel.inset(5).fill(window, draw.White)
+
+	title := "Recent Games and Sessions"
+	titleW, _ := window.GetScaledTextSize(title, textScale)
+	y := panel.y + 20
+	window.DrawScaledText(title, panel.x+(panel.w-titleW)/2, y, textScale, draw.Black)
+	y += 2 * rowH
+
+	// The cards show the thumbnail, the file, the game and when it was used.
+	const cardTextScale = 1.0
+	_, cardTextH := window.GetScaledTextSize("|", cardTextScale)
+	cardW := gameboy.ScreenWidth + 20
+	cardH := gameboy.ScreenHeight + 3*(cardTextH+4) + 20
+	const gap = 20
+	columns := max(1, (panel.w-40+gap)/(cardW+gap))
+	rows := max(0, (panel.y+panel.h-3*rowH-y+gap)/(cardH+gap))
+	left := panel.x + (panel.w-columns*(cardW+gap)+gap)/2
+
+	for i := range min(len(screen.entries), columns*rows) {
+		e := screen.entries[i]
+		card := rect(
+			left+i%columns*(cardW+gap),
+			y+i/columns*(cardH+gap),
+			cardW,
+			cardH,
+		)
+		hover := card.contains(mouseX, mouseY)
+		color := draw.LightGray
+		if hover {
+			color = draw.LightPurple
+		}
+		card.fill(window, color)
+
+		x := card.x + 10
+		textY := card.y + 10
+		if e.Thumbnail != nil {
+			window.DrawImageFile(startScreenThumbnailName(i), x, textY)
+		} else {
+			window.FillRect(x, textY, gameboy.ScreenWidth, gameboy.ScreenHeight, placeholderColor)
+		}
+		textY += gameboy.ScreenHeight + 4
+
+		kind := "ROM"
+		if e.isSession() {
+			kind = "Session"
+		}
+		for _, text := range []string{
+			e.name(),
+			e.Title,
+			kind + ", " + e.Used.Format("2006-01-02 15:04"),
+		} {
+			text = fitText(window, text, gameboy.ScreenWidth, cardTextScale)
+			window.DrawScaledText(text, x, textY, cardTextScale, draw.Black)
+			textY += cardTextH + 4
+		}
+
+		if leftClick && hover {
+			state.openLibraryEntry(window, e)
+			return
+		}
+	}
+	if more := len(screen.entries) - columns*rows; more > 0 {
+		note := fmt.Sprintf("%d more do not fit into the window", more)
+		noteW, _ := window.GetScaledTextSize(note, textScale)
+		window.DrawScaledText(note, panel.x+(panel.w-noteW)/2, panel.y+panel.h-3*rowH-10, textScale, draw.DarkGray)
+	}
+
+	buttons := []struct {
+		text  string
+		click func()
+	}{
+		{"Continue", state.closeStartScreen},
+		{"Open Session...", func() {
+			state.closeStartScreen()
+			state.openFile(window)
+		}},
+		{"New Session From ROM...", func() {
+			state.closeStartScreen()
+			state.createNewSpeedrun(window)
+		}},
+	}
+	buttonsW := 0
+	for _, b := range buttons {
+		w, _ := window.GetScaledTextSize(b.text, textScale)
+		buttonsW += w + 20 + gap
+	}
+	x := panel.x + (panel.w-buttonsW+gap)/2
+	for _, b := range buttons {
+		w, _ := window.GetScaledTextSize(b.text, textScale)
+		button := rect(x, panel.y+panel.h-2*rowH-10, w+20, rowH+6)
+		color := draw.LightPurple
+		if button.contains(mouseX, mouseY) {
+			color = draw.Purple
+		}
+		button.fill(window, color)
+		window.DrawScaledText(b.text, button.x+10, button.y+7, textScale, draw.Black)
+		if leftClick && button.contains(mouseX, mouseY) {
+			b.click()
+			return
+		}
+		x += button.w + gap
+	}
+}
+
+// fitText shortens the text with "..." to at most width pixels.
+func fitText(window draw.Window, text string, width int, textScale float32) string {
+	if w, _ := window.GetScaledTextSize(text, textScale); w <= width {
+		return text
+	}
+	runes := []rune(text)
+	for len(runes) > 0 {
+		runes = runes[:len(runes)-1]
+		short := string(runes) + "..."
+		if w, _ := window.GetScaledTextSize(short, textScale); w <= width {
+			return short
+		}
+	}
+	return ""
+}
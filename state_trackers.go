@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Humpheh/goboy/gameboy"
+)
+
+// A stateTracker derives values that runners of a game watch from the
+// emulator state, e.g. its random number generator. The editor shows them
+// below every frame in the grid.
+//
+// Trackers for more games are added by implementing the interface in a new
+// file and registering it in an init function.
+type stateTracker interface {
+	// matches reports whether the tracker is made for the ROM.
+	matches(h romHeader) bool
+	// track returns the values after a frame.
+	track(gb *gameboy.Gameboy) []trackedValue
+}
+
+type trackedValue struct {
+	label string
+	value string
+}
+
+var stateTrackers []stateTracker
+
+func registerStateTracker(t stateTracker) {
+	stateTrackers = append(stateTrackers, t)
+}
+
+// findStateTracker returns the first tracker made for the ROM or nil.
+func findStateTracker(rom []byte) stateTracker {
+	h, ok := parseROMHeader(rom)
+	if !ok {
+		return nil
+	}
+	for _, t := range stateTrackers {
+		if t.matches(h) {
+			return t
+		}
+	}
+	return nil
+}
+
+// formatTrackedValues joins the values into one line, e.g. "RNG 3A DSUM 7F".
+func formatTrackedValues(values []trackedValue) string {
+	var parts []string
+	for _, v := range values {
+		parts = append(parts, v.label+" "+v.value)
+	}
+	return strings.Join(parts, " ")
+}
+
+func init() {
+	// Red, Blue and Yellow keep their RNG in hRandomAdd and hRandomSub.
+	registerStateTracker(pokemonRNGTracker{
+		titles:    []string{"POKEMON RED", "POKEMON BLUE", "POKEMON YELLOW"},
+		randomAdd: 0xFFD3,
+		randomSub: 0xFFD4,
+	})
+	// So do Gold, Silver and Crystal, at different addresses.
+	registerStateTracker(pokemonRNGTracker{
+		titles:    []string{"POKEMON_GLD", "POKEMON_SLV", "PM_CRYSTAL"},
+		randomAdd: 0xFFE1,
+		randomSub: 0xFFE2,
+	})
+}
+
+// pokemonRNGTracker shows the two RNG bytes of the Pokémon games and their sum,
+// the DSUM, which decides wild encounters.
+type pokemonRNGTracker struct {
+	// titles are prefixes of the ROM titles.
+	titles               []string
+	randomAdd, randomSub uint16
+}
+
+func (t pokemonRNGTracker) matches(h romHeader) bool {
+	for _, title := range t.titles {
+		if strings.HasPrefix(h.title, title) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t pokemonRNGTracker) track(gb *gameboy.Gameboy) []trackedValue {
+	add := gb.ReadMemory(t.randomAdd)
+	sub := gb.ReadMemory(t.randomSub)
+	return []trackedValue{
+		{label: "RNG", value: fmt.Sprintf("%02X%02X", add, sub)},
+		{label: "DSUM", value: fmt.Sprintf("%02X", add+sub)},
+	}
+}
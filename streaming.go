@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/Humpheh/goboy/gameboy"
+)
+
+// The replay can be streamed to tools like OBS. The screen is served as an
+// MJPEG stream over HTTP and the pressed buttons are written to a text file
+// which OBS can display with a text source.
+
+const streamBoundary = "gameboyframe"
+
+type streamServer struct {
+	mu    sync.Mutex
+	frame []byte
+	// newFrame is closed and replaced whenever a new frame is published.
+	newFrame chan struct{}
+}
+
+var (
+	globalStream *streamServer
+	// lastStreamedInputs avoids re-writing the inputs file every frame.
+	lastStreamedInputs = "-"
+)
+
+// startStreaming serves the MJPEG stream on the given address, e.g.
+// "localhost:8090", in the background.
+func startStreaming(address string) {
+	s := &streamServer{newFrame: make(chan struct{})}
+	globalStream = s
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.serveMJPEG)
+	go func() {
+		if err := http.ListenAndServe(address, mux); err != nil {
+			fmt.Println("streaming server failed:", err)
+		}
+	}()
+}
+
+func (s *streamServer) serveMJPEG(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+streamBoundary)
+	w.Header().Set("Cache-Control", "no-cache")
+
+	for {
+		s.mu.Lock()
+		frame, next := s.frame, s.newFrame
+		s.mu.Unlock()
+
+		if frame != nil {
+			_, err := fmt.Fprintf(
+				w,
+				"--%s\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n",
+				streamBoundary, len(frame),
+			)
+			if err == nil {
+				// Other handlers send the same frame, we must not append to
+				// it.
+				_, err = w.Write(frame)
+			}
+			if err == nil {
+				_, err = io.WriteString(w, "\r\n")
+			}
+			if err != nil {
+				return
+			}
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+		}
+
+		select {
+		case <-next:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *streamServer) publish(screen *gameboy.Screen) {
+	img := image.NewRGBA(image.Rect(0, 0, gameboy.ScreenWidth, gameboy.ScreenHeight))
+	for y := range gameboy.ScreenHeight {
+		for x := range gameboy.ScreenWidth {
+			c := screen.Pixel(x, y)
+			img.SetRGBA(x, y, color.RGBA{R: c[0], G: c[1], B: c[2], A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		fmt.Println("encoding stream frame failed:", err)
+		return
+	}
+
+	s.mu.Lock()
+	s.frame = buf.Bytes()
+	close(s.newFrame)
+	s.newFrame = make(chan struct{})
+	s.mu.Unlock()
+}
+
+// streamReplayFrame publishes the displayed replay screen and its inputs if
+// streaming is enabled.
+func streamReplayFrame(screen *gameboy.Screen, inputs inputState) {
+	if globalStream != nil {
+		globalStream.publish(screen)
+	}
+
+	if *streamInputsPath != "" {
+		caption := inputCaption(inputs)
+		if caption != lastStreamedInputs {
+			lastStreamedInputs = caption
+			if err := os.WriteFile(*streamInputsPath, []byte(caption), 0666); err != nil {
+				fmt.Println("writing stream inputs failed:", err)
+			}
+		}
+	}
+}
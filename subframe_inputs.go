@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Humpheh/goboy/gameboy"
+)
+
+// A game can read the joypad several times in one frame. Normally the buttons
+// stay the same for the whole frame. Subframe inputs change them from a given
+// read of the joypad register on, which some console verification formats
+// support and some glitches need. They are an advanced feature, the frame's
+// inputs still decide the buttons up to the first change.
+
+// maxSubframeInputs is the number of changes that a frame can have.
+const maxSubframeInputs = gameboy.MaxPollInputs
+
+type subframeInput struct {
+	// fromPoll is the read of the joypad register, counting from 1, at which
+	// the buttons change to inputs. 0 marks unused changes.
+	fromPoll int32
+	inputs   inputState
+}
+
+// setPollInputs makes the changes apply to the next frame.
+func setPollInputs(gb *gameboy.Gameboy, changes []subframeInput) {
+	gb.PollInputs = [maxSubframeInputs]gameboy.PollInput{}
+	for i, c := range changes[:min(len(changes), maxSubframeInputs)] {
+		gb.PollInputs[i] = gameboy.PollInput{
+			FromPoll: c.fromPoll,
+			Buttons:  byte(c.inputs &^ frameEvents),
+		}
+	}
+}
+
+// formatPolls describes when the game read the joypad in the last frame.
+func formatPolls(gb *gameboy.Gameboy) string {
+	switch gb.JoypadPolls {
+	case 0:
+		return "lag frame"
+	case 1:
+		return fmt.Sprintf("1 poll at %d%%", gb.FirstPollCycle*100/gameboy.CyclesPerFrame)
+	default:
+		return fmt.Sprintf(
+			"%d polls from %d%%",
+			gb.JoypadPolls, gb.FirstPollCycle*100/gameboy.CyclesPerFrame,
+		)
+	}
+}
+
+// parseSubframeInputs parses changes like "3:a,right 5:", which presses A and
+// Right from the 3rd read of the joypad on and releases all buttons from the
+// 5th read on.
+func parseSubframeInputs(text string) ([]subframeInput, error) {
+	var changes []subframeInput
+	for change := range strings.FieldsSeq(text) {
+		poll, buttons, ok := strings.Cut(change, ":")
+		if !ok {
+			return nil, fmt.Errorf("'%s' is missing the ':' after the read number", change)
+		}
+		n, err := strconv.Atoi(poll)
+		if err != nil || n < 2 {
+			return nil, fmt.Errorf("'%s' is not a read number of 2 or more", poll)
+		}
+		inputs, err := parseButtonList(buttons)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, subframeInput{fromPoll: int32(n), inputs: inputs})
+	}
+	if len(changes) > maxSubframeInputs {
+		return nil, fmt.Errorf("a frame can have at most %d changes", maxSubframeInputs)
+	}
+	slices.SortFunc(changes, func(a, b subframeInput) int {
+		return int(a.fromPoll - b.fromPoll)
+	})
+	return changes, nil
+}
+
+func formatSubframeInputs(changes []subframeInput) string {
+	parts := make([]string, len(changes))
+	for i, c := range changes {
+		var buttons []string
+		for b := range gameboy.ButtonCount {
+			if isButtonDown(c.inputs, b) {
+				buttons = append(buttons, remoteButtonNames[b])
+			}
+		}
+		parts[i] = fmt.Sprintf("%d:%s", c.fromPoll, strings.Join(buttons, ","))
+	}
+	return strings.Join(parts, " ")
+}
+
+// startModalSubframeDialog edits the subframe inputs of the first selected
+// frame.
+func (s *editorState) startModalSubframeDialog() {
+	if s.branchLocked() {
+		return
+	}
+
+	frameIndex := s.activeSelection.start()
+	gb := s.generateFrame(frameIndex)
+	title := fmt.Sprintf(
+		"Buttons from a Joypad Read of Frame %d (%s), e.g. 3:a,right 5:",
+		frameIndex, formatPolls(&gb),
+	)
+	text := formatSubframeInputs(s.branch().subframeInputs[frameIndex])
+	s.startModalTextDialog(title, text, func(text string) {
+		changes, err := parseSubframeInputs(text)
+		if err != nil {
+			s.setWarning(err.Error())
+			return
+		}
+
+		b := s.branch()
+		if len(changes) == 0 {
+			delete(b.subframeInputs, frameIndex)
+		} else {
+			if b.subframeInputs == nil {
+				b.subframeInputs = make(map[int][]subframeInput)
+			}
+			b.subframeInputs[frameIndex] = changes
+		}
+		s.setDirtyFrame(frameIndex)
+		s.render()
+	})
+}
+
+// cloneSubframeInputs copies the map and the changes of every frame, edits
+// of the original do not show in the copy.
+func cloneSubframeInputs(inputs map[int][]subframeInput) map[int][]subframeInput {
+	if inputs == nil {
+		return nil
+	}
+	clone := make(map[int][]subframeInput, len(inputs))
+	for frameIndex, changes := range inputs {
+		clone[frameIndex] = slices.Clone(changes)
+	}
+	return clone
+}
+
+// subframeDifferences returns the frames, unsorted, whose subframe inputs
+// differ between a and b.
+func subframeDifferences(a, b map[int][]subframeInput) []int {
+	var frames []int
+	for frameIndex, changes := range a {
+		if !slices.Equal(changes, b[frameIndex]) {
+			frames = append(frames, frameIndex)
+		}
+	}
+	for frameIndex := range b {
+		if _, ok := a[frameIndex]; !ok {
+			frames = append(frames, frameIndex)
+		}
+	}
+	return frames
+}
+
+// subframeFrames returns the frames of the branch that have subframe inputs,
+// sorted.
+func (b *branch) subframeFrames() []int {
+	return slices.Sorted(maps.Keys(b.subframeInputs))
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"slices"
+	"sync/atomic"
+
+	"github.com/Humpheh/goboy/gameboy"
+)
+
+// A sync anchor remembers the screen of a frame, usually one at which a later
+// segment of the run starts. After every edit before an anchor, the branch is
+// emulated up to it again on a background goroutine and the anchor is flagged
+// if its screen changed. That catches edits that accidentally desync the rest
+// of the run right away, not only when replaying it. Setting the anchor again
+// accepts the new screen.
+type syncAnchor struct {
+	frameIndex int
+	screenHash uint64
+	// desynced is set when the last check found a different screen.
+	desynced bool
+}
+
+// syncAnchorCheck emulates the active branch from the first edited frame to
+// its last anchor.
+type syncAnchorCheck struct {
+	branchName string
+	firstFrame int
+	// emulatedFrames counts the frames from firstFrame on that the check has
+	// run. Anchors at later frames are shown as still being checked.
+	emulatedFrames atomic.Int64
+	// anchors receives the screen of every anchor that was reached and is
+	// closed when the check is done.
+	anchors chan syncAnchor
+	cancel  chan struct{}
+}
+
+// screenHash hashes what a sync anchor compares, the screen.
+func screenHash(gb *gameboy.Gameboy) uint64 {
+	h := fnv.New64a()
+	for y := range gb.PreparedData {
+		h.Write(gb.PreparedData[y][:])
+	}
+	return h.Sum64()
+}
+
+func (b *branch) anchorIndex(frameIndex int) int {
+	return slices.IndexFunc(b.anchors, func(a syncAnchor) bool {
+		return a.frameIndex == frameIndex
+	})
+}
+
+// toggleSyncAnchor sets a sync anchor at the first selected frame. If there
+// already is one, a desynced anchor accepts the current screen and an anchor
+// that is in sync is removed.
+func (s *editorState) toggleSyncAnchor() {
+	frameIndex := s.activeSelection.start()
+	b := s.branch()
+	gb := s.generateFrame(frameIndex)
+	hash := screenHash(&gb)
+
+	if i := b.anchorIndex(frameIndex); i != -1 {
+		if b.anchors[i].desynced {
+			b.anchors[i] = syncAnchor{frameIndex: frameIndex, screenHash: hash}
+			s.setInfo(fmt.Sprintf("Sync anchor at frame %d accepts the new screen.", frameIndex))
+		} else {
+			b.anchors = slices.Delete(b.anchors, i, i+1)
+			s.setInfo(fmt.Sprintf("Removed the sync anchor at frame %d.", frameIndex))
+		}
+		s.unsavedChanges = true
+		s.render()
+		return
+	}
+
+	i := 0
+	for i < len(b.anchors) && b.anchors[i].frameIndex < frameIndex {
+		i++
+	}
+	b.anchors = slices.Insert(b.anchors, i, syncAnchor{frameIndex: frameIndex, screenHash: hash})
+	s.setInfo(fmt.Sprintf("Set a sync anchor at frame %d, edits before it are checked against its screen.", frameIndex))
+	s.unsavedChanges = true
+	s.render()
+}
+
+// recheckSyncAnchorsFrom is called when the frames from frameIndex on change.
+// The check starts in the next UI frame, after the edit is done.
+func (s *editorState) recheckSyncAnchorsFrom(frameIndex int) {
+	if s.recheckAnchors {
+		s.recheckAnchorsFrom = min(s.recheckAnchorsFrom, frameIndex)
+	} else {
+		s.recheckAnchors = true
+		s.recheckAnchorsFrom = frameIndex
+	}
+	if c := s.syncAnchorCheck; c != nil {
+		// The running check still has to cover its own range.
+		s.recheckAnchorsFrom = min(s.recheckAnchorsFrom, c.firstFrame)
+	}
+}
+
+// startSyncAnchorCheck emulates the active branch from the last valid key
+// frame before the first changed frame up to its last anchor.
+func (s *editorState) startSyncAnchorCheck(firstChanged int) {
+	s.cancelSyncAnchorCheck()
+
+	b := s.branch()
+	if len(b.anchors) == 0 || b.anchors[len(b.anchors)-1].frameIndex < firstChanged {
+		return
+	}
+	lastFrame := b.anchors[len(b.anchors)-1].frameIndex
+	s.createInputsUpTo(lastFrame)
+
+	c := &syncAnchorCheck{
+		branchName: b.name,
+		anchors:    make(chan syncAnchor, 1),
+		cancel:     make(chan struct{}),
+	}
+	var start gameboy.Gameboy
+	if k := min(len(s.keyFrameStates), (firstChanged+keyFrameInterval-1)/keyFrameInterval); k > 0 {
+		start = s.keyFrameStates[k-1].gameboy()
+		c.firstFrame = (k-1)*keyFrameInterval + 1
+	} else {
+		start = s.startGameboy(s.gameboyOptions)
+	}
+	s.syncAnchorCheck = c
+	go c.run(
+		start,
+		slices.Clone(b.frameInputs[:lastFrame+1]),
+		maps.Clone(b.subframeInputs),
+		slices.Clone(b.anchors),
+	)
+}
+
+func (c *syncAnchorCheck) run(gb gameboy.Gameboy, inputs []inputState, subframes map[int][]subframeInput, anchors []syncAnchor) {
+	defer close(c.anchors)
+
+	// We do not need any sound to compare the screens.
+	gb.Options.Sound = false
+
+	for len(anchors) > 0 && anchors[0].frameIndex < c.firstFrame {
+		anchors = anchors[1:]
+	}
+
+	for i := c.firstFrame; i < len(inputs); i++ {
+		select {
+		case <-c.cancel:
+			return
+		default:
+		}
+
+		applyInputs(&gb, inputs[i])
+		setPollInputs(&gb, subframes[i])
+		gb.Step()
+		c.emulatedFrames.Store(int64(i + 1 - c.firstFrame))
+
+		if len(anchors) > 0 && anchors[0].frameIndex == i {
+			select {
+			case c.anchors <- syncAnchor{frameIndex: i, screenHash: screenHash(&gb)}:
+			case <-c.cancel:
+				return
+			}
+			anchors = anchors[1:]
+		}
+	}
+}
+
+// cancelSyncAnchorCheck stops a running check. It is safe to call if none is
+// running.
+func (s *editorState) cancelSyncAnchorCheck() {
+	if s.syncAnchorCheck != nil {
+		close(s.syncAnchorCheck.cancel)
+		s.syncAnchorCheck = nil
+	}
+}
+
+// updateSyncAnchorCheck is called once per UI frame. It starts a check after
+// an edit and flags the anchors that the running check has reached.
+func (s *editorState) updateSyncAnchorCheck() {
+	if s.recheckAnchors {
+		s.recheckAnchors = false
+		s.startSyncAnchorCheck(s.recheckAnchorsFrom)
+	}
+
+	c := s.syncAnchorCheck
+	if c == nil {
+		return
+	}
+
+	for {
+		select {
+		case checked, ok := <-c.anchors:
+			if !ok {
+				s.syncAnchorCheck = nil
+				s.render()
+				return
+			}
+			// The anchor is no longer shown as being checked.
+			s.render()
+			b := s.branch()
+			if b.name != c.branchName {
+				continue
+			}
+			i := b.anchorIndex(checked.frameIndex)
+			if i == -1 {
+				continue
+			}
+			anchor := &b.anchors[i]
+			wasDesynced := anchor.desynced
+			anchor.desynced = checked.screenHash != anchor.screenHash
+			if anchor.desynced != wasDesynced {
+				s.unsavedChanges = true
+			}
+			if anchor.desynced && !wasDesynced {
+				s.setWarning(fmt.Sprintf(
+					"The screen at the sync anchor at frame %d changed, the run might have desynced there.",
+					anchor.frameIndex,
+				))
+			}
+		default:
+			return
+		}
+	}
+}
+
+// syncAnchorState describes the anchor at the frame in the active branch, ""
+// if there is none.
+func (s *editorState) syncAnchorState(frameIndex int) string {
+	b := s.branch()
+	i := b.anchorIndex(frameIndex)
+	if i == -1 {
+		return ""
+	}
+	if c := s.syncAnchorCheck; c != nil && c.branchName == b.name &&
+		frameIndex >= c.firstFrame+int(c.emulatedFrames.Load()) {
+		return "anchor checking"
+	}
+	if b.anchors[i].desynced {
+		return "anchor DESYNCED"
+	}
+	return "anchor"
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/Humpheh/goboy/gameboy"
+)
+
+// runTestROM runs one of blargg's or mooneye's test ROMs until it reports its
+// result and returns the process exit code, 0 if the test passed.
+func runTestROM(path string) int {
+	rom, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Println(err)
+		return 2
+	}
+
+	serial, err := gameboy.RunTestROM(rom, gameboy.DefaultConsoleModel(rom))
+	if len(serial) > 0 {
+		fmt.Println(string(bytes.TrimSpace(serial)))
+	}
+	switch err {
+	case nil:
+		fmt.Println("PASS", path)
+		return 0
+	case gameboy.ErrTestROMTimeout:
+		fmt.Println("TIMEOUT", path)
+		return 1
+	default:
+		fmt.Println("FAIL", path)
+		return 1
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"github.com/gonutz/prototype/draw"
+
+	"github.com/Humpheh/goboy/gameboy"
+)
+
+// uiTheme holds the colors of the editor around the Gameboy screens. The
+// dialogs always use black on white.
+type uiTheme struct {
+	name string
+	// background fills the window around and below the grid of frames.
+	background     draw.Color
+	menuBackground draw.Color
+	menuText       draw.Color
+	menuHover      draw.Color
+	button         draw.Color
+	buttonHover    draw.Color
+	selection      draw.Color
+	highlight      draw.Color
+	infoBackground draw.Color
+	infoText       draw.Color
+	warningText    draw.Color
+	// emptyBorder is the border of frames without any buttons pressed.
+	emptyBorder draw.Color
+	// borderFloor lifts the channels of the input border colors, light themes
+	// use this so dark text stays readable on them.
+	borderFloor float32
+}
+
+var uiThemes = []uiTheme{
+	{
+		name:           "Dark",
+		background:     draw.Black,
+		menuBackground: rgb(224, 248, 208),
+		menuText:       draw.Black,
+		menuHover:      draw.RGBA(0, 0.5, 0, 0.3),
+		button:         draw.LightPurple,
+		buttonHover:    draw.Purple,
+		selection:      draw.RGBA(1, 0.5, 0.5, 0.2),
+		highlight:      draw.RGBA(1, 0.5, 1, 0.25),
+		infoBackground: draw.RGBA(0, 0, 0, 0.8),
+		infoText:       draw.White,
+		warningText:    draw.RGBA(1, 92/255.0, 92/255.0, 1),
+		emptyBorder:    draw.Black,
+	},
+	{
+		name:           "Light",
+		background:     rgb(236, 236, 236),
+		menuBackground: draw.White,
+		menuText:       draw.Black,
+		menuHover:      draw.RGBA(0, 0.3, 0.8, 0.25),
+		button:         rgb(200, 215, 255),
+		buttonHover:    rgb(150, 175, 255),
+		selection:      draw.RGBA(0.2, 0.4, 1, 0.25),
+		highlight:      draw.RGBA(1, 0.5, 1, 0.3),
+		infoBackground: draw.RGBA(1, 1, 1, 0.85),
+		infoText:       draw.Black,
+		warningText:    rgb(180, 0, 0),
+		emptyBorder:    rgb(200, 200, 200),
+		borderFloor:    0.5,
+	},
+	{
+		name:           "High Contrast",
+		background:     draw.Black,
+		menuBackground: draw.White,
+		menuText:       draw.Black,
+		menuHover:      draw.RGBA(0, 0, 1, 0.4),
+		button:         draw.Yellow,
+		buttonHover:    rgb(255, 170, 0),
+		selection:      draw.RGBA(1, 1, 0, 0.45),
+		highlight:      draw.RGBA(1, 0, 1, 0.5),
+		infoBackground: draw.Black,
+		infoText:       draw.White,
+		warningText:    rgb(255, 80, 80),
+		emptyBorder:    rgb(64, 64, 64),
+	},
+}
+
+func currentTheme() *uiTheme {
+	return &uiThemes[globalSettings.Theme]
+}
+
+// textColorOn returns black or white, whichever is more readable on c.
+func textColorOn(c draw.Color) draw.Color {
+	if 0.299*c.R+0.587*c.G+0.114*c.B > 0.55 {
+		return draw.Black
+	}
+	return draw.White
+}
+
+// inputBorderColor encodes the buttons in a color. The directions are green,
+// A, Start and Select are blue and B is red.
+func inputBorderColor(inputs inputState) draw.Color {
+	borderColor := draw.RGBA(0, 0, 0, 1)
+
+	// Create a 4 bit value for the directional keys: DURL
+	// (down up right left).
+	var directionalButtons byte
+	if isButtonDown(inputs, gameboy.ButtonLeft) {
+		directionalButtons += 1
+	}
+	if isButtonDown(inputs, gameboy.ButtonRight) {
+		directionalButtons += 2
+	}
+	if isButtonDown(inputs, gameboy.ButtonUp) {
+		directionalButtons += 4
+	}
+	if isButtonDown(inputs, gameboy.ButtonDown) {
+		directionalButtons += 8
+	}
+
+	// Valid combinations, which you could actually press on
+	// a real Gameboy, get a green tint between 100 and 200.
+	// Illegal combinations, like Left+Right, get 255 so
+	// they stand out as a very bright green.
+	borderColor.G = directionShades[directionalButtons]
+
+	if isButtonDown(inputs, gameboy.ButtonA) ||
+		isButtonDown(inputs, gameboy.ButtonStart) ||
+		isButtonDown(inputs, gameboy.ButtonSelect) {
+		borderColor.B = 192 / 255.0
+	}
+
+	if isButtonDown(inputs, gameboy.ButtonB) {
+		borderColor.R = 192 / 255.0
+	}
+
+	return borderColor
+}
+
+// directionShades are indexed by the DURL bits of the pressed directions.
+var directionShades = []float32{
+	0,           // durl
+	100 / 255.0, // durL
+	157 / 255.0, // duRl
+	255 / 255.0, // duRL
+	114 / 255.0, // dUrl
+	128 / 255.0, // dUrL
+	142 / 255.0, // dURl
+	255 / 255.0, // dURL
+	171 / 255.0, // Durl
+	200 / 255.0, // DurL
+	185 / 255.0, // DuRl
+	255 / 255.0, // DuRL
+	255 / 255.0, // DUrl
+	255 / 255.0, // DUrL
+	255 / 255.0, // DURl
+	255 / 255.0, // DURL
+}
+
+// Colors for the color-blind safe borders, from the Okabe-Ito palette, which
+// stays distinguishable with all common kinds of color blindness.
+var (
+	colorBlindDirections        = rgb(0, 114, 178)
+	colorBlindIllegalDirections = rgb(86, 180, 233)
+	colorBlindAStartSelect      = rgb(213, 94, 0)
+	colorBlindB                 = rgb(204, 121, 167)
+)
+
+// drawFrameBorder draws the border of a frame in the grid, which shows the
+// pressed buttons, with a title bar of height titleH at the top. It returns
+// the color for text in the title bar.
+func drawFrameBorder(window draw.Window, r rectangle, titleH int, inputs inputState) draw.Color {
+	theme := currentTheme()
+
+	if globalSettings.ColorBlindBorders {
+		// The title bar is split into three stripes, from top to bottom the
+		// directions, A/Start/Select and B. Their position tells them apart
+		// as well as their colors.
+		rect(r.x, r.y, r.w, titleH).fill(window, theme.emptyBorder)
+		stripeH := max(1, titleH/3)
+		stripe := func(i int, c draw.Color) {
+			rect(r.x, r.y+i*stripeH, r.w, stripeH).fill(window, c)
+		}
+		pressed := false
+		if shade := inputBorderColor(inputs).G; shade > 0 {
+			c := colorBlindDirections
+			if shade == 1 {
+				c = colorBlindIllegalDirections
+			}
+			stripe(0, c)
+			pressed = true
+		}
+		if isButtonDown(inputs, gameboy.ButtonA) ||
+			isButtonDown(inputs, gameboy.ButtonStart) ||
+			isButtonDown(inputs, gameboy.ButtonSelect) {
+			stripe(1, colorBlindAStartSelect)
+			pressed = true
+		}
+		if isButtonDown(inputs, gameboy.ButtonB) {
+			stripe(2, colorBlindB)
+			pressed = true
+		}
+		drawFrameSides(window, r, theme.emptyBorder)
+		if pressed {
+			// The text crosses the stripes, white is readable on all of them.
+			return draw.White
+		}
+		return textColorOn(theme.emptyBorder)
+	}
+
+	c := inputBorderColor(inputs)
+	if c == draw.RGBA(0, 0, 0, 1) {
+		c = theme.emptyBorder
+	} else if theme.borderFloor > 0 {
+		f := theme.borderFloor
+		c.R, c.G, c.B = f+(1-f)*c.R, f+(1-f)*c.G, f+(1-f)*c.B
+	}
+	rect(r.x, r.y, r.w, titleH).fill(window, c)
+	drawFrameSides(window, r, c)
+	return textColorOn(c)
+}
+
+func drawFrameSides(window draw.Window, r rectangle, c draw.Color) {
+	window.FillRect(r.x, r.y, 1, r.h, c)
+	window.FillRect(r.x, r.y+r.h-1, r.w, 1, c)
+	window.FillRect(r.x+r.w-1, r.y, 1, r.h, c)
+}
+
+// branchColors are the colors that the user can give to branches. Index 0
+// means the branch has no color. The others are the Okabe-Ito palette again.
+var branchColors = []struct {
+	name  string
+	color draw.Color
+}{
+	{"None", draw.Color{}},
+	{"Orange", rgb(230, 159, 0)},
+	{"Sky Blue", rgb(86, 180, 233)},
+	{"Green", rgb(0, 158, 115)},
+	{"Yellow", rgb(240, 228, 66)},
+	{"Blue", rgb(0, 114, 178)},
+	{"Vermillion", rgb(213, 94, 0)},
+	{"Purple", rgb(204, 121, 167)},
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/Humpheh/goboy/gameboy"
+	"github.com/gonutz/prototype/draw"
+)
+
+const (
+	// maxSyncThumbnailFrames is the number of frames that we emulate on the
+	// UI thread for a single screen in the grid, e.g. after editing inputs
+	// every screen is only one frame away from the one before it. Screens
+	// that need more emulation are shown as placeholders until a
+	// thumbnailWorker has emulated them.
+	maxSyncThumbnailFrames = 8
+	// syncThumbnailTime is how long we emulate on the UI thread in one pass
+	// over the grid before the rest is left to the thumbnailWorker.
+	syncThumbnailTime = 10 * time.Millisecond
+)
+
+// placeholderColor fills the screens in the grid that are still emulated.
+var placeholderColor = draw.RGB(0x60/255.0, 0x60/255.0, 0x60/255.0)
+
+// thumbnailWorker emulates the visible frames in the background, from the
+// closest state that we have, so scrolling to a region that was never
+// emulated does not block the UI.
+type thumbnailWorker struct {
+	firstFrame, lastFrame int
+	frames                chan emulatedFrame
+	cancel                chan struct{}
+}
+
+type emulatedFrame struct {
+	index   int
+	gameboy *gameboy.Gameboy
+}
+
+// closestState returns the index of the latest cached frame or key frame at
+// or before frameIndex. It returns -1 if there is none and we have to start
+// with a new Gameboy.
+func (s *editorState) closestState(frameIndex int) int {
+	index := -1
+	if len(s.keyFrameStates) > 0 {
+		index = min(frameIndex/keyFrameInterval, len(s.keyFrameStates)-1) * keyFrameInterval
+	}
+	for _, cached := range s.frameCache.frameIndices {
+		if index < cached && cached <= frameIndex {
+			index = cached
+		}
+	}
+	return index
+}
+
+// emulationCost is the number of frames that generateFrame has to emulate to
+// create frameIndex.
+func (s *editorState) emulationCost(frameIndex int) int {
+	return frameIndex - s.closestState(frameIndex)
+}
+
+// requestThumbnails makes sure that a worker emulates the frames from first
+// to last.
+func (s *editorState) requestThumbnails(first, last int) {
+	if w := s.thumbnails; w != nil && w.firstFrame <= first && last <= w.lastFrame {
+		return
+	}
+	s.cancelThumbnails()
+
+	s.createInputsUpTo(last)
+	w := &thumbnailWorker{
+		firstFrame: first,
+		lastFrame:  last,
+		frames:     make(chan emulatedFrame, 1),
+		cancel:     make(chan struct{}),
+	}
+	var start gameboy.Gameboy
+	index := s.closestState(first)
+	if index != -1 {
+		start, _ = s.storedState(index)
+	} else {
+		start = s.startGameboy(s.gameboyOptions)
+	}
+	s.thumbnails = w
+	b := s.branch()
+	go w.run(start, index, slices.Clone(b.frameInputs[:last+1]), maps.Clone(b.subframeInputs))
+}
+
+func (w *thumbnailWorker) run(gb gameboy.Gameboy, startIndex int, inputs []inputState, subframes map[int][]subframeInput) {
+	defer close(w.frames)
+
+	for i := startIndex + 1; i <= w.lastFrame; i++ {
+		select {
+		case <-w.cancel:
+			return
+		default:
+		}
+
+		applyInputs(&gb, inputs[i])
+		setPollInputs(&gb, subframes[i])
+		gb.Step()
+
+		// Key frames on the way are useful to the UI as well.
+		if i >= w.firstFrame || i%keyFrameInterval == 0 {
+			frame := new(gameboy.Gameboy)
+			*frame = gb
+			select {
+			case w.frames <- emulatedFrame{index: i, gameboy: frame}:
+			case <-w.cancel:
+				return
+			}
+		}
+	}
+}
+
+// cancelThumbnails stops the running worker. It is safe to call if none is
+// running.
+func (s *editorState) cancelThumbnails() {
+	if s.thumbnails != nil {
+		close(s.thumbnails.cancel)
+		s.thumbnails = nil
+	}
+}
+
+// showThumbnail replaces the placeholder for frameIndex. We upload the screen
+// right away instead of leaving it to the frame cache, which might not hold
+// all frames of a large grid.
+func (s *editorState) showThumbnail(window draw.Window, frameIndex int, gb *gameboy.Gameboy) {
+	t := &s.screenTextures
+	i := frameIndex - s.leftMostFrame
+	if 0 <= i && i < len(t.cells) && t.cells[i] == -1 {
+		t.cells[i] = s.uploadScreenTile(window, s.wantedScreenTile(frameIndex), gb)
+		s.render()
+	}
+}
+
+// updateThumbnails is called once per UI frame. It caches the frames that
+// the worker has emulated so far and redraws the grid with them.
+func (s *editorState) updateThumbnails(window draw.Window) {
+	w := s.thumbnails
+	if w == nil {
+		return
+	}
+
+	for {
+		select {
+		case f, ok := <-w.frames:
+			if !ok {
+				s.thumbnails = nil
+				return
+			}
+			s.frameCache.set(f.index, *f.gameboy)
+			if f.index%keyFrameInterval == 0 &&
+				f.index/keyFrameInterval == len(s.keyFrameStates) {
+				s.addKeyFrame(*f.gameboy)
+			}
+			if f.index >= w.firstFrame {
+				s.showThumbnail(window, f.index, f.gameboy)
+			}
+		default:
+			return
+		}
+	}
+}
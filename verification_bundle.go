@@ -0,0 +1,159 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/Humpheh/goboy/gameboy"
+)
+
+// A verification bundle holds everything that a verifier of a submission
+// needs to play the current branch back: the inputs as a session file
+// without the ROM, the file the session starts from and verification.txt,
+// which lists the hashes of the ROM and the settings of the emulator. There is
+// no lsnes movie, its inputs are resampled to LCD frames and might not sync.
+
+// exportVerificationBundle saves the bundle of the current branch as a zip
+// file.
+func (s *editorState) exportVerificationBundle() error {
+	b := s.branch()
+	if len(b.frameInputs) == 0 {
+		return fmt.Errorf("nothing to verify, the branch has no inputs")
+	}
+
+	s.startSaveDialog("Export Verification Bundle", "Zip Archive", "zip", func(path string) error {
+		err := writeSessionFile(path, s.writeVerificationBundle)
+		if err != nil {
+			return fmt.Errorf("failed to export '%s': %w", path, err)
+		}
+		s.setInfo(fmt.Sprintf("Exported the verification bundle of %s to %s", b.name, path))
+		return nil
+	})
+	return nil
+}
+
+type bundleFile struct {
+	name    string
+	content []byte
+}
+
+func (s *editorState) writeVerificationBundle(w io.Writer) error {
+	// The session only holds the current branch, the verifier should not have
+	// to pick it.
+	session := s.sessionSnapshot(0)
+	session.branches = session.branches[s.branchIndex : s.branchIndex+1]
+	session.branchIndex = 0
+	var speedrun bytes.Buffer
+	if err := session.writeSession(&speedrun, s.rom, false, s.gameboyOptions.Model, nil); err != nil {
+		return err
+	}
+
+	files := []bundleFile{
+		{"verification.txt", nil},
+		{"inputs.speedrun", speedrun.Bytes()},
+	}
+
+	startFile := ""
+	if s.start.kind != startAtPowerOn {
+		startFile = "start_" + s.metadata.startFile
+		files = append(files, bundleFile{startFile, s.start.data})
+	}
+
+	files[0].content = []byte(s.verificationText(startFile))
+
+	z := zip.NewWriter(w)
+	for _, file := range files {
+		w, err := z.Create(file.name)
+		if err == nil {
+			_, err = w.Write(file.content)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return z.Close()
+}
+
+// verificationText lists what a verifier has to know to play the branch back
+// and check that it is the same game. startFile names the file in the bundle.
+func (s *editorState) verificationText(startFile string) string {
+	var text strings.Builder
+	line := func(key string, value any) {
+		fmt.Fprintf(&text, "%-20s %v\n", key+":", value)
+	}
+
+	m := &s.metadata
+	b := s.branch()
+	rom := s.rom
+
+	line("Game", m.gameTitle)
+	if h, ok := parseROMHeader(rom); ok {
+		line("Cartridge", h.summary())
+	}
+	line("ROM size", fmt.Sprintf("%d bytes", len(rom)))
+	line("ROM SHA-256", fmt.Sprintf("%x", sha256.Sum256(rom)))
+	line("ROM SHA-1", fmt.Sprintf("%x", sha1.Sum(rom)))
+	line("ROM MD5", fmt.Sprintf("%x", md5.Sum(rom)))
+	line("ROM CRC32", fmt.Sprintf("%08x", crc32.ChecksumIEEE(rom)))
+	text.WriteString("\n")
+
+	line("Console model", s.gameboyOptions.Model)
+	if s.gameboyOptions.Model == gameboy.ModelLegacy {
+		line("Boot ROM", "none, the game starts with the registers of older editor versions, no real console boots like this")
+	} else {
+		line("Boot ROM", fmt.Sprintf("none, the game starts with the registers the %s boot ROM leaves behind", s.gameboyOptions.Model))
+	}
+	line("Emulator", emulatorVersion())
+	line("Emulator state", fmt.Sprintf("version %d", gameboy.StateVersion))
+	text.WriteString("\n")
+
+	if s.start.kind == startAtPowerOn {
+		line("Starts from", "power on")
+	} else {
+		line("Starts from", fmt.Sprintf("%s %s, included as %s", s.start.kind, m.startFile, startFile))
+		line("Start file SHA-256", fmt.Sprintf("%x", sha256.Sum256(s.start.data)))
+	}
+	line("Branch", b.name)
+	line("Frames", len(b.frameInputs))
+	line("Time", fmt.Sprintf("%s at %d frames per second", formatRunTime(framesToDuration(len(b.frameInputs))), gameboy.FramesSecond))
+	line("Subframe inputs", fmt.Sprintf("%d frames", len(b.subframeInputs)))
+	line("Author", m.author)
+	line("Created", m.createdText())
+	line("Rerecords", m.rerecordCount)
+	line("Exported", time.Now().Format("2006-01-02 15:04"))
+	text.WriteString("\n")
+
+	line("Inputs", "inputs.speedrun, open it with the editor and the ROM")
+	return text.String()
+}
+
+// emulatorVersion names the editor build, with the revision it was built from
+// if Go recorded it.
+func emulatorVersion() string {
+	version := windowTitle
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return version + ", unknown build"
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			version += ", revision " + setting.Value
+		}
+		if setting.Key == "vcs.modified" && setting.Value == "true" {
+			version += " with local changes"
+		}
+	}
+	if info.Main.Version != "" && info.Main.Version != "(devel)" {
+		version += ", module version " + info.Main.Version
+	}
+	return version
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/Humpheh/goboy/gameboy"
+	"github.com/gonutz/prototype/draw"
+)
+
+// maxReportedDifferences limits how many differing Gameboy fields we list when
+// a verification fails.
+const maxReportedDifferences = 5
+
+// verification re-emulates the active branch from frame 0 on a background
+// goroutine. Every key frame and the last frame are sent back to the UI thread
+// which compares them to the states that we have stored, i.e. our key frames
+// and cached frames.
+type verification struct {
+	lastFrame int
+	// emulatedFrames is written by the background goroutine and read by the UI
+	// to display the progress.
+	emulatedFrames atomic.Int64
+	states         chan verifiedState
+	cancel         chan struct{}
+	comparedStates int
+}
+
+type verifiedState struct {
+	frameIndex int
+	gameboy    *gameboy.Gameboy
+}
+
+func (s *editorState) startVerification() {
+	s.cancelVerification()
+
+	inputs := s.branch().frameInputs
+	if len(inputs) == 0 {
+		s.setWarning("Nothing to verify, the branch has no inputs.")
+		s.render()
+		return
+	}
+
+	v := &verification{
+		lastFrame: len(inputs) - 1,
+		states:    make(chan verifiedState, 1),
+		cancel:    make(chan struct{}),
+	}
+	s.verification = v
+	go v.run(s.startGameboy(s.gameboyOptions), slices.Clone(inputs), maps.Clone(s.branch().subframeInputs))
+}
+
+// run emulates the whole branch as a single job, every frame depends on the one
+// before it. Running it on the emulation workers keeps it from competing with
+// the optimizer or fuzzer for more cores than there are.
+func (v *verification) run(start gameboy.Gameboy, inputs []inputState, subframes map[int][]subframeInput) {
+	defer close(v.states)
+
+	emulateJobs([]emulationJob{{
+		start:     &start,
+		inputs:    inputs,
+		subframes: subframes,
+		afterFrame: func(i int, gb *gameboy.Gameboy) bool {
+			select {
+			case <-v.cancel:
+				return false
+			default:
+			}
+
+			v.emulatedFrames.Store(int64(i + 1))
+
+			if i%keyFrameInterval == 0 || i == len(inputs)-1 {
+				state := new(gameboy.Gameboy)
+				*state = *gb
+				select {
+				case v.states <- verifiedState{frameIndex: i, gameboy: state}:
+				case <-v.cancel:
+					return false
+				}
+			}
+			return true
+		},
+	}})
+}
+
+// cancelVerification stops a running verification. It is safe to call if none
+// is running.
+func (s *editorState) cancelVerification() {
+	if s.verification != nil {
+		close(s.verification.cancel)
+		s.verification = nil
+	}
+}
+
+// updateVerification is called once per UI frame. It compares the states
+// that the background goroutine has produced so far with the stored ones.
+func (s *editorState) updateVerification() {
+	v := s.verification
+	if v == nil {
+		return
+	}
+
+	for {
+		select {
+		case have, ok := <-v.states:
+			if !ok {
+				s.verification = nil
+				if v.comparedStates == 0 {
+					s.setInfo(fmt.Sprintf(
+						"Verified frames 0 to %d, but no stored states existed to compare against.",
+						v.lastFrame,
+					))
+				} else {
+					s.setInfo(fmt.Sprintf(
+						"Verification passed: %d stored states up to frame %d match.",
+						v.comparedStates, v.lastFrame,
+					))
+				}
+				s.render()
+				return
+			}
+
+			diffs, ok := s.storedStateDifferences(have)
+			if !ok {
+				continue
+			}
+			v.comparedStates++
+
+			if len(diffs) > 0 {
+				s.cancelVerification()
+				s.setWarning(fmt.Sprintf(
+					"Verification failed, frame %d diverges in %s",
+					have.frameIndex,
+					formatDifferences(diffs),
+				))
+				s.render()
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// storedState returns the key frame or cached frame at exactly frameIndex if
+// we have one. It does not emulate anything.
+func (s *editorState) storedState(frameIndex int) (gameboy.Gameboy, bool) {
+	if frameIndex%keyFrameInterval == 0 {
+		i := frameIndex / keyFrameInterval
+		if i < len(s.keyFrameStates) {
+			return s.keyFrameStates[i].gameboy(), true
+		}
+	}
+
+	gb, index := s.frameCache.latestFrameUpTo(frameIndex)
+	if index == frameIndex {
+		return gb, true
+	}
+
+	return gameboy.Gameboy{}, false
+}
+
+// storedStateDifferences compares a state of the verification run with the
+// stored state at its frame. ok is false if we have no stored state there.
+func (s *editorState) storedStateDifferences(have verifiedState) (diffs []string, ok bool) {
+	want, ok := s.storedState(have.frameIndex)
+	if !ok {
+		return nil, false
+	}
+
+	gb := have.gameboy
+	if k := have.frameIndex / keyFrameInterval; have.frameIndex%keyFrameInterval == 0 &&
+		k < len(s.keyFrameStates) && s.keyFrameStates[k].state == nil {
+		// A compressed key frame went through SaveState, which does not keep
+		// the fields that only live within one frame, like the frame's sound
+		// samples. Our state takes the same way so only the saved fields are
+		// compared.
+		loaded, err := gameboy.LoadState(gb.Memory.Cart.ROM(), gb.SaveState())
+		if err != nil {
+			panic("decoding verified state: " + err.Error())
+		}
+		gb = &loaded
+	}
+	return gameboyDifferences(&want, gb, maxReportedDifferences+1), true
+}
+
+func formatDifferences(diffs []string) string {
+	if len(diffs) > maxReportedDifferences {
+		return strings.Join(diffs[:maxReportedDifferences], ", ") + " and more"
+	}
+	return strings.Join(diffs, ", ")
+}
+
+// gameboyDifferences returns the paths of the fields, like "CPU.PC" or
+// "Memory.WRAM[12]", that differ between a and b. At most maxCount paths are
+// returned.
+func gameboyDifferences(a, b *gameboy.Gameboy, maxCount int) []string {
+	if *a == *b {
+		return nil
+	}
+
+	var diffs []string
+	var walk func(path string, x, y reflect.Value)
+	walk = func(path string, x, y reflect.Value) {
+		if len(diffs) >= maxCount {
+			return
+		}
+
+		switch x.Kind() {
+		case reflect.Struct:
+			for i := range x.NumField() {
+				name := x.Type().Field(i).Name
+				if path != "" {
+					name = path + "." + name
+				}
+				walk(name, x.Field(i), y.Field(i))
+			}
+		case reflect.Array:
+			if x.Equal(y) {
+				return
+			}
+			for i := range x.Len() {
+				walk(fmt.Sprintf("%s[%d]", path, i), x.Index(i), y.Index(i))
+			}
+		default:
+			if !x.Equal(y) {
+				diffs = append(diffs, path)
+			}
+		}
+	}
+	walk("", reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem())
+
+	return diffs
+}
+
+func (s *editorState) renderVerificationProgress(window draw.Window) {
+	v := s.verification
+	if v == nil {
+		return
+	}
+
+	done := v.emulatedFrames.Load()
+	total := int64(v.lastFrame + 1)
+	text := fmt.Sprintf(
+		"Verifying frame %d of %d (F3 or Escape to cancel)",
+		done, total,
+	)
+	renderProgressBox(window, text, done, total, 0)
+}
+
+// renderProgressBox draws a text and a progress bar at the bottom of the frame
+// grid. Boxes of different background tasks are stacked on top of each other,
+// slot 0 being the bottom one.
+func renderProgressBox(window draw.Window, text string, done, total int64, slot int) {
+	windowW, windowH := window.Size()
+	gridW := windowW - inputMenuW - inputMenuMargin
+
+	const textScale = 2
+	textW, textH := window.GetScaledTextSize(text, textScale)
+
+	boxW := max(textW+40, gridW/2)
+	boxH := 2*textH + 40
+	box := rect((gridW-boxW)/2, windowH-(slot+1)*(boxH+40), boxW, boxH)
+	box.fill(window, draw.Black)
+	box.inset(2).fill(window, draw.DarkGray)
+
+	window.DrawScaledText(text, box.x+(box.w-textW)/2, box.y+10, textScale, draw.White)
+
+	bar := rect(box.x+20, box.y+textH+20, box.w-40, textH)
+	bar.fill(window, draw.Black)
+	bar.w = int(int64(bar.w) * done / total)
+	bar.fill(window, draw.Green)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyCompressedKeyFrames(t *testing.T) {
+	// The cartridge loops forever, the sound generates samples anyway.
+	rom := make([]byte, 0x8000)
+	rom[0x100], rom[0x101] = 0x18, 0xFE // JR -2
+
+	s := newEditorState()
+	s.rom = rom
+	s.gameboyOptions.Sound = true
+	s.createInputsUpTo(3 * keyFrameInterval)
+	s.generateFrame(3 * keyFrameInterval)
+	compressKeyFrames(s.keyFrameStates, 0)
+	s.frameCache.clear()
+
+	s.startVerification()
+	for s.verification != nil {
+		s.updateVerification()
+		time.Sleep(time.Millisecond)
+	}
+	if s.infoIsWarning || !strings.HasPrefix(s.infoText, "Verification passed") {
+		t.Fatal(s.infoText)
+	}
+}
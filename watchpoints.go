@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Humpheh/goboy/gameboy"
+)
+
+// watchpoint is a data breakpoint. The replay pauses in the frame in which the
+// game writes to address, if hasValue is set only when it writes value.
+type watchpoint struct {
+	address  uint16
+	value    byte
+	hasValue bool
+
+	// executingPC is the address of the instruction that is executing right
+	// now.
+	executingPC uint16
+	// hit is set by the first matching write in a frame, hitPC and hitValue
+	// tell which instruction wrote what.
+	hit      bool
+	hitPC    uint16
+	hitValue byte
+	// hitFrame is the last frame with a hit, -1 if there was none.
+	hitFrame int
+}
+
+// Execute and Write make the watchpoint a gameboy.Watcher, they are called
+// while it is set on the Gameboy.
+func (w *watchpoint) Execute(pc uint16) {
+	w.executingPC = pc
+}
+
+func (w *watchpoint) Write(address uint16, value byte) {
+	if !w.hit && address == w.address && (!w.hasValue || value == w.value) {
+		w.hit = true
+		w.hitPC = w.executingPC
+		w.hitValue = value
+	}
+}
+
+func (w *watchpoint) String() string {
+	if w.hasValue {
+		return fmt.Sprintf("%04X=%02X", w.address, w.value)
+	}
+	return fmt.Sprintf("%04X", w.address)
+}
+
+// parseWatchpoint parses an address in hex or a name from the RAM map,
+// optionally followed by = and a value in hex, e.g. "C0A2" or "C0A2=05".
+func parseWatchpoint(text string, names ramMap) (*watchpoint, error) {
+	address, value, hasValue := strings.Cut(strings.TrimSpace(text), "=")
+	w := &watchpoint{hasValue: hasValue, hitFrame: -1}
+
+	if a, ok := names.lookup(address); ok {
+		w.address = a
+	} else {
+		a, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimSpace(address), "0x"), 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("'%s' is neither an address in hex, like C0A2, nor in the RAM map", address)
+		}
+		w.address = uint16(a)
+	}
+
+	if hasValue {
+		v, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimSpace(value), "0x"), 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("'%s' is not a byte value in hex, like 05", value)
+		}
+		w.value = byte(v)
+	}
+	return w, nil
+}
+
+// startModalWatchpointDialog asks for the watchpoint. An empty text clears it.
+func (s *editorState) startModalWatchpointDialog() {
+	text := ""
+	if s.watchpoint != nil {
+		text = s.watchpoint.String()
+	}
+	s.startModalTextDialog("Watch Writes to Address or RAM Map Name, e.g. C0A2 or C0A2=05 (empty to clear)", text, func(text string) {
+		if strings.TrimSpace(text) == "" {
+			s.watchpoint = nil
+			s.setInfo("Watchpoint cleared.")
+			return
+		}
+		w, err := parseWatchpoint(text, s.ramMap)
+		if err != nil {
+			s.setWarning(err.Error())
+			return
+		}
+		s.watchpoint = w
+		s.setInfo("The replay pauses when the game writes to " + s.ramMap.describe(w.address) + ".")
+	})
+}
+
+// watchpointHit emulates the frame again with the watchpoint set, frames in the
+// cache do not tell us what was written while emulating them. If the
+// watchpoint is hit, the user is told where and true is returned.
+func (s *editorState) watchpointHit(frameIndex int) bool {
+	w := s.watchpoint
+	if w == nil {
+		return false
+	}
+
+	var gb gameboy.Gameboy
+	if frameIndex == 0 {
+		gb = s.startGameboy(s.gameboyOptions)
+	} else {
+		gb = s.generateFrame(frameIndex - 1)
+	}
+	applyInputs(&gb, s.inputsAt(frameIndex))
+	// A power cycle in applyInputs replaces the Gameboy, so we set the
+	// watchpoint afterwards.
+	w.hit = false
+	gb.Watch = w
+	setPollInputs(&gb, s.branch().subframeInputs[frameIndex])
+	gb.Step()
+	if !w.hit {
+		return false
+	}
+
+	w.hitFrame = frameIndex
+	s.setInfo(s.watchpointHitText())
+	return true
+}
+
+func (s *editorState) watchpointHitText() string {
+	w := s.watchpoint
+	return fmt.Sprintf(
+		"Frame %d: PC %04X wrote %02X to %s",
+		w.hitFrame, w.hitPC, w.hitValue, s.ramMap.describe(w.address),
+	)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/Humpheh/goboy/gameboy"
+)
+
+// defaultFitRowFrames is how many frames Ctrl+W fits into one row if no number
+// was typed before.
+const defaultFitRowFrames = 20
+
+// gridLayout returns how many frames fit next to and below each other into a
+// grid of the given size at a zoom scale. This is the layout that the editor
+// uses in executeEditorFrame.
+func gridLayout(scale float64, gridW, gridH int) (columns, rows int) {
+	fontHeight := round(scale * baseFontHeight)
+	frameWidth := 1 + round(scale*gameboy.ScreenWidth) + 1
+	frameHeight := fontHeight + round(scale*gameboy.ScreenHeight) + 1
+	return gridW / frameWidth, gridH / frameHeight
+}
+
+// fitScale returns the largest zoom of scalePercentages at which the layout
+// fits, or the smallest zoom if none does.
+func fitScale(gridW, gridH int, fits func(columns, rows int) bool) (float64, bool) {
+	for i := len(scalePercentages) - 1; i >= 0; i-- {
+		scale := float64(scalePercentages[i]) / 100
+		if fits(gridLayout(scale, gridW, gridH)) {
+			return scale, true
+		}
+	}
+	return float64(scalePercentages[0]) / 100, false
+}
+
+// zoomToFitSelection zooms so all selected frames are on screen and scrolls to
+// the first of them.
+func (s *editorState) zoomToFitSelection(gridW, gridH int) {
+	count := s.activeSelection.count()
+	scale, ok := fitScale(gridW, gridH, func(columns, rows int) bool {
+		return columns*rows >= count
+	})
+	s.scaleFactor = scale
+	s.leftMostFrame = s.activeSelection.start()
+	if ok {
+		s.setInfo(fmt.Sprintf("Zoom: %.0f%%, fits %d selected frames", scale*100, count))
+	} else {
+		s.setWarning(fmt.Sprintf("%d frames do not fit on screen, zoomed out all the way.", count))
+	}
+	s.render()
+}
+
+// zoomToFitRow zooms so one row has as many frames as the number that the user
+// typed, or defaultFitRowFrames.
+func (s *editorState) zoomToFitRow(gridW, gridH int) {
+	frames := defaultFitRowFrames
+	if n, ok := s.repeatEntry.number(); ok && n > 0 {
+		frames = n
+	}
+	scale, ok := fitScale(gridW, gridH, func(columns, rows int) bool {
+		return columns >= frames && rows >= 1
+	})
+	s.scaleFactor = scale
+	if ok {
+		s.setInfo(fmt.Sprintf("Zoom: %.0f%%, fits %d frames per row", scale*100, frames))
+	} else {
+		s.setWarning(fmt.Sprintf("%d frames do not fit into a row, zoomed out all the way.", frames))
+	}
+	s.render()
+}